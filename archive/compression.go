@@ -0,0 +1,122 @@
+package archive
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"github.com/dsnet/compress/bzip2"
+	"github.com/ulikunitz/xz"
+	"io"
+)
+
+type Compression int
+
+const (
+	Uncompressed Compression = iota
+	Gzip
+	Bzip2
+	Xz
+	Zstd
+)
+
+func (c Compression) String() string {
+	switch c {
+	case Gzip:
+		return "gzip"
+	case Bzip2:
+		return "bzip2"
+	case Xz:
+		return "xz"
+	case Zstd:
+		return "zstd"
+	}
+	return "tar"
+}
+
+// DetectCompression 按文件头的magic判断压缩格式
+func DetectCompression(header []byte) Compression {
+	switch {
+	case bytes.HasPrefix(header, []byte{0x1F, 0x8B, 0x08}):
+		return Gzip
+	case bytes.HasPrefix(header, []byte{0x42, 0x5A, 0x68}):
+		return Bzip2
+	case bytes.HasPrefix(header, []byte{0xFD, 0x37, 0x7A, 0x58, 0x5A, 0x00}):
+		return Xz
+	case bytes.HasPrefix(header, []byte{0x28, 0xB5, 0x2F, 0xFD}):
+		return Zstd
+	}
+	return Uncompressed
+}
+
+type readCloser struct {
+	io.Reader
+	close func() error
+}
+
+func (r *readCloser) Close() error {
+	if r.close == nil {
+		return nil
+	}
+	return r.close()
+}
+
+// DecompressStream 根据数据开头的magic自动选择解压方式，返回解压后的流。
+// 关闭返回值不会关闭r
+func DecompressStream(r io.Reader) (io.ReadCloser, error) {
+	buf := bufio.NewReaderSize(r, 32*1024)
+	// Peek在数据不足时也会返回读到的部分，交给DetectCompression判断
+	header, _ := buf.Peek(10)
+	compression := DetectCompression(header)
+	switch compression {
+	case Gzip:
+		gz, err := gzip.NewReader(buf)
+		if err != nil {
+			return nil, fmt.Errorf("open %s stream error %v", compression, err)
+		}
+		return &readCloser{Reader: gz, close: gz.Close}, nil
+	case Bzip2:
+		bz, err := bzip2.NewReader(buf, nil)
+		if err != nil {
+			return nil, fmt.Errorf("open %s stream error %v", compression, err)
+		}
+		return &readCloser{Reader: bz, close: bz.Close}, nil
+	case Xz:
+		xzReader, err := xz.NewReader(buf)
+		if err != nil {
+			return nil, fmt.Errorf("open %s stream error %v", compression, err)
+		}
+		return &readCloser{Reader: xzReader}, nil
+	case Zstd:
+		return &readCloser{Reader: NewZstdReader(buf)}, nil
+	}
+	return &readCloser{Reader: buf}, nil
+}
+
+type writeCloser struct {
+	io.Writer
+	close func() error
+}
+
+func (w *writeCloser) Close() error {
+	if w.close == nil {
+		return nil
+	}
+	return w.close()
+}
+
+// CompressStream 返回按compression压缩后写入w的Writer，Close时写完压缩流的结尾但不关闭w。
+// zstd只支持解压
+func CompressStream(w io.Writer, compression Compression) (io.WriteCloser, error) {
+	switch compression {
+	case Uncompressed:
+		return &writeCloser{Writer: w}, nil
+	case Gzip:
+		return gzip.NewWriter(w), nil
+	case Bzip2:
+		return bzip2.NewWriter(w, nil)
+	case Xz:
+		return xz.NewWriter(w)
+	}
+	return nil, fmt.Errorf("%s compression is not supported", compression)
+}
@@ -0,0 +1,45 @@
+package archive
+
+import "io"
+
+// 总大小未知时，每读这么多字节报告一次进度
+const progressUnknownStep = 1 << 20
+
+// ProgressFunc 报告已经读取的字节数，total小于等于0表示总大小未知
+type ProgressFunc func(current, total int64)
+
+type progressReader struct {
+	r        io.Reader
+	total    int64
+	current  int64
+	reported int64
+	fn       ProgressFunc
+}
+
+// NewProgressReader 包装r，读取时调用fn报告进度。
+// 为了不刷屏，已知总大小时每增加1%报告一次，读到结尾时再报告一次
+func NewProgressReader(r io.Reader, total int64, fn ProgressFunc) io.Reader {
+	return &progressReader{r: r, total: total, reported: -1, fn: fn}
+}
+
+func (p *progressReader) Read(b []byte) (int, error) {
+	n, err := p.r.Read(b)
+	p.current += int64(n)
+	step := int64(progressUnknownStep)
+	if p.total > 0 {
+		step = p.total / 100
+	}
+	if err == io.EOF {
+		if p.reported != p.current {
+			p.report()
+		}
+	} else if p.reported < 0 || p.current-p.reported >= step {
+		p.report()
+	}
+	return n, err
+}
+
+func (p *progressReader) report() {
+	p.reported = p.current
+	p.fn(p.current, p.total)
+}
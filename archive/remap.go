@@ -0,0 +1,44 @@
+package archive
+
+import (
+	"archive/tar"
+	"fmt"
+	"github.com/xianlubird/mydocker/idtools"
+	"io"
+)
+
+// ToContainerOwnership 把tar流中条目的属主从宿主机上的id转换成容器内的id，
+// 用于commit和export运行在user namespace中的容器
+func ToContainerOwnership(r io.Reader, uidMaps, gidMaps []idtools.IDMap) io.ReadCloser {
+	reader, writer := io.Pipe()
+	go func() {
+		writer.CloseWithError(remapTar(r, writer, uidMaps, gidMaps))
+	}()
+	return reader
+}
+
+func remapTar(r io.Reader, w io.Writer, uidMaps, gidMaps []idtools.IDMap) error {
+	tr := tar.NewReader(r)
+	tw := tar.NewWriter(w)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return tw.Close()
+		}
+		if err != nil {
+			return err
+		}
+		if hdr.Uid, err = idtools.ToContainer(hdr.Uid, uidMaps); err != nil {
+			return fmt.Errorf("%s: %v", hdr.Name, err)
+		}
+		if hdr.Gid, err = idtools.ToContainer(hdr.Gid, gidMaps); err != nil {
+			return fmt.Errorf("%s: %v", hdr.Name, err)
+		}
+		if err := tw.WriteHeader(hdr); err != nil {
+			return err
+		}
+		if _, err := io.Copy(tw, tr); err != nil {
+			return err
+		}
+	}
+}
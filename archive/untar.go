@@ -0,0 +1,342 @@
+package archive
+
+import (
+	"archive/tar"
+	"errors"
+	"fmt"
+	"github.com/xianlubird/mydocker/idtools"
+	"golang.org/x/sys/unix"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
+	"unsafe"
+)
+
+// ErrEscape 表示tar包中的条目试图写到目标目录之外
+var ErrEscape = errors.New("path escapes from the target root")
+
+// 解析路径时最多跟随的符号链接数，和内核的限制一样
+const maxSymlinks = 255
+
+type TarOptions struct {
+	// 为true时不修改文件的属主，非root用户解压时使用
+	NoLchown bool
+	// user namespace的id映射，tar中的属主是容器内的id，解压时转换成宿主机上的id
+	UIDMaps []idtools.IDMap
+	GIDMaps []idtools.IDMap
+}
+
+// Untar 把tar流解到dest目录，tar流可以是gzip、bzip2、xz或zstd压缩的。
+// 保留属主、权限、时间、硬链接、符号链接、设备文件和扩展属性，
+// 任何条目（包括经过符号链接之后）落到dest之外时返回ErrEscape
+func Untar(r io.Reader, dest string, opts *TarOptions) error {
+	if opts == nil {
+		opts = &TarOptions{}
+	}
+	stream, err := DecompressStream(r)
+	if err != nil {
+		return err
+	}
+	defer stream.Close()
+
+	dest, err = filepath.Abs(dest)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(dest, 0755); err != nil {
+		return err
+	}
+	// tar中没有根目录时，根目录也要属于容器内的root
+	if !opts.NoLchown && (len(opts.UIDMaps) > 0 || len(opts.GIDMaps) > 0) {
+		uid, gid, err := hostOwner(0, 0, opts)
+		if err != nil {
+			return err
+		}
+		if err := os.Lchown(dest, uid, gid); err != nil {
+			return err
+		}
+	}
+	// 目录的时间要在里面的文件都写完之后再设置
+	type dirEntry struct {
+		path string
+		hdr  *tar.Header
+	}
+	var dirs []dirEntry
+	tr := tar.NewReader(stream)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			// 读完tar结尾的填充，让压缩流和进度都走到结尾
+			io.Copy(ioutil.Discard, stream)
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("read tar error %v", err)
+		}
+		if hdr.Typeflag == tar.TypeXGlobalHeader {
+			continue
+		}
+		path, err := ResolveInRoot(dest, hdr.Name)
+		if err != nil {
+			return fmt.Errorf("invalid tar entry %s: %v", hdr.Name, err)
+		}
+		if err := extractEntry(dest, path, hdr, tr, opts); err != nil {
+			return fmt.Errorf("extract %s error %v", hdr.Name, err)
+		}
+		if hdr.Typeflag == tar.TypeDir {
+			dirs = append(dirs, dirEntry{path, hdr})
+		}
+	}
+	for i := len(dirs) - 1; i >= 0; i-- {
+		if err := setTimes(dirs[i].path, dirs[i].hdr); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ResolveInRoot 把name当作root下的路径解析，路径中间的符号链接都在root内跟随，
+// 绝对路径的链接从root开始解析。最后一个组件不跟随，结果离开root时返回ErrEscape
+func ResolveInRoot(root, name string) (string, error) {
+	rel := filepath.Clean(strings.TrimLeft(name, "/"))
+	if rel == ".." || strings.HasPrefix(rel, "../") {
+		return "", ErrEscape
+	}
+	if rel == "." {
+		return root, nil
+	}
+	dir, base := filepath.Split(rel)
+	resolved, err := resolveDir(root, dir)
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(root, resolved, base), nil
+}
+
+func resolveDir(root, dir string) (string, error) {
+	pending := strings.Split(dir, "/")
+	current := ""
+	links := 0
+	for len(pending) > 0 {
+		part := pending[0]
+		pending = pending[1:]
+		switch part {
+		case "", ".":
+			continue
+		case "..":
+			if current == "" {
+				return "", ErrEscape
+			}
+			if current = filepath.Dir(current); current == "." {
+				current = ""
+			}
+			continue
+		}
+		next := filepath.Join(current, part)
+		fi, err := os.Lstat(filepath.Join(root, next))
+		if err != nil || fi.Mode()&os.ModeSymlink == 0 {
+			// 不存在的目录解压时会创建
+			current = next
+			continue
+		}
+		if links++; links > maxSymlinks {
+			return "", fmt.Errorf("too many levels of symbolic links in %s", dir)
+		}
+		target, err := os.Readlink(filepath.Join(root, next))
+		if err != nil {
+			return "", err
+		}
+		if filepath.IsAbs(target) {
+			current = ""
+		}
+		pending = append(strings.Split(target, "/"), pending...)
+	}
+	return current, nil
+}
+
+// extractEntry 创建tar中的一个条目，path是已经在root内解析好的路径
+func extractEntry(root, path string, hdr *tar.Header, r io.Reader, opts *TarOptions) error {
+	if path == root && hdr.Typeflag != tar.TypeDir {
+		return fmt.Errorf("root of the archive must be a directory")
+	}
+	if err := mkdirAllAsRoot(filepath.Dir(path), opts); err != nil {
+		return err
+	}
+	// 已经存在的同名文件直接替换，目录保留，只更新属性
+	if fi, err := os.Lstat(path); err == nil {
+		if !(fi.IsDir() && hdr.Typeflag == tar.TypeDir) {
+			if err := os.RemoveAll(path); err != nil {
+				return err
+			}
+		}
+	}
+	mode := uint32(hdr.Mode & 07777)
+	switch hdr.Typeflag {
+	case tar.TypeDir:
+		if err := os.Mkdir(path, os.FileMode(mode)); err != nil && !os.IsExist(err) {
+			return err
+		}
+	case tar.TypeReg, tar.TypeRegA:
+		f, err := os.OpenFile(path, os.O_CREATE|os.O_EXCL|os.O_WRONLY, os.FileMode(mode))
+		if err != nil {
+			return err
+		}
+		_, err = io.Copy(f, r)
+		if closeErr := f.Close(); err == nil {
+			err = closeErr
+		}
+		if err != nil {
+			return err
+		}
+	case tar.TypeSymlink:
+		if err := os.Symlink(hdr.Linkname, path); err != nil {
+			return err
+		}
+	case tar.TypeLink:
+		target, err := ResolveInRoot(root, hdr.Linkname)
+		if err != nil {
+			return fmt.Errorf("hardlink target %s: %v", hdr.Linkname, err)
+		}
+		if err := os.Link(target, path); err != nil {
+			return err
+		}
+		// 硬链接和目标共享inode，不需要再设置属性
+		return nil
+	case tar.TypeChar, tar.TypeBlock, tar.TypeFifo:
+		switch hdr.Typeflag {
+		case tar.TypeChar:
+			mode |= syscall.S_IFCHR
+		case tar.TypeBlock:
+			mode |= syscall.S_IFBLK
+		case tar.TypeFifo:
+			mode |= syscall.S_IFIFO
+		}
+		dev := Mkdev(uint64(hdr.Devmajor), uint64(hdr.Devminor))
+		if err := syscall.Mknod(path, mode, int(dev)); err != nil {
+			return err
+		}
+	default:
+		return fmt.Errorf("unsupported tar entry type %q", hdr.Typeflag)
+	}
+
+	if !opts.NoLchown {
+		uid, gid, err := hostOwner(hdr.Uid, hdr.Gid, opts)
+		if err != nil {
+			return err
+		}
+		if err := os.Lchown(path, uid, gid); err != nil {
+			return err
+		}
+	}
+	// chown会清掉setuid位，所以chmod放在后面
+	if hdr.Typeflag != tar.TypeSymlink {
+		if err := os.Chmod(path, hdr.FileInfo().Mode()); err != nil {
+			return err
+		}
+	}
+	for key, value := range hdr.Xattrs {
+		if err := lsetxattr(path, key, []byte(value)); err != nil {
+			// 目标文件系统不支持扩展属性时忽略
+			if err == syscall.ENOTSUP {
+				continue
+			}
+			return fmt.Errorf("set xattr %s error %v", key, err)
+		}
+	}
+	if hdr.Typeflag != tar.TypeDir {
+		return setTimes(path, hdr)
+	}
+	return nil
+}
+
+// mkdirAllAsRoot 创建tar中没有出现的上级目录，这些目录属于容器内的root
+func mkdirAllAsRoot(dir string, opts *TarOptions) error {
+	var created []string
+	for d := dir; ; d = filepath.Dir(d) {
+		if _, err := os.Lstat(d); err == nil {
+			break
+		}
+		created = append(created, d)
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	if opts.NoLchown || len(created) == 0 {
+		return nil
+	}
+	uid, gid, err := hostOwner(0, 0, opts)
+	if err != nil {
+		return err
+	}
+	for _, d := range created {
+		if err := os.Lchown(d, uid, gid); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func hostOwner(uid, gid int, opts *TarOptions) (int, int, error) {
+	hostUID, err := idtools.ToHost(uid, opts.UIDMaps)
+	if err != nil {
+		return -1, -1, err
+	}
+	hostGID, err := idtools.ToHost(gid, opts.GIDMaps)
+	if err != nil {
+		return -1, -1, err
+	}
+	return hostUID, hostGID, nil
+}
+
+// setTimes 设置访问和修改时间，符号链接设置的是链接本身
+func setTimes(path string, hdr *tar.Header) error {
+	atime := hdr.AccessTime
+	if atime.IsZero() {
+		atime = hdr.ModTime
+	}
+	ts := []unix.Timespec{timespec(atime), timespec(hdr.ModTime)}
+	return unix.UtimesNanoAt(unix.AT_FDCWD, path, ts, unix.AT_SYMLINK_NOFOLLOW)
+}
+
+func timespec(t time.Time) unix.Timespec {
+	return unix.NsecToTimespec(t.UnixNano())
+}
+
+// lsetxattr 不跟随符号链接，syscall包中没有这个函数
+func lsetxattr(path, key string, value []byte) error {
+	pathPtr, err := syscall.BytePtrFromString(path)
+	if err != nil {
+		return err
+	}
+	keyPtr, err := syscall.BytePtrFromString(key)
+	if err != nil {
+		return err
+	}
+	var valuePtr unsafe.Pointer
+	if len(value) > 0 {
+		valuePtr = unsafe.Pointer(&value[0])
+	}
+	_, _, errno := syscall.Syscall6(syscall.SYS_LSETXATTR, uintptr(unsafe.Pointer(pathPtr)), uintptr(unsafe.Pointer(keyPtr)),
+		uintptr(valuePtr), uintptr(len(value)), 0, 0)
+	if errno != 0 {
+		return errno
+	}
+	return nil
+}
+
+// Major, Minor 和 Mkdev 按照glibc的方式编码设备号
+func Major(dev uint64) uint64 {
+	return ((dev >> 8) & 0xfff) | ((dev >> 32) &^ 0xfff)
+}
+
+func Minor(dev uint64) uint64 {
+	return (dev & 0xff) | ((dev >> 12) &^ 0xff)
+}
+
+func Mkdev(major, minor uint64) uint64 {
+	return (minor & 0xff) | ((major & 0xfff) << 8) | ((minor &^ 0xff) << 12) | ((major &^ 0xfff) << 32)
+}
@@ -0,0 +1,186 @@
+package archive
+
+import (
+	"archive/tar"
+	"bytes"
+	"github.com/xianlubird/mydocker/idtools"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+	"time"
+)
+
+type testEntry struct {
+	hdr     tar.Header
+	content string
+}
+
+func testTar(t *testing.T, entries []testEntry) *bytes.Buffer {
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	for _, e := range entries {
+		hdr := e.hdr
+		hdr.Size = int64(len(e.content))
+		if hdr.Mode == 0 {
+			hdr.Mode = 0644
+		}
+		if err := tw.WriteHeader(&hdr); err != nil {
+			t.Fatal(err)
+		}
+		tw.Write([]byte(e.content))
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return &buf
+}
+
+func TestUntar(t *testing.T) {
+	dest, _ := ioutil.TempDir("", "untar")
+	defer os.RemoveAll(dest)
+	mtime := time.Unix(1500000000, 0)
+	buf := testTar(t, []testEntry{
+		{hdr: tar.Header{Name: "etc/", Typeflag: tar.TypeDir, Mode: 0750, ModTime: mtime}},
+		{hdr: tar.Header{Name: "etc/passwd", Typeflag: tar.TypeReg, Uid: 1000, Gid: 1001, ModTime: mtime}, content: "root"},
+		{hdr: tar.Header{Name: "etc/hard", Typeflag: tar.TypeLink, Linkname: "etc/passwd"}},
+		{hdr: tar.Header{Name: "bin/su", Typeflag: tar.TypeReg, Mode: 04755}, content: "su"},
+		{hdr: tar.Header{Name: "lib", Typeflag: tar.TypeSymlink, Linkname: "/usr/lib", ModTime: mtime}},
+		{hdr: tar.Header{Name: "lib/libc.so", Typeflag: tar.TypeReg}, content: "libc"},
+	})
+	if err := Untar(buf, dest, &TarOptions{NoLchown: os.Getuid() != 0}); err != nil {
+		t.Fatal(err)
+	}
+
+	content, err := ioutil.ReadFile(filepath.Join(dest, "etc/passwd"))
+	if err != nil || string(content) != "root" {
+		t.Fatalf("read passwd %q %v", content, err)
+	}
+	fi, _ := os.Stat(filepath.Join(dest, "etc/passwd"))
+	if !fi.ModTime().Equal(mtime) {
+		t.Errorf("passwd mtime %v", fi.ModTime())
+	}
+	if os.Getuid() == 0 {
+		if st := fi.Sys().(*syscall.Stat_t); st.Uid != 1000 || st.Gid != 1001 {
+			t.Errorf("passwd owner %d:%d", st.Uid, st.Gid)
+		}
+	}
+	hard, _ := os.Stat(filepath.Join(dest, "etc/hard"))
+	if !os.SameFile(fi, hard) {
+		t.Error("hardlink not preserved")
+	}
+	if fi, _ := os.Stat(filepath.Join(dest, "etc")); fi.Mode().Perm() != 0750 || !fi.ModTime().Equal(mtime) {
+		t.Errorf("etc mode %v mtime %v", fi.Mode(), fi.ModTime())
+	}
+	if fi, _ := os.Stat(filepath.Join(dest, "bin/su")); fi.Mode()&os.ModeSetuid == 0 {
+		t.Errorf("su mode %v", fi.Mode())
+	}
+	// 绝对路径的符号链接在dest内解析
+	if content, err := ioutil.ReadFile(filepath.Join(dest, "usr/lib/libc.so")); err != nil || string(content) != "libc" {
+		t.Errorf("read libc through symlink %q %v", content, err)
+	}
+	if fi, _ := os.Lstat(filepath.Join(dest, "lib")); !fi.ModTime().Equal(mtime) {
+		t.Errorf("symlink mtime %v", fi.ModTime())
+	}
+}
+
+func TestUntarEscape(t *testing.T) {
+	cases := map[string][]testEntry{
+		"dotdot":   {{hdr: tar.Header{Name: "../evil", Typeflag: tar.TypeReg}}},
+		"nested":   {{hdr: tar.Header{Name: "a/../../evil", Typeflag: tar.TypeReg}}},
+		"hardlink": {{hdr: tar.Header{Name: "passwd", Typeflag: tar.TypeLink, Linkname: "../../etc/passwd"}}},
+		"symlink": {
+			{hdr: tar.Header{Name: "up", Typeflag: tar.TypeSymlink, Linkname: "../.."}},
+			{hdr: tar.Header{Name: "up/evil", Typeflag: tar.TypeReg}},
+		},
+	}
+	for name, entries := range cases {
+		parent, _ := ioutil.TempDir("", "untar")
+		dest := filepath.Join(parent, "rootfs")
+		err := Untar(testTar(t, entries), dest, &TarOptions{NoLchown: true})
+		if err == nil {
+			t.Errorf("%s: untar succeeded", name)
+		}
+		if _, err := os.Lstat(filepath.Join(parent, "evil")); err == nil {
+			t.Errorf("%s: file written outside of root", name)
+		}
+		os.RemoveAll(parent)
+	}
+
+	// 绝对路径当作相对于root处理
+	dest, _ := ioutil.TempDir("", "untar")
+	defer os.RemoveAll(dest)
+	buf := testTar(t, []testEntry{{hdr: tar.Header{Name: "/etc/hostname", Typeflag: tar.TypeReg}, content: "box"}})
+	if err := Untar(buf, dest, &TarOptions{NoLchown: true}); err != nil {
+		t.Fatal(err)
+	}
+	if content, _ := ioutil.ReadFile(filepath.Join(dest, "etc/hostname")); string(content) != "box" {
+		t.Errorf("hostname %q", content)
+	}
+}
+
+func TestProgressReader(t *testing.T) {
+	var reports []int64
+	r := NewProgressReader(bytes.NewReader(make([]byte, 1000)), 1000, func(current, total int64) {
+		reports = append(reports, current)
+	})
+	buf := make([]byte, 3)
+	for {
+		if _, err := r.Read(buf); err != nil {
+			break
+		}
+	}
+	if len(reports) == 0 || len(reports) > 101 || reports[len(reports)-1] != 1000 {
+		t.Errorf("got %d reports, last %v", len(reports), reports)
+	}
+	for i := 1; i < len(reports); i++ {
+		if reports[i]-reports[i-1] < 10 && reports[i] != 1000 {
+			t.Fatalf("reports too frequent: %v", reports[i-1:i+1])
+		}
+	}
+}
+
+func TestUntarIDMapping(t *testing.T) {
+	if os.Getuid() != 0 {
+		t.Skip("need root to chown")
+	}
+	dest, _ := ioutil.TempDir("", "untar")
+	defer os.RemoveAll(dest)
+	maps := []idtools.IDMap{{ContainerID: 0, HostID: 100000, Size: 65536}}
+	buf := testTar(t, []testEntry{
+		{hdr: tar.Header{Name: "home/user/file", Typeflag: tar.TypeReg, Uid: 1000, Gid: 1000}, content: "x"},
+	})
+	if err := Untar(buf, dest, &TarOptions{UIDMaps: maps, GIDMaps: maps}); err != nil {
+		t.Fatal(err)
+	}
+	owner := func(path string) (uint32, uint32) {
+		fi, err := os.Lstat(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		st := fi.Sys().(*syscall.Stat_t)
+		return st.Uid, st.Gid
+	}
+	if uid, gid := owner(filepath.Join(dest, "home/user/file")); uid != 101000 || gid != 101000 {
+		t.Errorf("file owner %d:%d", uid, gid)
+	}
+	// 根目录和tar中没有的上级目录属于容器内的root
+	for _, dir := range []string{dest, filepath.Join(dest, "home/user")} {
+		if uid, gid := owner(dir); uid != 100000 || gid != 100000 {
+			t.Errorf("%s owner %d:%d", dir, uid, gid)
+		}
+	}
+
+	// 反过来转换成容器内的id
+	buf = testTar(t, []testEntry{{hdr: tar.Header{Name: "file", Typeflag: tar.TypeReg, Uid: 101000, Gid: 100000}}})
+	tr := tar.NewReader(ToContainerOwnership(buf, maps, maps))
+	hdr, err := tr.Next()
+	if err != nil || hdr.Uid != 1000 || hdr.Gid != 0 {
+		t.Errorf("remapped header %+v %v", hdr, err)
+	}
+	buf = testTar(t, []testEntry{{hdr: tar.Header{Name: "file", Typeflag: tar.TypeReg, Uid: 5}}})
+	if _, err := tar.NewReader(ToContainerOwnership(buf, maps, maps)).Next(); err == nil {
+		t.Error("unmapped owner remapped without error")
+	}
+}
@@ -0,0 +1,107 @@
+package archive
+
+import (
+	"encoding/binary"
+	"math/bits"
+)
+
+// xxhash64 用于校验zstd frame的内容，seed固定为0
+const (
+	xxPrime1 uint64 = 11400714785074694791
+	xxPrime2 uint64 = 14029467366897019727
+	xxPrime3 uint64 = 1609587929392839161
+	xxPrime4 uint64 = 9650029242287828579
+	xxPrime5 uint64 = 2870177450012600261
+)
+
+type xxhash64 struct {
+	v1, v2, v3, v4 uint64
+	total          uint64
+	buf            [32]byte
+	n              int
+}
+
+func newXXHash64() *xxhash64 {
+	p1, p2 := xxPrime1, xxPrime2
+	return &xxhash64{
+		v1: p1 + p2,
+		v2: p2,
+		v3: 0,
+		v4: -p1,
+	}
+}
+
+func xxRound(acc, input uint64) uint64 {
+	acc += input * xxPrime2
+	acc = bits.RotateLeft64(acc, 31)
+	return acc * xxPrime1
+}
+
+func xxMergeRound(acc, val uint64) uint64 {
+	val = xxRound(0, val)
+	acc ^= val
+	return acc*xxPrime1 + xxPrime4
+}
+
+func (h *xxhash64) Write(p []byte) (int, error) {
+	n := len(p)
+	h.total += uint64(n)
+	if h.n+len(p) < 32 {
+		h.n += copy(h.buf[h.n:], p)
+		return n, nil
+	}
+	if h.n > 0 {
+		c := copy(h.buf[h.n:], p)
+		h.stripe(h.buf[:])
+		p = p[c:]
+		h.n = 0
+	}
+	for len(p) >= 32 {
+		h.stripe(p[:32])
+		p = p[32:]
+	}
+	h.n = copy(h.buf[:], p)
+	return n, nil
+}
+
+func (h *xxhash64) stripe(b []byte) {
+	h.v1 = xxRound(h.v1, binary.LittleEndian.Uint64(b[0:]))
+	h.v2 = xxRound(h.v2, binary.LittleEndian.Uint64(b[8:]))
+	h.v3 = xxRound(h.v3, binary.LittleEndian.Uint64(b[16:]))
+	h.v4 = xxRound(h.v4, binary.LittleEndian.Uint64(b[24:]))
+}
+
+func (h *xxhash64) Sum64() uint64 {
+	var acc uint64
+	if h.total >= 32 {
+		acc = bits.RotateLeft64(h.v1, 1) + bits.RotateLeft64(h.v2, 7) +
+			bits.RotateLeft64(h.v3, 12) + bits.RotateLeft64(h.v4, 18)
+		acc = xxMergeRound(acc, h.v1)
+		acc = xxMergeRound(acc, h.v2)
+		acc = xxMergeRound(acc, h.v3)
+		acc = xxMergeRound(acc, h.v4)
+	} else {
+		acc = xxPrime5
+	}
+	acc += h.total
+	b := h.buf[:h.n]
+	for ; len(b) >= 8; b = b[8:] {
+		acc ^= xxRound(0, binary.LittleEndian.Uint64(b))
+		acc = bits.RotateLeft64(acc, 27)*xxPrime1 + xxPrime4
+	}
+	if len(b) >= 4 {
+		acc ^= uint64(binary.LittleEndian.Uint32(b)) * xxPrime1
+		acc = bits.RotateLeft64(acc, 23)*xxPrime2 + xxPrime3
+		b = b[4:]
+	}
+	for _, c := range b {
+		acc ^= uint64(c) * xxPrime5
+		acc = bits.RotateLeft64(acc, 11) * xxPrime1
+	}
+	acc ^= acc >> 33
+	acc *= xxPrime2
+	acc ^= acc >> 29
+	acc *= xxPrime3
+	acc ^= acc >> 32
+	return acc
+}
@@ -0,0 +1,578 @@
+package archive
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+)
+
+// 纯Go实现的zstd解压，只支持解压，不支持字典。格式参考 RFC 8878
+
+const (
+	zstdMagic         = 0xFD2FB528
+	zstdSkippableMask = 0xFFFFFFF0
+	zstdSkippableBase = 0x184D2A50
+	zstdMaxBlockSize  = 128 << 10
+	// 和zstd命令行的默认值一样，最多使用128MB的窗口
+	zstdMaxWindowSize = 1 << 27
+)
+
+var errZstdDictionary = errors.New("zstd: dictionaries are not supported")
+
+// zstdReader 按块解压，解压出来的数据保存在history中，匹配可以引用窗口内之前的数据
+type zstdReader struct {
+	r   io.Reader
+	err error
+
+	history    []byte
+	out        int // history[out:] 还没有被读取
+	windowSize int
+
+	frameStarted bool
+	checksum     bool
+	hash         *xxhash64
+	block        []byte
+
+	huffman   *huffmanTable
+	llTable   *fseTable
+	ofTable   *fseTable
+	mlTable   *fseTable
+	repeats   [3]int
+	literals  []byte
+	sequences []sequence
+}
+
+type sequence struct {
+	literalLength int
+	matchLength   int
+	offset        int
+}
+
+// NewZstdReader 返回解压zstd数据的Reader，支持多个frame首尾相连
+func NewZstdReader(r io.Reader) io.Reader {
+	return &zstdReader{r: r}
+}
+
+func (z *zstdReader) Read(p []byte) (int, error) {
+	for z.out == len(z.history) {
+		if z.err != nil {
+			return 0, z.err
+		}
+		z.err = z.decodeNext()
+	}
+	n := copy(p, z.history[z.out:])
+	z.out += n
+	return n, nil
+}
+
+// decodeNext 解压下一个块，到达流的末尾时返回io.EOF
+func (z *zstdReader) decodeNext() error {
+	if !z.frameStarted {
+		if err := z.readFrameHeader(); err != nil {
+			return err
+		}
+	}
+	// 只保留一个窗口的历史数据
+	if keep := z.windowSize; len(z.history) > 2*keep+zstdMaxBlockSize {
+		drop := len(z.history) - keep
+		z.history = append(z.history[:0], z.history[drop:]...)
+		z.out -= drop
+	}
+
+	var header [3]byte
+	if _, err := io.ReadFull(z.r, header[:]); err != nil {
+		return unexpectedEOF(err)
+	}
+	h := uint32(header[0]) | uint32(header[1])<<8 | uint32(header[2])<<16
+	last := h&1 == 1
+	blockType := (h >> 1) & 3
+	blockSize := int(h >> 3)
+	maxBlock := zstdMaxBlockSize
+	if z.windowSize < maxBlock {
+		maxBlock = z.windowSize
+	}
+
+	start := len(z.history)
+	switch blockType {
+	case 0:
+		if blockSize > maxBlock {
+			return errCorrupt
+		}
+		z.history = append(z.history, make([]byte, blockSize)...)
+		if _, err := io.ReadFull(z.r, z.history[start:]); err != nil {
+			return unexpectedEOF(err)
+		}
+	case 1:
+		if blockSize > maxBlock {
+			return errCorrupt
+		}
+		var b [1]byte
+		if _, err := io.ReadFull(z.r, b[:]); err != nil {
+			return unexpectedEOF(err)
+		}
+		for i := 0; i < blockSize; i++ {
+			z.history = append(z.history, b[0])
+		}
+	case 2:
+		if blockSize > maxBlock {
+			return errCorrupt
+		}
+		if cap(z.block) < blockSize {
+			z.block = make([]byte, blockSize)
+		}
+		z.block = z.block[:blockSize]
+		if _, err := io.ReadFull(z.r, z.block); err != nil {
+			return unexpectedEOF(err)
+		}
+		if err := z.decodeCompressedBlock(z.block, maxBlock); err != nil {
+			return err
+		}
+	default:
+		return errCorrupt
+	}
+	if z.checksum {
+		z.hash.Write(z.history[start:])
+	}
+	if last {
+		return z.finishFrame()
+	}
+	return nil
+}
+
+func unexpectedEOF(err error) error {
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
+
+func (z *zstdReader) readFrameHeader() error {
+	for {
+		var magic [4]byte
+		if _, err := io.ReadFull(z.r, magic[:]); err != nil {
+			if err == io.ErrUnexpectedEOF {
+				return errCorrupt
+			}
+			return err
+		}
+		m := binary.LittleEndian.Uint32(magic[:])
+		if m&zstdSkippableMask == zstdSkippableBase {
+			var size [4]byte
+			if _, err := io.ReadFull(z.r, size[:]); err != nil {
+				return unexpectedEOF(err)
+			}
+			if _, err := io.CopyN(ioutil.Discard, z.r, int64(binary.LittleEndian.Uint32(size[:]))); err != nil {
+				return unexpectedEOF(err)
+			}
+			continue
+		}
+		if m != zstdMagic {
+			return fmt.Errorf("zstd: invalid magic number %#x", m)
+		}
+		break
+	}
+
+	var descriptor [1]byte
+	if _, err := io.ReadFull(z.r, descriptor[:]); err != nil {
+		return unexpectedEOF(err)
+	}
+	d := descriptor[0]
+	fcsFlag := d >> 6
+	singleSegment := d&0x20 != 0
+	if d&0x08 != 0 {
+		return errCorrupt
+	}
+	z.checksum = d&0x04 != 0
+	dictIDSize := []int{0, 1, 2, 4}[d&3]
+	fcsSize := []int{0, 2, 4, 8}[fcsFlag]
+	if fcsFlag == 0 && singleSegment {
+		fcsSize = 1
+	}
+	windowDescSize := 1
+	if singleSegment {
+		windowDescSize = 0
+	}
+	header := make([]byte, windowDescSize+dictIDSize+fcsSize)
+	if _, err := io.ReadFull(z.r, header); err != nil {
+		return unexpectedEOF(err)
+	}
+
+	windowSize := uint64(0)
+	if !singleSegment {
+		exponent := uint(header[0] >> 3)
+		mantissa := uint64(header[0] & 7)
+		base := uint64(1) << (10 + exponent)
+		windowSize = base + base/8*mantissa
+	}
+	dictID := header[windowDescSize : windowDescSize+dictIDSize]
+	for _, b := range dictID {
+		if b != 0 {
+			return errZstdDictionary
+		}
+	}
+	fcs := header[windowDescSize+dictIDSize:]
+	var contentSize uint64
+	switch len(fcs) {
+	case 1:
+		contentSize = uint64(fcs[0])
+	case 2:
+		contentSize = uint64(binary.LittleEndian.Uint16(fcs)) + 256
+	case 4:
+		contentSize = uint64(binary.LittleEndian.Uint32(fcs))
+	case 8:
+		contentSize = binary.LittleEndian.Uint64(fcs)
+	}
+	if singleSegment {
+		windowSize = contentSize
+	}
+	if windowSize > zstdMaxWindowSize {
+		return fmt.Errorf("zstd: window size %d is too large", windowSize)
+	}
+	z.windowSize = int(windowSize)
+	if z.windowSize < 1024 {
+		z.windowSize = 1024
+	}
+
+	// 每个frame的状态是独立的
+	z.frameStarted = true
+	z.history = z.history[:0]
+	z.out = 0
+	z.huffman = nil
+	z.llTable, z.ofTable, z.mlTable = nil, nil, nil
+	z.repeats = [3]int{1, 4, 8}
+	if z.checksum {
+		z.hash = newXXHash64()
+	}
+	return nil
+}
+
+func (z *zstdReader) finishFrame() error {
+	z.frameStarted = false
+	if z.checksum {
+		var sum [4]byte
+		if _, err := io.ReadFull(z.r, sum[:]); err != nil {
+			return unexpectedEOF(err)
+		}
+		if binary.LittleEndian.Uint32(sum[:]) != uint32(z.hash.Sum64()) {
+			return errors.New("zstd: checksum mismatch")
+		}
+	}
+	return nil
+}
+
+func (z *zstdReader) decodeCompressedBlock(block []byte, maxBlock int) error {
+	n, err := z.readLiterals(block, maxBlock)
+	if err != nil {
+		return err
+	}
+	if err := z.readSequences(block[n:]); err != nil {
+		return err
+	}
+	return z.executeSequences(maxBlock)
+}
+
+// readLiterals 解析块的literals部分，返回用掉的字节数
+func (z *zstdReader) readLiterals(block []byte, maxBlock int) (int, error) {
+	if len(block) == 0 {
+		return 0, errCorrupt
+	}
+	litType := block[0] & 3
+	sizeFormat := (block[0] >> 2) & 3
+	z.literals = z.literals[:0]
+
+	if litType == 0 || litType == 1 {
+		var size, headerSize int
+		switch sizeFormat {
+		case 0, 2:
+			size, headerSize = int(block[0]>>3), 1
+		case 1:
+			if len(block) < 2 {
+				return 0, errCorrupt
+			}
+			size, headerSize = int(block[0]>>4)|int(block[1])<<4, 2
+		case 3:
+			if len(block) < 3 {
+				return 0, errCorrupt
+			}
+			size, headerSize = int(block[0]>>4)|int(block[1])<<4|int(block[2])<<12, 3
+		}
+		if size > maxBlock {
+			return 0, errCorrupt
+		}
+		if litType == 0 {
+			if len(block) < headerSize+size {
+				return 0, errCorrupt
+			}
+			z.literals = append(z.literals, block[headerSize:headerSize+size]...)
+			return headerSize + size, nil
+		}
+		if len(block) < headerSize+1 {
+			return 0, errCorrupt
+		}
+		for i := 0; i < size; i++ {
+			z.literals = append(z.literals, block[headerSize])
+		}
+		return headerSize + 1, nil
+	}
+
+	var regenSize, compSize, headerSize int
+	streams := 4
+	switch sizeFormat {
+	case 0, 1:
+		if len(block) < 3 {
+			return 0, errCorrupt
+		}
+		h := uint32(block[0]) | uint32(block[1])<<8 | uint32(block[2])<<16
+		regenSize, compSize, headerSize = int(h>>4&0x3ff), int(h>>14&0x3ff), 3
+		if sizeFormat == 0 {
+			streams = 1
+		}
+	case 2:
+		if len(block) < 4 {
+			return 0, errCorrupt
+		}
+		h := binary.LittleEndian.Uint32(block)
+		regenSize, compSize, headerSize = int(h>>4&0x3fff), int(h>>18&0x3fff), 4
+	case 3:
+		if len(block) < 5 {
+			return 0, errCorrupt
+		}
+		h := uint64(binary.LittleEndian.Uint32(block)) | uint64(block[4])<<32
+		regenSize, compSize, headerSize = int(h>>4&0x3ffff), int(h>>22&0x3ffff), 5
+	}
+	if regenSize > maxBlock || len(block) < headerSize+compSize {
+		return 0, errCorrupt
+	}
+	data := block[headerSize : headerSize+compSize]
+	if litType == 2 {
+		table, n, err := readHuffmanTable(data)
+		if err != nil {
+			return 0, err
+		}
+		z.huffman = table
+		data = data[n:]
+	} else if z.huffman == nil {
+		return 0, errCorrupt
+	}
+
+	if streams == 1 {
+		literals, err := z.huffman.decodeStream(z.literals, data, regenSize)
+		if err != nil {
+			return 0, err
+		}
+		z.literals = literals
+		return headerSize + compSize, nil
+	}
+	if len(data) < 6 {
+		return 0, errCorrupt
+	}
+	sizes := [4]int{
+		int(binary.LittleEndian.Uint16(data[0:])),
+		int(binary.LittleEndian.Uint16(data[2:])),
+		int(binary.LittleEndian.Uint16(data[4:])),
+	}
+	data = data[6:]
+	sizes[3] = len(data) - sizes[0] - sizes[1] - sizes[2]
+	if sizes[3] < 0 {
+		return 0, errCorrupt
+	}
+	segment := (regenSize + 3) / 4
+	for i := 0; i < 4; i++ {
+		n := segment
+		if i == 3 {
+			n = regenSize - 3*segment
+		}
+		if n < 0 {
+			return 0, errCorrupt
+		}
+		literals, err := z.huffman.decodeStream(z.literals, data[:sizes[i]], n)
+		if err != nil {
+			return 0, err
+		}
+		z.literals = literals
+		data = data[sizes[i]:]
+	}
+	return headerSize + compSize, nil
+}
+
+var (
+	llDefaultNorm = []int16{4, 3, 2, 2, 2, 2, 2, 2, 2, 2, 2, 2, 2, 1, 1, 1, 2, 2, 2, 2, 2, 2, 2, 2, 2, 3, 2, 1, 1, 1, 1, 1, -1, -1, -1, -1}
+	mlDefaultNorm = []int16{1, 4, 3, 2, 2, 2, 2, 2, 2, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, -1, -1, -1, -1, -1, -1, -1}
+	ofDefaultNorm = []int16{1, 1, 1, 1, 1, 1, 2, 2, 2, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, -1, -1, -1, -1, -1}
+
+	llDefaultTable, _ = buildFSETable(llDefaultNorm, 6)
+	mlDefaultTable, _ = buildFSETable(mlDefaultNorm, 6)
+	ofDefaultTable, _ = buildFSETable(ofDefaultNorm, 5)
+
+	llBase = []int{0, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15,
+		16, 18, 20, 22, 24, 28, 32, 40, 48, 64, 128, 256, 512, 1024, 2048, 4096, 8192, 16384, 32768, 65536}
+	llBits = []uint{0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0,
+		1, 1, 1, 1, 2, 2, 3, 3, 4, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15, 16}
+	mlBase = []int{3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15, 16, 17, 18, 19, 20, 21, 22, 23, 24, 25, 26, 27, 28, 29, 30, 31, 32, 33, 34,
+		35, 37, 39, 41, 43, 47, 51, 59, 67, 83, 99, 131, 259, 515, 1027, 2051, 4099, 8195, 16387, 32771, 65539}
+	mlBits = []uint{0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0,
+		1, 1, 1, 1, 2, 2, 3, 3, 4, 4, 5, 7, 8, 9, 10, 11, 12, 13, 14, 15, 16}
+)
+
+// readTable 按压缩模式取得序列的FSE表：0 默认分布，1 RLE，2 FSE压缩的表，3 沿用上一个块的表
+func readSequenceTable(mode byte, data []byte, prev, def *fseTable, maxSymbol int, maxLog uint) (*fseTable, int, error) {
+	switch mode {
+	case 0:
+		return def, 0, nil
+	case 1:
+		if len(data) < 1 || int(data[0]) > maxSymbol {
+			return nil, 0, errCorrupt
+		}
+		return rleFSETable(data[0]), 1, nil
+	case 2:
+		return readFSETable(data, maxSymbol, maxLog)
+	default:
+		if prev == nil {
+			return nil, 0, errCorrupt
+		}
+		return prev, 0, nil
+	}
+}
+
+func (z *zstdReader) readSequences(data []byte) error {
+	z.sequences = z.sequences[:0]
+	if len(data) < 1 {
+		return errCorrupt
+	}
+	nbSeq := int(data[0])
+	pos := 1
+	switch {
+	case nbSeq == 0:
+		return nil
+	case nbSeq < 128:
+	case nbSeq < 255:
+		if len(data) < 2 {
+			return errCorrupt
+		}
+		nbSeq = (nbSeq-128)<<8 + int(data[1])
+		pos = 2
+	default:
+		if len(data) < 3 {
+			return errCorrupt
+		}
+		nbSeq = int(data[1]) + int(data[2])<<8 + 0x7f00
+		pos = 3
+	}
+	if len(data) < pos+1 {
+		return errCorrupt
+	}
+	modes := data[pos]
+	pos++
+	if modes&3 != 0 {
+		return errCorrupt
+	}
+	var n int
+	var err error
+	if z.llTable, n, err = readSequenceTable(modes>>6, data[pos:], z.llTable, llDefaultTable, 35, 9); err != nil {
+		return err
+	}
+	pos += n
+	if z.ofTable, n, err = readSequenceTable(modes>>4&3, data[pos:], z.ofTable, ofDefaultTable, 31, 8); err != nil {
+		return err
+	}
+	pos += n
+	if z.mlTable, n, err = readSequenceTable(modes>>2&3, data[pos:], z.mlTable, mlDefaultTable, 52, 9); err != nil {
+		return err
+	}
+	pos += n
+
+	r, err := newBackwardBitReader(data[pos:])
+	if err != nil {
+		return err
+	}
+	var ll, of, ml fseState
+	ll.init(r, z.llTable)
+	of.init(r, z.ofTable)
+	ml.init(r, z.mlTable)
+	for i := 0; i < nbSeq; i++ {
+		llCode, ofCode, mlCode := ll.symbol(), of.symbol(), ml.symbol()
+		if int(llCode) >= len(llBase) || int(mlCode) >= len(mlBase) || ofCode > 31 {
+			return errCorrupt
+		}
+		offsetValue := 1<<ofCode + int(r.readBits(uint(ofCode)))
+		s := sequence{matchLength: mlBase[mlCode] + int(r.readBits(mlBits[mlCode]))}
+		s.literalLength = llBase[llCode] + int(r.readBits(llBits[llCode]))
+		s.offset = z.resolveOffset(offsetValue, s.literalLength)
+		if s.offset <= 0 {
+			return errCorrupt
+		}
+		z.sequences = append(z.sequences, s)
+		if i != nbSeq-1 {
+			ll.update(r)
+			ml.update(r)
+			of.update(r)
+		}
+		if r.overflowed() {
+			return errCorrupt
+		}
+	}
+	if !r.finished() {
+		return errCorrupt
+	}
+	return nil
+}
+
+// resolveOffset 处理重复偏移，值小于等于3时表示使用最近用过的偏移
+func (z *zstdReader) resolveOffset(offsetValue, literalLength int) int {
+	if offsetValue > 3 {
+		offset := offsetValue - 3
+		z.repeats = [3]int{offset, z.repeats[0], z.repeats[1]}
+		return offset
+	}
+	idx := offsetValue - 1
+	if literalLength == 0 {
+		idx++
+	}
+	var offset int
+	switch idx {
+	case 0:
+		return z.repeats[0]
+	case 1:
+		offset = z.repeats[1]
+		z.repeats[1] = z.repeats[0]
+		z.repeats[0] = offset
+		return offset
+	case 2:
+		offset = z.repeats[2]
+	default:
+		offset = z.repeats[0] - 1
+	}
+	z.repeats = [3]int{offset, z.repeats[0], z.repeats[1]}
+	return offset
+}
+
+func (z *zstdReader) executeSequences(maxBlock int) error {
+	start := len(z.history)
+	literals := z.literals
+	for _, s := range z.sequences {
+		if s.literalLength > len(literals) {
+			return errCorrupt
+		}
+		z.history = append(z.history, literals[:s.literalLength]...)
+		literals = literals[s.literalLength:]
+		if s.offset > len(z.history) || s.offset > z.windowSize {
+			return errCorrupt
+		}
+		from := len(z.history) - s.offset
+		if s.offset >= s.matchLength {
+			z.history = append(z.history, z.history[from:from+s.matchLength]...)
+		} else {
+			// 重叠的匹配只能逐字节复制
+			for i := 0; i < s.matchLength; i++ {
+				z.history = append(z.history, z.history[from+i])
+			}
+		}
+	}
+	z.history = append(z.history, literals...)
+	if len(z.history)-start > maxBlock {
+		return errCorrupt
+	}
+	return nil
+}
@@ -0,0 +1,378 @@
+package archive
+
+import (
+	"errors"
+	"math/bits"
+)
+
+// zstd的熵编码部分：FSE和Huffman，参考 RFC 8878
+
+var errCorrupt = errors.New("zstd: corrupted data")
+
+// forwardBitReader 从前往后按低位优先读取比特，用于FSE表的描述
+type forwardBitReader struct {
+	data []byte
+	pos  uint // 已经读取的比特数
+}
+
+func (r *forwardBitReader) peek(n uint) uint32 {
+	var v uint32
+	for i := uint(0); i < n; i++ {
+		byteIdx := (r.pos + i) / 8
+		if byteIdx >= uint(len(r.data)) {
+			break
+		}
+		v |= uint32(r.data[byteIdx]>>((r.pos+i)%8)&1) << i
+	}
+	return v
+}
+
+func (r *forwardBitReader) skip(n uint) {
+	r.pos += n
+}
+
+func (r *forwardBitReader) bytesUsed() int {
+	return int((r.pos + 7) / 8)
+}
+
+// backwardBitReader 从流的末尾往前读取，Huffman和FSE的数据流都是这种格式。
+// 最后一个字节的最高位的1是结束标记
+type backwardBitReader struct {
+	data  []byte
+	pos   int    // data[:pos] 还没有装入value
+	value uint64 // 低count位是还没读取的比特，高位先读
+	count uint
+	// 读到流开头之后补的0的个数，大于0说明读过头了
+	overread uint
+}
+
+func newBackwardBitReader(data []byte) (*backwardBitReader, error) {
+	if len(data) == 0 || data[len(data)-1] == 0 {
+		return nil, errCorrupt
+	}
+	r := &backwardBitReader{data: data, pos: len(data)}
+	r.refill()
+	r.readBits(uint(bits.LeadingZeros8(data[len(data)-1])) + 1)
+	return r, nil
+}
+
+func (r *backwardBitReader) refill() {
+	for r.count <= 56 && r.pos > 0 {
+		r.pos--
+		r.value = r.value<<8 | uint64(r.data[r.pos])
+		r.count += 8
+	}
+}
+
+func (r *backwardBitReader) readBits(n uint) uint64 {
+	if n == 0 {
+		return 0
+	}
+	v := r.peekBits(n)
+	r.consume(n)
+	return v
+}
+
+func (r *backwardBitReader) peekBits(n uint) uint64 {
+	if r.count < n {
+		r.refill()
+	}
+	if r.count >= n {
+		return (r.value >> (r.count - n)) & (1<<n - 1)
+	}
+	return (r.value << (n - r.count)) & (1<<n - 1)
+}
+
+func (r *backwardBitReader) consume(n uint) {
+	if r.count >= n {
+		r.count -= n
+		return
+	}
+	r.overread += n - r.count
+	r.count = 0
+}
+
+// finished 表示所有比特刚好读完
+func (r *backwardBitReader) finished() bool {
+	return r.pos == 0 && r.count == 0 && r.overread == 0
+}
+
+func (r *backwardBitReader) overflowed() bool {
+	return r.overread > 0
+}
+
+type fseEntry struct {
+	symbol   uint8
+	nbBits   uint8
+	newState uint16
+}
+
+type fseTable struct {
+	accuracyLog uint
+	entries     []fseEntry
+}
+
+// readFSETable 解析FSE表的描述，返回表和用掉的字节数
+func readFSETable(data []byte, maxSymbol int, maxAccuracyLog uint) (*fseTable, int, error) {
+	if len(data) == 0 {
+		return nil, 0, errCorrupt
+	}
+	r := &forwardBitReader{data: data}
+	accuracyLog := uint(r.peek(4)) + 5
+	r.skip(4)
+	if accuracyLog > maxAccuracyLog {
+		return nil, 0, errCorrupt
+	}
+	norm := make([]int16, 0, maxSymbol+1)
+	remaining := int32(1<<accuracyLog) + 1
+	threshold := int32(1 << accuracyLog)
+	nbBits := accuracyLog + 1
+	previous0 := false
+	for remaining > 1 {
+		if previous0 {
+			for {
+				repeat := r.peek(2)
+				r.skip(2)
+				for i := uint32(0); i < repeat; i++ {
+					norm = append(norm, 0)
+				}
+				if repeat != 3 {
+					break
+				}
+			}
+		}
+		if len(norm) > maxSymbol {
+			return nil, 0, errCorrupt
+		}
+		max := 2*threshold - 1 - remaining
+		var count int32
+		if low := int32(r.peek(nbBits - 1)); low < max {
+			count = low
+			r.skip(nbBits - 1)
+		} else {
+			count = int32(r.peek(nbBits))
+			if count >= threshold {
+				count -= max
+			}
+			r.skip(nbBits)
+		}
+		count--
+		if count < 0 {
+			remaining += count
+		} else {
+			remaining -= count
+		}
+		norm = append(norm, int16(count))
+		previous0 = count == 0
+		for remaining < threshold {
+			nbBits--
+			threshold >>= 1
+		}
+	}
+	if remaining != 1 || len(norm) > maxSymbol+1 || r.bytesUsed() > len(data) {
+		return nil, 0, errCorrupt
+	}
+	table, err := buildFSETable(norm, accuracyLog)
+	return table, r.bytesUsed(), err
+}
+
+func buildFSETable(norm []int16, accuracyLog uint) (*fseTable, error) {
+	tableSize := 1 << accuracyLog
+	table := &fseTable{accuracyLog: accuracyLog, entries: make([]fseEntry, tableSize)}
+	symbolNext := make([]uint16, len(norm))
+	highThreshold := tableSize - 1
+	for s, n := range norm {
+		if n == -1 {
+			table.entries[highThreshold].symbol = uint8(s)
+			highThreshold--
+			symbolNext[s] = 1
+		} else {
+			symbolNext[s] = uint16(n)
+		}
+	}
+	step := tableSize>>1 + tableSize>>3 + 3
+	mask := tableSize - 1
+	pos := 0
+	for s, n := range norm {
+		for i := 0; i < int(n); i++ {
+			table.entries[pos].symbol = uint8(s)
+			pos = (pos + step) & mask
+			for pos > highThreshold {
+				pos = (pos + step) & mask
+			}
+		}
+	}
+	if pos != 0 {
+		return nil, errCorrupt
+	}
+	for u := range table.entries {
+		e := &table.entries[u]
+		next := symbolNext[e.symbol]
+		symbolNext[e.symbol]++
+		e.nbBits = uint8(accuracyLog - uint(bits.Len16(next)-1))
+		e.newState = uint16((int(next) << e.nbBits) - tableSize)
+	}
+	return table, nil
+}
+
+// rleFSETable 是RLE模式下只有一个符号的表
+func rleFSETable(symbol uint8) *fseTable {
+	return &fseTable{entries: []fseEntry{{symbol: symbol}}}
+}
+
+type fseState struct {
+	table *fseTable
+	state uint16
+}
+
+func (s *fseState) init(r *backwardBitReader, table *fseTable) {
+	s.table = table
+	s.state = uint16(r.readBits(table.accuracyLog))
+}
+
+func (s *fseState) symbol() uint8 {
+	return s.table.entries[s.state].symbol
+}
+
+func (s *fseState) update(r *backwardBitReader) {
+	e := s.table.entries[s.state]
+	s.state = e.newState + uint16(r.readBits(uint(e.nbBits)))
+}
+
+const maxHuffmanBits = 11
+
+type huffmanEntry struct {
+	symbol uint8
+	nbBits uint8
+}
+
+type huffmanTable struct {
+	maxBits uint
+	entries []huffmanEntry
+}
+
+// readHuffmanTable 解析Huffman树的描述，返回表和用掉的字节数
+func readHuffmanTable(data []byte) (*huffmanTable, int, error) {
+	if len(data) == 0 {
+		return nil, 0, errCorrupt
+	}
+	header := int(data[0])
+	var weights []uint8
+	used := 0
+	if header >= 128 {
+		// 直接用4比特表示每个权重
+		n := header - 127
+		used = 1 + (n+1)/2
+		if used > len(data) {
+			return nil, 0, errCorrupt
+		}
+		for i := 0; i < n; i++ {
+			b := data[1+i/2]
+			if i%2 == 0 {
+				weights = append(weights, b>>4)
+			} else {
+				weights = append(weights, b&0xf)
+			}
+		}
+	} else {
+		// 权重用FSE压缩，两个状态交替解码
+		used = 1 + header
+		if used > len(data) {
+			return nil, 0, errCorrupt
+		}
+		table, n, err := readFSETable(data[1:used], 255, 6)
+		if err != nil {
+			return nil, 0, err
+		}
+		r, err := newBackwardBitReader(data[1+n : used])
+		if err != nil {
+			return nil, 0, err
+		}
+		var s1, s2 fseState
+		s1.init(r, table)
+		s2.init(r, table)
+		for len(weights) < 255 {
+			weights = append(weights, s1.symbol())
+			s1.update(r)
+			if r.overflowed() {
+				weights = append(weights, s2.symbol())
+				break
+			}
+			weights = append(weights, s2.symbol())
+			s2.update(r)
+			if r.overflowed() {
+				weights = append(weights, s1.symbol())
+				break
+			}
+		}
+	}
+	table, err := buildHuffmanTable(weights)
+	return table, used, err
+}
+
+func buildHuffmanTable(weights []uint8) (*huffmanTable, error) {
+	if len(weights) == 0 || len(weights) > 255 {
+		return nil, errCorrupt
+	}
+	var total uint32
+	for _, w := range weights {
+		if w > maxHuffmanBits {
+			return nil, errCorrupt
+		}
+		if w > 0 {
+			total += 1 << (w - 1)
+		}
+	}
+	if total == 0 {
+		return nil, errCorrupt
+	}
+	// 最后一个符号的权重是隐含的，补齐到2的幂
+	maxBits := uint(bits.Len32(total))
+	rest := uint32(1)<<maxBits - total
+	if rest == 0 || rest&(rest-1) != 0 || maxBits > maxHuffmanBits {
+		return nil, errCorrupt
+	}
+	weights = append(weights, uint8(bits.Len32(rest)))
+
+	var rankStart [maxHuffmanBits + 2]uint32
+	for _, w := range weights {
+		if w > 0 {
+			rankStart[w] += 1 << (w - 1)
+		}
+	}
+	next := uint32(0)
+	for w := 1; w <= int(maxBits); w++ {
+		count := rankStart[w]
+		rankStart[w] = next
+		next += count
+	}
+	table := &huffmanTable{maxBits: maxBits, entries: make([]huffmanEntry, 1<<maxBits)}
+	for s, w := range weights {
+		if w == 0 {
+			continue
+		}
+		length := uint32(1) << (w - 1)
+		for u := rankStart[w]; u < rankStart[w]+length; u++ {
+			table.entries[u] = huffmanEntry{symbol: uint8(s), nbBits: uint8(maxBits + 1 - uint(w))}
+		}
+		rankStart[w] += length
+	}
+	return table, nil
+}
+
+// decodeStream 解码一个Huffman流，输出n个字节
+func (t *huffmanTable) decodeStream(dst []byte, data []byte, n int) ([]byte, error) {
+	r, err := newBackwardBitReader(data)
+	if err != nil {
+		return nil, err
+	}
+	for i := 0; i < n; i++ {
+		e := t.entries[r.peekBits(t.maxBits)]
+		r.consume(uint(e.nbBits))
+		dst = append(dst, e.symbol)
+	}
+	if !r.finished() {
+		return nil, errCorrupt
+	}
+	return dst, nil
+}
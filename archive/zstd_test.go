@@ -0,0 +1,109 @@
+package archive
+
+import (
+	"bytes"
+	"io/ioutil"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// zstdTestData 生成testdata中压缩前的内容，混合了重复的单词、随机字节和回溯的匹配
+func zstdTestData(size int) []byte {
+	rnd := rand.New(rand.NewSource(int64(size)))
+	words := []string{"mydocker", "container", "layer", "image", "overlay", "whiteout", "/usr/lib/", "\n", " ", "0123456789"}
+	data := make([]byte, 0, size)
+	for len(data) < size {
+		switch rnd.Intn(10) {
+		case 0:
+			data = append(data, byte(rnd.Intn(256)))
+		case 1:
+			if len(data) > 100 {
+				start := rnd.Intn(len(data) - 50)
+				data = append(data, data[start:start+rnd.Intn(50)]...)
+			}
+		default:
+			data = append(data, words[rnd.Intn(len(words))]...)
+		}
+	}
+	return data[:size]
+}
+
+// testdata中的文件由zstd命令行生成，比如 zstd -19 data-300000 -o l19-300000.zst
+func TestZstdReader(t *testing.T) {
+	cases := map[string][]byte{
+		"l3-0.zst":                nil,
+		"l3-100.zst":              zstdTestData(100),
+		"l1-5000.zst":             zstdTestData(5000),
+		"l19-300000.zst":          zstdTestData(300000),
+		"multiframe-100-5000.zst": append(zstdTestData(100), zstdTestData(5000)...),
+	}
+	for name, want := range cases {
+		compressed, err := ioutil.ReadFile(filepath.Join("testdata", name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := ioutil.ReadAll(NewZstdReader(bytes.NewReader(compressed)))
+		if err != nil {
+			t.Errorf("%s: %v", name, err)
+			continue
+		}
+		if !bytes.Equal(got, want) {
+			t.Errorf("%s: decompressed %d bytes, want %d", name, len(got), len(want))
+		}
+	}
+}
+
+func TestZstdReaderCorrupt(t *testing.T) {
+	compressed, err := ioutil.ReadFile(filepath.Join("testdata", "l19-300000.zst"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 改动最后一个块中的数据，要么解码失败，要么校验和不一致
+	compressed[len(compressed)-100] ^= 0xff
+	if _, err := ioutil.ReadAll(NewZstdReader(bytes.NewReader(compressed))); err == nil {
+		t.Error("corrupted data decompressed without error")
+	}
+	if _, err := ioutil.ReadAll(NewZstdReader(bytes.NewReader(compressed[:len(compressed)/2]))); err == nil {
+		t.Error("truncated data decompressed without error")
+	}
+}
+
+func TestDecompressStream(t *testing.T) {
+	want := zstdTestData(5000)
+	for _, compression := range []Compression{Uncompressed, Gzip, Bzip2, Xz} {
+		var buf bytes.Buffer
+		w, err := CompressStream(&buf, compression)
+		if err != nil {
+			t.Fatal(err)
+		}
+		w.Write(want)
+		w.Close()
+		if got := DetectCompression(buf.Bytes()); got != compression {
+			t.Errorf("detected %s, want %s", got, compression)
+		}
+		r, err := DecompressStream(&buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := ioutil.ReadAll(r)
+		r.Close()
+		if err != nil || !bytes.Equal(got, want) {
+			t.Errorf("%s: read %d bytes error %v", compression, len(got), err)
+		}
+	}
+
+	f, err := os.Open(filepath.Join("testdata", "l1-5000.zst"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	r, err := DecompressStream(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, err := ioutil.ReadAll(r); err != nil || !bytes.Equal(got, want) {
+		t.Errorf("zstd: read %d bytes error %v", len(got), err)
+	}
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"github.com/xianlubird/mydocker/container"
+	"github.com/xianlubird/mydocker/term"
+	"io"
+	"net"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+)
+
+// attachConn 串行化客户端发给shim的消息，标准输入和窗口大小在不同的goroutine中发送
+type attachConn struct {
+	net.Conn
+	mu sync.Mutex
+}
+
+func (c *attachConn) send(kind byte, payload []byte) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return writeAttachFrame(c.Conn, kind, payload)
+}
+
+func (c *attachConn) sendWinsize(fd uintptr) error {
+	ws, err := term.GetWinsize(fd)
+	if err != nil {
+		return err
+	}
+	payload := make([]byte, 4)
+	binary.BigEndian.PutUint16(payload, ws.Height)
+	binary.BigEndian.PutUint16(payload[2:], ws.Width)
+	return c.send(attachResize, payload)
+}
+
+// attachContainer 连接detach容器的shim，输出一直打印到容器退出；
+// run时指定了-i才转发标准输入，输入detach按键序列时断开，容器继续运行
+func attachContainer(containerName, detachKeys string) error {
+	containerInfo, err := inspectContainer(containerName)
+	if err != nil {
+		return err
+	}
+	if containerInfo.Status != container.RUNNING {
+		return fmt.Errorf("container %s is %s, only running container can be attached", containerName, containerInfo.Status)
+	}
+	keys, err := term.ParseDetachKeys(detachKeys)
+	if err != nil {
+		return err
+	}
+	socketPath := attachSocketPath(containerName)
+	c, err := net.Dial("unix", socketPath)
+	if err != nil {
+		// 前台运行(-ti 不带 -d)的容器没有shim
+		return fmt.Errorf("container %s can not be attached: %v", containerName, err)
+	}
+	conn := &attachConn{Conn: c}
+	defer conn.Close()
+
+	stdin := os.Stdin.Fd()
+	if containerInfo.Tty && containerInfo.OpenStdin && term.IsTerminal(stdin) {
+		state, err := term.MakeRaw(stdin)
+		if err != nil {
+			return err
+		}
+		defer term.Restore(stdin, state)
+		conn.sendWinsize(stdin)
+		winch := make(chan os.Signal, 1)
+		signal.Notify(winch, syscall.SIGWINCH)
+		defer signal.Stop(winch)
+		go func() {
+			for range winch {
+				conn.sendWinsize(stdin)
+			}
+		}()
+	}
+
+	outputDone := make(chan struct{})
+	go func() {
+		io.Copy(os.Stdout, conn)
+		close(outputDone)
+	}()
+	if !containerInfo.OpenStdin {
+		<-outputDone
+		return nil
+	}
+
+	inputDone := make(chan error, 1)
+	go func() {
+		input := term.NewEscapeProxy(os.Stdin, keys)
+		buf := make([]byte, 32*1024)
+		for {
+			n, err := input.Read(buf)
+			if n > 0 {
+				if err := conn.send(attachStdin, buf[:n]); err != nil {
+					inputDone <- err
+					return
+				}
+			}
+			if err != nil {
+				inputDone <- err
+				return
+			}
+		}
+	}()
+	select {
+	case <-outputDone:
+	case err := <-inputDone:
+		if err == term.ErrDetached {
+			return nil
+		}
+		// 标准输入结束之后继续打印输出，直到容器退出
+		<-outputDone
+	}
+	return nil
+}
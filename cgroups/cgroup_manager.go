@@ -1,45 +1,137 @@
 package cgroups
 
 import (
-	"github.com/xianlubird/mydocker/cgroups/subsystems"
+	"fmt"
 	"github.com/Sirupsen/logrus"
+	"github.com/xianlubird/mydocker/cgroups/subsystems"
+	"github.com/xianlubird/mydocker/cgroups/systemd"
+	"syscall"
+	"time"
 )
 
+// cgroupDriver 是cgroup的具体实现，v1和v2中cgroup的组织方式和接口文件不一样
+type cgroupDriver interface {
+	Apply(path string, pid int) error
+	Set(path string, res *subsystems.ResourceConfig) error
+	Destroy(path string) error
+	Processes(path string) ([]int, error)
+	Freeze(path string, frozen bool) error
+	OOMKilled(path string) bool
+	// ProcPath 返回 /proc/<pid>/cgroup 中显示的cgroup路径
+	ProcPath(path string) string
+}
+
 type CgroupManager struct {
 	// cgroup在hierarchy中的路径 相当于创建的cgroup目录相对于root cgroup目录的路径
 	Path     string
 	// 资源配置
 	Resource *subsystems.ResourceConfig
+	driver   cgroupDriver
+}
+
+// cgroup驱动的名字，cgroupfs直接读写cgroup文件系统，systemd通过systemd创建scope
+const (
+	CgroupfsDriver      = "cgroupfs"
+	SystemdDriver       = "systemd"
+	DefaultCgroupDriver = CgroupfsDriver
+)
+
+// 全局参数选择的cgroup驱动，新创建的容器使用它
+var cgroupDriverName = DefaultCgroupDriver
+
+// SetCgroupDriver 选择cgroup驱动，连不上systemd时退回到cgroupfs
+func SetCgroupDriver(name string) error {
+	switch name {
+	case CgroupfsDriver:
+	case SystemdDriver:
+		if err := systemd.Available(); err != nil {
+			logrus.Warnf("systemd is not available, use cgroupfs driver: %v", err)
+			name = CgroupfsDriver
+		}
+	default:
+		return fmt.Errorf("unknown cgroup driver %s", name)
+	}
+	cgroupDriverName = name
+	return nil
+}
+
+// CgroupDriver 返回当前选择的cgroup驱动
+func CgroupDriver() string {
+	return cgroupDriverName
 }
 
-func NewCgroupManager(path string) *CgroupManager {
+// NewCgroupManager 创建使用driver驱动的CgroupManager，driver为空时使用当前选择的驱动。
+// cgroupfs驱动根据宿主机的cgroup模式选择实现，只有v2一个hierarchy时用v2，hybrid模式下用v1
+func NewCgroupManager(path string, driver string) *CgroupManager {
+	if driver == "" {
+		driver = cgroupDriverName
+	}
+	var impl cgroupDriver = &fsDriver{}
+	if driver == SystemdDriver {
+		impl = newSystemdDriver()
+	} else if subsystems.IsCgroup2UnifiedMode() {
+		impl = &fs2Driver{root: subsystems.FindCgroup2Mountpoint(), parent: fs2Parent}
+	}
 	return &CgroupManager{
-		Path: path,
+		Path:   path,
+		driver: impl,
 	}
 }
 
+// ProcPath 返回容器的进程在 /proc/<pid>/cgroup 中的路径，用来判断一个进程是不是在这个cgroup中
+func (c *CgroupManager) ProcPath() string {
+	return c.driver.ProcPath(c.Path)
+}
+
 // 将进程pid加入到这个cgroup中
 func (c *CgroupManager) Apply(pid int) error {
-	for _, subSysIns := range(subsystems.SubsystemsIns) {
-		subSysIns.Apply(c.Path, pid)
-	}
-	return nil
+	return c.driver.Apply(c.Path, pid)
 }
 
 // 设置cgroup资源限制
 func (c *CgroupManager) Set(res *subsystems.ResourceConfig) error {
-	for _, subSysIns := range(subsystems.SubsystemsIns) {
-		subSysIns.Set(c.Path, res)
+	return c.driver.Set(c.Path, res)
+}
+
+// 是否有进程因为超过内存限制被杀掉，要在Destroy之前调用
+func (c *CgroupManager) OOMKilled() bool {
+	return c.driver.OOMKilled(c.Path)
+}
+
+// 返回cgroup中的所有进程
+func (c *CgroupManager) Processes() ([]int, error) {
+	return c.driver.Processes(c.Path)
+}
+
+// 冻结或者解冻cgroup中的所有进程
+func (c *CgroupManager) Freeze(frozen bool) error {
+	return c.driver.Freeze(c.Path, frozen)
+}
+
+// 用SIGKILL杀掉cgroup中的所有进程，包括exec进去的进程。
+// 先冻结cgroup，杀的时候进程不能再fork；冻结的进程解冻之后才会处理SIGKILL
+func (c *CgroupManager) Kill() error {
+	for i := 0; i < 10; i++ {
+		frozen := c.Freeze(true) == nil
+		pids, err := c.Processes()
+		for _, pid := range pids {
+			syscall.Kill(pid, syscall.SIGKILL)
+		}
+		if frozen {
+			c.Freeze(false)
+		}
+		if err != nil {
+			return err
+		}
+		if len(pids) == 0 {
+			return nil
+		}
+		time.Sleep(100 * time.Millisecond)
 	}
-	return nil
+	return fmt.Errorf("cgroup %s still has processes after kill", c.Path)
 }
 
 //释放cgroup
 func (c *CgroupManager) Destroy() error {
-	for _, subSysIns := range(subsystems.SubsystemsIns) {
-		if err := subSysIns.Remove(c.Path); err != nil {
-			logrus.Warnf("remove cgroup fail %v", err)
-		}
-	}
-	return nil
+	return c.driver.Destroy(c.Path)
 }
@@ -0,0 +1,81 @@
+package cgroups
+
+import (
+	"fmt"
+	"os"
+	"path"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/xianlubird/mydocker/cgroups/subsystems"
+)
+
+// fsDriver 操作cgroup v1，每个subsystem在自己的hierarchy中都有一个同名的cgroup
+type fsDriver struct{}
+
+// Apply 把进程加入每个hierarchy中已经创建的cgroup，hierarchy没有挂载或者Set时没有创建cgroup的跳过
+func (d *fsDriver) Apply(cgroupPath string, pid int) error {
+	for _, subSysIns := range subsystems.SubsystemsIns {
+		if err := subSysIns.Apply(cgroupPath, pid); err != nil {
+			root := subsystems.FindCgroupMountpoint(subSysIns.Name())
+			if _, statErr := os.Stat(path.Join(root, cgroupPath)); root == "" || os.IsNotExist(statErr) {
+				logrus.Debugf("apply cgroup %s error %v", subSysIns.Name(), err)
+				continue
+			}
+			return fmt.Errorf("apply cgroup %s error %v", subSysIns.Name(), err)
+		}
+	}
+	return nil
+}
+
+func (d *fsDriver) Set(cgroupPath string, res *subsystems.ResourceConfig) error {
+	for _, subSysIns := range subsystems.SubsystemsIns {
+		if err := subSysIns.Set(cgroupPath, res); err != nil {
+			// 设置了的限制设不上时不能让容器在没有限制的情况下运行，比如设备白名单不生效时容器可以访问所有设备。
+			// 没有用到的subsystem没有挂载不影响运行
+			if subsystems.Used(subSysIns.Name(), res) {
+				return fmt.Errorf("set cgroup %s error %v", subSysIns.Name(), err)
+			}
+			logrus.Debugf("set cgroup %s error %v", subSysIns.Name(), err)
+		}
+	}
+	return nil
+}
+
+// 已经不存在的cgroup跳过，systemd管理的hierarchy中的cgroup由systemd删除
+func (d *fsDriver) Destroy(cgroupPath string) error {
+	for _, subSysIns := range subsystems.SubsystemsIns {
+		if _, err := os.Stat(path.Join(subsystems.FindCgroupMountpoint(subSysIns.Name()), cgroupPath)); os.IsNotExist(err) {
+			continue
+		}
+		if err := subSysIns.Remove(cgroupPath); err != nil {
+			logrus.Warnf("remove cgroup fail %v", err)
+		}
+	}
+	return nil
+}
+
+// 各个subsystem中的进程是一样的，读第一个存在的就可以
+func (d *fsDriver) Processes(cgroupPath string) ([]int, error) {
+	var lastErr error
+	for _, subSysIns := range subsystems.SubsystemsIns {
+		pids, err := subsystems.CgroupProcs(subSysIns.Name(), cgroupPath)
+		if err == nil {
+			return pids, nil
+		}
+		lastErr = err
+	}
+	return nil, lastErr
+}
+
+func (d *fsDriver) Freeze(cgroupPath string, frozen bool) error {
+	return subsystems.Freeze(cgroupPath, frozen)
+}
+
+func (d *fsDriver) OOMKilled(cgroupPath string) bool {
+	return subsystems.OOMKillCount(cgroupPath) > 0
+}
+
+// ProcPath v1中每个hierarchy里的路径都一样
+func (d *fsDriver) ProcPath(cgroupPath string) string {
+	return path.Join("/", cgroupPath)
+}
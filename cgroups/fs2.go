@@ -0,0 +1,112 @@
+package cgroups
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/xianlubird/mydocker/cgroups/subsystems"
+)
+
+// 所有容器的cgroup都放在这个父cgroup下面。v2中有进程的cgroup不能再给子cgroup启用controller，
+// 父cgroup中始终没有进程
+const fs2Parent = "mydocker"
+
+// fs2Driver 操作cgroup v2，只有一个hierarchy，容器的cgroup是 root/parent/<id>
+type fs2Driver struct {
+	root   string
+	parent string
+}
+
+func (d *fs2Driver) dir(cgroupPath string) string {
+	return path.Join(d.root, d.parent, cgroupPath)
+}
+
+// enableControllers 子cgroup只能使用父cgroup在cgroup.subtree_control中启用了的controller，
+// dir的cgroup.controllers中是它自己可以使用的controller
+func enableControllers(dir string) {
+	content, err := ioutil.ReadFile(path.Join(dir, "cgroup.controllers"))
+	if err != nil {
+		logrus.Warnf("read cgroup.controllers error %v", err)
+		return
+	}
+	available := map[string]bool{}
+	for _, controller := range strings.Fields(string(content)) {
+		available[controller] = true
+	}
+	for _, subSysIns := range subsystems.SubsystemsIns {
+		controller := subSysIns.Controller()
+		if controller == "" {
+			continue
+		}
+		if !available[controller] {
+			// 没有用到的controller不可用不影响运行，用到时SetUnified写不了接口文件，Set会返回错误
+			logrus.Debugf("cgroup v2 controller %s is not available in %s", controller, dir)
+			continue
+		}
+		if err := ioutil.WriteFile(path.Join(dir, "cgroup.subtree_control"), []byte("+"+controller), 0644); err != nil {
+			logrus.Warnf("enable cgroup v2 controller %s in %s error %v", controller, dir, err)
+		}
+	}
+}
+
+func (d *fs2Driver) Set(cgroupPath string, res *subsystems.ResourceConfig) error {
+	// parent为空时cgroup由systemd创建，controller也由systemd启用
+	if d.parent != "" {
+		parent := path.Join(d.root, d.parent)
+		if err := os.Mkdir(parent, 0755); err != nil && !os.IsExist(err) {
+			return fmt.Errorf("error create cgroup %v", err)
+		}
+		enableControllers(d.root)
+		enableControllers(parent)
+	}
+	dir := d.dir(cgroupPath)
+	if err := os.Mkdir(dir, 0755); err != nil && !os.IsExist(err) {
+		return fmt.Errorf("error create cgroup %v", err)
+	}
+	if res == nil {
+		return nil
+	}
+	// SetUnified只处理res中设置了的限制，出错说明限制设不上，不能让容器在没有限制的情况下运行
+	for _, subSysIns := range subsystems.SubsystemsIns {
+		if err := subSysIns.SetUnified(dir, res); err != nil {
+			return fmt.Errorf("set cgroup %s error %v", subSysIns.Name(), err)
+		}
+	}
+	return nil
+}
+
+func (d *fs2Driver) Apply(cgroupPath string, pid int) error {
+	if err := ioutil.WriteFile(path.Join(d.dir(cgroupPath), "cgroup.procs"), []byte(strconv.Itoa(pid)), 0644); err != nil {
+		return fmt.Errorf("set cgroup proc fail %v", err)
+	}
+	return nil
+}
+
+// Destroy cgroup中没有进程之后才能删除，目录中的接口文件不需要也不能删除
+func (d *fs2Driver) Destroy(cgroupPath string) error {
+	if err := os.Remove(d.dir(cgroupPath)); err != nil && !os.IsNotExist(err) {
+		logrus.Warnf("remove cgroup fail %v", err)
+	}
+	return nil
+}
+
+func (d *fs2Driver) Processes(cgroupPath string) ([]int, error) {
+	return subsystems.ReadCgroupProcs(d.dir(cgroupPath))
+}
+
+func (d *fs2Driver) Freeze(cgroupPath string, frozen bool) error {
+	return subsystems.FreezeUnified(d.dir(cgroupPath), frozen)
+}
+
+func (d *fs2Driver) OOMKilled(cgroupPath string) bool {
+	return subsystems.OOMKillCountUnified(d.dir(cgroupPath)) > 0
+}
+
+func (d *fs2Driver) ProcPath(cgroupPath string) string {
+	return path.Join("/", d.parent, cgroupPath)
+}
@@ -0,0 +1,74 @@
+package cgroups
+
+import (
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path"
+	"strings"
+	"testing"
+
+	"github.com/xianlubird/mydocker/cgroups/subsystems"
+)
+
+func TestFs2Driver(t *testing.T) {
+	root := subsystems.FindCgroup2Mountpoint()
+	if root == "" {
+		t.Skip("cgroup2 is not mounted")
+	}
+	driver := &fs2Driver{root: root, parent: fs2Parent}
+	testCgroup := "testfs2"
+	if err := driver.Set(testCgroup, &subsystems.ResourceConfig{}); err != nil {
+		t.Fatalf("cgroup fail %v", err)
+	}
+	defer driver.Destroy(testCgroup)
+	if _, err := os.Stat(path.Join(root, fs2Parent, testCgroup, "cgroup.procs")); err != nil {
+		t.Fatalf("cgroup not created %v", err)
+	}
+
+	cmd := exec.Command("sleep", "10")
+	if err := cmd.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer cmd.Wait()
+	defer cmd.Process.Kill()
+	if err := driver.Apply(testCgroup, cmd.Process.Pid); err != nil {
+		t.Fatalf("cgroup Apply %v", err)
+	}
+	pids, err := driver.Processes(testCgroup)
+	if err != nil || len(pids) != 1 || pids[0] != cmd.Process.Pid {
+		t.Fatalf("processes %v %v, want [%d]", pids, err, cmd.Process.Pid)
+	}
+	for _, frozen := range []bool{true, false} {
+		if err := driver.Freeze(testCgroup, frozen); err != nil {
+			t.Fatalf("freeze %v: %v", frozen, err)
+		}
+	}
+	if driver.OOMKilled(testCgroup) {
+		t.Errorf("unexpected oom kill")
+	}
+}
+
+func TestFs2DriverUnavailableController(t *testing.T) {
+	root := subsystems.FindCgroup2Mountpoint()
+	if root == "" {
+		t.Skip("cgroup2 is not mounted")
+	}
+	driver := &fs2Driver{root: root, parent: fs2Parent}
+	testCgroup := "testfs2controller"
+	if err := driver.Set(testCgroup, &subsystems.ResourceConfig{}); err != nil {
+		t.Fatalf("cgroup fail %v", err)
+	}
+	defer driver.Destroy(testCgroup)
+	content, err := ioutil.ReadFile(path.Join(root, fs2Parent, testCgroup, "cgroup.controllers"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(content), "pids") {
+		t.Skip("pids controller is available")
+	}
+	// 用到了不可用的controller时不能只打警告
+	if err := driver.Set(testCgroup, &subsystems.ResourceConfig{PidsLimit: "10"}); err == nil {
+		t.Fatalf("expect error when pids controller is not available")
+	}
+}
@@ -0,0 +1,64 @@
+package cgroups
+
+import (
+	"os/exec"
+	"testing"
+
+	"github.com/xianlubird/mydocker/cgroups/subsystems"
+)
+
+func TestFsDriverDevices(t *testing.T) {
+	if subsystems.FindCgroupMountpoint("devices") == "" {
+		t.Skip("devices cgroup is not mounted")
+	}
+	driver := &fsDriver{}
+	testCgroup := "testfsdevices"
+	defer driver.Destroy(testCgroup)
+	if err := driver.Set(testCgroup, &subsystems.ResourceConfig{Devices: []string{"c 1:3 rwm"}}); err != nil {
+		t.Fatalf("set devices %v", err)
+	}
+	// 白名单写不进去时不能当作没有限制继续运行
+	if err := driver.Set(testCgroup, &subsystems.ResourceConfig{Devices: []string{"x 1:3 rwm"}}); err == nil {
+		t.Fatalf("expect error for invalid device rule")
+	}
+}
+
+func TestFsDriverApply(t *testing.T) {
+	if subsystems.FindCgroupMountpoint("cpuset") == "" {
+		t.Skip("cpuset cgroup is not mounted")
+	}
+	driver := &fsDriver{}
+	testCgroup := "testfsapply/nested"
+	defer driver.Destroy("testfsapply")
+	defer driver.Destroy(testCgroup)
+	if err := driver.Set(testCgroup, &subsystems.ResourceConfig{}); err != nil {
+		t.Fatalf("set %v", err)
+	}
+	cmd := exec.Command("sleep", "10")
+	if err := cmd.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer cmd.Wait()
+	defer cmd.Process.Kill()
+	// 没有设置cpuset时也要能加入cpuset的cgroup，cpus和mems从父cgroup继承
+	if err := driver.Apply(testCgroup, cmd.Process.Pid); err != nil {
+		t.Fatalf("apply %v", err)
+	}
+	pids, err := subsystems.CgroupProcs("cpuset", testCgroup)
+	if err != nil || len(pids) != 1 || pids[0] != cmd.Process.Pid {
+		t.Fatalf("cpuset processes %v %v, want [%d]", pids, err, cmd.Process.Pid)
+	}
+}
+
+func TestFsDriverUnmountedSubsystem(t *testing.T) {
+	if subsystems.FindCgroupMountpoint("hugetlb") != "" {
+		t.Skip("hugetlb cgroup is mounted")
+	}
+	driver := &fsDriver{}
+	testCgroup := "testfshugetlb"
+	defer driver.Destroy(testCgroup)
+	// 设置了限制的subsystem没有挂载时要报错
+	if err := driver.Set(testCgroup, &subsystems.ResourceConfig{HugetlbLimits: []string{"2MB 1048576"}}); err == nil {
+		t.Fatalf("expect error when hugetlb cgroup is not mounted")
+	}
+}
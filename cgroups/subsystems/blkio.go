@@ -0,0 +1,121 @@
+package subsystems
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+)
+
+// BlkioSubSystem 设置块设备IO的权重和每个设备的读写速率限制，v2中对应io controller
+type BlkioSubSystem struct {
+}
+
+func (s *BlkioSubSystem) Set(cgroupPath string, res *ResourceConfig) error {
+	subsysCgroupPath, err := GetCgroupPath(s.Name(), cgroupPath, true)
+	if err != nil {
+		return err
+	}
+	// CFQ调度器的权重是blkio.weight，BFQ是blkio.bfq.weight，都是10到1000
+	if res.BlkioWeight != "" {
+		if err := writeFirstExisting(subsysCgroupPath, res.BlkioWeight, "blkio.weight", "blkio.bfq.weight"); err != nil {
+			return fmt.Errorf("set cgroup blkio weight fail %v", err)
+		}
+	}
+	// 每个设备写一行，格式是 "major:minor bytes"
+	for _, rule := range res.DeviceReadBps {
+		if err := ioutil.WriteFile(path.Join(subsysCgroupPath, "blkio.throttle.read_bps_device"), []byte(rule), 0644); err != nil {
+			return fmt.Errorf("set cgroup device read bps %s fail %v", rule, err)
+		}
+	}
+	for _, rule := range res.DeviceWriteBps {
+		if err := ioutil.WriteFile(path.Join(subsysCgroupPath, "blkio.throttle.write_bps_device"), []byte(rule), 0644); err != nil {
+			return fmt.Errorf("set cgroup device write bps %s fail %v", rule, err)
+		}
+	}
+	return nil
+}
+
+func (s *BlkioSubSystem) Remove(cgroupPath string) error {
+	if subsysCgroupPath, err := GetCgroupPath(s.Name(), cgroupPath, false); err == nil {
+		return os.RemoveAll(subsysCgroupPath)
+	} else {
+		return err
+	}
+}
+
+func (s *BlkioSubSystem) Apply(cgroupPath string, pid int) error {
+	if subsysCgroupPath, err := GetCgroupPath(s.Name(), cgroupPath, false); err == nil {
+		if err := ioutil.WriteFile(path.Join(subsysCgroupPath, "tasks"), []byte(strconv.Itoa(pid)), 0644); err != nil {
+			return fmt.Errorf("set cgroup proc fail %v", err)
+		}
+		return nil
+	} else {
+		return fmt.Errorf("get cgroup %s error: %v", cgroupPath, err)
+	}
+}
+
+func (s *BlkioSubSystem) Name() string {
+	return "blkio"
+}
+
+func (s *BlkioSubSystem) Controller() string {
+	return "io"
+}
+
+// SetUnified io.weight的范围是1到10000，要从blkio的权重换算；io.bfq.weight和v1一样。
+// 速率限制写到io.max中，比如 "8:0 rbps=10485760"
+func (s *BlkioSubSystem) SetUnified(dir string, res *ResourceConfig) error {
+	if res.BlkioWeight != "" {
+		weight, err := strconv.ParseUint(res.BlkioWeight, 10, 64)
+		if err != nil {
+			return fmt.Errorf("invalid blkio weight %s", res.BlkioWeight)
+		}
+		err = ioutil.WriteFile(path.Join(dir, "io.weight"), []byte("default "+strconv.FormatUint(BlkioWeightToIOWeight(weight), 10)), 0644)
+		if os.IsNotExist(err) {
+			err = ioutil.WriteFile(path.Join(dir, "io.bfq.weight"), []byte(res.BlkioWeight), 0644)
+		}
+		if err != nil {
+			return fmt.Errorf("set cgroup io weight fail %v", err)
+		}
+	}
+	for _, limit := range []struct {
+		key   string
+		rules []string
+	}{{"rbps", res.DeviceReadBps}, {"wbps", res.DeviceWriteBps}} {
+		for _, rule := range limit.rules {
+			fields := strings.Fields(rule)
+			if len(fields) != 2 {
+				return fmt.Errorf("invalid device throttle %s", rule)
+			}
+			if err := ioutil.WriteFile(path.Join(dir, "io.max"), []byte(fmt.Sprintf("%s %s=%s", fields[0], limit.key, fields[1])), 0644); err != nil {
+				return fmt.Errorf("set cgroup io max %s fail %v", rule, err)
+			}
+		}
+	}
+	return nil
+}
+
+// BlkioWeightToIOWeight 把blkio的权重 [10, 1000] 线性映射到io.weight [1, 10000]
+func BlkioWeightToIOWeight(weight uint64) uint64 {
+	if weight < 10 {
+		weight = 10
+	}
+	if weight > 1000 {
+		weight = 1000
+	}
+	return 1 + (weight-10)*9999/990
+}
+
+// writeFirstExisting 写入files中第一个存在的文件，不同内核或者IO调度器提供的文件不一样
+func writeFirstExisting(dir, value string, files ...string) error {
+	for _, file := range files {
+		if _, err := os.Stat(path.Join(dir, file)); err != nil {
+			continue
+		}
+		return ioutil.WriteFile(path.Join(dir, file), []byte(value), 0644)
+	}
+	return fmt.Errorf("none of %s exists", strings.Join(files, ", "))
+}
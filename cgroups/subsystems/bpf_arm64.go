@@ -0,0 +1,4 @@
+package subsystems
+
+// bpf系统调用号，syscall包中没有
+const sysBpf = 280
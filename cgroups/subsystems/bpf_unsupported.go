@@ -0,0 +1,7 @@
+//go:build !amd64 && !arm64
+// +build !amd64,!arm64
+
+package subsystems
+
+// 其他架构还没有bpf的系统调用号，AttachDeviceFilter会返回错误
+const sysBpf = -1
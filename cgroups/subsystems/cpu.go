@@ -17,6 +17,17 @@ func (s *CpuSubSystem) Set(cgroupPath string, res *ResourceConfig) error {
 				return fmt.Errorf("set cgroup cpu share fail %v", err)
 			}
 		}
+		// 先设置周期，quota不能超过周期允许的范围
+		if res.CpuPeriod != "" {
+			if err := ioutil.WriteFile(path.Join(subsysCgroupPath, "cpu.cfs_period_us"), []byte(res.CpuPeriod), 0644); err != nil {
+				return fmt.Errorf("set cgroup cpu period fail %v", err)
+			}
+		}
+		if res.CpuQuota != "" {
+			if err := ioutil.WriteFile(path.Join(subsysCgroupPath, "cpu.cfs_quota_us"), []byte(res.CpuQuota), 0644); err != nil {
+				return fmt.Errorf("set cgroup cpu quota fail %v", err)
+			}
+		}
 		return nil
 	} else {
 		return err
@@ -46,3 +57,49 @@ func (s *CpuSubSystem) Name() string {
 	return "cpu"
 }
 
+func (s *CpuSubSystem) Controller() string {
+	return "cpu"
+}
+
+// SetUnified v2中cpu.shares换算成cpu.weight，quota和period合并成cpu.max
+func (s *CpuSubSystem) SetUnified(dir string, res *ResourceConfig) error {
+	if res.CpuShare != "" {
+		shares, err := strconv.ParseUint(res.CpuShare, 10, 64)
+		if err != nil {
+			return fmt.Errorf("invalid cpu share %s", res.CpuShare)
+		}
+		if err := ioutil.WriteFile(path.Join(dir, "cpu.weight"), []byte(strconv.FormatUint(CpuSharesToWeight(shares), 10)), 0644); err != nil {
+			return fmt.Errorf("set cgroup cpu weight fail %v", err)
+		}
+	}
+	if res.CpuQuota != "" || res.CpuPeriod != "" {
+		if err := ioutil.WriteFile(path.Join(dir, "cpu.max"), []byte(cpuMax(res.CpuQuota, res.CpuPeriod)), 0644); err != nil {
+			return fmt.Errorf("set cgroup cpu max fail %v", err)
+		}
+	}
+	return nil
+}
+
+// CpuSharesToWeight 把v1的shares [2, 262144] 线性映射到v2的weight [1, 10000]，和runc的换算一样。
+// 线性映射下默认的1024对应39，而不是v2默认的100
+func CpuSharesToWeight(shares uint64) uint64 {
+	if shares < 2 {
+		shares = 2
+	}
+	if shares > 262144 {
+		shares = 262144
+	}
+	return 1 + (shares-2)*9999/262142
+}
+
+// cpuMax 生成cpu.max的内容 "$QUOTA $PERIOD"，quota为空或者-1时是max，period默认100000
+func cpuMax(quota, period string) string {
+	if quota == "" || quota == "-1" {
+		quota = "max"
+	}
+	if period == "" {
+		period = "100000"
+	}
+	return quota + " " + period
+}
+
@@ -6,6 +6,7 @@ import(
 	"path"
 	"os"
 	"strconv"
+	"strings"
 )
 
 type CpusetSubSystem struct {
@@ -14,6 +15,9 @@ type CpusetSubSystem struct {
 
 func (s *CpusetSubSystem) Set(cgroupPath string, res *ResourceConfig) error {
 	if subsysCgroupPath, err := GetCgroupPath(s.Name(), cgroupPath, true); err == nil {
+		if err := inheritCpuset(subsysCgroupPath, FindCgroupMountpoint(s.Name())); err != nil {
+			return err
+		}
 		if res.CpuSet != "" {
 			if err := ioutil.WriteFile(path.Join(subsysCgroupPath, "cpuset.cpus"), []byte(res.CpuSet), 0644); err != nil {
 				return fmt.Errorf("set cgroup cpuset fail %v", err)
@@ -46,6 +50,47 @@ func (s *CpusetSubSystem)Apply(cgroupPath string, pid int) error {
 }
 
 
+func (s *CpusetSubSystem) Controller() string {
+	return "cpuset"
+}
+
+func (s *CpusetSubSystem) SetUnified(dir string, res *ResourceConfig) error {
+	if res.CpuSet != "" {
+		if err := ioutil.WriteFile(path.Join(dir, "cpuset.cpus"), []byte(res.CpuSet), 0644); err != nil {
+			return fmt.Errorf("set cgroup cpuset fail %v", err)
+		}
+	}
+	return nil
+}
+
 func (s *CpusetSubSystem) Name() string {
 	return "cpuset"
 }
+
+// inheritCpuset 新建的cpuset cgroup中cpus和mems是空的，进程加不进去，从父cgroup复制过来。
+// 中间自动创建的父cgroup也是空的，要先从上往下处理
+func inheritCpuset(dir, root string) error {
+	if dir == root || !strings.HasPrefix(dir, root) {
+		return nil
+	}
+	if err := inheritCpuset(path.Dir(dir), root); err != nil {
+		return err
+	}
+	for _, file := range []string{"cpuset.cpus", "cpuset.mems"} {
+		content, err := ioutil.ReadFile(path.Join(dir, file))
+		if err != nil {
+			return fmt.Errorf("read %s error %v", file, err)
+		}
+		if strings.TrimSpace(string(content)) != "" {
+			continue
+		}
+		parent, err := ioutil.ReadFile(path.Join(path.Dir(dir), file))
+		if err != nil {
+			return fmt.Errorf("read %s error %v", file, err)
+		}
+		if err := ioutil.WriteFile(path.Join(dir, file), parent, 0644); err != nil {
+			return fmt.Errorf("set cgroup %s fail %v", file, err)
+		}
+	}
+	return nil
+}
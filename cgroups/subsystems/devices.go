@@ -0,0 +1,68 @@
+package subsystems
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"strconv"
+)
+
+type DevicesSubSystem struct {
+}
+
+// Set 先拒绝所有设备，再逐条放行白名单中的规则
+func (s *DevicesSubSystem) Set(cgroupPath string, res *ResourceConfig) error {
+	subsysCgroupPath, err := GetCgroupPath(s.Name(), cgroupPath, true)
+	if err != nil {
+		return err
+	}
+	if res.Devices == nil {
+		return nil
+	}
+	if err := ioutil.WriteFile(path.Join(subsysCgroupPath, "devices.deny"), []byte("a"), 0644); err != nil {
+		return fmt.Errorf("set cgroup devices deny fail %v", err)
+	}
+	for _, rule := range res.Devices {
+		if err := ioutil.WriteFile(path.Join(subsysCgroupPath, "devices.allow"), []byte(rule), 0644); err != nil {
+			return fmt.Errorf("set cgroup devices allow %s fail %v", rule, err)
+		}
+	}
+	return nil
+}
+
+func (s *DevicesSubSystem) Remove(cgroupPath string) error {
+	if subsysCgroupPath, err := GetCgroupPath(s.Name(), cgroupPath, false); err == nil {
+		return os.RemoveAll(subsysCgroupPath)
+	} else {
+		return err
+	}
+}
+
+func (s *DevicesSubSystem) Apply(cgroupPath string, pid int) error {
+	if subsysCgroupPath, err := GetCgroupPath(s.Name(), cgroupPath, false); err == nil {
+		if err := ioutil.WriteFile(path.Join(subsysCgroupPath, "tasks"), []byte(strconv.Itoa(pid)), 0644); err != nil {
+			return fmt.Errorf("set cgroup proc fail %v", err)
+		}
+		return nil
+	} else {
+		return fmt.Errorf("get cgroup %s error: %v", cgroupPath, err)
+	}
+}
+
+func (s *DevicesSubSystem) Name() string {
+	return "devices"
+}
+
+// Controller v2没有devices controller，设备访问控制要挂载eBPF程序
+func (s *DevicesSubSystem) Controller() string {
+	return ""
+}
+
+// SetUnified 把白名单编译成BPF_PROG_TYPE_CGROUP_DEVICE程序挂到容器的cgroup上
+func (s *DevicesSubSystem) SetUnified(dir string, res *ResourceConfig) error {
+	if res.Devices == nil {
+		return nil
+	}
+	return AttachDeviceFilter(dir, res.Devices)
+}
@@ -0,0 +1,236 @@
+package subsystems
+
+import (
+	"encoding/binary"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"syscall"
+	"unsafe"
+)
+
+// cgroup v2没有devices.allow，设备访问由挂在cgroup上的BPF_PROG_TYPE_CGROUP_DEVICE程序决定。
+// 程序的输入是 struct bpf_cgroup_dev_ctx { u32 access_type; u32 major; u32 minor; }，
+// access_type的低16位是设备类型，高16位是访问方式，返回1允许，返回0拒绝
+const (
+	bpfProgLoad   = 5
+	bpfProgAttach = 8
+
+	bpfProgTypeCgroupDevice = 15
+	bpfCgroupDevice         = 6
+	bpfFAllowMulti          = 2
+
+	devcgDevBlock = 1
+	devcgDevChar  = 2
+
+	devcgAccMknod = 1
+	devcgAccRead  = 2
+	devcgAccWrite = 4
+)
+
+// eBPF指令的操作码，参考 linux/bpf.h 和 linux/bpf_common.h
+const (
+	opLdxMemW  = 0x61 // BPF_LDX | BPF_MEM | BPF_W
+	opAnd32Imm = 0x54 // BPF_ALU | BPF_AND | BPF_K
+	opRsh32Imm = 0x74 // BPF_ALU | BPF_RSH | BPF_K
+	opMov64Reg = 0xbf // BPF_ALU64 | BPF_MOV | BPF_X
+	opMov64Imm = 0xb7 // BPF_ALU64 | BPF_MOV | BPF_K
+	opJneImm   = 0x55 // BPF_JMP | BPF_JNE | BPF_K
+	opJneReg   = 0x5d // BPF_JMP | BPF_JNE | BPF_X
+	opExit     = 0x95 // BPF_JMP | BPF_EXIT
+)
+
+// 寄存器：r1是ctx，r2设备类型，r3访问方式，r4 major，r5 minor
+const (
+	r0 = iota
+	r1
+	r2
+	r3
+	r4
+	r5
+)
+
+// bpfInsn 对应 struct bpf_insn
+type bpfInsn struct {
+	Code uint8
+	Regs uint8 // 低4位是dst，高4位是src
+	Off  int16
+	Imm  int32
+}
+
+func insn(code uint8, dst, src uint8, off int16, imm int32) bpfInsn {
+	return bpfInsn{Code: code, Regs: dst | src<<4, Off: off, Imm: imm}
+}
+
+// deviceRule 是一条 "c 1:3 rwm" 这样的白名单规则，major和minor为-1表示任意
+type deviceRule struct {
+	typ    int32
+	major  int64
+	minor  int64
+	access int32
+}
+
+func parseDeviceRule(rule string) (deviceRule, error) {
+	fields := strings.Fields(rule)
+	if len(fields) != 3 {
+		return deviceRule{}, fmt.Errorf("invalid device rule %s", rule)
+	}
+	var r deviceRule
+	switch fields[0] {
+	case "a":
+		r.typ = 0
+	case "b":
+		r.typ = devcgDevBlock
+	case "c":
+		r.typ = devcgDevChar
+	default:
+		return deviceRule{}, fmt.Errorf("invalid device type in rule %s", rule)
+	}
+	numbers := strings.Split(fields[1], ":")
+	if len(numbers) != 2 {
+		return deviceRule{}, fmt.Errorf("invalid device number in rule %s", rule)
+	}
+	for i, n := range numbers {
+		value := int64(-1)
+		if n != "*" {
+			v, err := strconv.ParseUint(n, 10, 32)
+			if err != nil {
+				return deviceRule{}, fmt.Errorf("invalid device number in rule %s", rule)
+			}
+			value = int64(v)
+		}
+		if i == 0 {
+			r.major = value
+		} else {
+			r.minor = value
+		}
+	}
+	for _, c := range fields[2] {
+		switch c {
+		case 'r':
+			r.access |= devcgAccRead
+		case 'w':
+			r.access |= devcgAccWrite
+		case 'm':
+			r.access |= devcgAccMknod
+		default:
+			return deviceRule{}, fmt.Errorf("invalid device access in rule %s", rule)
+		}
+	}
+	return r, nil
+}
+
+// DeviceFilter 把白名单编译成eBPF程序，匹配任意一条规则就允许，都不匹配时拒绝
+func DeviceFilter(rules []string) ([]bpfInsn, error) {
+	prog := []bpfInsn{
+		insn(opLdxMemW, r2, r1, 0, 0),
+		insn(opAnd32Imm, r2, 0, 0, 0xffff),
+		insn(opLdxMemW, r3, r1, 0, 0),
+		insn(opRsh32Imm, r3, 0, 0, 16),
+		insn(opLdxMemW, r4, r1, 4, 0),
+		insn(opLdxMemW, r5, r1, 8, 0),
+	}
+	for _, rule := range rules {
+		r, err := parseDeviceRule(rule)
+		if err != nil {
+			return nil, err
+		}
+		// 不匹配时跳过这条规则，跳转的偏移等整条规则生成之后再填
+		var block []bpfInsn
+		var jumps []int
+		if r.typ != 0 {
+			jumps = append(jumps, len(block))
+			block = append(block, insn(opJneImm, r2, 0, 0, r.typ))
+		}
+		// 请求的访问方式都在规则允许的范围内才匹配
+		block = append(block, insn(opMov64Reg, r1, r3, 0, 0), insn(opAnd32Imm, r1, 0, 0, r.access))
+		jumps = append(jumps, len(block))
+		block = append(block, insn(opJneReg, r1, r3, 0, 0))
+		if r.major >= 0 {
+			jumps = append(jumps, len(block))
+			block = append(block, insn(opJneImm, r4, 0, 0, int32(r.major)))
+		}
+		if r.minor >= 0 {
+			jumps = append(jumps, len(block))
+			block = append(block, insn(opJneImm, r5, 0, 0, int32(r.minor)))
+		}
+		block = append(block, insn(opMov64Imm, r0, 0, 0, 1), insn(opExit, 0, 0, 0, 0))
+		for _, i := range jumps {
+			block[i].Off = int16(len(block) - i - 1)
+		}
+		prog = append(prog, block...)
+	}
+	return append(prog, insn(opMov64Imm, r0, 0, 0, 0), insn(opExit, 0, 0, 0, 0)), nil
+}
+
+// bpfProgLoadAttr 对应 union bpf_attr 中BPF_PROG_LOAD用到的部分
+type bpfProgLoadAttr struct {
+	ProgType    uint32
+	InsnCnt     uint32
+	Insns       uint64
+	License     uint64
+	LogLevel    uint32
+	LogSize     uint32
+	LogBuf      uint64
+	KernVersion uint32
+	ProgFlags   uint32
+}
+
+// bpfProgAttachAttr 对应 union bpf_attr 中BPF_PROG_ATTACH用到的部分
+type bpfProgAttachAttr struct {
+	TargetFd    uint32
+	AttachBpfFd uint32
+	AttachType  uint32
+	AttachFlags uint32
+}
+
+// AttachDeviceFilter 把白名单编译成eBPF程序挂到cgroup目录dir上。
+// 用BPF_F_ALLOW_MULTI挂载，父cgroup上已有的程序仍然生效
+func AttachDeviceFilter(dir string, rules []string) error {
+	if sysBpf < 0 {
+		return fmt.Errorf("device filter is not supported on this architecture")
+	}
+	prog, err := DeviceFilter(rules)
+	if err != nil {
+		return err
+	}
+	buf := make([]byte, 0, len(prog)*8)
+	for _, i := range prog {
+		buf = append(buf, i.Code, i.Regs)
+		buf = binary.LittleEndian.AppendUint16(buf, uint16(i.Off))
+		buf = binary.LittleEndian.AppendUint32(buf, uint32(i.Imm))
+	}
+	license := []byte("GPL\x00")
+	logBuf := make([]byte, 4096)
+	load := bpfProgLoadAttr{
+		ProgType: bpfProgTypeCgroupDevice,
+		InsnCnt:  uint32(len(prog)),
+		Insns:    uint64(uintptr(unsafe.Pointer(&buf[0]))),
+		License:  uint64(uintptr(unsafe.Pointer(&license[0]))),
+		LogLevel: 1,
+		LogSize:  uint32(len(logBuf)),
+		LogBuf:   uint64(uintptr(unsafe.Pointer(&logBuf[0]))),
+	}
+	progFd, _, errno := syscall.Syscall(uintptr(sysBpf), bpfProgLoad, uintptr(unsafe.Pointer(&load)), unsafe.Sizeof(load))
+	if errno != 0 {
+		return fmt.Errorf("load device filter error %v: %s", errno, strings.TrimRight(string(logBuf), "\x00"))
+	}
+	defer syscall.Close(int(progFd))
+
+	cgroup, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer cgroup.Close()
+	attach := bpfProgAttachAttr{
+		TargetFd:    uint32(cgroup.Fd()),
+		AttachBpfFd: uint32(progFd),
+		AttachType:  bpfCgroupDevice,
+		AttachFlags: bpfFAllowMulti,
+	}
+	if _, _, errno := syscall.Syscall(uintptr(sysBpf), bpfProgAttach, uintptr(unsafe.Pointer(&attach)), unsafe.Sizeof(attach)); errno != 0 {
+		return fmt.Errorf("attach device filter to %s error %v", dir, errno)
+	}
+	return nil
+}
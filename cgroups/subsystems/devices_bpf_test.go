@@ -0,0 +1,74 @@
+package subsystems
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"strconv"
+	"testing"
+)
+
+func TestDeviceFilter(t *testing.T) {
+	prog, err := DeviceFilter([]string{"c 1:3 rwm", "b *:* m"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 6条prologue，第一条规则8条，第二条规则6条，最后拒绝2条
+	if len(prog) != 22 {
+		t.Fatalf("expect 22 instructions, got %d", len(prog))
+	}
+	// 第一条规则中每个跳转都跳到第二条规则的开头
+	for i := 6; i < 14; i++ {
+		if prog[i].Code == opJneImm || prog[i].Code == opJneReg {
+			if target := i + 1 + int(prog[i].Off); target != 14 {
+				t.Fatalf("instruction %d jumps to %d, expect 14", i, target)
+			}
+		}
+	}
+	if prog[6] != insn(opJneImm, r2, 0, 7, devcgDevChar) {
+		t.Fatalf("unexpected type check %+v", prog[6])
+	}
+	if last := prog[len(prog)-2]; last != insn(opMov64Imm, r0, 0, 0, 0) {
+		t.Fatalf("expect deny by default, got %+v", last)
+	}
+
+	for _, rule := range []string{"c 1:3", "x 1:3 rwm", "c 1 rwm", "c a:3 rwm", "c 1:3 rwx"} {
+		if _, err := DeviceFilter([]string{rule}); err == nil {
+			t.Fatalf("expect error for rule %s", rule)
+		}
+	}
+}
+
+func TestAttachDeviceFilter(t *testing.T) {
+	root := FindCgroup2Mountpoint()
+	if root == "" || os.Getuid() != 0 {
+		t.Skip("cgroup v2 is not available")
+	}
+	dir, err := ioutil.TempDir(root, "mydocker-test")
+	if err != nil {
+		t.Skip(err)
+	}
+	defer os.Remove(dir)
+	if err := AttachDeviceFilter(dir, []string{"c 1:3 rwm"}); err != nil {
+		t.Skip(err)
+	}
+	// 整个测试进程移到这个cgroup中，结束后再移回去
+	origin := path.Join(root, "cgroup.procs")
+	pid := []byte(strconv.Itoa(os.Getpid()))
+	if err := ioutil.WriteFile(path.Join(dir, "cgroup.procs"), pid, 0644); err != nil {
+		t.Skip(err)
+	}
+	defer ioutil.WriteFile(origin, pid, 0644)
+
+	f, err := os.Open("/dev/null")
+	if err != nil {
+		t.Fatalf("open /dev/null %v", err)
+	}
+	f.Close()
+	if f, err := os.Open("/dev/zero"); !os.IsPermission(err) {
+		if err == nil {
+			f.Close()
+		}
+		t.Fatalf("expect /dev/zero to be denied, got %v", err)
+	}
+}
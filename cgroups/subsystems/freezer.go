@@ -0,0 +1,117 @@
+package subsystems
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// cgroup v1 freezer.state中的状态，正在冻结时是FREEZING
+const (
+	FreezerFrozen = "FROZEN"
+	FreezerThawed = "THAWED"
+)
+
+// FreezerSubSystem 没有资源限制，只用来冻结和解冻容器中的所有进程
+type FreezerSubSystem struct {
+}
+
+func (s *FreezerSubSystem) Set(cgroupPath string, res *ResourceConfig) error {
+	_, err := GetCgroupPath(s.Name(), cgroupPath, true)
+	return err
+}
+
+func (s *FreezerSubSystem) Remove(cgroupPath string) error {
+	if subsysCgroupPath, err := GetCgroupPath(s.Name(), cgroupPath, false); err == nil {
+		return os.RemoveAll(subsysCgroupPath)
+	} else {
+		return err
+	}
+}
+
+func (s *FreezerSubSystem) Apply(cgroupPath string, pid int) error {
+	if subsysCgroupPath, err := GetCgroupPath(s.Name(), cgroupPath, false); err == nil {
+		if err := ioutil.WriteFile(path.Join(subsysCgroupPath, "tasks"), []byte(strconv.Itoa(pid)), 0644); err != nil {
+			return fmt.Errorf("set cgroup proc fail %v", err)
+		}
+		return nil
+	} else {
+		return fmt.Errorf("get cgroup %s error: %v", cgroupPath, err)
+	}
+}
+
+func (s *FreezerSubSystem) Name() string {
+	return "freezer"
+}
+
+// Controller v2中每个cgroup都有cgroup.freeze，不需要启用controller
+func (s *FreezerSubSystem) Controller() string {
+	return ""
+}
+
+func (s *FreezerSubSystem) SetUnified(dir string, res *ResourceConfig) error {
+	return nil
+}
+
+// Freeze 通过v1的freezer.state冻结或者解冻cgroup中的所有进程，等到状态真正变化之后才返回
+func Freeze(cgroupPath string, frozen bool) error {
+	subsysCgroupPath, err := GetCgroupPath("freezer", cgroupPath, false)
+	if err != nil {
+		return err
+	}
+	state := FreezerThawed
+	if frozen {
+		state = FreezerFrozen
+	}
+	return waitFreezerState(path.Join(subsysCgroupPath, "freezer.state"), state, func(content string) bool {
+		return content == state
+	})
+}
+
+// FreezeUnified 通过v2的cgroup.freeze冻结或者解冻dir中的所有进程
+func FreezeUnified(dir string, frozen bool) error {
+	value := "0"
+	if frozen {
+		value = "1"
+	}
+	if err := ioutil.WriteFile(path.Join(dir, "cgroup.freeze"), []byte(value), 0644); err != nil {
+		return fmt.Errorf("write cgroup.freeze error %v", err)
+	}
+	// cgroup.events中的frozen在所有进程都停下来之后才变成1
+	return waitFreezerState(path.Join(dir, "cgroup.events"), "", func(content string) bool {
+		for _, line := range strings.Split(content, "\n") {
+			if fields := strings.Fields(line); len(fields) == 2 && fields[0] == "frozen" {
+				return fields[1] == value
+			}
+		}
+		return false
+	})
+}
+
+// waitFreezerState 每10ms检查一次状态，最多等1秒。write不为空时每次都重新写入，
+// v1中有进程正在fork时会一直停在FREEZING，需要重试
+func waitFreezerState(file, write string, done func(content string) bool) error {
+	for i := 0; i < 100; i++ {
+		if write != "" {
+			if err := ioutil.WriteFile(file, []byte(write), 0644); err != nil {
+				return fmt.Errorf("write %s error %v", file, err)
+			}
+		}
+		content, err := ioutil.ReadFile(file)
+		if err != nil {
+			return err
+		}
+		if done(strings.TrimSpace(string(content))) {
+			return nil
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if write == FreezerFrozen {
+		ioutil.WriteFile(file, []byte(FreezerThawed), 0644)
+	}
+	return fmt.Errorf("timeout waiting for %s", file)
+}
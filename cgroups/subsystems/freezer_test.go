@@ -0,0 +1,46 @@
+package subsystems
+
+import (
+	"io/ioutil"
+	"os/exec"
+	"path"
+	"strings"
+	"testing"
+)
+
+func TestFreezerCgroup(t *testing.T) {
+	if FindCgroupMountpoint("freezer") == "" {
+		t.Skip("freezer cgroup is not mounted")
+	}
+	freezerSubSys := FreezerSubSystem{}
+	testCgroup := "testfreezer"
+	if err := freezerSubSys.Set(testCgroup, &ResourceConfig{}); err != nil {
+		t.Fatalf("cgroup fail %v", err)
+	}
+	defer freezerSubSys.Remove(testCgroup)
+
+	cmd := exec.Command("sleep", "10")
+	if err := cmd.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer cmd.Wait()
+	defer cmd.Process.Kill()
+	if err := freezerSubSys.Apply(testCgroup, cmd.Process.Pid); err != nil {
+		t.Fatalf("cgroup Apply %v", err)
+	}
+
+	stateFile := path.Join(FindCgroupMountpoint("freezer"), testCgroup, "freezer.state")
+	for _, frozen := range []bool{true, false} {
+		if err := Freeze(testCgroup, frozen); err != nil {
+			t.Fatalf("freeze %v: %v", frozen, err)
+		}
+		content, _ := ioutil.ReadFile(stateFile)
+		want := FreezerThawed
+		if frozen {
+			want = FreezerFrozen
+		}
+		if state := strings.TrimSpace(string(content)); state != want {
+			t.Errorf("freezer state %s, want %s", state, want)
+		}
+	}
+}
@@ -0,0 +1,102 @@
+package subsystems
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+)
+
+// 内核支持的大页大小，目录名是 hugepages-2048kB 这样的形式
+const hugepagesDir = "/sys/kernel/mm/hugepages"
+
+// HugetlbSubSystem 限制每种大小的大页的使用量
+type HugetlbSubSystem struct {
+}
+
+func (s *HugetlbSubSystem) Set(cgroupPath string, res *ResourceConfig) error {
+	subsysCgroupPath, err := GetCgroupPath(s.Name(), cgroupPath, true)
+	if err != nil {
+		return err
+	}
+	return setHugetlbLimits(subsysCgroupPath, res, "limit_in_bytes")
+}
+
+func (s *HugetlbSubSystem) Remove(cgroupPath string) error {
+	if subsysCgroupPath, err := GetCgroupPath(s.Name(), cgroupPath, false); err == nil {
+		return os.RemoveAll(subsysCgroupPath)
+	} else {
+		return err
+	}
+}
+
+func (s *HugetlbSubSystem) Apply(cgroupPath string, pid int) error {
+	if subsysCgroupPath, err := GetCgroupPath(s.Name(), cgroupPath, false); err == nil {
+		if err := ioutil.WriteFile(path.Join(subsysCgroupPath, "tasks"), []byte(strconv.Itoa(pid)), 0644); err != nil {
+			return fmt.Errorf("set cgroup proc fail %v", err)
+		}
+		return nil
+	} else {
+		return fmt.Errorf("get cgroup %s error: %v", cgroupPath, err)
+	}
+}
+
+func (s *HugetlbSubSystem) Name() string {
+	return "hugetlb"
+}
+
+func (s *HugetlbSubSystem) Controller() string {
+	return "hugetlb"
+}
+
+func (s *HugetlbSubSystem) SetUnified(dir string, res *ResourceConfig) error {
+	return setHugetlbLimits(dir, res, "max")
+}
+
+// setHugetlbLimits 每条限制是 "2MB 104857600"，写到 hugetlb.2MB.<suffix> 中
+func setHugetlbLimits(dir string, res *ResourceConfig, suffix string) error {
+	for _, limit := range res.HugetlbLimits {
+		fields := strings.Fields(limit)
+		if len(fields) != 2 {
+			return fmt.Errorf("invalid hugetlb limit %s", limit)
+		}
+		file := fmt.Sprintf("hugetlb.%s.%s", fields[0], suffix)
+		if err := ioutil.WriteFile(path.Join(dir, file), []byte(fields[1]), 0644); err != nil {
+			return fmt.Errorf("set cgroup hugetlb limit %s fail %v", limit, err)
+		}
+	}
+	return nil
+}
+
+// HugePageSizes 返回内核支持的大页大小，使用cgroup文件名中的写法，比如 2MB、1GB
+func HugePageSizes() ([]string, error) {
+	entries, err := ioutil.ReadDir(hugepagesDir)
+	if err != nil {
+		return nil, err
+	}
+	var sizes []string
+	for _, entry := range entries {
+		kb := strings.TrimSuffix(strings.TrimPrefix(entry.Name(), "hugepages-"), "kB")
+		size, err := strconv.ParseInt(kb, 10, 64)
+		if err != nil {
+			continue
+		}
+		sizes = append(sizes, HugePageSizeName(size<<10))
+	}
+	return sizes, nil
+}
+
+// HugePageSizeName 按内核的规则给大页大小命名，用能整除的最大单位
+func HugePageSizeName(size int64) string {
+	for _, unit := range []struct {
+		name  string
+		bytes int64
+	}{{"GB", 1 << 30}, {"MB", 1 << 20}, {"KB", 1 << 10}} {
+		if size >= unit.bytes && size%unit.bytes == 0 {
+			return strconv.FormatInt(size/unit.bytes, 10) + unit.name
+		}
+	}
+	return strconv.FormatInt(size, 10) + "B"
+}
@@ -6,6 +6,7 @@ import(
 	"os"
 	"path"
 	"strconv"
+	"strings"
 )
 
 type MemorySubSystem struct {
@@ -18,6 +19,25 @@ func (s *MemorySubSystem) Set(cgroupPath string, res *ResourceConfig) error {
 				return fmt.Errorf("set cgroup memory fail %v", err)
 			}
 		}
+		// memsw要在limit之后设置，不能小于limit；没有开启swap accounting时没有这个文件
+		if res.MemorySwap != "" {
+			swap, err := sizeLimit(res.MemorySwap, "-1")
+			if err != nil {
+				return err
+			}
+			if err := ioutil.WriteFile(path.Join(subsysCgroupPath, "memory.memsw.limit_in_bytes"), []byte(swap), 0644); err != nil {
+				return fmt.Errorf("set cgroup memory swap fail %v", err)
+			}
+		}
+		if res.MemoryReservation != "" {
+			reservation, err := sizeLimit(res.MemoryReservation, "-1")
+			if err != nil {
+				return err
+			}
+			if err := ioutil.WriteFile(path.Join(subsysCgroupPath, "memory.soft_limit_in_bytes"), []byte(reservation), 0644); err != nil {
+				return fmt.Errorf("set cgroup memory reservation fail %v", err)
+			}
+		}
 		return nil
 	} else {
 		return err
@@ -46,6 +66,91 @@ func (s *MemorySubSystem) Apply(cgroupPath string, pid int) error {
 }
 
 
+func (s *MemorySubSystem) Controller() string {
+	return "memory"
+}
+
+// SetUnified v2的memory.max只接受字节数或者max，不能带单位
+func (s *MemorySubSystem) SetUnified(dir string, res *ResourceConfig) error {
+	if res.MemoryLimit != "" {
+		limit, err := sizeLimit(res.MemoryLimit, "max")
+		if err != nil {
+			return err
+		}
+		if err := ioutil.WriteFile(path.Join(dir, "memory.max"), []byte(limit), 0644); err != nil {
+			return fmt.Errorf("set cgroup memory fail %v", err)
+		}
+	}
+	if res.MemorySwap != "" {
+		swap, err := swapMax(res.MemoryLimit, res.MemorySwap)
+		if err != nil {
+			return err
+		}
+		if err := ioutil.WriteFile(path.Join(dir, "memory.swap.max"), []byte(swap), 0644); err != nil {
+			return fmt.Errorf("set cgroup memory swap fail %v", err)
+		}
+	}
+	if res.MemoryReservation != "" {
+		reservation, err := sizeLimit(res.MemoryReservation, "0")
+		if err != nil {
+			return err
+		}
+		if err := ioutil.WriteFile(path.Join(dir, "memory.low"), []byte(reservation), 0644); err != nil {
+			return fmt.Errorf("set cgroup memory reservation fail %v", err)
+		}
+	}
+	return nil
+}
+
+// swapMax v1的memsw是内存加swap的总量，v2的memory.swap.max只是swap，要减去内存限制
+func swapMax(memory, memorySwap string) (string, error) {
+	if memorySwap == "-1" || memory == "" || memory == "-1" {
+		return "max", nil
+	}
+	limit, err := ParseSize(memory)
+	if err != nil {
+		return "", err
+	}
+	swap, err := ParseSize(memorySwap)
+	if err != nil {
+		return "", err
+	}
+	if swap < limit {
+		return "", fmt.Errorf("memory swap %s is smaller than memory limit %s", memorySwap, memory)
+	}
+	return strconv.FormatInt(swap-limit, 10), nil
+}
+
 func (s *MemorySubSystem) Name() string {
 	return "memory"
-}
\ No newline at end of file
+}
+
+// OOMKillCount 返回cgroup中因为超过内存限制被杀掉的进程数，
+// 来自memory.oom_control中的oom_kill，4.13之前的内核没有这一项时返回0
+func OOMKillCount(cgroupPath string) int {
+	subsysCgroupPath, err := GetCgroupPath("memory", cgroupPath, false)
+	if err != nil {
+		return 0
+	}
+	return readOOMKill(path.Join(subsysCgroupPath, "memory.oom_control"))
+}
+
+// OOMKillCountUnified 从v2的memory.events中读取oom_kill
+func OOMKillCountUnified(dir string) int {
+	return readOOMKill(path.Join(dir, "memory.events"))
+}
+
+func readOOMKill(file string) int {
+	content, err := ioutil.ReadFile(file)
+	if err != nil {
+		return 0
+	}
+	for _, line := range strings.Split(string(content), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) == 2 && fields[0] == "oom_kill" {
+			count, _ := strconv.Atoi(fields[1])
+			return count
+		}
+	}
+	return 0
+}
@@ -0,0 +1,67 @@
+package subsystems
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"strconv"
+)
+
+// PidsSubSystem 限制cgroup中最多能有多少个进程，防止fork炸弹
+type PidsSubSystem struct {
+}
+
+func (s *PidsSubSystem) Set(cgroupPath string, res *ResourceConfig) error {
+	subsysCgroupPath, err := GetCgroupPath(s.Name(), cgroupPath, true)
+	if err != nil {
+		return err
+	}
+	return setPidsMax(subsysCgroupPath, res)
+}
+
+func (s *PidsSubSystem) Remove(cgroupPath string) error {
+	if subsysCgroupPath, err := GetCgroupPath(s.Name(), cgroupPath, false); err == nil {
+		return os.RemoveAll(subsysCgroupPath)
+	} else {
+		return err
+	}
+}
+
+func (s *PidsSubSystem) Apply(cgroupPath string, pid int) error {
+	if subsysCgroupPath, err := GetCgroupPath(s.Name(), cgroupPath, false); err == nil {
+		if err := ioutil.WriteFile(path.Join(subsysCgroupPath, "tasks"), []byte(strconv.Itoa(pid)), 0644); err != nil {
+			return fmt.Errorf("set cgroup proc fail %v", err)
+		}
+		return nil
+	} else {
+		return fmt.Errorf("get cgroup %s error: %v", cgroupPath, err)
+	}
+}
+
+func (s *PidsSubSystem) Name() string {
+	return "pids"
+}
+
+func (s *PidsSubSystem) Controller() string {
+	return "pids"
+}
+
+// SetUnified v1和v2中pids.max的格式一样
+func (s *PidsSubSystem) SetUnified(dir string, res *ResourceConfig) error {
+	return setPidsMax(dir, res)
+}
+
+func setPidsMax(dir string, res *ResourceConfig) error {
+	if res.PidsLimit == "" {
+		return nil
+	}
+	limit := res.PidsLimit
+	if limit == "-1" {
+		limit = "max"
+	}
+	if err := ioutil.WriteFile(path.Join(dir, "pids.max"), []byte(limit), 0644); err != nil {
+		return fmt.Errorf("set cgroup pids limit fail %v", err)
+	}
+	return nil
+}
@@ -0,0 +1,39 @@
+package subsystems
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+	"testing"
+)
+
+func TestPidsCgroup(t *testing.T) {
+	if FindCgroupMountpoint("pids") == "" {
+		t.Skip("pids cgroup is not mounted")
+	}
+	pidsSubSys := PidsSubSystem{}
+	testCgroup := "testpidslimit"
+	if err := pidsSubSys.Set(testCgroup, &ResourceConfig{PidsLimit: "20"}); err != nil {
+		t.Fatalf("cgroup fail %v", err)
+	}
+	defer pidsSubSys.Remove(testCgroup)
+
+	content, _ := ioutil.ReadFile(path.Join(FindCgroupMountpoint("pids"), testCgroup, "pids.max"))
+	if limit := strings.TrimSpace(string(content)); limit != "20" {
+		t.Errorf("pids.max %s, want 20", limit)
+	}
+	if err := pidsSubSys.Apply(testCgroup, os.Getpid()); err != nil {
+		t.Fatalf("cgroup Apply %v", err)
+	}
+	//将进程移回到根Cgroup节点
+	if err := pidsSubSys.Apply("", os.Getpid()); err != nil {
+		t.Fatalf("cgroup Apply %v", err)
+	}
+}
+
+func TestGetCgroupPathNotMounted(t *testing.T) {
+	if _, err := GetCgroupPath("nosuchsubsystem", "testcgroup", true); err == nil {
+		t.Errorf("subsystem which is not mounted should fail")
+	}
+}
@@ -3,14 +3,30 @@ package subsystems
 type ResourceConfig struct {
 	MemoryLimit string
 	CpuShare    string
+	CpuPeriod   string //CFS调度周期，单位微秒
+	CpuQuota    string //每个周期内可以使用的CPU时间，单位微秒，-1表示不限制
 	CpuSet      string
+	Devices     []string //devices cgroup白名单，比如 c 1:3 rwm，nil表示不限制
+
+	MemorySwap        string   //内存加swap的限制，-1表示不限制swap
+	MemoryReservation string   //内存的软限制，内存紧张时回收到这个值
+	PidsLimit         string   //最多能创建的进程数，-1表示不限制
+	BlkioWeight       string   //块设备IO的权重，10到1000
+	DeviceReadBps     []string //块设备的读速率限制，比如 8:0 10485760
+	DeviceWriteBps    []string //块设备的写速率限制
+	HugetlbLimits     []string //大页的使用限制，比如 2MB 104857600
 }
 
+// Subsystem 的Set、Apply和Remove操作cgroup v1中这个subsystem自己的hierarchy；
+// cgroup v2只有一个hierarchy，SetUnified把同样的资源限制写到容器的cgroup目录中
 type Subsystem interface {
 	Name() string
 	Set(path string, res *ResourceConfig) error
 	Apply(path string, pid int) error
 	Remove(path string) error
+	// cgroup v2中对应的controller，需要在父cgroup的cgroup.subtree_control中启用，为空表示不需要
+	Controller() string
+	SetUnified(dir string, res *ResourceConfig) error
 }
 
 var (
@@ -18,5 +34,34 @@ var (
 		&CpusetSubSystem{},
 		&MemorySubSystem{},
 		&CpuSubSystem{},
+		&DevicesSubSystem{},
+		&FreezerSubSystem{},
+		&PidsSubSystem{},
+		&BlkioSubSystem{},
+		&HugetlbSubSystem{},
 	}
 )
+
+// Used 判断res中有没有设置name这个subsystem负责的限制，设置了的限制不能生效时要报错
+func Used(name string, res *ResourceConfig) bool {
+	if res == nil {
+		return false
+	}
+	switch name {
+	case "cpuset":
+		return res.CpuSet != ""
+	case "memory":
+		return res.MemoryLimit != "" || res.MemorySwap != "" || res.MemoryReservation != ""
+	case "cpu":
+		return res.CpuShare != "" || res.CpuPeriod != "" || res.CpuQuota != ""
+	case "devices":
+		return res.Devices != nil
+	case "pids":
+		return res.PidsLimit != ""
+	case "blkio":
+		return res.BlkioWeight != "" || len(res.DeviceReadBps) > 0 || len(res.DeviceWriteBps) > 0
+	case "hugetlb":
+		return len(res.HugetlbLimits) > 0
+	}
+	return false
+}
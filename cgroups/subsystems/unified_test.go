@@ -0,0 +1,121 @@
+package subsystems
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+	"testing"
+)
+
+func TestSetUnified(t *testing.T) {
+	dir, err := ioutil.TempDir("", "cgroup2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	res := &ResourceConfig{
+		MemoryLimit: "100m",
+		CpuShare:    "1024",
+		CpuQuota:    "50000",
+		CpuSet:      "0-1",
+	}
+	for _, subSysIns := range SubsystemsIns {
+		if err := subSysIns.SetUnified(dir, res); err != nil {
+			t.Fatalf("%s SetUnified %v", subSysIns.Name(), err)
+		}
+	}
+	want := map[string]string{
+		"memory.max":  "104857600",
+		"cpu.weight":  "39",
+		"cpu.max":     "50000 100000",
+		"cpuset.cpus": "0-1",
+	}
+	for file, value := range want {
+		content, err := ioutil.ReadFile(path.Join(dir, file))
+		if err != nil {
+			t.Fatalf("read %s %v", file, err)
+		}
+		if strings.TrimSpace(string(content)) != value {
+			t.Errorf("%s = %s, want %s", file, content, value)
+		}
+	}
+
+	if err := (&MemorySubSystem{}).SetUnified(dir, &ResourceConfig{MemoryLimit: "-1"}); err != nil {
+		t.Fatal(err)
+	}
+	if content, _ := ioutil.ReadFile(path.Join(dir, "memory.max")); string(content) != "max" {
+		t.Errorf("memory.max = %s, want max", content)
+	}
+	if err := (&DevicesSubSystem{}).SetUnified(dir, &ResourceConfig{Devices: []string{"c 1:3 rwm"}}); err == nil {
+		t.Errorf("device whitelist should not be supported on cgroup v2")
+	}
+}
+
+func TestSetUnifiedExtendedLimits(t *testing.T) {
+	dir, err := ioutil.TempDir("", "cgroup2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	res := &ResourceConfig{
+		MemoryLimit:       "100m",
+		MemorySwap:        "300m",
+		MemoryReservation: "50m",
+		PidsLimit:         "-1",
+		BlkioWeight:       "500",
+		DeviceReadBps:     []string{"8:0 1048576"},
+		HugetlbLimits:     []string{"2MB 4194304"},
+	}
+	for _, subSysIns := range SubsystemsIns {
+		if err := subSysIns.SetUnified(dir, res); err != nil {
+			t.Fatalf("%s SetUnified %v", subSysIns.Name(), err)
+		}
+	}
+	want := map[string]string{
+		"memory.swap.max": "209715200",
+		"memory.low":      "52428800",
+		"pids.max":        "max",
+		"io.max":          "8:0 rbps=1048576",
+		"hugetlb.2MB.max": "4194304",
+	}
+	for file, value := range want {
+		content, err := ioutil.ReadFile(path.Join(dir, file))
+		if err != nil {
+			t.Fatalf("read %s %v", file, err)
+		}
+		if string(content) != value {
+			t.Errorf("%s = %s, want %s", file, content, value)
+		}
+	}
+	// 临时目录中没有io.weight，写入io.weight会创建文件
+	if content, _ := ioutil.ReadFile(path.Join(dir, "io.weight")); string(content) != "default 4950" {
+		t.Errorf("io.weight = %s", content)
+	}
+	if _, err := swapMax("100m", "50m"); err == nil {
+		t.Errorf("swap smaller than memory should fail")
+	}
+}
+
+func TestHugePageSizeName(t *testing.T) {
+	cases := map[int64]string{2 << 20: "2MB", 1 << 30: "1GB", 64 << 10: "64KB", 32 << 20: "32MB"}
+	for size, want := range cases {
+		if name := HugePageSizeName(size); name != want {
+			t.Errorf("size %d -> %s, want %s", size, name, want)
+		}
+	}
+}
+
+func TestCpuSharesToWeight(t *testing.T) {
+	cases := map[uint64]uint64{2: 1, 1024: 39, 262144: 10000, 0: 1}
+	for shares, want := range cases {
+		if weight := CpuSharesToWeight(shares); weight != want {
+			t.Errorf("shares %d -> weight %d, want %d", shares, weight, want)
+		}
+	}
+	if max := cpuMax("", "200000"); max != "max 200000" {
+		t.Errorf("cpu.max %s", max)
+	}
+}
@@ -0,0 +1,46 @@
+package subsystems
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// sizeLimit 把大小限制转换成写到cgroup文件中的字节数，-1表示不限制，写入unlimited
+func sizeLimit(val, unlimited string) (string, error) {
+	if val == "-1" {
+		return unlimited, nil
+	}
+	bytes, err := ParseSize(val)
+	if err != nil {
+		return "", err
+	}
+	return strconv.FormatInt(bytes, 10), nil
+}
+
+// ParseSize 解析 64m、1g 这样的大小，单位是1024的倍数，没有单位时是字节
+func ParseSize(val string) (int64, error) {
+	s := strings.ToLower(strings.TrimSpace(val))
+	s = strings.TrimSuffix(s, "b")
+	unit := int64(1)
+	if s != "" {
+		switch s[len(s)-1] {
+		case 'k':
+			unit = 1 << 10
+		case 'm':
+			unit = 1 << 20
+		case 'g':
+			unit = 1 << 30
+		case 't':
+			unit = 1 << 40
+		}
+		if unit > 1 {
+			s = s[:len(s)-1]
+		}
+	}
+	n, err := strconv.ParseInt(s, 10, 64)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid size %s", val)
+	}
+	return n * unit, nil
+}
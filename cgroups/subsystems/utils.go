@@ -6,8 +6,14 @@ import (
 	"os"
 	"path"
 	"bufio"
+	"io/ioutil"
+	"strconv"
+	"syscall"
 )
 
+// cgroup2文件系统的magic number，见 linux/magic.h
+const cgroup2SuperMagic = 0x63677270
+
 
 func FindCgroupMountpoint(subsystem string) string {
 	f, err := os.Open("/proc/self/mountinfo")
@@ -35,9 +41,13 @@ func FindCgroupMountpoint(subsystem string) string {
 
 func GetCgroupPath(subsystem string, cgroupPath string, autoCreate bool) (string, error) {
 	cgroupRoot := FindCgroupMountpoint(subsystem)
+	// 没有挂载的subsystem不能在当前目录下创建出一个cgroup目录
+	if cgroupRoot == "" {
+		return "", fmt.Errorf("%s cgroup is not mounted", subsystem)
+	}
 	if _, err := os.Stat(path.Join(cgroupRoot, cgroupPath)); err == nil || (autoCreate && os.IsNotExist(err)) {
 		if os.IsNotExist(err) {
-			if err := os.Mkdir(path.Join(cgroupRoot, cgroupPath), 0755); err == nil {
+			if err := os.MkdirAll(path.Join(cgroupRoot, cgroupPath), 0755); err == nil {
 			} else {
 				return "", fmt.Errorf("error create cgroup %v", err)
 			}
@@ -46,4 +56,60 @@ func GetCgroupPath(subsystem string, cgroupPath string, autoCreate bool) (string
 	} else {
 		return "", fmt.Errorf("cgroup path error %v", err)
 	}
-}
\ No newline at end of file
+}
+// CgroupProcs 读取cgroup.procs，返回cgroup中的进程
+func CgroupProcs(subsystem string, cgroupPath string) ([]int, error) {
+	subsysCgroupPath, err := GetCgroupPath(subsystem, cgroupPath, false)
+	if err != nil {
+		return nil, err
+	}
+	return ReadCgroupProcs(subsysCgroupPath)
+}
+
+// ReadCgroupProcs 读取cgroup目录dir中的cgroup.procs，v1和v2的格式是一样的
+func ReadCgroupProcs(dir string) ([]int, error) {
+	content, err := ioutil.ReadFile(path.Join(dir, "cgroup.procs"))
+	if err != nil {
+		return nil, err
+	}
+	var pids []int
+	for _, field := range strings.Fields(string(content)) {
+		pid, err := strconv.Atoi(field)
+		if err != nil {
+			return nil, fmt.Errorf("invalid pid %s in cgroup.procs", field)
+		}
+		pids = append(pids, pid)
+	}
+	return pids, nil
+}
+
+// FindCgroup2Mountpoint 返回cgroup v2(unified)的挂载点，没有挂载时返回空字符串
+func FindCgroup2Mountpoint() string {
+	f, err := os.Open("/proc/self/mountinfo")
+	if err != nil {
+		return ""
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.Split(scanner.Text(), " ")
+		// 可选字段的个数不固定，文件系统类型在 - 之后
+		for i, field := range fields {
+			if field == "-" && i+1 < len(fields) && fields[i+1] == "cgroup2" {
+				return fields[4]
+			}
+		}
+	}
+	return ""
+}
+
+// IsCgroup2UnifiedMode 判断/sys/fs/cgroup本身是不是cgroup2，也就是只有v2一个hierarchy。
+// hybrid模式下v2挂载在/sys/fs/cgroup/unified，controller都还在v1中，仍然按v1处理
+func IsCgroup2UnifiedMode() bool {
+	var st syscall.Statfs_t
+	if err := syscall.Statfs("/sys/fs/cgroup", &st); err != nil {
+		return false
+	}
+	return st.Type == cgroup2SuperMagic
+}
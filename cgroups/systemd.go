@@ -0,0 +1,220 @@
+package cgroups
+
+import (
+	"fmt"
+	"path"
+	"strconv"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/xianlubird/mydocker/cgroups/subsystems"
+	"github.com/xianlubird/mydocker/cgroups/systemd"
+)
+
+// 容器的scope放在system.slice下面，和docker的systemd驱动一样
+const systemdSlice = "system.slice"
+
+// systemd从这个版本开始支持CPUQuotaPeriodUSec
+const minCPUQuotaPeriodVersion = 242
+
+// systemdDriver 通过systemd的D-Bus接口给每个容器创建一个transient scope，
+// cgroup由systemd创建和删除，读写cgroup中的文件仍然交给v1或者v2的driver
+type systemdDriver struct {
+	unified bool
+	inner   cgroupDriver
+	res     *subsystems.ResourceConfig
+}
+
+func newSystemdDriver() *systemdDriver {
+	if subsystems.IsCgroup2UnifiedMode() {
+		return &systemdDriver{unified: true, inner: &fs2Driver{root: subsystems.FindCgroup2Mountpoint()}}
+	}
+	return &systemdDriver{inner: &fsDriver{}}
+}
+
+func unitName(cgroupPath string) string {
+	return "mydocker-" + cgroupPath + ".scope"
+}
+
+// scope 返回scope对应的cgroup在hierarchy中的路径
+func scope(cgroupPath string) string {
+	return path.Join(systemdSlice, unitName(cgroupPath))
+}
+
+// Set scope中至少要有一个进程才能创建，资源限制先记下来，Apply时作为unit的属性设置
+func (d *systemdDriver) Set(cgroupPath string, res *subsystems.ResourceConfig) error {
+	d.res = res
+	return nil
+}
+
+func (d *systemdDriver) Apply(cgroupPath string, pid int) error {
+	// scope已经存在时是exec进去的进程，scope有Delegate，可以直接写cgroup.procs
+	if _, err := d.inner.Processes(scope(cgroupPath)); err == nil {
+		return d.inner.Apply(scope(cgroupPath), pid)
+	}
+	res := d.res
+	if res == nil {
+		res = &subsystems.ResourceConfig{}
+	}
+	properties, err := systemdProperties(cgroupPath, pid, res, d.unified)
+	if err != nil {
+		return err
+	}
+	// 创建不了scope时容器不在任何cgroup中，必须返回错误。连不上systemd时退回cgroupfs只在SetCgroupDriver选择驱动时做
+	conn, err := systemd.Dial(systemd.SystemBusAddress())
+	if err != nil {
+		return fmt.Errorf("connect to systemd error %v", err)
+	}
+	defer conn.Close()
+	// CPUQuotaPeriodUSec是systemd 242才有的属性，旧版本会拒绝整个unit。去掉之后systemd按默认的周期换算quota，
+	// 后面inner.Set仍然会把周期写到cgroup中
+	if version, err := conn.Version(); err != nil || version < minCPUQuotaPeriodVersion {
+		var removed bool
+		if properties, removed = removeProperty(properties, "CPUQuotaPeriodUSec"); removed {
+			reason := fmt.Sprintf("systemd %d does not support CPUQuotaPeriodUSec", version)
+			if err != nil {
+				reason = err.Error()
+			}
+			logrus.Warnf("%s, use the default cpu period in unit %s", reason, unitName(cgroupPath))
+		}
+	}
+	if err := conn.StartTransientUnit(unitName(cgroupPath), properties); err != nil {
+		return err
+	}
+	// 没有对应属性的限制，比如cpuset、devices白名单和设备速率，还有v1中systemd不管的freezer和hugetlb，
+	// 由我们自己写到scope对应的cgroup中；有属性的限制再写一遍，值是一样的
+	if err := d.inner.Set(scope(cgroupPath), res); err != nil {
+		return err
+	}
+	return d.inner.Apply(scope(cgroupPath), pid)
+}
+
+// Destroy 停止scope，systemd删除它创建的cgroup，我们自己创建的再由inner删除
+func (d *systemdDriver) Destroy(cgroupPath string) error {
+	conn, err := systemd.Dial(systemd.SystemBusAddress())
+	if err != nil {
+		logrus.Warnf("remove cgroup fail %v", err)
+	} else {
+		defer conn.Close()
+		if err := conn.StopUnit(unitName(cgroupPath)); err != nil && !systemd.IsNoSuchUnit(err) {
+			logrus.Warnf("stop unit %s error %v", unitName(cgroupPath), err)
+		}
+		conn.ResetFailedUnit(unitName(cgroupPath))
+	}
+	return d.inner.Destroy(scope(cgroupPath))
+}
+
+func (d *systemdDriver) Processes(cgroupPath string) ([]int, error) {
+	return d.inner.Processes(scope(cgroupPath))
+}
+
+func (d *systemdDriver) Freeze(cgroupPath string, frozen bool) error {
+	return d.inner.Freeze(scope(cgroupPath), frozen)
+}
+
+func (d *systemdDriver) OOMKilled(cgroupPath string) bool {
+	return d.inner.OOMKilled(scope(cgroupPath))
+}
+
+func (d *systemdDriver) ProcPath(cgroupPath string) string {
+	return path.Join("/", scope(cgroupPath))
+}
+
+// removeProperty 去掉名字是name的属性，返回是否有这个属性
+func removeProperty(properties []systemd.Property, name string) ([]systemd.Property, bool) {
+	for i, p := range properties {
+		if p.Name == name {
+			return append(properties[:i:i], properties[i+1:]...), true
+		}
+	}
+	return properties, false
+}
+
+// systemdProperties 把ResourceConfig转换成scope的属性，v1和v2中内存和cpu权重的属性名不一样
+func systemdProperties(cgroupPath string, pid int, res *subsystems.ResourceConfig, unified bool) ([]systemd.Property, error) {
+	properties := []systemd.Property{
+		systemd.PropString("Description", "mydocker container "+cgroupPath),
+		systemd.PropString("Slice", systemdSlice),
+		systemd.PropBool("Delegate", true),
+		systemd.PropBool("DefaultDependencies", false),
+		systemd.PropPids(uint32(pid)),
+	}
+	if res.MemoryLimit != "" && res.MemoryLimit != "-1" {
+		bytes, err := subsystems.ParseSize(res.MemoryLimit)
+		if err != nil {
+			return nil, err
+		}
+		name := "MemoryLimit"
+		if unified {
+			name = "MemoryMax"
+		}
+		properties = append(properties, systemd.PropUint64(name, uint64(bytes)))
+	}
+	if res.CpuShare != "" {
+		shares, err := strconv.ParseUint(res.CpuShare, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid cpu share %s", res.CpuShare)
+		}
+		if unified {
+			properties = append(properties, systemd.PropUint64("CPUWeight", subsystems.CpuSharesToWeight(shares)))
+		} else {
+			properties = append(properties, systemd.PropUint64("CPUShares", shares))
+		}
+	}
+	period := uint64(100000)
+	if res.CpuPeriod != "" {
+		var err error
+		if period, err = strconv.ParseUint(res.CpuPeriod, 10, 64); err != nil || period == 0 {
+			return nil, fmt.Errorf("invalid cpu period %s", res.CpuPeriod)
+		}
+		properties = append(properties, systemd.PropUint64("CPUQuotaPeriodUSec", period))
+	}
+	// systemd的quota是每秒可以使用的CPU时间
+	if res.CpuQuota != "" && res.CpuQuota != "-1" {
+		quota, err := strconv.ParseUint(res.CpuQuota, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid cpu quota %s", res.CpuQuota)
+		}
+		properties = append(properties, systemd.PropUint64("CPUQuotaPerSecUSec", quota*1000000/period))
+	}
+	if res.PidsLimit != "" && res.PidsLimit != "-1" {
+		limit, err := strconv.ParseUint(res.PidsLimit, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid pids limit %s", res.PidsLimit)
+		}
+		properties = append(properties, systemd.PropUint64("TasksMax", limit))
+	}
+	if res.BlkioWeight != "" {
+		weight, err := strconv.ParseUint(res.BlkioWeight, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid blkio weight %s", res.BlkioWeight)
+		}
+		if unified {
+			properties = append(properties, systemd.PropUint64("IOWeight", subsystems.BlkioWeightToIOWeight(weight)))
+		} else {
+			properties = append(properties, systemd.PropUint64("BlockIOWeight", weight))
+		}
+	}
+	// v1中swap和软限制没有对应的属性
+	if unified && res.MemoryReservation != "" && res.MemoryReservation != "-1" {
+		reservation, err := subsystems.ParseSize(res.MemoryReservation)
+		if err != nil {
+			return nil, err
+		}
+		properties = append(properties, systemd.PropUint64("MemoryLow", uint64(reservation)))
+	}
+	if unified && res.MemorySwap != "" && res.MemorySwap != "-1" && res.MemoryLimit != "" && res.MemoryLimit != "-1" {
+		limit, err := subsystems.ParseSize(res.MemoryLimit)
+		if err != nil {
+			return nil, err
+		}
+		swap, err := subsystems.ParseSize(res.MemorySwap)
+		if err != nil {
+			return nil, err
+		}
+		if swap < limit {
+			return nil, fmt.Errorf("memory swap %s is smaller than memory limit %s", res.MemorySwap, res.MemoryLimit)
+		}
+		properties = append(properties, systemd.PropUint64("MemorySwapMax", uint64(swap-limit)))
+	}
+	return properties, nil
+}
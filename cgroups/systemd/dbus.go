@@ -0,0 +1,611 @@
+package systemd
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// 只实现了调用systemd需要的那一部分D-Bus协议：unix socket、EXTERNAL认证和方法调用，
+// 协议见 https://dbus.freedesktop.org/doc/dbus-specification.html
+
+// DefaultSystemBusAddress 是没有设置 DBUS_SYSTEM_BUS_ADDRESS 时system bus的地址
+const DefaultSystemBusAddress = "unix:path=/run/dbus/system_bus_socket"
+
+// 消息类型
+const (
+	typeMethodCall   = 1
+	typeMethodReturn = 2
+	typeError        = 3
+	typeSignal       = 4
+)
+
+// 消息头中的字段
+const (
+	fieldPath        = 1
+	fieldInterface   = 2
+	fieldMember      = 3
+	fieldErrorName   = 4
+	fieldReplySerial = 5
+	fieldDestination = 6
+	fieldSender      = 7
+	fieldSignature   = 8
+)
+
+// Variant 是D-Bus中的 v 类型，值的类型由Signature决定
+type Variant struct {
+	Signature string
+	Value     interface{}
+}
+
+// Error 是对方返回的错误消息
+type Error struct {
+	Name    string
+	Message string
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("%s: %s", e.Name, e.Message)
+}
+
+// Message 是一条D-Bus消息，Body中值的Go类型：y byte、b bool、u uint32、t uint64、
+// s/o/g string、v Variant，数组和结构体都是[]interface{}
+type Message struct {
+	Type   byte
+	Serial uint32
+	Fields map[byte]interface{}
+	Body   []interface{}
+}
+
+func (m *Message) field(code byte) string {
+	s, _ := m.Fields[code].(string)
+	return s
+}
+
+// Conn 是一个D-Bus连接，方法调用是同步的，不能在多个goroutine中同时使用
+type Conn struct {
+	conn   net.Conn
+	reader *bufio.Reader
+	serial uint32
+}
+
+// SystemBusAddress 返回system bus的地址
+func SystemBusAddress() string {
+	if address := os.Getenv("DBUS_SYSTEM_BUS_ADDRESS"); address != "" {
+		return address
+	}
+	return DefaultSystemBusAddress
+}
+
+// Dial 连接address指定的bus，完成认证之后发送Hello
+func Dial(address string) (*Conn, error) {
+	socket, err := parseAddress(address)
+	if err != nil {
+		return nil, err
+	}
+	conn, err := net.Dial("unix", socket)
+	if err != nil {
+		return nil, fmt.Errorf("connect dbus %s error %v", address, err)
+	}
+	c := &Conn{conn: conn, reader: bufio.NewReader(conn)}
+	if err := c.auth(); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	if _, err := c.Call("org.freedesktop.DBus", "/org/freedesktop/DBus", "org.freedesktop.DBus", "Hello", ""); err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("dbus hello error %v", err)
+	}
+	return c, nil
+}
+
+// parseAddress 只支持 unix:path=xxx 这种地址，多个地址用;分隔时取第一个能用的
+func parseAddress(address string) (string, error) {
+	for _, addr := range strings.Split(address, ";") {
+		if !strings.HasPrefix(addr, "unix:") {
+			continue
+		}
+		for _, kv := range strings.Split(strings.TrimPrefix(addr, "unix:"), ",") {
+			if strings.HasPrefix(kv, "path=") {
+				return strings.TrimPrefix(kv, "path="), nil
+			}
+		}
+	}
+	return "", fmt.Errorf("unsupported dbus address %s", address)
+}
+
+// auth 用EXTERNAL方式认证，bus通过SO_PEERCRED检查我们的uid
+func (c *Conn) auth() error {
+	uid := hex.EncodeToString([]byte(strconv.Itoa(os.Getuid())))
+	if _, err := c.conn.Write([]byte("\x00AUTH EXTERNAL " + uid + "\r\n")); err != nil {
+		return fmt.Errorf("dbus auth error %v", err)
+	}
+	line, err := c.reader.ReadString('\n')
+	if err != nil {
+		return fmt.Errorf("dbus auth error %v", err)
+	}
+	if !strings.HasPrefix(line, "OK") {
+		return fmt.Errorf("dbus auth rejected: %s", strings.TrimSpace(line))
+	}
+	if _, err := c.conn.Write([]byte("BEGIN\r\n")); err != nil {
+		return fmt.Errorf("dbus auth error %v", err)
+	}
+	return nil
+}
+
+// NameHasOwner 判断bus上有没有进程持有name，比如systemd是不是连到了这个bus上
+func (c *Conn) NameHasOwner(name string) (bool, error) {
+	reply, err := c.Call("org.freedesktop.DBus", "/org/freedesktop/DBus", "org.freedesktop.DBus", "NameHasOwner", "s", name)
+	if err != nil {
+		return false, err
+	}
+	owned, _ := reply[0].(bool)
+	return owned, nil
+}
+
+// Close 关闭连接
+func (c *Conn) Close() error {
+	return c.conn.Close()
+}
+
+// Call 调用方法并等待返回，signature是参数的类型，返回值是回复的Body
+func (c *Conn) Call(dest, path, iface, member, signature string, args ...interface{}) ([]interface{}, error) {
+	c.serial++
+	msg := &Message{
+		Type:   typeMethodCall,
+		Serial: c.serial,
+		Fields: map[byte]interface{}{
+			fieldPath:        path,
+			fieldInterface:   iface,
+			fieldMember:      member,
+			fieldDestination: dest,
+		},
+		Body: args,
+	}
+	if signature != "" {
+		msg.Fields[fieldSignature] = signature
+	}
+	if err := WriteMessage(c.conn, msg); err != nil {
+		return nil, err
+	}
+	for {
+		reply, err := ReadMessage(c.reader)
+		if err != nil {
+			return nil, err
+		}
+		// 中间可能收到bus发来的信号，比如NameAcquired，直接丢弃
+		if serial, ok := reply.Fields[fieldReplySerial].(uint32); !ok || serial != msg.Serial {
+			continue
+		}
+		switch reply.Type {
+		case typeMethodReturn:
+			return reply.Body, nil
+		case typeError:
+			dbusErr := &Error{Name: reply.field(fieldErrorName)}
+			if len(reply.Body) > 0 {
+				dbusErr.Message, _ = reply.Body[0].(string)
+			}
+			return nil, dbusErr
+		}
+	}
+}
+
+// WriteMessage 按小端序编码并发送一条消息
+func WriteMessage(w io.Writer, msg *Message) error {
+	body := &encoder{}
+	signature, _ := msg.Fields[fieldSignature].(string)
+	types, err := splitSignature(signature)
+	if err != nil {
+		return err
+	}
+	if len(types) != len(msg.Body) {
+		return fmt.Errorf("signature %s does not match %d arguments", signature, len(msg.Body))
+	}
+	for i, t := range types {
+		if err := body.encode(t, msg.Body[i]); err != nil {
+			return err
+		}
+	}
+
+	var fields []interface{}
+	for code := byte(fieldPath); code <= fieldSignature; code++ {
+		value, ok := msg.Fields[code]
+		if !ok {
+			continue
+		}
+		fields = append(fields, []interface{}{code, Variant{fieldSignatures[code], value}})
+	}
+	header := &encoder{}
+	header.encode("y", byte('l'))
+	header.encode("y", msg.Type)
+	header.encode("y", byte(0))
+	header.encode("y", byte(1))
+	header.encode("u", uint32(body.buf.Len()))
+	header.encode("u", msg.Serial)
+	if err := header.encode("a(yv)", fields); err != nil {
+		return err
+	}
+	header.align(8)
+	_, err = w.Write(append(header.buf.Bytes(), body.buf.Bytes()...))
+	return err
+}
+
+// 各个头字段的类型
+var fieldSignatures = map[byte]string{
+	fieldPath:        "o",
+	fieldInterface:   "s",
+	fieldMember:      "s",
+	fieldErrorName:   "s",
+	fieldReplySerial: "u",
+	fieldDestination: "s",
+	fieldSender:      "s",
+	fieldSignature:   "g",
+}
+
+// ReadMessage 读取并解码一条消息
+func ReadMessage(r io.Reader) (*Message, error) {
+	fixed := make([]byte, 16)
+	if _, err := io.ReadFull(r, fixed); err != nil {
+		return nil, err
+	}
+	var order binary.ByteOrder = binary.LittleEndian
+	if fixed[0] == 'B' {
+		order = binary.BigEndian
+	} else if fixed[0] != 'l' {
+		return nil, fmt.Errorf("invalid dbus message endianness %q", fixed[0])
+	}
+	bodyLen := order.Uint32(fixed[4:8])
+	fieldsLen := order.Uint32(fixed[12:16])
+	// 头字段数组之后补齐到8字节
+	headerLen := 16 + int(fieldsLen)
+	headerLen += (8 - headerLen%8) % 8
+	rest := make([]byte, headerLen-16+int(bodyLen))
+	if _, err := io.ReadFull(r, rest); err != nil {
+		return nil, err
+	}
+	header := &decoder{buf: append(fixed, rest[:headerLen-16]...), order: order, pos: 12}
+	value, err := header.decode("a(yv)")
+	if err != nil {
+		return nil, fmt.Errorf("decode dbus header error %v", err)
+	}
+	msg := &Message{Type: fixed[1], Serial: order.Uint32(fixed[8:12]), Fields: map[byte]interface{}{}}
+	for _, field := range value.([]interface{}) {
+		f := field.([]interface{})
+		msg.Fields[f[0].(byte)] = f[1].(Variant).Value
+	}
+	signature, _ := msg.Fields[fieldSignature].(string)
+	types, err := splitSignature(signature)
+	if err != nil {
+		return nil, err
+	}
+	body := &decoder{buf: rest[headerLen-16:], order: order}
+	for _, t := range types {
+		v, err := body.decode(t)
+		if err != nil {
+			return nil, fmt.Errorf("decode dbus body error %v", err)
+		}
+		msg.Body = append(msg.Body, v)
+	}
+	return msg, nil
+}
+
+// splitSignature 把签名拆成一个个完整的类型，比如 "sa(sv)" 拆成 "s" 和 "a(sv)"
+func splitSignature(signature string) ([]string, error) {
+	var types []string
+	for signature != "" {
+		n, err := typeLen(signature)
+		if err != nil {
+			return nil, err
+		}
+		types = append(types, signature[:n])
+		signature = signature[n:]
+	}
+	return types, nil
+}
+
+// typeLen 返回签名开头第一个完整类型的长度
+func typeLen(signature string) (int, error) {
+	if signature == "" {
+		return 0, fmt.Errorf("incomplete signature")
+	}
+	switch signature[0] {
+	case 'y', 'b', 'n', 'q', 'i', 'u', 'x', 't', 's', 'o', 'g', 'v', 'h':
+		return 1, nil
+	case 'a':
+		n, err := typeLen(signature[1:])
+		return n + 1, err
+	case '(', '{':
+		end := byte(')')
+		if signature[0] == '{' {
+			end = '}'
+		}
+		i := 1
+		for i < len(signature) && signature[i] != end {
+			n, err := typeLen(signature[i:])
+			if err != nil {
+				return 0, err
+			}
+			i += n
+		}
+		if i >= len(signature) {
+			return 0, fmt.Errorf("unterminated struct in signature %s", signature)
+		}
+		return i + 1, nil
+	}
+	return 0, fmt.Errorf("unsupported type %q in signature", signature[0])
+}
+
+// alignment 返回类型的对齐字节数
+func alignment(t byte) int {
+	switch t {
+	case 'y', 'g', 'v':
+		return 1
+	case 'n', 'q':
+		return 2
+	case 'x', 't', 'd', '(', '{':
+		return 8
+	}
+	return 4
+}
+
+type encoder struct {
+	buf bytes.Buffer
+}
+
+func (e *encoder) align(n int) {
+	for e.buf.Len()%n != 0 {
+		e.buf.WriteByte(0)
+	}
+}
+
+func (e *encoder) uint32(v uint32) {
+	e.align(4)
+	binary.Write(&e.buf, binary.LittleEndian, v)
+}
+
+// encode 按类型t编码一个值，t必须是一个完整类型
+func (e *encoder) encode(t string, v interface{}) error {
+	switch t[0] {
+	case 'y':
+		b, ok := v.(byte)
+		if !ok {
+			return fmt.Errorf("value %v is not a byte", v)
+		}
+		e.buf.WriteByte(b)
+	case 'b':
+		b, ok := v.(bool)
+		if !ok {
+			return fmt.Errorf("value %v is not a bool", v)
+		}
+		var u uint32
+		if b {
+			u = 1
+		}
+		e.uint32(u)
+	case 'u':
+		u, ok := v.(uint32)
+		if !ok {
+			return fmt.Errorf("value %v is not a uint32", v)
+		}
+		e.uint32(u)
+	case 'i':
+		i, ok := v.(int32)
+		if !ok {
+			return fmt.Errorf("value %v is not an int32", v)
+		}
+		e.uint32(uint32(i))
+	case 't', 'x':
+		var u uint64
+		switch n := v.(type) {
+		case uint64:
+			u = n
+		case int64:
+			u = uint64(n)
+		default:
+			return fmt.Errorf("value %v is not a 64 bit integer", v)
+		}
+		e.align(8)
+		binary.Write(&e.buf, binary.LittleEndian, u)
+	case 's', 'o':
+		s, ok := v.(string)
+		if !ok {
+			return fmt.Errorf("value %v is not a string", v)
+		}
+		e.uint32(uint32(len(s)))
+		e.buf.WriteString(s)
+		e.buf.WriteByte(0)
+	case 'g':
+		s, ok := v.(string)
+		if !ok {
+			return fmt.Errorf("value %v is not a signature", v)
+		}
+		e.buf.WriteByte(byte(len(s)))
+		e.buf.WriteString(s)
+		e.buf.WriteByte(0)
+	case 'v':
+		variant, ok := v.(Variant)
+		if !ok {
+			return fmt.Errorf("value %v is not a variant", v)
+		}
+		e.encode("g", variant.Signature)
+		return e.encode(variant.Signature, variant.Value)
+	case 'a':
+		slice := reflect.ValueOf(v)
+		if slice.Kind() != reflect.Slice {
+			return fmt.Errorf("value %v is not an array", v)
+		}
+		e.uint32(0)
+		lenPos := e.buf.Len() - 4
+		// 数组长度不包括第一个元素之前的对齐
+		e.align(alignment(t[1]))
+		start := e.buf.Len()
+		for i := 0; i < slice.Len(); i++ {
+			if err := e.encode(t[1:], slice.Index(i).Interface()); err != nil {
+				return err
+			}
+		}
+		binary.LittleEndian.PutUint32(e.buf.Bytes()[lenPos:], uint32(e.buf.Len()-start))
+	case '(', '{':
+		values, ok := v.([]interface{})
+		if !ok {
+			return fmt.Errorf("value %v is not a struct", v)
+		}
+		types, err := splitSignature(t[1 : len(t)-1])
+		if err != nil {
+			return err
+		}
+		if len(types) != len(values) {
+			return fmt.Errorf("struct %s has %d fields, got %d", t, len(types), len(values))
+		}
+		e.align(8)
+		for i, ft := range types {
+			if err := e.encode(ft, values[i]); err != nil {
+				return err
+			}
+		}
+	default:
+		return fmt.Errorf("unsupported type %s", t)
+	}
+	return nil
+}
+
+type decoder struct {
+	buf   []byte
+	order binary.ByteOrder
+	pos   int
+}
+
+func (d *decoder) align(n int) {
+	d.pos += (n - d.pos%n) % n
+}
+
+func (d *decoder) next(n int) ([]byte, error) {
+	if d.pos+n > len(d.buf) {
+		return nil, io.ErrUnexpectedEOF
+	}
+	b := d.buf[d.pos : d.pos+n]
+	d.pos += n
+	return b, nil
+}
+
+func (d *decoder) uint32() (uint32, error) {
+	d.align(4)
+	b, err := d.next(4)
+	if err != nil {
+		return 0, err
+	}
+	return d.order.Uint32(b), nil
+}
+
+func (d *decoder) decode(t string) (interface{}, error) {
+	switch t[0] {
+	case 'y':
+		b, err := d.next(1)
+		if err != nil {
+			return nil, err
+		}
+		return b[0], nil
+	case 'b':
+		u, err := d.uint32()
+		return u != 0, err
+	case 'u', 'h':
+		return d.uint32()
+	case 'i':
+		u, err := d.uint32()
+		return int32(u), err
+	case 'n', 'q':
+		d.align(2)
+		b, err := d.next(2)
+		if err != nil {
+			return nil, err
+		}
+		if t[0] == 'n' {
+			return int16(d.order.Uint16(b)), nil
+		}
+		return d.order.Uint16(b), nil
+	case 't', 'x':
+		d.align(8)
+		b, err := d.next(8)
+		if err != nil {
+			return nil, err
+		}
+		if t[0] == 'x' {
+			return int64(d.order.Uint64(b)), nil
+		}
+		return d.order.Uint64(b), nil
+	case 's', 'o':
+		n, err := d.uint32()
+		if err != nil {
+			return nil, err
+		}
+		b, err := d.next(int(n) + 1)
+		if err != nil {
+			return nil, err
+		}
+		return string(b[:n]), nil
+	case 'g':
+		n, err := d.next(1)
+		if err != nil {
+			return nil, err
+		}
+		b, err := d.next(int(n[0]) + 1)
+		if err != nil {
+			return nil, err
+		}
+		return string(b[:n[0]]), nil
+	case 'v':
+		signature, err := d.decode("g")
+		if err != nil {
+			return nil, err
+		}
+		sig := signature.(string)
+		if n, err := typeLen(sig); err != nil || n != len(sig) {
+			return nil, fmt.Errorf("invalid variant signature %s", sig)
+		}
+		value, err := d.decode(sig)
+		return Variant{sig, value}, err
+	case 'a':
+		n, err := d.uint32()
+		if err != nil {
+			return nil, err
+		}
+		d.align(alignment(t[1]))
+		end := d.pos + int(n)
+		if end > len(d.buf) {
+			return nil, io.ErrUnexpectedEOF
+		}
+		values := []interface{}{}
+		for d.pos < end {
+			v, err := d.decode(t[1:])
+			if err != nil {
+				return nil, err
+			}
+			values = append(values, v)
+		}
+		return values, nil
+	case '(', '{':
+		types, err := splitSignature(t[1 : len(t)-1])
+		if err != nil {
+			return nil, err
+		}
+		d.align(8)
+		var values []interface{}
+		for _, ft := range types {
+			v, err := d.decode(ft)
+			if err != nil {
+				return nil, err
+			}
+			values = append(values, v)
+		}
+		return values, nil
+	}
+	return nil, fmt.Errorf("unsupported type %s", t)
+}
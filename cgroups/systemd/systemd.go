@@ -0,0 +1,154 @@
+package systemd
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	systemdDest     = "org.freedesktop.systemd1"
+	systemdPath     = "/org/freedesktop/systemd1"
+	managerIface    = "org.freedesktop.systemd1.Manager"
+	unitIface       = "org.freedesktop.systemd1.Unit"
+	propertiesIface = "org.freedesktop.DBus.Properties"
+
+	// ErrNoSuchUnit 是unit不存在时systemd返回的错误
+	ErrNoSuchUnit = "org.freedesktop.systemd1.NoSuchUnit"
+)
+
+// Property 是创建transient unit时设置的属性
+type Property struct {
+	Name  string
+	Value Variant
+}
+
+// PropString、PropUint64等创建不同类型的属性
+func PropString(name, value string) Property {
+	return Property{name, Variant{"s", value}}
+}
+
+func PropBool(name string, value bool) Property {
+	return Property{name, Variant{"b", value}}
+}
+
+func PropUint64(name string, value uint64) Property {
+	return Property{name, Variant{"t", value}}
+}
+
+func PropPids(pids ...uint32) Property {
+	return Property{"PIDs", Variant{"au", pids}}
+}
+
+// Available 判断能不能通过system bus调用systemd
+func Available() error {
+	conn, err := Dial(SystemBusAddress())
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	owned, err := conn.NameHasOwner(systemdDest)
+	if err != nil {
+		return err
+	}
+	if !owned {
+		return fmt.Errorf("%s is not running on the system bus", systemdDest)
+	}
+	return nil
+}
+
+// Version 返回systemd的主版本号，有些unit属性只有新版本才支持
+func (c *Conn) Version() (int, error) {
+	reply, err := c.Call(systemdDest, systemdPath, propertiesIface, "Get", "ss", managerIface, "Version")
+	if err != nil {
+		return 0, fmt.Errorf("get systemd version error %v", err)
+	}
+	if v, ok := reply[0].(Variant); ok {
+		if version, ok := v.Value.(string); ok {
+			return parseVersion(version)
+		}
+	}
+	return 0, fmt.Errorf("invalid Version reply %v", reply)
+}
+
+// parseVersion 发行版的版本号各不相同，比如 245.4-4ubuntu3、v239、252，只取开头的数字
+func parseVersion(version string) (int, error) {
+	s := strings.TrimPrefix(version, "v")
+	end := 0
+	for end < len(s) && s[end] >= '0' && s[end] <= '9' {
+		end++
+	}
+	major, err := strconv.Atoi(s[:end])
+	if err != nil {
+		return 0, fmt.Errorf("invalid systemd version %s", version)
+	}
+	return major, nil
+}
+
+// StartTransientUnit 创建并启动一个transient unit，等到unit变成active才返回
+func (c *Conn) StartTransientUnit(name string, properties []Property) error {
+	var props []interface{}
+	for _, p := range properties {
+		props = append(props, []interface{}{p.Name, p.Value})
+	}
+	if _, err := c.Call(systemdDest, systemdPath, managerIface, "StartTransientUnit", "ssa(sv)a(sa(sv))",
+		name, "replace", props, []interface{}{}); err != nil {
+		return fmt.Errorf("start transient unit %s error %v", name, err)
+	}
+	// StartTransientUnit只是创建了一个job，job完成之后unit才是active
+	for i := 0; i < 100; i++ {
+		state, err := c.UnitActiveState(name)
+		if err != nil {
+			return err
+		}
+		switch state {
+		case "active":
+			return nil
+		case "failed":
+			return fmt.Errorf("unit %s failed to start", name)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	return fmt.Errorf("timeout waiting for unit %s to start", name)
+}
+
+// UnitActiveState 返回unit的ActiveState，比如active、activating、failed
+func (c *Conn) UnitActiveState(name string) (string, error) {
+	reply, err := c.Call(systemdDest, systemdPath, managerIface, "GetUnit", "s", name)
+	if err != nil {
+		return "", fmt.Errorf("get unit %s error %v", name, err)
+	}
+	path, ok := reply[0].(string)
+	if !ok {
+		return "", fmt.Errorf("invalid GetUnit reply %v", reply)
+	}
+	reply, err = c.Call(systemdDest, path, propertiesIface, "Get", "ss", unitIface, "ActiveState")
+	if err != nil {
+		return "", fmt.Errorf("get unit %s state error %v", name, err)
+	}
+	if v, ok := reply[0].(Variant); ok {
+		if state, ok := v.Value.(string); ok {
+			return state, nil
+		}
+	}
+	return "", fmt.Errorf("invalid ActiveState reply %v", reply)
+}
+
+// StopUnit 停止unit，会杀掉unit中剩下的进程
+func (c *Conn) StopUnit(name string) error {
+	_, err := c.Call(systemdDest, systemdPath, managerIface, "StopUnit", "ss", name, "replace")
+	return err
+}
+
+// ResetFailedUnit 清除unit的failed状态，这样同名的unit才能再次创建
+func (c *Conn) ResetFailedUnit(name string) error {
+	_, err := c.Call(systemdDest, systemdPath, managerIface, "ResetFailedUnit", "s", name)
+	return err
+}
+
+// IsNoSuchUnit 判断错误是不是unit不存在
+func IsNoSuchUnit(err error) bool {
+	dbusErr, ok := err.(*Error)
+	return ok && dbusErr.Name == ErrNoSuchUnit
+}
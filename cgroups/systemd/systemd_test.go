@@ -0,0 +1,175 @@
+package systemd
+
+import (
+	"bufio"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// fakeBus 模拟system bus和systemd，记录收到的方法调用
+type fakeBus struct {
+	listener net.Listener
+	calls    chan *Message
+}
+
+func newFakeBus(t *testing.T) (*fakeBus, string) {
+	dir, err := ioutil.TempDir("", "dbus")
+	if err != nil {
+		t.Fatal(err)
+	}
+	socket := filepath.Join(dir, "bus")
+	listener, err := net.Listen("unix", socket)
+	if err != nil {
+		os.RemoveAll(dir)
+		t.Fatal(err)
+	}
+	bus := &fakeBus{listener: listener, calls: make(chan *Message, 16)}
+	go bus.serve()
+	return bus, "unix:path=" + socket
+}
+
+func (b *fakeBus) close() {
+	b.listener.Close()
+	os.RemoveAll(filepath.Dir(b.listener.Addr().String()))
+}
+
+func (b *fakeBus) serve() {
+	conn, err := b.listener.Accept()
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+	reader := bufio.NewReader(conn)
+	line, err := reader.ReadString('\n')
+	if err != nil || !strings.HasPrefix(line, "\x00AUTH EXTERNAL ") {
+		conn.Write([]byte("REJECTED EXTERNAL\r\n"))
+		return
+	}
+	conn.Write([]byte("OK 0123456789abcdef\r\n"))
+	if line, err := reader.ReadString('\n'); err != nil || line != "BEGIN\r\n" {
+		return
+	}
+	for serial := uint32(1); ; serial++ {
+		msg, err := ReadMessage(reader)
+		if err != nil {
+			return
+		}
+		b.calls <- msg
+		reply := &Message{
+			Type:   typeMethodReturn,
+			Serial: serial,
+			Fields: map[byte]interface{}{fieldReplySerial: msg.Serial},
+		}
+		switch msg.field(fieldMember) {
+		case "Hello":
+			// 先发一个信号，客户端要能跳过它
+			WriteMessage(conn, &Message{Type: typeSignal, Serial: serial, Fields: map[byte]interface{}{
+				fieldPath: "/org/freedesktop/DBus", fieldInterface: "org.freedesktop.DBus", fieldMember: "NameAcquired",
+				fieldSignature: "s"}, Body: []interface{}{":1.1"}})
+			reply.Fields[fieldSignature], reply.Body = "s", []interface{}{":1.1"}
+		case "StartTransientUnit":
+			reply.Fields[fieldSignature], reply.Body = "o", []interface{}{"/org/freedesktop/systemd1/job/1"}
+		case "GetUnit":
+			reply.Fields[fieldSignature], reply.Body = "o", []interface{}{"/org/freedesktop/systemd1/unit/test_2escope"}
+		case "Get":
+			value := "active"
+			if len(msg.Body) == 2 && msg.Body[1] == "Version" {
+				value = "245.4-4ubuntu3"
+			}
+			reply.Fields[fieldSignature], reply.Body = "v", []interface{}{Variant{"s", value}}
+		default:
+			reply.Type = typeError
+			reply.Fields[fieldErrorName] = ErrNoSuchUnit
+			reply.Fields[fieldSignature], reply.Body = "s", []interface{}{"Unit test.scope not loaded."}
+		}
+		WriteMessage(conn, reply)
+	}
+}
+
+func TestStartTransientUnit(t *testing.T) {
+	bus, address := newFakeBus(t)
+	defer bus.close()
+
+	conn, err := Dial(address)
+	if err != nil {
+		t.Fatalf("dial %v", err)
+	}
+	defer conn.Close()
+	<-bus.calls
+
+	properties := []Property{
+		PropString("Slice", "system.slice"),
+		PropBool("Delegate", true),
+		PropPids(123),
+		PropUint64("MemoryMax", 104857600),
+	}
+	if err := conn.StartTransientUnit("test.scope", properties); err != nil {
+		t.Fatalf("start transient unit %v", err)
+	}
+	call := <-bus.calls
+	if call.field(fieldMember) != "StartTransientUnit" || call.field(fieldSignature) != "ssa(sv)a(sa(sv))" {
+		t.Fatalf("unexpected call %v", call.Fields)
+	}
+	want := []interface{}{
+		"test.scope",
+		"replace",
+		[]interface{}{
+			[]interface{}{"Slice", Variant{"s", "system.slice"}},
+			[]interface{}{"Delegate", Variant{"b", true}},
+			[]interface{}{"PIDs", Variant{"au", []interface{}{uint32(123)}}},
+			[]interface{}{"MemoryMax", Variant{"t", uint64(104857600)}},
+		},
+		[]interface{}{},
+	}
+	if !reflect.DeepEqual(call.Body, want) {
+		t.Errorf("body %#v, want %#v", call.Body, want)
+	}
+	if call := <-bus.calls; call.field(fieldMember) != "GetUnit" {
+		t.Errorf("unexpected call %s", call.field(fieldMember))
+	}
+	if call := <-bus.calls; call.field(fieldMember) != "Get" || call.field(fieldPath) != "/org/freedesktop/systemd1/unit/test_2escope" {
+		t.Errorf("unexpected call %s %s", call.field(fieldMember), call.field(fieldPath))
+	}
+
+	err = conn.StopUnit("test.scope")
+	if !IsNoSuchUnit(err) {
+		t.Errorf("stop unit error %v, want %s", err, ErrNoSuchUnit)
+	}
+}
+
+func TestVersion(t *testing.T) {
+	bus, address := newFakeBus(t)
+	defer bus.close()
+
+	conn, err := Dial(address)
+	if err != nil {
+		t.Fatalf("dial %v", err)
+	}
+	defer conn.Close()
+	if version, err := conn.Version(); err != nil || version != 245 {
+		t.Errorf("version %d %v, want 245", version, err)
+	}
+	for s, want := range map[string]int{"v239": 239, "252": 252, "249.11-0ubuntu3": 249} {
+		if version, err := parseVersion(s); err != nil || version != want {
+			t.Errorf("parse version %s: %d %v", s, version, err)
+		}
+	}
+	if _, err := parseVersion("unknown"); err == nil {
+		t.Errorf("invalid version should fail")
+	}
+}
+
+func TestParseAddress(t *testing.T) {
+	socket, err := parseAddress("tcp:host=localhost;unix:path=/run/dbus/system_bus_socket,guid=1")
+	if err != nil || socket != "/run/dbus/system_bus_socket" {
+		t.Errorf("parse address %s %v", socket, err)
+	}
+	if _, err := parseAddress("unix:abstract=/tmp/dbus"); err == nil {
+		t.Errorf("abstract socket should not be supported")
+	}
+}
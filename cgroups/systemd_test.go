@@ -0,0 +1,103 @@
+package cgroups
+
+import (
+	"os"
+	"reflect"
+	"testing"
+
+	"github.com/xianlubird/mydocker/cgroups/subsystems"
+	"github.com/xianlubird/mydocker/cgroups/systemd"
+)
+
+func TestSystemdProperties(t *testing.T) {
+	res := &subsystems.ResourceConfig{
+		MemoryLimit: "100m",
+		CpuShare:    "1024",
+		CpuPeriod:   "50000",
+		CpuQuota:    "25000",
+		PidsLimit:   "100",
+		BlkioWeight: "500",
+		MemorySwap:  "200m",
+	}
+	properties, err := systemdProperties("abc", 123, res, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	values := map[string]interface{}{}
+	for _, p := range properties {
+		values[p.Name] = p.Value.Value
+	}
+	want := map[string]interface{}{
+		"Description":         "mydocker container abc",
+		"Slice":               "system.slice",
+		"Delegate":            true,
+		"DefaultDependencies": false,
+		"PIDs":                []uint32{123},
+		"MemoryLimit":         uint64(104857600),
+		"CPUShares":           uint64(1024),
+		"CPUQuotaPeriodUSec":  uint64(50000),
+		"CPUQuotaPerSecUSec":  uint64(500000),
+		"TasksMax":            uint64(100),
+		"BlockIOWeight":       uint64(500),
+	}
+	if !reflect.DeepEqual(values, want) {
+		t.Errorf("properties %v, want %v", values, want)
+	}
+
+	properties, err = systemdProperties("abc", 123, &subsystems.ResourceConfig{MemoryLimit: "1g", CpuShare: "1024"}, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p := properties[len(properties)-2]; p.Name != "MemoryMax" || p.Value != (systemd.Variant{Signature: "t", Value: uint64(1 << 30)}) {
+		t.Errorf("memory property %v", p)
+	}
+	if p := properties[len(properties)-1]; p.Name != "CPUWeight" || p.Value != (systemd.Variant{Signature: "t", Value: uint64(39)}) {
+		t.Errorf("cpu property %v", p)
+	}
+	if _, err := systemdProperties("abc", 123, &subsystems.ResourceConfig{CpuQuota: "x"}, false); err == nil {
+		t.Errorf("invalid cpu quota should fail")
+	}
+}
+
+func TestRemoveProperty(t *testing.T) {
+	properties, err := systemdProperties("abc", 123, &subsystems.ResourceConfig{CpuPeriod: "50000", CpuQuota: "25000"}, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	remaining, removed := removeProperty(properties, "CPUQuotaPeriodUSec")
+	if !removed || len(remaining) != len(properties)-1 {
+		t.Fatalf("CPUQuotaPeriodUSec is not removed from %v", remaining)
+	}
+	for _, p := range remaining {
+		if p.Name == "CPUQuotaPeriodUSec" {
+			t.Errorf("CPUQuotaPeriodUSec is still in %v", remaining)
+		}
+	}
+	// quota按周期换算成每秒的时间，和systemd使用的周期无关
+	if p := remaining[len(remaining)-1]; p.Name != "CPUQuotaPerSecUSec" || p.Value.Value != uint64(500000) {
+		t.Errorf("quota property %v", p)
+	}
+	if _, removed := removeProperty(remaining, "CPUQuotaPeriodUSec"); removed {
+		t.Errorf("removed a property that does not exist")
+	}
+}
+
+func TestSystemdApplyError(t *testing.T) {
+	old, had := os.LookupEnv("DBUS_SYSTEM_BUS_ADDRESS")
+	os.Setenv("DBUS_SYSTEM_BUS_ADDRESS", "unix:path=/nonexistent/mydocker-test-bus")
+	defer func() {
+		if had {
+			os.Setenv("DBUS_SYSTEM_BUS_ADDRESS", old)
+		} else {
+			os.Unsetenv("DBUS_SYSTEM_BUS_ADDRESS")
+		}
+	}()
+	// 没有scope的时候不能当作成功，容器会运行在cgroup之外
+	manager := &CgroupManager{Path: "testsystemdapply", driver: newSystemdDriver()}
+	if err := manager.Set(&subsystems.ResourceConfig{}); err != nil {
+		t.Fatal(err)
+	}
+	if err := manager.Apply(os.Getpid()); err == nil {
+		t.Fatalf("expect error when systemd is not reachable")
+	}
+}
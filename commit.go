@@ -4,17 +4,81 @@ package main
 import (
 	log "github.com/Sirupsen/logrus"
 	"fmt"
+	"github.com/xianlubird/mydocker/archive"
 	"github.com/xianlubird/mydocker/container"
-	"os/exec"
+	"github.com/xianlubird/mydocker/image"
+	"io"
 )
 
-func commitContainer(containerName, imageName string){
-	mntURL := fmt.Sprintf(container.MntUrl, containerName)
-	mntURL += "/"
+//commit只保存容器可写层的改动，作为新的layer叠加在容器所用镜像的layer之上
+func commitContainer(containerName, imageName string, opts image.CommitOptions) error {
+	containerInfo, err := getContainerInfoByName(containerName)
+	if err != nil {
+		return err
+	}
+	if containerInfo.Bundle != "" {
+		return fmt.Errorf("container %s is created from bundle %s, commit is not supported", containerName, containerInfo.Bundle)
+	}
+	parent, err := image.Get(containerInfo.Image)
+	if err != nil {
+		log.Errorf("Get image %s error %v", containerInfo.Image, err)
+		return err
+	}
+	if parent == nil {
+		return fmt.Errorf("image %s of container %s is not a layered image, can not commit", containerInfo.Image, containerName)
+	}
+	driver, err := container.GetStorageDriver(containerInfo.StorageDriver)
+	if err != nil {
+		return err
+	}
+	diff, err := driver.Diff(containerName)
+	if err != nil {
+		log.Errorf("Diff write layer of %s error %v", containerName, err)
+		return err
+	}
+	defer diff.Close()
+	var layer io.Reader = diff
+	//user namespace中的容器，可写层里是宿主机上的id，转换回容器内的id再保存
+	if m := containerInfo.IDMapping; m != nil {
+		remapped := archive.ToContainerOwnership(diff, m.UIDMaps, m.GIDMaps)
+		defer remapped.Close()
+		layer = remapped
+	}
 
-	imageTar := container.RootUrl + "/" + imageName + ".tar"
+	img, err := image.Commit(imageName, parent, layer, opts)
+	if err != nil {
+		log.Errorf("Commit container %s to image %s error %v", containerName, imageName, err)
+		return err
+	}
+	fmt.Println(img.Digest)
+	return nil
+}
 
-	if _, err := exec.Command("tar", "-czf", imageTar, "-C", mntURL, ".").CombinedOutput(); err != nil {
-		log.Errorf("Tar folder %s error %v", mntURL, err)
+//把镜像config中的默认参数加到init spec中，run命令行参数优先，返回镜像指定的stop信号
+func applyImageConfig(spec *container.InitSpec, imageName string) (string, error) {
+	img, err := image.Get(imageName)
+	if err != nil || img == nil {
+		return "", err
+	}
+	config, err := img.LoadConfig()
+	if err != nil {
+		log.Errorf("Load config of image %s error %v", imageName, err)
+		return "", err
+	}
+	if config == nil {
+		return "", nil
+	}
+	if len(spec.Args) == 0 {
+		spec.Args = append(append([]string{}, config.Config.Entrypoint...), config.Config.Cmd...)
+	} else if len(config.Config.Entrypoint) > 0 {
+		spec.Args = append(append([]string{}, config.Config.Entrypoint...), spec.Args...)
+	}
+	spec.Env = append(spec.Env, config.Config.Env...)
+	if spec.Cwd == "" {
+		spec.Cwd = config.Config.WorkingDir
+	}
+	if spec.User == "" {
+		spec.User = config.Config.User
 	}
+	return config.Config.StopSignal, nil
 }
@@ -0,0 +1,225 @@
+package container
+
+import (
+	"fmt"
+	"io/ioutil"
+	"sort"
+	"strconv"
+	"strings"
+	"syscall"
+	"unsafe"
+)
+
+// capabilities 是capability名字到编号的映射，参考 linux/capability.h
+var capabilities = map[string]uint{
+	"CAP_CHOWN":              0,
+	"CAP_DAC_OVERRIDE":       1,
+	"CAP_DAC_READ_SEARCH":    2,
+	"CAP_FOWNER":             3,
+	"CAP_FSETID":             4,
+	"CAP_KILL":               5,
+	"CAP_SETGID":             6,
+	"CAP_SETUID":             7,
+	"CAP_SETPCAP":            8,
+	"CAP_LINUX_IMMUTABLE":    9,
+	"CAP_NET_BIND_SERVICE":   10,
+	"CAP_NET_BROADCAST":      11,
+	"CAP_NET_ADMIN":          12,
+	"CAP_NET_RAW":            13,
+	"CAP_IPC_LOCK":           14,
+	"CAP_IPC_OWNER":          15,
+	"CAP_SYS_MODULE":         16,
+	"CAP_SYS_RAWIO":          17,
+	"CAP_SYS_CHROOT":         18,
+	"CAP_SYS_PTRACE":         19,
+	"CAP_SYS_PACCT":          20,
+	"CAP_SYS_ADMIN":          21,
+	"CAP_SYS_BOOT":           22,
+	"CAP_SYS_NICE":           23,
+	"CAP_SYS_RESOURCE":       24,
+	"CAP_SYS_TIME":           25,
+	"CAP_SYS_TTY_CONFIG":     26,
+	"CAP_MKNOD":              27,
+	"CAP_LEASE":              28,
+	"CAP_AUDIT_WRITE":        29,
+	"CAP_AUDIT_CONTROL":      30,
+	"CAP_SETFCAP":            31,
+	"CAP_MAC_OVERRIDE":       32,
+	"CAP_MAC_ADMIN":          33,
+	"CAP_SYSLOG":             34,
+	"CAP_WAKE_ALARM":         35,
+	"CAP_BLOCK_SUSPEND":      36,
+	"CAP_AUDIT_READ":         37,
+	"CAP_PERFMON":            38,
+	"CAP_BPF":                39,
+	"CAP_CHECKPOINT_RESTORE": 40,
+}
+
+// DefaultCapabilities 和docker默认保留的capability一致
+var DefaultCapabilities = []string{
+	"CAP_CHOWN",
+	"CAP_DAC_OVERRIDE",
+	"CAP_FSETID",
+	"CAP_FOWNER",
+	"CAP_MKNOD",
+	"CAP_NET_RAW",
+	"CAP_SETGID",
+	"CAP_SETUID",
+	"CAP_SETFCAP",
+	"CAP_SETPCAP",
+	"CAP_NET_BIND_SERVICE",
+	"CAP_SYS_CHROOT",
+	"CAP_KILL",
+	"CAP_AUDIT_WRITE",
+}
+
+// normalizeCapability 接受 net_admin、NET_ADMIN 和 CAP_NET_ADMIN 几种写法
+func normalizeCapability(name string) (string, error) {
+	name = strings.ToUpper(name)
+	if name == "ALL" {
+		return name, nil
+	}
+	if !strings.HasPrefix(name, "CAP_") {
+		name = "CAP_" + name
+	}
+	if _, ok := capabilities[name]; !ok {
+		return "", fmt.Errorf("unknown capability %s", name)
+	}
+	return name, nil
+}
+
+func allCapabilities() []string {
+	caps := make([]string, 0, len(capabilities))
+	for name := range capabilities {
+		caps = append(caps, name)
+	}
+	return caps
+}
+
+// ComputeCapabilities 在默认capability的基础上处理 --cap-add/--cap-drop，ALL表示所有capability，
+// 先drop再add，所以 --cap-drop ALL --cap-add NET_ADMIN 只保留NET_ADMIN。privileged时保留全部
+func ComputeCapabilities(add, drop []string, privileged bool) ([]string, error) {
+	set := map[string]bool{}
+	base := DefaultCapabilities
+	if privileged {
+		base = allCapabilities()
+	}
+	for _, name := range base {
+		set[name] = true
+	}
+	for _, name := range drop {
+		name, err := normalizeCapability(name)
+		if err != nil {
+			return nil, err
+		}
+		if name == "ALL" {
+			set = map[string]bool{}
+			continue
+		}
+		delete(set, name)
+	}
+	for _, name := range add {
+		name, err := normalizeCapability(name)
+		if err != nil {
+			return nil, err
+		}
+		if name == "ALL" {
+			for _, c := range allCapabilities() {
+				set[c] = true
+			}
+			continue
+		}
+		set[name] = true
+	}
+	caps := make([]string, 0, len(set))
+	for name := range set {
+		caps = append(caps, name)
+	}
+	sort.Slice(caps, func(i, j int) bool { return capabilities[caps[i]] < capabilities[caps[j]] })
+	return caps, nil
+}
+
+// CapabilityMask 把capability列表转换成位图，exec时交给nsenter
+func CapabilityMask(caps []string) (uint64, error) {
+	var mask uint64
+	for _, name := range caps {
+		name, err := normalizeCapability(name)
+		if err != nil {
+			return 0, err
+		}
+		if name == "ALL" {
+			return ^uint64(0), nil
+		}
+		mask |= 1 << capabilities[name]
+	}
+	return mask, nil
+}
+
+// lastCap 是当前内核支持的最大capability编号
+func lastCap() uint {
+	content, err := ioutil.ReadFile("/proc/sys/kernel/cap_last_cap")
+	if err != nil {
+		return 40
+	}
+	last, err := strconv.Atoi(strings.TrimSpace(string(content)))
+	if err != nil {
+		return 40
+	}
+	return uint(last)
+}
+
+const (
+	prCapbsetDrop        = 24
+	prCapAmbient         = 47
+	prCapAmbientClearAll = 4
+	linuxCapabilityV3    = 0x20080522
+)
+
+type capHeader struct {
+	version uint32
+	pid     int32
+}
+
+type capData struct {
+	effective   uint32
+	permitted   uint32
+	inheritable uint32
+}
+
+// dropBoundingSet 把不在mask中的capability从bounding set中去掉，之后exec出来的进程再也拿不回来。
+// 需要CAP_SETPCAP，所以要在切换用户之前调用
+func dropBoundingSet(mask uint64) error {
+	last := lastCap()
+	for c := uint(0); c <= last; c++ {
+		if mask&(1<<c) != 0 {
+			continue
+		}
+		if _, _, errno := syscall.RawSyscall(syscall.SYS_PRCTL, prCapbsetDrop, uintptr(c), 0); errno != 0 {
+			return fmt.Errorf("drop capability %d from bounding set error %v", c, errno)
+		}
+	}
+	_, _, errno := syscall.RawSyscall6(syscall.SYS_PRCTL, prCapAmbient, prCapAmbientClearAll, 0, 0, 0, 0)
+	if errno != 0 && errno != syscall.EINVAL {
+		return fmt.Errorf("clear ambient capabilities error %v", errno)
+	}
+	return nil
+}
+
+// setCapabilities 把effective、permitted和inheritable都设置成mask，只能减少不能增加
+func setCapabilities(mask uint64) error {
+	hdr := capHeader{version: linuxCapabilityV3}
+	var data [2]capData
+	if _, _, errno := syscall.RawSyscall(syscall.SYS_CAPGET, uintptr(unsafe.Pointer(&hdr)), uintptr(unsafe.Pointer(&data[0])), 0); errno != 0 {
+		return fmt.Errorf("capget error %v", errno)
+	}
+	for i := range data {
+		m := uint32(mask >> (32 * uint(i)))
+		data[i].permitted &= m
+		data[i].effective = data[i].permitted
+		data[i].inheritable = data[i].permitted
+	}
+	if _, _, errno := syscall.RawSyscall(syscall.SYS_CAPSET, uintptr(unsafe.Pointer(&hdr)), uintptr(unsafe.Pointer(&data[0])), 0); errno != 0 {
+		return fmt.Errorf("capset error %v", errno)
+	}
+	return nil
+}
@@ -0,0 +1,38 @@
+package container
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestComputeCapabilities(t *testing.T) {
+	caps, err := ComputeCapabilities(nil, nil, false)
+	if err != nil || len(caps) != len(DefaultCapabilities) {
+		t.Fatalf("default capabilities %v %v", caps, err)
+	}
+	caps, err = ComputeCapabilities([]string{"net_admin"}, []string{"ALL"}, false)
+	if err != nil || !reflect.DeepEqual(caps, []string{"CAP_NET_ADMIN"}) {
+		t.Errorf("drop all add net_admin %v %v", caps, err)
+	}
+	caps, err = ComputeCapabilities(nil, []string{"CAP_CHOWN", "mknod"}, false)
+	if err != nil || len(caps) != len(DefaultCapabilities)-2 || caps[0] != "CAP_DAC_OVERRIDE" {
+		t.Errorf("drop chown and mknod %v %v", caps, err)
+	}
+	caps, err = ComputeCapabilities(nil, nil, true)
+	if err != nil || len(caps) != len(capabilities) {
+		t.Errorf("privileged %d capabilities %v", len(caps), err)
+	}
+	if _, err := ComputeCapabilities([]string{"CAP_FLY"}, nil, false); err == nil {
+		t.Error("unknown capability accepted")
+	}
+}
+
+func TestCapabilityMask(t *testing.T) {
+	mask, err := CapabilityMask([]string{"CAP_CHOWN", "CAP_KILL", "CAP_MAC_ADMIN"})
+	if err != nil || mask != 1|1<<5|1<<33 {
+		t.Errorf("mask %x %v", mask, err)
+	}
+	if mask, _ := CapabilityMask(nil); mask != 0 {
+		t.Errorf("empty mask %x", mask)
+	}
+}
@@ -3,20 +3,30 @@ package container
 import (
 	"fmt"
 	log "github.com/Sirupsen/logrus"
+	"github.com/xianlubird/mydocker/cgroups/subsystems"
+	"github.com/xianlubird/mydocker/idtools"
+	"github.com/xianlubird/mydocker/seccomp"
 	"os"
 	"os/exec"
 	"syscall"
 )
 
 var (
+	CREATED             string = "created"
 	RUNNING             string = "running"
 	STOP                string = "stopped"
 	Exit                string = "exited"
+	PAUSED              string = "paused"
+	DEAD                string = "dead"
+	RESTARTING          string = "restarting"
 	DefaultInfoLocation string = "/var/run/mydocker/%s/"
 	ConfigName          string = "config.json"
 	ContainerLogFile    string = "container.log"
+	ShimLogFile         string = "shim.log"
+	AttachSocketName    string = "attach.sock"
 	RootUrl				string = "/root"
-	MntUrl				string = "/root/mnt/%s"
+	RuntimeRoot			string = "/var/lib/mydocker"
+	MntUrl				string = "/var/lib/mydocker/mnt/%s"
 	WriteLayerUrl 		string = "/root/writeLayer/%s"
 )
 
@@ -27,19 +37,92 @@ type ContainerInfo struct {
 	Command     string `json:"command"`    //容器内init运行命令
 	CreatedTime string `json:"createTime"` //创建时间
 	Status      string `json:"status"`     //容器的状态
+	Bundle      string `json:"bundle,omitempty"` //OCI bundle目录，通过 create 创建的容器才有
+	Image       string `json:"image,omitempty"`  //run 使用的镜像
+	ImageID     string `json:"imageId,omitempty"` //镜像的digest，rmi时用来判断镜像是否被使用
 	Volume      string `json:"volume"`     //容器的数据卷
 	PortMapping []string `json:"portmapping"` //端口映射
+	StorageDriver string `json:"storageDriver,omitempty"` //创建rootfs使用的存储驱动
+	CgroupDriver string `json:"cgroupDriver,omitempty"` //cgroupfs或者systemd，为空时使用全局参数选择的驱动
+	IDMapping   *idtools.IdentityMapping `json:"idMapping,omitempty"` //user namespace的id映射，为空时和宿主机共用
+	Capabilities []string `json:"capabilities"` //容器进程保留的capability，exec进去的进程使用同样的集合
+	Privileged  bool     `json:"privileged,omitempty"` //run --privileged
+	Seccomp     *seccomp.Seccomp `json:"seccomp,omitempty"` //exec进去的进程安装同样的seccomp过滤器
+	Tty         bool     `json:"tty,omitempty"`       //容器进程的标准输入输出是shim持有的pty
+	OpenStdin   bool     `json:"openStdin,omitempty"` //run -i，attach时转发标准输入
+	Network     string   `json:"network,omitempty"`   //容器连接的网络
+	IPAddress   string   `json:"ip,omitempty"`        //在网络中分配到的IP，容器退出时释放
+	AutoRemove  bool     `json:"autoRemove,omitempty"` //run --rm，退出之后删除容器
+	ExitCode    int      `json:"exitCode"`            //init进程的退出码，被信号杀掉时是128+信号
+	OOMKilled   bool     `json:"oomKilled,omitempty"` //init因为超过内存限制被杀掉
+	StartedAt   string   `json:"startedAt,omitempty"` //最近一次开始运行的时间
+	FinishedAt  string   `json:"finishedAt,omitempty"` //退出时间
+	ShimPid     int      `json:"shimPid,omitempty"`   //等待init退出的进程，detach时是shim，前台运行时是CLI
+	Spec        *InitSpec `json:"spec,omitempty"`     //run时发给init的InitSpec，start时用它重新运行原来的命令
+	Resources   *subsystems.ResourceConfig `json:"resources,omitempty"` //run时指定的cgroup限制，start时重新设置
+	RestartPolicy *RestartPolicy `json:"restartPolicy,omitempty"` //run --restart，由shim在容器退出时执行
+	RestartCount int `json:"restartCount"` //按重启策略自动重启的次数，手动start时清零
+	StopSignal  string   `json:"stopSignal,omitempty"` //stop时发给init的信号，为空时是SIGTERM
+	ManuallyStopped bool `json:"manuallyStopped,omitempty"` //stop或者kill之后退出的容器记录为stopped，不再按重启策略重启
 }
 /*
 这里是父进程，也就是当前进程执行的内容，
 1.这里的/process/self/exe调用中，/proc/self/指的是当前运行进程自己的环境。exec其实就是自己调用自己
 使用这种方式对创建出来的进程进行初始化
 2.后面的args是参数，其中init是传递给本进程的第一个参数，在本例中，其实就是会去调用initCommand去初始化
-进程的一下环境和资源
+进程的一下环境和资源，用户命令、环境变量等通过返回的writePipe以InitSpec的形式发送给init
 3.下面的clone参数就是去fork出来一个新进程，并且使用namespace隔离新创建的进程和外部环境。
-4. 如果用户指定了-ti参数，就需要把当前进程的输入输出导入到标准的输入输出上。
+4. 如果用户指定了-ti参数，就分配一个pty，slave作为容器进程的标准输入输出，master留在调用者一侧；
+否则容器的输出写入管道，interactive时标准输入也是一个管道，由调用者(shim)转发。
+5. idMapping不为nil时再创建user namespace，容器内的root映射成宿主机上的普通用户。
 */
-func NewParentProcess(tty bool, containerName, volume, imageName string, envSlice []string) (*exec.Cmd, *os.File) {
+func NewParentProcess(tty, interactive bool, containerName, volume, imageName string, idMapping *idtools.IdentityMapping) (*exec.Cmd, *os.File, *ProcessIO) {
+	attr := &syscall.SysProcAttr{
+		Cloneflags: syscall.CLONE_NEWUTS | syscall.CLONE_NEWPID | syscall.CLONE_NEWNS |
+			syscall.CLONE_NEWNET | syscall.CLONE_NEWIPC,
+	}
+	if idMapping != nil {
+		attr.Cloneflags |= syscall.CLONE_NEWUSER
+		attr.UidMappings = idtools.SysProcIDMaps(idMapping.UIDMaps)
+		attr.GidMappings = idtools.SysProcIDMaps(idMapping.GIDMaps)
+		// 由特权进程写gid_map，容器内切换用户时还需要setgroups
+		attr.GidMappingsEnableSetgroups = true
+		// 宿主机的root在映射中不存在，不切换的话init会变成nobody，exec之后也就没有了capability
+		attr.Credential = &syscall.Credential{Uid: 0, Gid: 0}
+	}
+	cmd, writePipe := newInitProcess(attr)
+	if cmd == nil {
+		return nil, nil, nil
+	}
+	processIO, err := newProcessIO(cmd, tty, interactive)
+	if err != nil {
+		log.Errorf("NewParentProcess set up stdio error %v", err)
+		return nil, nil, nil
+	}
+	NewWorkSpace(volume, imageName, containerName, idMapping)
+	cmd.Dir = fmt.Sprintf(MntUrl, containerName)
+	return cmd, writePipe, processIO
+}
+
+// NewBundleProcess 为OCI bundle创建init进程，rootfs直接使用bundle中的目录，namespace由config.json决定，
+// 输出写入container.log
+func NewBundleProcess(containerName, rootfs string, attr *syscall.SysProcAttr) (*exec.Cmd, *os.File) {
+	cmd, writePipe := newInitProcess(attr)
+	if cmd == nil {
+		return nil, nil
+	}
+	logFile, err := OpenLogFile(containerName)
+	if err != nil {
+		log.Errorf("NewBundleProcess %v", err)
+		return nil, nil
+	}
+	cmd.Stdout = logFile
+	cmd.Stderr = logFile
+	cmd.Dir = rootfs
+	return cmd, writePipe
+}
+
+func newInitProcess(attr *syscall.SysProcAttr) (*exec.Cmd, *os.File) {
 	readPipe, writePipe, err := NewPipe()
 	if err != nil {
 		log.Errorf("New pipe error %v", err)
@@ -52,37 +135,25 @@ func NewParentProcess(tty bool, containerName, volume, imageName string, envSlic
 	}
 
 	cmd := exec.Command(initCmd, "init")
-	cmd.SysProcAttr = &syscall.SysProcAttr{
-		Cloneflags: syscall.CLONE_NEWUTS | syscall.CLONE_NEWPID | syscall.CLONE_NEWNS |
-			syscall.CLONE_NEWNET | syscall.CLONE_NEWIPC,
-	}
-
-	if tty {
-		cmd.Stdin = os.Stdin
-		cmd.Stdout = os.Stdout
-		cmd.Stderr = os.Stderr
-	} else {
-		dirURL := fmt.Sprintf(DefaultInfoLocation, containerName)
-		if err := os.MkdirAll(dirURL, 0622); err != nil {
-			log.Errorf("NewParentProcess mkdir %s error %v", dirURL, err)
-			return nil, nil
-		}
-		stdLogFilePath := dirURL + ContainerLogFile
-		stdLogFile, err := os.Create(stdLogFilePath)
-		if err != nil {
-			log.Errorf("NewParentProcess create file %s error %v", stdLogFilePath, err)
-			return nil, nil
-		}
-		cmd.Stdout = stdLogFile
-	}
-
+	cmd.SysProcAttr = attr
 	cmd.ExtraFiles = []*os.File{readPipe}
-	cmd.Env = append(os.Environ(), envSlice...)
-	NewWorkSpace(volume, imageName, containerName)
-	cmd.Dir = fmt.Sprintf(MntUrl, containerName)
 	return cmd, writePipe
 }
 
+// OpenLogFile 打开容器的container.log，start重新运行的容器接着原来的日志写
+func OpenLogFile(containerName string) (*os.File, error) {
+	dirURL := fmt.Sprintf(DefaultInfoLocation, containerName)
+	if err := os.MkdirAll(dirURL, 0622); err != nil {
+		return nil, fmt.Errorf("mkdir %s error %v", dirURL, err)
+	}
+	logFilePath := dirURL + ContainerLogFile
+	logFile, err := os.OpenFile(logFilePath, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("open file %s error %v", logFilePath, err)
+	}
+	return logFile, nil
+}
+
 //在Go语言中，os.Pipe函数用于创建一个管道，该管道可以在同一个进程内的不同协程之间进行通信，
 //也可以在不同进程之间进行通信。管道是一种特殊的文件描述符，它可以用于在进程之间进行双向通信，一个进程可以将数据写入管道，另一个进程可以从管道中读取数据。
 func NewPipe() (*os.File, *os.File, error) {
@@ -0,0 +1,246 @@
+package container
+
+import (
+	"fmt"
+	"github.com/xianlubird/mydocker/archive"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+)
+
+// DefaultShmSize 是没有指定 --shm-size 时/dev/shm的大小，和docker一样是64M
+const DefaultShmSize = 64 * 1024 * 1024
+
+// Device 是容器/dev下的一个设备节点
+type Device struct {
+	Path        string      `json:"path"`               //容器内的路径
+	HostPath    string      `json:"hostPath,omitempty"` //宿主机上的设备，user namespace中不能mknod，改为bind这个路径
+	Type        string      `json:"type"`               //c 字符设备，b 块设备
+	Major       int64       `json:"major"`
+	Minor       int64       `json:"minor"`
+	FileMode    os.FileMode `json:"fileMode"`
+	Uid         uint32      `json:"uid"`
+	Gid         uint32      `json:"gid"`
+	Permissions string      `json:"permissions,omitempty"` //devices cgroup中的权限，rwm的组合
+}
+
+// CgroupRule 返回写入devices.allow的规则，比如 c 1:3 rwm
+func (d Device) CgroupRule() string {
+	perms := d.Permissions
+	if perms == "" {
+		perms = "rwm"
+	}
+	return fmt.Sprintf("%s %d:%d %s", d.Type, d.Major, d.Minor, perms)
+}
+
+func charDevice(path string, major, minor int64) Device {
+	return Device{Path: path, HostPath: path, Type: "c", Major: major, Minor: minor, FileMode: 0666, Permissions: "rwm"}
+}
+
+// DefaultDevices 是每个容器都有的设备
+func DefaultDevices() []Device {
+	return []Device{
+		charDevice("/dev/null", 1, 3),
+		charDevice("/dev/zero", 1, 5),
+		charDevice("/dev/full", 1, 7),
+		charDevice("/dev/random", 1, 8),
+		charDevice("/dev/urandom", 1, 9),
+		charDevice("/dev/tty", 5, 0),
+	}
+}
+
+// defaultDeviceRules 是除了设备节点之外devices cgroup默认放行的规则：
+// 允许mknod但不能读写、/dev/console、/dev/pts下的终端和/dev/ptmx
+var defaultDeviceRules = []string{
+	"c *:* m",
+	"b *:* m",
+	"c 5:1 rwm",
+	"c 136:* rwm",
+	"c 5:2 rwm",
+}
+
+// DeviceCgroupRules 返回容器devices cgroup的白名单
+func DeviceCgroupRules(devices []Device) []string {
+	rules := append([]string{}, defaultDeviceRules...)
+	for _, d := range devices {
+		rules = append(rules, d.CgroupRule())
+	}
+	return rules
+}
+
+// ParseDevice 解析 --device 参数，格式为 host[:container][:permissions]，比如 /dev/fuse:/dev/fuse:rwm
+func ParseDevice(val string) (Device, error) {
+	parts := strings.Split(val, ":")
+	hostPath, path, perms := parts[0], parts[0], "rwm"
+	switch len(parts) {
+	case 1:
+	case 2:
+		if validDevicePermissions(parts[1]) {
+			perms = parts[1]
+		} else {
+			path = parts[1]
+		}
+	case 3:
+		path, perms = parts[1], parts[2]
+	default:
+		return Device{}, fmt.Errorf("invalid device %s, expect host[:container][:permissions]", val)
+	}
+	if !filepath.IsAbs(path) {
+		return Device{}, fmt.Errorf("invalid device %s, container path must be absolute", val)
+	}
+	if !validDevicePermissions(perms) {
+		return Device{}, fmt.Errorf("invalid device permissions %s, expect a combination of r, w and m", perms)
+	}
+	fi, err := os.Stat(hostPath)
+	if err != nil {
+		return Device{}, fmt.Errorf("stat device %s error %v", hostPath, err)
+	}
+	if fi.Mode()&os.ModeDevice == 0 {
+		return Device{}, fmt.Errorf("%s is not a device", hostPath)
+	}
+	st := fi.Sys().(*syscall.Stat_t)
+	device := Device{
+		Path:        path,
+		HostPath:    hostPath,
+		Type:        "b",
+		Major:       int64(archive.Major(uint64(st.Rdev))),
+		Minor:       int64(archive.Minor(uint64(st.Rdev))),
+		FileMode:    fi.Mode().Perm(),
+		Uid:         st.Uid,
+		Gid:         st.Gid,
+		Permissions: perms,
+	}
+	if fi.Mode()&os.ModeCharDevice != 0 {
+		device.Type = "c"
+	}
+	return device, nil
+}
+
+func validDevicePermissions(perms string) bool {
+	if perms == "" {
+		return false
+	}
+	for _, c := range perms {
+		if !strings.ContainsRune("rwm", c) {
+			return false
+		}
+	}
+	return true
+}
+
+// runningInUserNS 当前进程不在初始user namespace中时，uid_map不是 0 0 4294967295
+func runningInUserNS() bool {
+	content, err := ioutil.ReadFile("/proc/self/uid_map")
+	if err != nil {
+		return false
+	}
+	fields := strings.Fields(string(content))
+	return !(len(fields) == 3 && fields[0] == "0" && fields[1] == "0" && fields[2] == "4294967295")
+}
+
+// createDevice 在rootfs中创建设备节点，user namespace中没有mknod的权限，bind宿主机上的设备
+func createDevice(root string, d Device, bind bool) error {
+	target, err := archive.ResolveInRoot(root, d.Path)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+		return err
+	}
+	if bind {
+		f, err := os.OpenFile(target, os.O_CREATE, 0644)
+		if err != nil {
+			return err
+		}
+		f.Close()
+		return syscall.Mount(d.HostPath, target, "", syscall.MS_BIND, "")
+	}
+	mode := uint32(d.FileMode.Perm())
+	switch d.Type {
+	case "c":
+		mode |= syscall.S_IFCHR
+	case "b":
+		mode |= syscall.S_IFBLK
+	default:
+		return fmt.Errorf("unknown device type %s", d.Type)
+	}
+	os.Remove(target)
+	if err := syscall.Mknod(target, mode, int(archive.Mkdev(uint64(d.Major), uint64(d.Minor)))); err != nil {
+		return err
+	}
+	if err := os.Chown(target, int(d.Uid), int(d.Gid)); err != nil {
+		return err
+	}
+	// mknod受umask影响
+	return os.Chmod(target, d.FileMode.Perm())
+}
+
+var devSymlinks = [][2]string{
+	{"/proc/self/fd", "/dev/fd"},
+	{"/proc/self/fd/0", "/dev/stdin"},
+	{"/proc/self/fd/1", "/dev/stdout"},
+	{"/proc/self/fd/2", "/dev/stderr"},
+	{"pts/ptmx", "/dev/ptmx"},
+}
+
+// setUpDev 在新挂载的/dev tmpfs中创建设备节点、常用的符号链接、devpts和/dev/shm
+func setUpDev(root string, spec *InitSpec) error {
+	dev := filepath.Join(root, "dev")
+	bind := runningInUserNS()
+	for _, d := range spec.Devices {
+		if err := createDevice(root, d, bind); err != nil {
+			return fmt.Errorf("create device %s error %v", d.Path, err)
+		}
+	}
+	for _, link := range devSymlinks {
+		if err := os.Symlink(link[0], filepath.Join(root, link[1])); err != nil && !os.IsExist(err) {
+			return fmt.Errorf("create symlink %s error %v", link[1], err)
+		}
+	}
+
+	// 有终端时/dev/console指向容器的pty，这时还在pivot_root之前，可以bind宿主机上的slave。
+	// fd 0属于父进程mount namespace中的挂载，不能直接作为源，要用它的路径
+	if spec.Terminal {
+		slave, err := os.Readlink("/proc/self/fd/0")
+		if err != nil {
+			return err
+		}
+		console := filepath.Join(dev, "console")
+		f, err := os.OpenFile(console, os.O_CREATE, 0600)
+		if err != nil {
+			return err
+		}
+		f.Close()
+		if err := syscall.Mount(slave, console, "", syscall.MS_BIND, ""); err != nil {
+			return fmt.Errorf("bind /dev/console error %v", err)
+		}
+	}
+
+	// newinstance让容器有自己的pty编号，gid=5是tty组，user namespace中没有映射时去掉
+	pts := filepath.Join(dev, "pts")
+	if err := os.MkdirAll(pts, 0755); err != nil {
+		return err
+	}
+	ptsFlags := uintptr(syscall.MS_NOSUID | syscall.MS_NOEXEC)
+	if err := syscall.Mount("devpts", pts, "devpts", ptsFlags, "newinstance,ptmxmode=0666,mode=0620,gid=5"); err != nil {
+		if err := syscall.Mount("devpts", pts, "devpts", ptsFlags, "newinstance,ptmxmode=0666,mode=0620"); err != nil {
+			return fmt.Errorf("mount devpts error %v", err)
+		}
+	}
+
+	shmSize := spec.ShmSize
+	if shmSize <= 0 {
+		shmSize = DefaultShmSize
+	}
+	shm := filepath.Join(dev, "shm")
+	if err := os.MkdirAll(shm, 0755); err != nil {
+		return err
+	}
+	if err := syscall.Mount("shm", shm, "tmpfs", syscall.MS_NOSUID|syscall.MS_NODEV|syscall.MS_NOEXEC,
+		fmt.Sprintf("mode=1777,size=%d", shmSize)); err != nil {
+		return fmt.Errorf("mount /dev/shm error %v", err)
+	}
+	return nil
+}
@@ -0,0 +1,104 @@
+package container
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+// StorageDriver 负责组装容器的rootfs：镜像的只读层加上容器自己的可写层
+type StorageDriver interface {
+	Name() string
+	// CreateLayer 创建容器的可写层
+	CreateLayer(containerName string) error
+	// Mount 把只读层(最上层在前)和可写层挂载到容器的挂载点
+	Mount(containerName string, lowerDirs []string) error
+	Unmount(containerName string) error
+	// Diff 以OCI layer的tar格式返回可写层相对于只读层的改动
+	Diff(containerName string) (io.ReadCloser, error)
+	// Remove 删除容器的可写层
+	Remove(containerName string) error
+	// ConvertLayer 把解压出来的OCI layer中的whiteout文件转换成驱动自己的格式
+	ConvertLayer(layerDir string) error
+}
+
+const DefaultStorageDriver = "overlay"
+
+var (
+	storageDrivers = map[string]StorageDriver{
+		"overlay": &OverlayDriver{},
+		"aufs":    &AufsDriver{},
+		"vfs":     &VfsDriver{},
+	}
+	storageDriver StorageDriver = storageDrivers[DefaultStorageDriver]
+)
+
+// SetStorageDriver 选择新建容器使用的存储驱动，由全局参数 --storage-driver 指定
+func SetStorageDriver(name string) error {
+	driver, err := GetStorageDriver(name)
+	if err != nil {
+		return err
+	}
+	storageDriver = driver
+	return nil
+}
+
+// GetStorageDriver 按名字取存储驱动，名字为空时返回当前选择的驱动，
+// 已有的容器要用它创建时记录在ContainerInfo中的驱动
+func GetStorageDriver(name string) (StorageDriver, error) {
+	if name == "" {
+		return storageDriver, nil
+	}
+	driver, ok := storageDrivers[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown storage driver %s", name)
+	}
+	return driver, nil
+}
+
+// 可写层目录下的文件布局：diff 是容器的改动，work 是overlay需要的工作目录，
+// lower 记录挂载时使用的只读层
+func layerDiffDir(containerName string) string {
+	return filepath.Join(fmt.Sprintf(WriteLayerUrl, containerName), "diff")
+}
+
+func layerWorkDir(containerName string) string {
+	return filepath.Join(fmt.Sprintf(WriteLayerUrl, containerName), "work")
+}
+
+func layerLowerFile(containerName string) string {
+	return filepath.Join(fmt.Sprintf(WriteLayerUrl, containerName), "lower")
+}
+
+func saveLowerDirs(containerName string, lowerDirs []string) error {
+	content, err := json.Marshal(lowerDirs)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(layerLowerFile(containerName), content, 0644)
+}
+
+// LowerDirs 返回容器挂载时使用的只读层，最上层在前
+func LowerDirs(containerName string) ([]string, error) {
+	content, err := ioutil.ReadFile(layerLowerFile(containerName))
+	if err != nil {
+		return nil, err
+	}
+	var lowerDirs []string
+	if err := json.Unmarshal(content, &lowerDirs); err != nil {
+		return nil, err
+	}
+	return lowerDirs, nil
+}
+
+func createLayerDirs(dirs ...string) error {
+	for _, dir := range dirs {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return fmt.Errorf("mkdir %s error %v", dir, err)
+		}
+	}
+	return nil
+}
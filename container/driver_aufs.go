@@ -0,0 +1,79 @@
+package container
+
+import (
+	"archive/tar"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// AufsDriver 是mydocker最早使用的存储驱动，aufs的whiteout格式和OCI layer相同，不需要转换。
+// 新的内核大多已经不带aufs了
+type AufsDriver struct {
+}
+
+func (d *AufsDriver) Name() string {
+	return "aufs"
+}
+
+func (d *AufsDriver) CreateLayer(containerName string) error {
+	return createLayerDirs(layerDiffDir(containerName))
+}
+
+func (d *AufsDriver) Mount(containerName string, lowerDirs []string) error {
+	mntURL := fmt.Sprintf(MntUrl, containerName)
+	if err := os.MkdirAll(mntURL, 0777); err != nil {
+		return fmt.Errorf("mkdir mountpoint dir %s error %v", mntURL, err)
+	}
+	if err := saveLowerDirs(containerName, lowerDirs); err != nil {
+		return err
+	}
+	dirs := "dirs=" + layerDiffDir(containerName) + "=rw"
+	// 镜像layer中的.wh.文件就是aufs的whiteout，只读层需要加上+wh才会生效
+	for _, lowerDir := range lowerDirs {
+		dirs += ":" + lowerDir + "=ro+wh"
+	}
+	if output, err := exec.Command("mount", "-t", "aufs", "-o", dirs, "none", mntURL).CombinedOutput(); err != nil {
+		return fmt.Errorf("mount aufs %s error %v: %s", mntURL, err, output)
+	}
+	return nil
+}
+
+func (d *AufsDriver) Unmount(containerName string) error {
+	return unmountRootfs(containerName)
+}
+
+func (d *AufsDriver) Diff(containerName string) (io.ReadCloser, error) {
+	diffDir := layerDiffDir(containerName)
+	if _, err := os.Stat(diffDir); err != nil {
+		return nil, err
+	}
+	return newDiffStream(func(tw *tar.Writer) error {
+		w := newDiffWriter(tw)
+		return walkLayer(diffDir, func(rel, path string, fi os.FileInfo) error {
+			name := fi.Name()
+			// .wh..wh.plnk .wh..wh.orph 等是aufs内部使用的目录
+			if strings.HasPrefix(name, WhiteoutPrefix+WhiteoutPrefix) && name != WhiteoutOpaque {
+				if fi.IsDir() {
+					return filepath.SkipDir
+				}
+				return nil
+			}
+			if strings.HasPrefix(name, WhiteoutPrefix) {
+				return w.addWhiteout(rel)
+			}
+			return w.addFile(rel, path, fi)
+		})
+	}), nil
+}
+
+func (d *AufsDriver) Remove(containerName string) error {
+	return removeWriteLayer(containerName)
+}
+
+func (d *AufsDriver) ConvertLayer(layerDir string) error {
+	return nil
+}
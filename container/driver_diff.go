@@ -0,0 +1,150 @@
+package container
+
+import (
+	"archive/tar"
+	"github.com/xianlubird/mydocker/archive"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+)
+
+// OCI layer中的whiteout文件：.wh.<name> 表示删除了<name>，.wh..wh..opq 表示目录被整个替换
+const (
+	WhiteoutPrefix = ".wh."
+	WhiteoutOpaque = ".wh..wh..opq"
+)
+
+// newDiffStream 在goroutine中调用write生成tar，返回读取端
+func newDiffStream(write func(tw *tar.Writer) error) io.ReadCloser {
+	reader, writer := io.Pipe()
+	go func() {
+		tw := tar.NewWriter(writer)
+		err := write(tw)
+		if err == nil {
+			err = tw.Close()
+		}
+		writer.CloseWithError(err)
+	}()
+	return reader
+}
+
+// diffWriter 把文件系统对象写进tar，同一个inode的多个硬链接只写一次内容
+type diffWriter struct {
+	tw    *tar.Writer
+	links map[uint64]string
+}
+
+func newDiffWriter(tw *tar.Writer) *diffWriter {
+	return &diffWriter{tw: tw, links: map[uint64]string{}}
+}
+
+func (w *diffWriter) addWhiteout(name string) error {
+	return w.tw.WriteHeader(&tar.Header{
+		Name:     name,
+		Mode:     0644,
+		Typeflag: tar.TypeReg,
+	})
+}
+
+func (w *diffWriter) addFile(name, path string, fi os.FileInfo) error {
+	link := ""
+	if fi.Mode()&os.ModeSymlink != 0 {
+		target, err := os.Readlink(path)
+		if err != nil {
+			return err
+		}
+		link = target
+	}
+	hdr, err := tar.FileInfoHeader(fi, link)
+	if err != nil {
+		return err
+	}
+	hdr.Name = name
+	if fi.IsDir() {
+		hdr.Name += "/"
+	}
+	if st, ok := fi.Sys().(*syscall.Stat_t); ok {
+		hdr.Uid = int(st.Uid)
+		hdr.Gid = int(st.Gid)
+		hdr.Uname = ""
+		hdr.Gname = ""
+		if fi.Mode()&os.ModeDevice != 0 {
+			hdr.Devmajor = int64(archive.Major(uint64(st.Rdev)))
+			hdr.Devminor = int64(archive.Minor(uint64(st.Rdev)))
+		}
+		if fi.Mode().IsRegular() && st.Nlink > 1 {
+			if first, ok := w.links[st.Ino]; ok {
+				hdr.Typeflag = tar.TypeLink
+				hdr.Linkname = first
+				hdr.Size = 0
+			} else {
+				w.links[st.Ino] = name
+			}
+		}
+	}
+	hdr.Xattrs = readXattrs(path)
+	if err := w.tw.WriteHeader(hdr); err != nil {
+		return err
+	}
+	if hdr.Typeflag != tar.TypeReg || hdr.Size == 0 {
+		return nil
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	_, err = io.Copy(w.tw, f)
+	return err
+}
+
+// readXattrs 读取除overlay内部属性外的扩展属性
+func readXattrs(path string) map[string]string {
+	size, err := syscall.Listxattr(path, nil)
+	if err != nil || size <= 0 {
+		return nil
+	}
+	buf := make([]byte, size)
+	if size, err = syscall.Listxattr(path, buf); err != nil {
+		return nil
+	}
+	xattrs := map[string]string{}
+	for _, key := range strings.Split(strings.TrimRight(string(buf[:size]), "\x00"), "\x00") {
+		if key == "" || strings.HasPrefix(key, "trusted.overlay.") {
+			continue
+		}
+		vsize, err := syscall.Getxattr(path, key, nil)
+		if err != nil {
+			continue
+		}
+		value := make([]byte, vsize)
+		if vsize, err = syscall.Getxattr(path, key, value); err != nil {
+			continue
+		}
+		xattrs[key] = string(value[:vsize])
+	}
+	if len(xattrs) == 0 {
+		return nil
+	}
+	return xattrs
+}
+
+// walkLayer 遍历目录，fn拿到的是相对路径，不包括根目录本身
+func walkLayer(root string, fn func(rel, path string, fi os.FileInfo) error) error {
+	return filepath.Walk(root, func(path string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(root, path)
+		if err != nil || rel == "." {
+			return err
+		}
+		return fn(rel, path, fi)
+	})
+}
+
+func whiteoutName(rel string) string {
+	return filepath.Join(filepath.Dir(rel), WhiteoutPrefix+filepath.Base(rel))
+}
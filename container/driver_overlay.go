@@ -0,0 +1,140 @@
+package container
+
+import (
+	"archive/tar"
+	"fmt"
+	log "github.com/Sirupsen/logrus"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+)
+
+const overlayOpaqueXattr = "trusted.overlay.opaque"
+
+// OverlayDriver 使用内核自带的overlayfs，whiteout是0/0的字符设备，
+// 被整个替换的目录带有 trusted.overlay.opaque=y 属性
+type OverlayDriver struct {
+}
+
+func (d *OverlayDriver) Name() string {
+	return "overlay"
+}
+
+func (d *OverlayDriver) CreateLayer(containerName string) error {
+	return createLayerDirs(layerDiffDir(containerName), layerWorkDir(containerName))
+}
+
+func (d *OverlayDriver) Mount(containerName string, lowerDirs []string) error {
+	mntURL := fmt.Sprintf(MntUrl, containerName)
+	if err := os.MkdirAll(mntURL, 0777); err != nil {
+		return fmt.Errorf("mkdir mountpoint dir %s error %v", mntURL, err)
+	}
+	if err := saveLowerDirs(containerName, lowerDirs); err != nil {
+		return err
+	}
+	options := fmt.Sprintf("lowerdir=%s,upperdir=%s,workdir=%s", strings.Join(lowerDirs, ":"),
+		layerDiffDir(containerName), layerWorkDir(containerName))
+	if err := syscall.Mount("overlay", mntURL, "overlay", 0, options); err != nil {
+		return fmt.Errorf("mount overlay %s error %v", mntURL, err)
+	}
+	return nil
+}
+
+func (d *OverlayDriver) Unmount(containerName string) error {
+	return unmountRootfs(containerName)
+}
+
+func (d *OverlayDriver) Diff(containerName string) (io.ReadCloser, error) {
+	diffDir := layerDiffDir(containerName)
+	if _, err := os.Stat(diffDir); err != nil {
+		return nil, err
+	}
+	return newDiffStream(func(tw *tar.Writer) error {
+		w := newDiffWriter(tw)
+		return walkLayer(diffDir, func(rel, path string, fi os.FileInfo) error {
+			if isOverlayWhiteout(fi) {
+				return w.addWhiteout(whiteoutName(rel))
+			}
+			if err := w.addFile(rel, path, fi); err != nil {
+				return err
+			}
+			if fi.IsDir() && isOverlayOpaque(path) {
+				return w.addWhiteout(filepath.Join(rel, WhiteoutOpaque))
+			}
+			return nil
+		})
+	}), nil
+}
+
+func (d *OverlayDriver) Remove(containerName string) error {
+	return removeWriteLayer(containerName)
+}
+
+// ConvertLayer 把 .wh.<name> 换成字符设备，.wh..wh..opq 换成目录的opaque属性
+func (d *OverlayDriver) ConvertLayer(layerDir string) error {
+	var whiteouts []string
+	err := walkLayer(layerDir, func(rel, path string, fi os.FileInfo) error {
+		if strings.HasPrefix(fi.Name(), WhiteoutPrefix) {
+			whiteouts = append(whiteouts, path)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	for _, path := range whiteouts {
+		if err := os.Remove(path); err != nil {
+			return err
+		}
+		dir, name := filepath.Split(path)
+		if name == WhiteoutOpaque {
+			if err := syscall.Setxattr(dir, overlayOpaqueXattr, []byte("y"), 0); err != nil {
+				return fmt.Errorf("set opaque on %s error %v", dir, err)
+			}
+			continue
+		}
+		target := filepath.Join(dir, strings.TrimPrefix(name, WhiteoutPrefix))
+		if err := syscall.Mknod(target, syscall.S_IFCHR, 0); err != nil {
+			return fmt.Errorf("mknod whiteout %s error %v", target, err)
+		}
+	}
+	return nil
+}
+
+func isOverlayWhiteout(fi os.FileInfo) bool {
+	if fi.Mode()&os.ModeCharDevice == 0 {
+		return false
+	}
+	st, ok := fi.Sys().(*syscall.Stat_t)
+	return ok && st.Rdev == 0
+}
+
+func isOverlayOpaque(path string) bool {
+	value := make([]byte, 1)
+	n, err := syscall.Getxattr(path, overlayOpaqueXattr, value)
+	return err == nil && n == 1 && value[0] == 'y'
+}
+
+func unmountRootfs(containerName string) error {
+	mntURL := fmt.Sprintf(MntUrl, containerName)
+	if err := syscall.Unmount(mntURL, 0); err != nil && err != syscall.EINVAL && !os.IsNotExist(err) {
+		log.Errorf("Unmount %s error %v", mntURL, err)
+		return err
+	}
+	if err := os.RemoveAll(mntURL); err != nil {
+		log.Errorf("Remove mountpoint dir %s error %v", mntURL, err)
+		return err
+	}
+	return nil
+}
+
+func removeWriteLayer(containerName string) error {
+	writeURL := fmt.Sprintf(WriteLayerUrl, containerName)
+	if err := os.RemoveAll(writeURL); err != nil {
+		log.Infof("Remove writeLayer dir %s error %v", writeURL, err)
+		return err
+	}
+	return nil
+}
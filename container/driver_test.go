@@ -0,0 +1,153 @@
+package container
+
+import (
+	"archive/tar"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+)
+
+func setTestWriteLayer(t *testing.T) (string, func()) {
+	root, err := ioutil.TempDir("", "driver")
+	if err != nil {
+		t.Fatal(err)
+	}
+	old := WriteLayerUrl
+	WriteLayerUrl = filepath.Join(root, "writeLayer", "%s")
+	return root, func() {
+		WriteLayerUrl = old
+		os.RemoveAll(root)
+	}
+}
+
+func diffEntries(t *testing.T, driver StorageDriver, containerName string) []string {
+	stream, err := driver.Diff(containerName)
+	if err != nil {
+		t.Fatalf("diff %v", err)
+	}
+	defer stream.Close()
+	var names []string
+	tr := tar.NewReader(stream)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("read diff %v", err)
+		}
+		names = append(names, hdr.Name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func writeFiles(t *testing.T, root string, files map[string]string) {
+	for name, content := range files {
+		path := filepath.Join(root, name)
+		os.MkdirAll(filepath.Dir(path), 0755)
+		if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestOverlayConvertAndDiff(t *testing.T) {
+	_, cleanup := setTestWriteLayer(t)
+	defer cleanup()
+	driver := &OverlayDriver{}
+	if err := driver.CreateLayer("c1"); err != nil {
+		t.Fatal(err)
+	}
+	diffDir := layerDiffDir("c1")
+	writeFiles(t, diffDir, map[string]string{
+		".wh.removed":      "",
+		"etc/.wh..wh..opq": "",
+		"etc/hosts":        "127.0.0.1 localhost",
+	})
+	if err := driver.ConvertLayer(diffDir); err != nil {
+		t.Skipf("overlay whiteouts need root: %v", err)
+	}
+	fi, err := os.Lstat(filepath.Join(diffDir, "removed"))
+	if err != nil || !isOverlayWhiteout(fi) {
+		t.Fatalf("removed should be a whiteout device, %v", err)
+	}
+	if !isOverlayOpaque(filepath.Join(diffDir, "etc")) {
+		t.Fatalf("etc should be opaque")
+	}
+	got := diffEntries(t, driver, "c1")
+	want := []string{".wh.removed", "etc/", "etc/.wh..wh..opq", "etc/hosts"}
+	if len(got) != len(want) {
+		t.Fatalf("diff entries got %v want %v", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("diff entries got %v want %v", got, want)
+		}
+	}
+}
+
+func TestVfsDiff(t *testing.T) {
+	root, cleanup := setTestWriteLayer(t)
+	defer cleanup()
+	lower := filepath.Join(root, "lower")
+	writeFiles(t, lower, map[string]string{
+		"keep":      "same",
+		"modify":    "old",
+		"remove":    "gone",
+		"dir/child": "gone too",
+	})
+	driver := &VfsDriver{}
+	if err := driver.CreateLayer("c1"); err != nil {
+		t.Fatal(err)
+	}
+	diffDir := layerDiffDir("c1")
+	if err := copyLayer(lower, diffDir); err != nil {
+		t.Fatal(err)
+	}
+	if err := saveLowerDirs("c1", []string{lower}); err != nil {
+		t.Fatal(err)
+	}
+	os.Remove(filepath.Join(diffDir, "remove"))
+	os.RemoveAll(filepath.Join(diffDir, "dir"))
+	writeFiles(t, diffDir, map[string]string{"modify": "new!", "added": "new"})
+
+	got := diffEntries(t, driver, "c1")
+	want := []string{".wh.dir", ".wh.remove", "added", "modify"}
+	if len(got) != len(want) {
+		t.Fatalf("diff entries got %v want %v", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("diff entries got %v want %v", got, want)
+		}
+	}
+}
+
+func TestCopyLayerWhiteouts(t *testing.T) {
+	root, cleanup := setTestWriteLayer(t)
+	defer cleanup()
+	base, top, dest := filepath.Join(root, "base"), filepath.Join(root, "top"), filepath.Join(root, "dest")
+	writeFiles(t, base, map[string]string{"a": "a", "opq/old": "old", "b": "b"})
+	writeFiles(t, top, map[string]string{".wh.a": "", "opq/.wh..wh..opq": "", "opq/new": "new"})
+	os.MkdirAll(dest, 0755)
+	if err := copyLayer(base, dest); err != nil {
+		t.Fatal(err)
+	}
+	if err := copyLayer(top, dest); err != nil {
+		t.Fatal(err)
+	}
+	for _, gone := range []string{"a", ".wh.a", "opq/old", "opq/.wh..wh..opq"} {
+		if _, err := os.Lstat(filepath.Join(dest, gone)); !os.IsNotExist(err) {
+			t.Fatalf("%s should not exist", gone)
+		}
+	}
+	for _, kept := range []string{"b", "opq/new"} {
+		if _, err := os.Lstat(filepath.Join(dest, kept)); err != nil {
+			t.Fatalf("%s should exist", kept)
+		}
+	}
+}
@@ -0,0 +1,205 @@
+package container
+
+import (
+	"archive/tar"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"syscall"
+)
+
+// VfsDriver 不依赖任何联合文件系统，挂载时把所有只读层依次复制到可写层，
+// 再bind mount到挂载点。占用空间大，但在任何内核上都能用
+type VfsDriver struct {
+}
+
+func (d *VfsDriver) Name() string {
+	return "vfs"
+}
+
+func (d *VfsDriver) CreateLayer(containerName string) error {
+	return createLayerDirs(layerDiffDir(containerName))
+}
+
+func (d *VfsDriver) Mount(containerName string, lowerDirs []string) error {
+	mntURL := fmt.Sprintf(MntUrl, containerName)
+	if err := os.MkdirAll(mntURL, 0777); err != nil {
+		return fmt.Errorf("mkdir mountpoint dir %s error %v", mntURL, err)
+	}
+	diffDir := layerDiffDir(containerName)
+	// lower文件存在说明之前已经复制过，重新挂载时不能覆盖容器的改动
+	if _, err := os.Stat(layerLowerFile(containerName)); os.IsNotExist(err) {
+		for i := len(lowerDirs) - 1; i >= 0; i-- {
+			if err := copyLayer(lowerDirs[i], diffDir); err != nil {
+				return err
+			}
+		}
+		if err := saveLowerDirs(containerName, lowerDirs); err != nil {
+			return err
+		}
+	}
+	if err := syscall.Mount(diffDir, mntURL, "bind", syscall.MS_BIND, ""); err != nil {
+		return fmt.Errorf("bind mount %s error %v", mntURL, err)
+	}
+	return nil
+}
+
+// copyLayer 先按照layer中的whiteout删除下层的文件，再把layer复制上去
+func copyLayer(layerDir, dest string) error {
+	var whiteouts []string
+	err := walkLayer(layerDir, func(rel, path string, fi os.FileInfo) error {
+		if strings.HasPrefix(fi.Name(), WhiteoutPrefix) {
+			whiteouts = append(whiteouts, rel)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	for _, rel := range whiteouts {
+		dir, name := filepath.Split(rel)
+		if name == WhiteoutOpaque {
+			children, err := filepath.Glob(filepath.Join(dest, dir, "*"))
+			if err != nil {
+				return err
+			}
+			hidden, _ := filepath.Glob(filepath.Join(dest, dir, ".*"))
+			for _, child := range append(children, hidden...) {
+				os.RemoveAll(child)
+			}
+			continue
+		}
+		os.RemoveAll(filepath.Join(dest, dir, strings.TrimPrefix(name, WhiteoutPrefix)))
+	}
+	if output, err := exec.Command("cp", "-a", layerDir+"/.", dest).CombinedOutput(); err != nil {
+		return fmt.Errorf("copy layer %s error %v: %s", layerDir, err, output)
+	}
+	for _, rel := range whiteouts {
+		os.Remove(filepath.Join(dest, rel))
+	}
+	return nil
+}
+
+func (d *VfsDriver) Unmount(containerName string) error {
+	return unmountRootfs(containerName)
+}
+
+// Diff 可写层里是完整的rootfs，需要和只读层逐个比较
+func (d *VfsDriver) Diff(containerName string) (io.ReadCloser, error) {
+	diffDir := layerDiffDir(containerName)
+	lowerDirs, err := LowerDirs(containerName)
+	if err != nil {
+		return nil, err
+	}
+	return newDiffStream(func(tw *tar.Writer) error {
+		w := newDiffWriter(tw)
+		err := walkLayer(diffDir, func(rel, path string, fi os.FileInfo) error {
+			lowerPath, lowerFi := lookupLower(lowerDirs, rel)
+			if lowerFi != nil && !fileChanged(path, fi, lowerPath, lowerFi) {
+				return nil
+			}
+			return w.addFile(rel, path, fi)
+		})
+		if err != nil {
+			return err
+		}
+		// 只读层中能看到、可写层中已经不存在的文件需要写whiteout
+		deleted := map[string]bool{}
+		for _, lowerDir := range lowerDirs {
+			err := walkLayer(lowerDir, func(rel, path string, fi os.FileInfo) error {
+				if strings.HasPrefix(fi.Name(), WhiteoutPrefix) || deleted[rel] {
+					return nil
+				}
+				if _, err := os.Lstat(filepath.Join(diffDir, rel)); err == nil {
+					return nil
+				}
+				if lowerPath, _ := lookupLower(lowerDirs, rel); lowerPath != path {
+					return nil
+				}
+				deleted[rel] = true
+				if err := w.addWhiteout(whiteoutName(rel)); err != nil {
+					return err
+				}
+				if fi.IsDir() {
+					return filepath.SkipDir
+				}
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	}), nil
+}
+
+// lookupLower 在只读层中从上往下查找rel，遇到whiteout就停止
+func lookupLower(lowerDirs []string, rel string) (string, os.FileInfo) {
+	for _, lowerDir := range lowerDirs {
+		if hiddenInLayer(lowerDir, rel) {
+			return "", nil
+		}
+		path := filepath.Join(lowerDir, rel)
+		if fi, err := os.Lstat(path); err == nil {
+			return path, fi
+		}
+		if opaqueInLayer(lowerDir, rel) {
+			return "", nil
+		}
+	}
+	return "", nil
+}
+
+// rel或者它的某一级父目录在这一层被删除了
+func hiddenInLayer(layerDir, rel string) bool {
+	for p := rel; p != "." && p != "/"; p = filepath.Dir(p) {
+		if _, err := os.Lstat(filepath.Join(layerDir, whiteoutName(p))); err == nil {
+			return true
+		}
+	}
+	return false
+}
+
+// rel的某一级父目录在这一层是opaque的，下面的层都看不到
+func opaqueInLayer(layerDir, rel string) bool {
+	for p := filepath.Dir(rel); p != "." && p != "/"; p = filepath.Dir(p) {
+		if _, err := os.Lstat(filepath.Join(layerDir, p, WhiteoutOpaque)); err == nil {
+			return true
+		}
+	}
+	_, err := os.Lstat(filepath.Join(layerDir, WhiteoutOpaque))
+	return err == nil
+}
+
+func fileChanged(path string, fi os.FileInfo, lowerPath string, lowerFi os.FileInfo) bool {
+	if fi.Mode() != lowerFi.Mode() || fi.Size() != lowerFi.Size() {
+		return true
+	}
+	st, ok1 := fi.Sys().(*syscall.Stat_t)
+	lowerSt, ok2 := lowerFi.Sys().(*syscall.Stat_t)
+	if ok1 && ok2 && (st.Uid != lowerSt.Uid || st.Gid != lowerSt.Gid || st.Rdev != lowerSt.Rdev) {
+		return true
+	}
+	if fi.Mode()&os.ModeSymlink != 0 {
+		target, _ := os.Readlink(path)
+		lowerTarget, _ := os.Readlink(lowerPath)
+		return target != lowerTarget
+	}
+	// 目录的修改时间会随着子项变化，子项本身会单独比较
+	if fi.IsDir() {
+		return false
+	}
+	return !fi.ModTime().Equal(lowerFi.ModTime())
+}
+
+func (d *VfsDriver) Remove(containerName string) error {
+	return removeWriteLayer(containerName)
+}
+
+// ConvertLayer vfs复制layer时直接处理whiteout，这里保持OCI格式不变
+func (d *VfsDriver) ConvertLayer(layerDir string) error {
+	return nil
+}
@@ -0,0 +1,35 @@
+package container
+
+import (
+	"archive/tar"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// ExportRootfs 把容器挂载好的rootfs打成一个完整的tar流，数据卷中的内容不包括在内
+func ExportRootfs(containerName, volume string) (io.ReadCloser, error) {
+	mntURL := fmt.Sprintf(MntUrl, containerName)
+	if exist, _ := PathExists(mntURL); !exist {
+		return nil, fmt.Errorf("rootfs of container %s is not mounted", containerName)
+	}
+	volumeDir := ""
+	if volumeURLs := strings.Split(volume, ":"); len(volumeURLs) == 2 && volumeURLs[1] != "" {
+		volumeDir = filepath.Clean(strings.TrimPrefix(volumeURLs[1], "/"))
+	}
+	return newDiffStream(func(tw *tar.Writer) error {
+		w := newDiffWriter(tw)
+		return walkLayer(mntURL, func(rel, path string, fi os.FileInfo) error {
+			if err := w.addFile(rel, path, fi); err != nil {
+				return err
+			}
+			// 只保留数据卷的挂载点
+			if rel == volumeDir && fi.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		})
+	}), nil
+}
@@ -3,10 +3,14 @@ package container
 import (
 	"fmt"
 	log "github.com/Sirupsen/logrus"
-	"io/ioutil"
+	"github.com/xianlubird/mydocker/archive"
+	"github.com/xianlubird/mydocker/seccomp"
+	"github.com/xianlubird/mydocker/term"
+	"golang.org/x/sys/unix"
 	"os"
 	"os/exec"
 	"path/filepath"
+	"runtime"
 	"strings"
 	"syscall"
 )
@@ -14,64 +18,354 @@ import (
 //这是本容器执行的第一个进程。
 //使用mount先去挂载proc文件系统，以便后面通过ps等系统命令去查看当前进程
 func RunContainerInitProcess() error {
-	cmdArray := readUserCommand()
-	if cmdArray == nil || len(cmdArray) == 0 {
-		return fmt.Errorf("Run container get user command error, cmdArray is nil")
+	// capability是线程级别的，设置capability和最后的exec必须在同一个线程中
+	runtime.LockOSThread()
+	spec, err := readInitSpec()
+	if err != nil {
+		return fmt.Errorf("Run container get init spec error %v", err)
 	}
 
-	setUpMount()
+	// 父进程分配的pty，在新的session中设置成控制终端，shell才能使用job control
+	if spec.Terminal {
+		if err := term.SetControllingTerminal(0); err != nil {
+			return err
+		}
+	}
 
-	path, err := exec.LookPath(cmdArray[0])
+	execFifo, err := openExecFifo(spec.ExecFifo)
+	if err != nil {
+		return err
+	}
+
+	if err := setUpMount(spec); err != nil {
+		log.Errorf("Set up mount error %v", err)
+		return err
+	}
+
+	if err := applyInitSpec(spec); err != nil {
+		log.Errorf("Apply init spec error %v", err)
+		return err
+	}
+	if execFifo >= 0 {
+		if err := waitForStart(execFifo); err != nil {
+			return err
+		}
+	}
+	if err := setUpProcess(spec); err != nil {
+		return err
+	}
+
+	path, err := exec.LookPath(spec.Args[0])
 	if err != nil {
 		log.Errorf("Exec loop path error %v", err)
 		return err
 	}
 	log.Infof("Find path %s", path)
-	if err := syscall.Exec(path, cmdArray[0:], os.Environ()); err != nil {
-		log.Errorf(err.Error())
+	if err := syscall.Exec(path, spec.Args, spec.Env); err != nil {
+		log.Errorf("Exec %s error %v", path, err)
+		return err
+	}
+	return nil
+}
+
+//按照spec设置主机名、rlimit和只读根目录，此时已经完成了pivot_root
+func applyInitSpec(spec *InitSpec) error {
+	if spec.Hostname != "" {
+		if err := syscall.Sethostname([]byte(spec.Hostname)); err != nil {
+			return fmt.Errorf("set hostname %s error %v", spec.Hostname, err)
+		}
+	}
+	for _, rl := range spec.Rlimits {
+		resource, err := rlimitResource(rl.Type)
+		if err != nil {
+			return err
+		}
+		if err := syscall.Setrlimit(resource, &syscall.Rlimit{Cur: rl.Soft, Max: rl.Hard}); err != nil {
+			return fmt.Errorf("set rlimit %s error %v", rl.Type, err)
+		}
+	}
+	// 只影响根目录这一个挂载点，/proc、/dev、数据卷和tmpfs仍然可写
+	if spec.ReadonlyRootfs {
+		if err := remountReadonly("/"); err != nil {
+			return fmt.Errorf("remount rootfs readonly error %v", err)
+		}
+	}
+	return nil
+}
+
+//切换用户和工作目录，并把环境变量换成容器进程的。
+//用户名和组名按容器自己的 /etc/passwd 和 /etc/group 解析，所以要在pivot_root之后调用
+func setUpProcess(spec *InitSpec) error {
+	user, err := LookupUser(spec.User, "/")
+	if err != nil {
+		return err
+	}
+	// 环境变量中没有HOME时使用passwd中的家目录
+	hasHome := false
+	for _, env := range spec.Env {
+		if strings.HasPrefix(env, "HOME=") {
+			hasHome = true
+		}
+	}
+	if !hasHome {
+		spec.Env = append(spec.Env, "HOME="+user.Home)
+	}
+	// LookPath 使用当前进程的PATH，这里换成容器进程的环境变量
+	os.Clearenv()
+	for _, env := range spec.Env {
+		kv := strings.SplitN(env, "=", 2)
+		if len(kv) == 2 {
+			os.Setenv(kv[0], kv[1])
+		}
+	}
+	// bounding set要在切换用户之前收紧，切换用户本身还需要CAP_SETUID和CAP_SETGID
+	var capMask uint64
+	if spec.Capabilities != nil {
+		if capMask, err = CapabilityMask(spec.Capabilities); err != nil {
+			return err
+		}
+		if err := dropBoundingSet(capMask); err != nil {
+			return err
+		}
+	}
+	// seccomp过滤器应当尽量靠近exec安装，但没有no_new_privs时安装需要CAP_SYS_ADMIN，
+	// 所以放在切换用户和去掉capability之前，之后的系统调用都在默认profile的放行范围内
+	if spec.Seccomp != nil {
+		filter, err := seccomp.Compile(spec.Seccomp)
+		if err != nil {
+			return err
+		}
+		if err := seccomp.InstallFilter(filter); err != nil {
+			return err
+		}
+	}
+	if spec.User != "" || len(spec.AdditionalGids) > 0 {
+		if err := setUser(user, spec.AdditionalGids); err != nil {
+			return err
+		}
+	}
+	// 切换成普通用户时内核已经清空了capability，root还需要去掉当前持有的其余capability
+	if spec.Capabilities != nil && os.Geteuid() == 0 {
+		if err := setCapabilities(capMask); err != nil {
+			return err
+		}
+	}
+	cwd := spec.Cwd
+	if cwd == "" {
+		cwd = "/"
+	}
+	if err := os.Chdir(cwd); err != nil {
+		return fmt.Errorf("chdir %s error %v", cwd, err)
 	}
 	return nil
 }
 
-func readUserCommand() []string {
-	pipe := os.NewFile(uintptr(3), "pipe")
-	defer pipe.Close()
-	msg, err := ioutil.ReadAll(pipe)
+// 在pivot_root之前以O_PATH打开exec fifo，pivot_root之后宿主机上的路径就不可见了
+func openExecFifo(path string) (int, error) {
+	if path == "" {
+		return -1, nil
+	}
+	fd, err := syscall.Open(path, unix.O_PATH|syscall.O_CLOEXEC, 0)
 	if err != nil {
-		log.Errorf("init read pipe error %v", err)
-		return nil
+		return -1, fmt.Errorf("open exec fifo %s error %v", path, err)
 	}
-	msgStr := string(msg)
-	return strings.Split(msgStr, " ")
+	return fd, nil
+}
+
+// waitForStart 以写方式重新打开fifo，会一直阻塞到 mydocker start 读取它
+func waitForStart(fd int) error {
+	fifo, err := os.OpenFile(fmt.Sprintf("/proc/self/fd/%d", fd), os.O_WRONLY, 0)
+	if err != nil {
+		return fmt.Errorf("open exec fifo error %v", err)
+	}
+	defer fifo.Close()
+	syscall.Close(fd)
+	if _, err := fifo.Write([]byte("0")); err != nil {
+		return fmt.Errorf("write exec fifo error %v", err)
+	}
+	return nil
+}
+
+// setUser 切换到解析好的用户，附加组包括passwd/group中的和spec中额外指定的
+func setUser(user *ExecUser, additionalGids []int) error {
+	groups := append(append([]int{}, user.Sgids...), additionalGids...)
+	if err := syscall.Setgroups(groups); err != nil {
+		return fmt.Errorf("setgroups error %v", err)
+	}
+	if err := syscall.Setgid(user.Gid); err != nil {
+		return fmt.Errorf("setgid %d error %v", user.Gid, err)
+	}
+	if err := syscall.Setuid(user.Uid); err != nil {
+		return fmt.Errorf("setuid %d error %v", user.Uid, err)
+	}
+	return nil
+}
+
+var mountOptions = map[string]struct {
+	clear bool
+	flag  uintptr
+}{
+	"ro":          {false, syscall.MS_RDONLY},
+	"rw":          {true, syscall.MS_RDONLY},
+	"nosuid":      {false, syscall.MS_NOSUID},
+	"suid":        {true, syscall.MS_NOSUID},
+	"nodev":       {false, syscall.MS_NODEV},
+	"dev":         {true, syscall.MS_NODEV},
+	"noexec":      {false, syscall.MS_NOEXEC},
+	"exec":        {true, syscall.MS_NOEXEC},
+	"noatime":     {false, syscall.MS_NOATIME},
+	"nodiratime":  {false, syscall.MS_NODIRATIME},
+	"relatime":    {false, syscall.MS_RELATIME},
+	"strictatime": {false, syscall.MS_STRICTATIME},
+	"bind":        {false, syscall.MS_BIND},
+	"rbind":       {false, syscall.MS_BIND | syscall.MS_REC},
+	"private":     {false, syscall.MS_PRIVATE},
+	"rprivate":    {false, syscall.MS_PRIVATE | syscall.MS_REC},
+	"slave":       {false, syscall.MS_SLAVE},
+	"rslave":      {false, syscall.MS_SLAVE | syscall.MS_REC},
+	"shared":      {false, syscall.MS_SHARED},
+	"rshared":     {false, syscall.MS_SHARED | syscall.MS_REC},
+}
+
+const propagationFlags = syscall.MS_PRIVATE | syscall.MS_SLAVE | syscall.MS_SHARED
+
+//把mount选项拆成mount flags和交给文件系统的data
+func parseMountOptions(options []string) (uintptr, string) {
+	var flags uintptr
+	var data []string
+	for _, o := range options {
+		if opt, ok := mountOptions[o]; ok {
+			if opt.clear {
+				flags &^= opt.flag
+			} else {
+				flags |= opt.flag
+			}
+			continue
+		}
+		data = append(data, o)
+	}
+	return flags, strings.Join(data, ",")
+}
+
+// bind mount 单个文件时挂载点也必须是文件
+func createMountTarget(source, dest string, bind bool) error {
+	if bind {
+		if fi, err := os.Stat(source); err == nil && !fi.IsDir() {
+			if err := os.MkdirAll(filepath.Dir(dest), 0755); err != nil {
+				return err
+			}
+			f, err := os.OpenFile(dest, os.O_CREATE, 0644)
+			if err != nil {
+				return err
+			}
+			return f.Close()
+		}
+	}
+	return os.MkdirAll(dest, 0755)
+}
+
+// resolveMountDestination 在rootfs内解析挂载点，rootfs中的符号链接不能让挂载点或者新建的目录落到宿主机上。
+// 最后一段是符号链接时也要跟随，所以多加一段再取目录
+func resolveMountDestination(root, destination string) (string, error) {
+	target, err := archive.ResolveInRoot(root, filepath.Join(destination, "_"))
+	if err != nil {
+		return "", fmt.Errorf("invalid mount destination %s: %v", destination, err)
+	}
+	return filepath.Dir(target), nil
+}
+
+func mountSpecMount(root string, m Mount) error {
+	flags, data := parseMountOptions(m.Options)
+	source := m.Source
+	if source == "" {
+		source = m.Type
+	}
+	dest, err := resolveMountDestination(root, m.Destination)
+	if err != nil {
+		return err
+	}
+	if err := createMountTarget(source, dest, flags&syscall.MS_BIND != 0); err != nil {
+		return fmt.Errorf("create mount destination %s error %v", m.Destination, err)
+	}
+	// 传播属性不能和其他flag一起设置，需要单独mount一次
+	var propagation uintptr
+	if flags&propagationFlags != 0 {
+		propagation = flags & (propagationFlags | syscall.MS_REC)
+		flags &^= propagationFlags
+		if flags&syscall.MS_BIND == 0 {
+			flags &^= syscall.MS_REC
+		}
+	}
+	mountFlags := flags
+	// bind mount 时只读等flag需要remount才能生效
+	if flags&syscall.MS_BIND != 0 {
+		mountFlags &^= syscall.MS_RDONLY
+	}
+	if err := syscall.Mount(source, dest, m.Type, mountFlags, data); err != nil {
+		return fmt.Errorf("mount %s to %s error %v", source, m.Destination, err)
+	}
+	if flags&syscall.MS_BIND != 0 && flags&syscall.MS_RDONLY != 0 {
+		if err := syscall.Mount(source, dest, m.Type, flags|syscall.MS_REMOUNT, data); err != nil {
+			return fmt.Errorf("remount %s readonly error %v", m.Destination, err)
+		}
+	}
+	if propagation != 0 {
+		if err := syscall.Mount("", dest, "", propagation, ""); err != nil {
+			return fmt.Errorf("set propagation of %s error %v", m.Destination, err)
+		}
+	}
+	return nil
 }
 
 /**
 Init 挂载点
+proc、dev以及spec中的挂载都在pivot_root之前完成，这样bind mount还能看到宿主机上的源路径
 */
-func setUpMount() {
+func setUpMount(spec *InitSpec) error {
 	pwd, err := os.Getwd()
 	if err != nil {
 		log.Errorf("Get current location error %v", err)
-		return
+		return err
 	}
 	log.Infof("Current location is %s", pwd)
-	pivotRoot(pwd)
+	// 挂载事件不要传播回宿主机，否则pivot_root会失败
+	if err := syscall.Mount("", "/", "", syscall.MS_PRIVATE|syscall.MS_REC, ""); err != nil {
+		return fmt.Errorf("make mounts private error %v", err)
+	}
+	/**
+	  为了使当前root的老 root 和新 root 不在同一个文件系统下，我们把root重新mount了一次
+	  bind mount是把相同的内容换了一个挂载点的挂载方法
+	*/
+	if err := syscall.Mount(pwd, pwd, "bind", syscall.MS_BIND|syscall.MS_REC, ""); err != nil {
+		return fmt.Errorf("Mount rootfs to itself error: %v", err)
+	}
 
 	//mount proc
 	defaultMountFlags := syscall.MS_NOEXEC | syscall.MS_NOSUID | syscall.MS_NODEV
-	syscall.Mount("proc", "/proc", "proc", uintptr(defaultMountFlags), "")
+	syscall.Mount("proc", filepath.Join(pwd, "proc"), "proc", uintptr(defaultMountFlags), "")
+
+	if err := syscall.Mount("tmpfs", filepath.Join(pwd, "dev"), "tmpfs", syscall.MS_NOSUID|syscall.MS_STRICTATIME, "mode=755"); err != nil {
+		return fmt.Errorf("mount /dev error %v", err)
+	}
+	if err := setUpDev(pwd, spec); err != nil {
+		return err
+	}
 
-	syscall.Mount("tmpfs", "/dev", "tmpfs", syscall.MS_NOSUID|syscall.MS_STRICTATIME, "mode=755")
+	for _, m := range spec.Mounts {
+		if err := mountSpecMount(pwd, m); err != nil {
+			return err
+		}
+	}
+	if err := maskPaths(pwd, spec.MaskedPaths); err != nil {
+		return err
+	}
+	if err := readonlyPaths(pwd, spec.ReadonlyPaths); err != nil {
+		return err
+	}
+	return pivotRoot(pwd)
 }
 
 func pivotRoot(root string) error {
-	/**
-	  为了使当前root的老 root 和新 root 不在同一个文件系统下，我们把root重新mount了一次
-	  bind mount是把相同的内容换了一个挂载点的挂载方法
-	*/
-	if err := syscall.Mount(root, root, "bind", syscall.MS_BIND|syscall.MS_REC, ""); err != nil {
-		return fmt.Errorf("Mount rootfs to itself error: %v", err)
-	}
 	// 创建 rootfs/.pivot_root 存储 old_root
 	pivotDir := filepath.Join(root, ".pivot_root")
 	if err := os.Mkdir(pivotDir, 0777); err != nil {
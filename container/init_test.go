@@ -0,0 +1,39 @@
+package container
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestResolveMountDestination(t *testing.T) {
+	root, err := ioutil.TempDir("", "rootfs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+	os.MkdirAll(filepath.Join(root, "usr", "lib"), 0755)
+	os.Symlink("/usr", filepath.Join(root, "etc"))
+	os.Symlink("lib", filepath.Join(root, "usr", "data"))
+	os.Symlink("../../..", filepath.Join(root, "usr", "lib", "up"))
+
+	for dest, want := range map[string]string{
+		"/":             root,
+		"/proc":         filepath.Join(root, "proc"),
+		"/etc/hosts":    filepath.Join(root, "usr", "hosts"),
+		"/etc":          filepath.Join(root, "usr"),
+		"/etc/data/x":   filepath.Join(root, "usr", "lib", "x"),
+		"/usr/../mnt/a": filepath.Join(root, "mnt", "a"),
+	} {
+		got, err := resolveMountDestination(root, dest)
+		if err != nil || got != want {
+			t.Errorf("resolve %s: got %s %v, want %s", dest, got, err, want)
+		}
+	}
+	for _, dest := range []string{"/usr/lib/up", "/usr/lib/up/tmp"} {
+		if got, err := resolveMountDestination(root, dest); err == nil {
+			t.Errorf("resolve %s: got %s, want escape error", dest, got)
+		}
+	}
+}
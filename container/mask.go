@@ -0,0 +1,121 @@
+package container
+
+import (
+	"fmt"
+	log "github.com/Sirupsen/logrus"
+	"github.com/xianlubird/mydocker/archive"
+	"os"
+	"syscall"
+)
+
+// DefaultMaskedPaths 容器内看不到这些路径的内容，文件用/dev/null覆盖，目录用只读的空tmpfs覆盖
+var DefaultMaskedPaths = []string{
+	"/proc/asound",
+	"/proc/acpi",
+	"/proc/kcore",
+	"/proc/keys",
+	"/proc/latency_stats",
+	"/proc/timer_list",
+	"/proc/timer_stats",
+	"/proc/sched_debug",
+	"/proc/scsi",
+	"/sys/firmware",
+	"/sys/devices/virtual/powercap",
+}
+
+// DefaultReadonlyPaths 容器内这些路径可以读但不能写
+var DefaultReadonlyPaths = []string{
+	"/proc/bus",
+	"/proc/fs",
+	"/proc/irq",
+	"/proc/sys",
+	"/proc/sysrq-trigger",
+}
+
+// statfs返回的挂载flag，remount时需要原样带上，否则user namespace中会因为去掉了锁定的flag而失败
+var statfsMountFlags = map[int64]uintptr{
+	0x1:    syscall.MS_RDONLY,
+	0x2:    syscall.MS_NOSUID,
+	0x4:    syscall.MS_NODEV,
+	0x8:    syscall.MS_NOEXEC,
+	0x400:  syscall.MS_NOATIME,
+	0x800:  syscall.MS_NODIRATIME,
+	0x1000: syscall.MS_RELATIME,
+}
+
+// remountReadonly 把path所在的bind mount改成只读，保留原来的nosuid、nodev等flag
+func remountReadonly(path string) error {
+	var st syscall.Statfs_t
+	if err := syscall.Statfs(path, &st); err != nil {
+		return err
+	}
+	flags := uintptr(syscall.MS_BIND | syscall.MS_REMOUNT | syscall.MS_RDONLY)
+	for stFlag, msFlag := range statfsMountFlags {
+		if st.Flags&stFlag != 0 {
+			flags |= msFlag
+		}
+	}
+	return syscall.Mount("", path, "", flags, "")
+}
+
+// resolveMaskPath 在rootfs内解析路径，不存在的路径和符号链接都不需要处理
+func resolveMaskPath(root, path string) (string, os.FileInfo, error) {
+	target, err := archive.ResolveInRoot(root, path)
+	if err != nil {
+		return "", nil, err
+	}
+	fi, err := os.Lstat(target)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return "", nil, nil
+		}
+		return "", nil, err
+	}
+	if fi.Mode()&os.ModeSymlink != 0 {
+		log.Warnf("Skip %s, it is a symlink in the container", path)
+		return "", nil, nil
+	}
+	return target, fi, nil
+}
+
+// maskPaths 在pivot_root之前屏蔽rootfs中的路径，这时还能使用宿主机的/dev/null
+func maskPaths(root string, paths []string) error {
+	for _, path := range paths {
+		target, fi, err := resolveMaskPath(root, path)
+		if err != nil {
+			return fmt.Errorf("mask %s error %v", path, err)
+		}
+		if target == "" {
+			continue
+		}
+		if fi.IsDir() {
+			err = syscall.Mount("tmpfs", target, "tmpfs", syscall.MS_RDONLY, "size=0")
+		} else {
+			err = syscall.Mount("/dev/null", target, "", syscall.MS_BIND, "")
+		}
+		if err != nil {
+			return fmt.Errorf("mask %s error %v", path, err)
+		}
+	}
+	return nil
+}
+
+// readonlyPaths 把rootfs中的路径bind到自己再remount成只读
+func readonlyPaths(root string, paths []string) error {
+	for _, path := range paths {
+		target, _, err := resolveMaskPath(root, path)
+		if err != nil {
+			return fmt.Errorf("make %s readonly error %v", path, err)
+		}
+		if target == "" {
+			continue
+		}
+		if err := syscall.Mount(target, target, "", syscall.MS_BIND|syscall.MS_REC, ""); err != nil {
+			return fmt.Errorf("bind %s error %v", path, err)
+		}
+		if err := remountReadonly(target); err != nil {
+			return fmt.Errorf("remount %s readonly error %v", path, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,381 @@
+package container
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/xianlubird/mydocker/cgroups/subsystems"
+	"github.com/xianlubird/mydocker/seccomp"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// OCI bundle中的config.json，只包含mydocker能够支持的字段
+// 参考 https://github.com/opencontainers/runtime-spec/blob/master/config.md
+type OCISpec struct {
+	Version     string            `json:"ociVersion"`
+	Process     *OCIProcess       `json:"process"`
+	Root        *OCIRoot          `json:"root"`
+	Hostname    string            `json:"hostname,omitempty"`
+	Mounts      []OCIMount        `json:"mounts,omitempty"`
+	Linux       *OCILinux         `json:"linux,omitempty"`
+	Annotations map[string]string `json:"annotations,omitempty"`
+}
+
+type OCIProcess struct {
+	Terminal     bool             `json:"terminal,omitempty"`
+	User         OCIUser          `json:"user"`
+	Args         []string         `json:"args"`
+	Env          []string         `json:"env,omitempty"`
+	Cwd          string           `json:"cwd"`
+	Capabilities *OCICapabilities `json:"capabilities,omitempty"`
+	Rlimits      []OCIRlimit      `json:"rlimits,omitempty"`
+}
+
+// OCICapabilities 中mydocker只使用bounding，effective等几个集合都由它决定
+type OCICapabilities struct {
+	Bounding    []string `json:"bounding,omitempty"`
+	Effective   []string `json:"effective,omitempty"`
+	Inheritable []string `json:"inheritable,omitempty"`
+	Permitted   []string `json:"permitted,omitempty"`
+	Ambient     []string `json:"ambient,omitempty"`
+}
+
+type OCIUser struct {
+	UID            uint32   `json:"uid"`
+	GID            uint32   `json:"gid"`
+	AdditionalGids []uint32 `json:"additionalGids,omitempty"`
+}
+
+type OCIRlimit struct {
+	Type string `json:"type"`
+	Hard uint64 `json:"hard"`
+	Soft uint64 `json:"soft"`
+}
+
+type OCIRoot struct {
+	Path     string `json:"path"`
+	Readonly bool   `json:"readonly,omitempty"`
+}
+
+type OCIMount struct {
+	Destination string   `json:"destination"`
+	Type        string   `json:"type,omitempty"`
+	Source      string   `json:"source,omitempty"`
+	Options     []string `json:"options,omitempty"`
+}
+
+type OCILinux struct {
+	Namespaces    []OCINamespace   `json:"namespaces,omitempty"`
+	UIDMappings   []OCIIDMapping   `json:"uidMappings,omitempty"`
+	GIDMappings   []OCIIDMapping   `json:"gidMappings,omitempty"`
+	Resources     *OCIResources    `json:"resources,omitempty"`
+	CgroupsPath   string           `json:"cgroupsPath,omitempty"`
+	Seccomp       *seccomp.Seccomp `json:"seccomp,omitempty"`
+	MaskedPaths   []string         `json:"maskedPaths,omitempty"`
+	ReadonlyPaths []string         `json:"readonlyPaths,omitempty"`
+	Devices       []OCIDevice      `json:"devices,omitempty"`
+}
+
+type OCIDevice struct {
+	Type     string       `json:"type"`
+	Path     string       `json:"path"`
+	Major    int64        `json:"major,omitempty"`
+	Minor    int64        `json:"minor,omitempty"`
+	FileMode *os.FileMode `json:"fileMode,omitempty"`
+	UID      *uint32      `json:"uid,omitempty"`
+	GID      *uint32      `json:"gid,omitempty"`
+}
+
+type OCINamespace struct {
+	Type string `json:"type"`
+	Path string `json:"path,omitempty"`
+}
+
+type OCIIDMapping struct {
+	ContainerID uint32 `json:"containerID"`
+	HostID      uint32 `json:"hostID"`
+	Size        uint32 `json:"size"`
+}
+
+type OCIResources struct {
+	Memory  *OCIMemory        `json:"memory,omitempty"`
+	CPU     *OCICPU           `json:"cpu,omitempty"`
+	Devices []OCIDeviceCgroup `json:"devices,omitempty"`
+}
+
+// OCIDeviceCgroup 是devices cgroup中的一条规则，type为空或者a表示所有设备，major和minor为空表示任意
+type OCIDeviceCgroup struct {
+	Allow  bool   `json:"allow"`
+	Type   string `json:"type,omitempty"`
+	Major  *int64 `json:"major,omitempty"`
+	Minor  *int64 `json:"minor,omitempty"`
+	Access string `json:"access,omitempty"`
+}
+
+type OCIMemory struct {
+	Limit *int64 `json:"limit,omitempty"`
+}
+
+type OCICPU struct {
+	Shares *uint64 `json:"shares,omitempty"`
+	Quota  *int64  `json:"quota,omitempty"`
+	Period *uint64 `json:"period,omitempty"`
+	Cpus   string  `json:"cpus,omitempty"`
+}
+
+// OCIState 是 state 命令输出的容器状态
+type OCIState struct {
+	Version     string            `json:"ociVersion"`
+	ID          string            `json:"id"`
+	Status      string            `json:"status"`
+	Pid         int               `json:"pid,omitempty"`
+	Bundle      string            `json:"bundle"`
+	Annotations map[string]string `json:"annotations,omitempty"`
+}
+
+const (
+	OCIVersion    = "1.0.2"
+	OCIConfigName = "config.json"
+	// create 之后init进程阻塞在这个fifo上，直到 start 打开它
+	ExecFifoName = "exec.fifo"
+)
+
+var namespaceFlags = map[string]uintptr{
+	"pid":     syscall.CLONE_NEWPID,
+	"network": syscall.CLONE_NEWNET,
+	"mount":   syscall.CLONE_NEWNS,
+	"ipc":     syscall.CLONE_NEWIPC,
+	"uts":     syscall.CLONE_NEWUTS,
+	"user":    syscall.CLONE_NEWUSER,
+	"cgroup":  0x02000000,
+}
+
+// LoadBundle 读取bundle目录下的config.json
+func LoadBundle(bundle string) (*OCISpec, error) {
+	configPath := filepath.Join(bundle, OCIConfigName)
+	content, err := ioutil.ReadFile(configPath)
+	if err != nil {
+		return nil, fmt.Errorf("read %s error %v", configPath, err)
+	}
+	spec := &OCISpec{}
+	if err := json.Unmarshal(content, spec); err != nil {
+		return nil, fmt.Errorf("parse %s error %v", configPath, err)
+	}
+	if spec.Process == nil || len(spec.Process.Args) == 0 {
+		return nil, fmt.Errorf("%s has no process args", configPath)
+	}
+	if spec.Root == nil || spec.Root.Path == "" {
+		return nil, fmt.Errorf("%s has no root path", configPath)
+	}
+	return spec, nil
+}
+
+// RootfsPath 返回rootfs的绝对路径，相对路径是相对于bundle目录的
+func (s *OCISpec) RootfsPath(bundle string) string {
+	if filepath.IsAbs(s.Root.Path) {
+		return s.Root.Path
+	}
+	return filepath.Join(bundle, s.Root.Path)
+}
+
+// SysProcAttr 把linux.namespaces和id映射翻译成clone参数
+func (s *OCISpec) SysProcAttr() (*syscall.SysProcAttr, error) {
+	attr := &syscall.SysProcAttr{}
+	if s.Linux == nil {
+		return nil, fmt.Errorf("config has no linux section")
+	}
+	for _, ns := range s.Linux.Namespaces {
+		flag, ok := namespaceFlags[ns.Type]
+		if !ok {
+			return nil, fmt.Errorf("unknown namespace type %s", ns.Type)
+		}
+		if ns.Path != "" {
+			return nil, fmt.Errorf("joining existing %s namespace %s is not supported", ns.Type, ns.Path)
+		}
+		attr.Cloneflags |= flag
+	}
+	// pivot_root 需要独立的mount namespace
+	if attr.Cloneflags&syscall.CLONE_NEWNS == 0 {
+		return nil, fmt.Errorf("mount namespace is required")
+	}
+	for _, m := range s.Linux.UIDMappings {
+		attr.UidMappings = append(attr.UidMappings, syscall.SysProcIDMap{ContainerID: int(m.ContainerID), HostID: int(m.HostID), Size: int(m.Size)})
+	}
+	for _, m := range s.Linux.GIDMappings {
+		attr.GidMappings = append(attr.GidMappings, syscall.SysProcIDMap{ContainerID: int(m.ContainerID), HostID: int(m.HostID), Size: int(m.Size)})
+	}
+	// 和 --userns 一样：init总是会切换到process.user，需要setgroups；
+	// 不切换到映射中的root的话init在新的user namespace中没有capability
+	if attr.Cloneflags&syscall.CLONE_NEWUSER != 0 {
+		if len(attr.UidMappings) == 0 || len(attr.GidMappings) == 0 {
+			return nil, fmt.Errorf("user namespace requires uidMappings and gidMappings")
+		}
+		attr.GidMappingsEnableSetgroups = true
+		attr.Credential = &syscall.Credential{Uid: 0, Gid: 0}
+	}
+	return attr, nil
+}
+
+// InitSpec 把process、hostname、mounts等翻译成发送给init进程的InitSpec
+func (s *OCISpec) InitSpec() *InitSpec {
+	spec := &InitSpec{
+		Args:           s.Process.Args,
+		Env:            s.Process.Env,
+		Cwd:            s.Process.Cwd,
+		Hostname:       s.Hostname,
+		User:           fmt.Sprintf("%d:%d", s.Process.User.UID, s.Process.User.GID),
+		ReadonlyRootfs: s.Root.Readonly,
+	}
+	if s.Process.Capabilities != nil {
+		spec.Capabilities = append([]string{}, s.Process.Capabilities.Bounding...)
+	}
+	// OCI的seccomp格式中没有includes/excludes，可以直接使用
+	if s.Linux != nil {
+		spec.Seccomp = s.Linux.Seccomp
+		spec.MaskedPaths = s.Linux.MaskedPaths
+		spec.ReadonlyPaths = s.Linux.ReadonlyPaths
+	}
+	spec.Devices = s.devices()
+	for _, gid := range s.Process.User.AdditionalGids {
+		spec.AdditionalGids = append(spec.AdditionalGids, int(gid))
+	}
+	for _, rl := range s.Process.Rlimits {
+		spec.Rlimits = append(spec.Rlimits, Rlimit{Type: rl.Type, Soft: rl.Soft, Hard: rl.Hard})
+	}
+	for _, m := range s.Mounts {
+		// /proc 和 /dev 由 setUpMount 负责
+		if m.Destination == "/proc" || m.Destination == "/dev" {
+			continue
+		}
+		spec.Mounts = append(spec.Mounts, Mount{
+			Source:      m.Source,
+			Destination: m.Destination,
+			Type:        m.Type,
+			Options:     m.Options,
+		})
+	}
+	return spec
+}
+
+// devices 返回容器中要创建的设备，运行时必须提供默认的设备，linux.devices是额外的设备
+func (s *OCISpec) devices() []Device {
+	devices := DefaultDevices()
+	if s.Linux == nil {
+		return devices
+	}
+	for _, d := range s.Linux.Devices {
+		device := Device{Path: d.Path, HostPath: d.Path, Type: d.Type, Major: d.Major, Minor: d.Minor, FileMode: 0666}
+		if d.FileMode != nil {
+			device.FileMode = *d.FileMode
+		}
+		if d.UID != nil {
+			device.Uid = *d.UID
+		}
+		if d.GID != nil {
+			device.Gid = *d.GID
+		}
+		devices = append(devices, device)
+	}
+	return devices
+}
+
+// Resources 把linux.resources翻译成容器cgroup的资源限制。
+// 和run一样，devices cgroup只放行默认设备、linux.devices和linux.resources.devices中允许的设备
+func (s *OCISpec) Resources() (*subsystems.ResourceConfig, error) {
+	resConf := &subsystems.ResourceConfig{}
+	var deviceRules []string
+	if s.Linux != nil && s.Linux.Resources != nil {
+		res := s.Linux.Resources
+		if res.Memory != nil && res.Memory.Limit != nil && *res.Memory.Limit > 0 {
+			resConf.MemoryLimit = strconv.FormatInt(*res.Memory.Limit, 10)
+		}
+		if res.CPU != nil {
+			if res.CPU.Shares != nil {
+				resConf.CpuShare = strconv.FormatUint(*res.CPU.Shares, 10)
+			}
+			if res.CPU.Quota != nil {
+				resConf.CpuQuota = strconv.FormatInt(*res.CPU.Quota, 10)
+			}
+			if res.CPU.Period != nil {
+				resConf.CpuPeriod = strconv.FormatUint(*res.CPU.Period, 10)
+			}
+			resConf.CpuSet = res.CPU.Cpus
+		}
+		rules, err := ociDeviceRules(res.Devices)
+		if err != nil {
+			return nil, err
+		}
+		deviceRules = rules
+	}
+	resConf.Devices = append(deviceRules, DeviceCgroupRules(s.devices())...)
+	return resConf, nil
+}
+
+// ociDeviceRules 按顺序把linux.resources.devices转换成白名单。白名单默认拒绝所有设备，
+// 所以deny规则只能整条去掉前面放行过的规则，只拒绝一条allow规则中的一部分时无法表示，直接报错
+func ociDeviceRules(devices []OCIDeviceCgroup) ([]string, error) {
+	var rules []string
+	for _, d := range devices {
+		typ, access := d.Type, d.Access
+		if typ == "" {
+			typ = "a"
+		}
+		if access == "" {
+			access = "rwm"
+		}
+		if (typ != "a" && typ != "b" && typ != "c") || !validDevicePermissions(access) {
+			return nil, fmt.Errorf("invalid device cgroup rule %+v", d)
+		}
+		major, minor := "*", "*"
+		if d.Major != nil && *d.Major >= 0 {
+			major = strconv.FormatInt(*d.Major, 10)
+		}
+		if d.Minor != nil && *d.Minor >= 0 {
+			minor = strconv.FormatInt(*d.Minor, 10)
+		}
+		rule := fmt.Sprintf("%s %s:%s %s", typ, major, minor, access)
+		if d.Allow {
+			rules = append(rules, rule)
+			continue
+		}
+		var kept []string
+		for _, allowed := range rules {
+			if deviceRuleCovers(rule, allowed) {
+				continue
+			}
+			if deviceRulesOverlap(allowed, rule) {
+				return nil, fmt.Errorf("device cgroup rule deny %s overlaps with allow %s, which is not supported", rule, allowed)
+			}
+			kept = append(kept, allowed)
+		}
+		rules = kept
+	}
+	return rules, nil
+}
+
+// deviceRuleCovers 判断 "c 1:3 rwm" 格式的规则a是否包含了规则b的所有设备和访问方式
+func deviceRuleCovers(a, b string) bool {
+	fa, fb := strings.Fields(a), strings.Fields(b)
+	na, nb := strings.Split(fa[1], ":"), strings.Split(fb[1], ":")
+	for _, c := range fb[2] {
+		if !strings.ContainsRune(fa[2], c) {
+			return false
+		}
+	}
+	return (fa[0] == "a" || fa[0] == fb[0]) && (na[0] == "*" || na[0] == nb[0]) && (na[1] == "*" || na[1] == nb[1])
+}
+
+// deviceRulesOverlap 判断两条规则是否可能作用于同一个设备的同一种访问
+func deviceRulesOverlap(a, b string) bool {
+	fa, fb := strings.Fields(a), strings.Fields(b)
+	na, nb := strings.Split(fa[1], ":"), strings.Split(fb[1], ":")
+	match := func(x, y, any string) bool {
+		return x == y || x == any || y == any
+	}
+	return match(fa[0], fb[0], "a") && match(na[0], nb[0], "*") && match(na[1], nb[1], "*") &&
+		strings.ContainsAny(fa[2], fb[2])
+}
@@ -0,0 +1,143 @@
+package container
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+)
+
+const testOCIConfig = `{
+	"ociVersion": "1.0.2",
+	"process": {
+		"user": {"uid": 1000, "gid": 100},
+		"args": ["sh", "-c", "echo hello world"],
+		"env": ["PATH=/bin"],
+		"cwd": "/work",
+		"rlimits": [{"type": "RLIMIT_NOFILE", "hard": 1024, "soft": 512}]
+	},
+	"root": {"path": "rootfs", "readonly": true},
+	"hostname": "oci",
+	"mounts": [
+		{"destination": "/proc", "type": "proc", "source": "proc"},
+		{"destination": "/tmp", "type": "tmpfs", "source": "tmpfs", "options": ["nosuid", "size=65536k"]}
+	],
+	"linux": {
+		"namespaces": [{"type": "pid"}, {"type": "mount"}, {"type": "uts"}],
+		"resources": {
+			"memory": {"limit": 104857600},
+			"cpu": {"shares": 512, "quota": 50000, "period": 100000, "cpus": "0-1"},
+			"devices": [{"allow": false, "access": "rwm"}, {"allow": true, "type": "c", "major": 10, "minor": 229, "access": "rw"}]
+		},
+		"devices": [{"type": "c", "path": "/dev/fuse", "major": 10, "minor": 229}],
+		"maskedPaths": ["/proc/kcore"],
+		"readonlyPaths": ["/proc/sys"]
+	}
+}`
+
+func TestLoadBundle(t *testing.T) {
+	bundle, err := ioutil.TempDir("", "bundle")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(bundle)
+	if err := ioutil.WriteFile(filepath.Join(bundle, OCIConfigName), []byte(testOCIConfig), 0644); err != nil {
+		t.Fatal(err)
+	}
+	spec, err := LoadBundle(bundle)
+	if err != nil {
+		t.Fatalf("load bundle %v", err)
+	}
+	if rootfs := spec.RootfsPath(bundle); rootfs != filepath.Join(bundle, "rootfs") {
+		t.Fatalf("unexpected rootfs %s", rootfs)
+	}
+	attr, err := spec.SysProcAttr()
+	if err != nil {
+		t.Fatalf("sys proc attr %v", err)
+	}
+	if attr.Cloneflags != syscall.CLONE_NEWPID|syscall.CLONE_NEWNS|syscall.CLONE_NEWUTS {
+		t.Fatalf("unexpected clone flags %x", attr.Cloneflags)
+	}
+	initSpec := spec.InitSpec()
+	if len(initSpec.Args) != 3 || initSpec.Args[2] != "echo hello world" {
+		t.Fatalf("unexpected args %q", initSpec.Args)
+	}
+	if initSpec.User != "1000:100" || !initSpec.ReadonlyRootfs || initSpec.Hostname != "oci" {
+		t.Fatalf("unexpected init spec %+v", initSpec)
+	}
+	if len(initSpec.MaskedPaths) != 1 || len(initSpec.ReadonlyPaths) != 1 {
+		t.Fatalf("unexpected masked paths %v readonly paths %v", initSpec.MaskedPaths, initSpec.ReadonlyPaths)
+	}
+	if len(initSpec.Mounts) != 1 || initSpec.Mounts[0].Destination != "/tmp" {
+		t.Fatalf("unexpected mounts %+v", initSpec.Mounts)
+	}
+	res, err := spec.Resources()
+	if err != nil {
+		t.Fatalf("resources %v", err)
+	}
+	if res.MemoryLimit != "104857600" || res.CpuShare != "512" || res.CpuQuota != "50000" ||
+		res.CpuPeriod != "100000" || res.CpuSet != "0-1" {
+		t.Fatalf("unexpected resources %+v", res)
+	}
+	if res.Devices[0] != "c 10:229 rw" || res.Devices[len(res.Devices)-1] != "c 10:229 rwm" {
+		t.Fatalf("unexpected device rules %q", res.Devices)
+	}
+}
+
+func TestOCIDeviceRules(t *testing.T) {
+	major, minor := int64(1), int64(3)
+	rules, err := ociDeviceRules([]OCIDeviceCgroup{
+		{Allow: true, Type: "c", Major: &major, Access: "rwm"},
+		{Allow: true, Type: "b", Access: "m"},
+		{Allow: false, Type: "b"},
+		{Allow: true, Type: "c", Major: &major, Minor: &minor, Access: "r"},
+	})
+	if err != nil || len(rules) != 2 || rules[0] != "c 1:* rwm" || rules[1] != "c 1:3 r" {
+		t.Fatalf("unexpected rules %q %v", rules, err)
+	}
+	rules, err = ociDeviceRules([]OCIDeviceCgroup{
+		{Allow: true, Type: "c", Major: &major, Access: "rwm"},
+		{Allow: false, Type: "c", Major: &major, Minor: &minor, Access: "w"},
+	})
+	if err == nil {
+		t.Fatalf("partial deny should be rejected, got %q", rules)
+	}
+	if _, err := ociDeviceRules([]OCIDeviceCgroup{{Allow: true, Type: "x"}}); err == nil {
+		t.Fatalf("invalid device type should be rejected")
+	}
+}
+
+func TestSysProcAttrRequiresMountNamespace(t *testing.T) {
+	spec := &OCISpec{Linux: &OCILinux{Namespaces: []OCINamespace{{Type: "pid"}}}}
+	if _, err := spec.SysProcAttr(); err == nil {
+		t.Fatalf("expect error without mount namespace")
+	}
+}
+
+func TestSysProcAttrUserNamespace(t *testing.T) {
+	spec := &OCISpec{Linux: &OCILinux{
+		Namespaces:  []OCINamespace{{Type: "mount"}, {Type: "user"}},
+		UIDMappings: []OCIIDMapping{{ContainerID: 0, HostID: 100000, Size: 65536}},
+		GIDMappings: []OCIIDMapping{{ContainerID: 0, HostID: 100000, Size: 65536}},
+	}}
+	attr, err := spec.SysProcAttr()
+	if err != nil {
+		t.Fatalf("sys proc attr %v", err)
+	}
+	// init切换用户需要setgroups，并且要以映射中的root运行才有capability
+	if !attr.GidMappingsEnableSetgroups {
+		t.Errorf("setgroups should be enabled")
+	}
+	if attr.Credential == nil || attr.Credential.Uid != 0 || attr.Credential.Gid != 0 {
+		t.Errorf("unexpected credential %+v", attr.Credential)
+	}
+	if len(attr.UidMappings) != 1 || attr.UidMappings[0].HostID != 100000 {
+		t.Errorf("unexpected uid mappings %+v", attr.UidMappings)
+	}
+
+	spec.Linux.GIDMappings = nil
+	if _, err := spec.SysProcAttr(); err == nil {
+		t.Errorf("expect error without gid mappings")
+	}
+}
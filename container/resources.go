@@ -0,0 +1,135 @@
+package container
+
+import (
+	"fmt"
+	"os"
+	"runtime"
+	"strconv"
+	"strings"
+	"syscall"
+
+	"github.com/xianlubird/mydocker/archive"
+	"github.com/xianlubird/mydocker/cgroups/subsystems"
+)
+
+// DefaultCpuPeriod 是 --cpus 使用的CFS调度周期，单位微秒
+const DefaultCpuPeriod = 100000
+
+// ParseCpus 把 --cpus 1.5 转换成CFS的quota和period，period不为空时使用它
+func ParseCpus(val, period string) (string, string, error) {
+	cpus, err := strconv.ParseFloat(val, 64)
+	if err != nil || cpus <= 0 {
+		return "", "", fmt.Errorf("invalid cpus %s", val)
+	}
+	if cpus > float64(runtime.NumCPU()) {
+		return "", "", fmt.Errorf("cpus %s is larger than the %d cpus available", val, runtime.NumCPU())
+	}
+	p := int64(DefaultCpuPeriod)
+	if period != "" {
+		if p, err = strconv.ParseInt(period, 10, 64); err != nil {
+			return "", "", fmt.Errorf("invalid cpu period %s", period)
+		}
+	}
+	return strconv.FormatInt(int64(cpus*float64(p)), 10), strconv.FormatInt(p, 10), nil
+}
+
+// ParseThrottleDevice 解析 --device-read-bps /dev/sda:10m，返回cgroup中使用的 "major:minor bytes"
+func ParseThrottleDevice(val string) (string, error) {
+	i := strings.LastIndex(val, ":")
+	if i <= 0 {
+		return "", fmt.Errorf("invalid device rate %s, expect device:rate", val)
+	}
+	rate, err := subsystems.ParseSize(val[i+1:])
+	if err != nil {
+		return "", err
+	}
+	fi, err := os.Stat(val[:i])
+	if err != nil {
+		return "", fmt.Errorf("stat device %s error %v", val[:i], err)
+	}
+	if fi.Mode()&os.ModeDevice == 0 || fi.Mode()&os.ModeCharDevice != 0 {
+		return "", fmt.Errorf("%s is not a block device", val[:i])
+	}
+	st := fi.Sys().(*syscall.Stat_t)
+	return fmt.Sprintf("%d:%d %d", archive.Major(uint64(st.Rdev)), archive.Minor(uint64(st.Rdev)), rate), nil
+}
+
+// ParseHugetlbLimit 解析 --hugetlb-limit 2MB:1g，返回 "2MB 1073741824"，大页大小必须是内核支持的
+func ParseHugetlbLimit(val string) (string, error) {
+	parts := strings.Split(val, ":")
+	if len(parts) != 2 {
+		return "", fmt.Errorf("invalid hugetlb limit %s, expect pagesize:limit", val)
+	}
+	pageSize, err := subsystems.ParseSize(parts[0])
+	if err != nil {
+		return "", err
+	}
+	limit, err := subsystems.ParseSize(parts[1])
+	if err != nil {
+		return "", err
+	}
+	sizes, err := subsystems.HugePageSizes()
+	if err != nil {
+		return "", fmt.Errorf("hugetlb is not supported: %v", err)
+	}
+	name := subsystems.HugePageSizeName(pageSize)
+	for _, size := range sizes {
+		if size == name {
+			return fmt.Sprintf("%s %d", name, limit), nil
+		}
+	}
+	return "", fmt.Errorf("hugepage size %s is not supported, supported sizes: %s", parts[0], strings.Join(sizes, ", "))
+}
+
+// ValidateResources 检查各个限制的取值以及它们之间的关系，run的时候就报错，而不是在设置cgroup时才失败
+func ValidateResources(res *subsystems.ResourceConfig) error {
+	sizes := map[string]int64{}
+	for name, val := range map[string]string{
+		"memory":             res.MemoryLimit,
+		"memory swap":        res.MemorySwap,
+		"memory reservation": res.MemoryReservation,
+	} {
+		if val == "" || val == "-1" {
+			continue
+		}
+		size, err := subsystems.ParseSize(val)
+		if err != nil {
+			return fmt.Errorf("invalid %s %s", name, val)
+		}
+		sizes[name] = size
+	}
+	if res.MemorySwap != "" {
+		if res.MemoryLimit == "" || res.MemoryLimit == "-1" {
+			return fmt.Errorf("memory swap can only be set together with a memory limit")
+		}
+		if res.MemorySwap != "-1" && sizes["memory swap"] < sizes["memory"] {
+			return fmt.Errorf("memory swap %s should be larger than memory limit %s", res.MemorySwap, res.MemoryLimit)
+		}
+	}
+	if reservation, ok := sizes["memory reservation"]; ok {
+		if limit, ok := sizes["memory"]; ok && reservation > limit {
+			return fmt.Errorf("memory reservation %s should be smaller than memory limit %s", res.MemoryReservation, res.MemoryLimit)
+		}
+	}
+	if res.CpuPeriod != "" {
+		if period, err := strconv.ParseInt(res.CpuPeriod, 10, 64); err != nil || period < 1000 || period > 1000000 {
+			return fmt.Errorf("invalid cpu period %s, expect 1000 to 1000000 microseconds", res.CpuPeriod)
+		}
+	}
+	if res.CpuQuota != "" && res.CpuQuota != "-1" {
+		if quota, err := strconv.ParseInt(res.CpuQuota, 10, 64); err != nil || quota < 1000 {
+			return fmt.Errorf("invalid cpu quota %s, expect -1 or at least 1000 microseconds", res.CpuQuota)
+		}
+	}
+	if res.PidsLimit != "" && res.PidsLimit != "-1" {
+		if limit, err := strconv.ParseInt(res.PidsLimit, 10, 64); err != nil || limit <= 0 {
+			return fmt.Errorf("invalid pids limit %s, expect -1 or a positive number", res.PidsLimit)
+		}
+	}
+	if res.BlkioWeight != "" {
+		if weight, err := strconv.ParseInt(res.BlkioWeight, 10, 64); err != nil || weight < 10 || weight > 1000 {
+			return fmt.Errorf("invalid blkio weight %s, expect 10 to 1000", res.BlkioWeight)
+		}
+	}
+	return nil
+}
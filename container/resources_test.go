@@ -0,0 +1,63 @@
+package container
+
+import (
+	"runtime"
+	"strconv"
+	"testing"
+
+	"github.com/xianlubird/mydocker/cgroups/subsystems"
+)
+
+func TestParseCpus(t *testing.T) {
+	quota, period, err := ParseCpus("0.5", "")
+	if err != nil || quota != "50000" || period != "100000" {
+		t.Errorf("parse 0.5 = %s %s %v", quota, period, err)
+	}
+	quota, period, err = ParseCpus("0.5", "200000")
+	if err != nil || quota != "100000" || period != "200000" {
+		t.Errorf("parse 0.5 with period = %s %s %v", quota, period, err)
+	}
+	tooMany := strconv.Itoa(runtime.NumCPU() + 1)
+	for _, val := range []string{"", "0", "-1", "abc", tooMany} {
+		if _, _, err := ParseCpus(val, ""); err == nil {
+			t.Errorf("parse %q should fail", val)
+		}
+	}
+}
+
+func TestParseThrottleDevice(t *testing.T) {
+	for _, val := range []string{"/dev/null:1m", "/dev/nosuchdevice:1m", "/dev/null", "/dev/null:abc"} {
+		if _, err := ParseThrottleDevice(val); err == nil {
+			t.Errorf("parse %q should fail", val)
+		}
+	}
+}
+
+func TestValidateResources(t *testing.T) {
+	valid := []*subsystems.ResourceConfig{
+		{MemoryLimit: "100m", MemorySwap: "200m", MemoryReservation: "50m"},
+		{MemoryLimit: "100m", MemorySwap: "-1"},
+		{CpuPeriod: "100000", CpuQuota: "-1", PidsLimit: "-1"},
+		{PidsLimit: "100", BlkioWeight: "10"},
+	}
+	for _, res := range valid {
+		if err := ValidateResources(res); err != nil {
+			t.Errorf("validate %+v: %v", res, err)
+		}
+	}
+	invalid := []*subsystems.ResourceConfig{
+		{MemoryLimit: "100x"},
+		{MemorySwap: "200m"},
+		{MemoryLimit: "100m", MemorySwap: "50m"},
+		{MemoryLimit: "100m", MemoryReservation: "200m"},
+		{CpuPeriod: "100"},
+		{CpuQuota: "10"},
+		{PidsLimit: "0"},
+		{BlkioWeight: "5"},
+	}
+	for _, res := range invalid {
+		if err := ValidateResources(res); err == nil {
+			t.Errorf("validate %+v should fail", res)
+		}
+	}
+}
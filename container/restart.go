@@ -0,0 +1,86 @@
+package container
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// 重启策略的名字，和docker的 --restart 一样
+const (
+	RestartNo            = "no"
+	RestartOnFailure     = "on-failure"
+	RestartAlways        = "always"
+	RestartUnlessStopped = "unless-stopped"
+)
+
+// 第一次重启前等待100ms，之后每次翻倍，最多等1分钟；容器运行超过10s之后再退出又从100ms开始
+const (
+	restartDelayMin   = 100 * time.Millisecond
+	restartDelayMax   = time.Minute
+	restartResetAfter = 10 * time.Second
+)
+
+// RestartPolicy 由shim在容器退出时执行，stop停止的容器不会被重启。
+// 没有常驻的daemon，always和unless-stopped的区别只在于以后daemon启动时是否拉起stop过的容器，现在行为一样
+type RestartPolicy struct {
+	Name              string `json:"name"`
+	MaximumRetryCount int    `json:"maximumRetryCount,omitempty"` //on-failure的最大重启次数，0表示不限制
+}
+
+// ParseRestartPolicy 解析 no、on-failure[:N]、always、unless-stopped，空字符串等同于no
+func ParseRestartPolicy(policy string) (*RestartPolicy, error) {
+	parts := strings.SplitN(policy, ":", 2)
+	p := &RestartPolicy{Name: parts[0]}
+	switch p.Name {
+	case "":
+		p.Name = RestartNo
+	case RestartNo, RestartAlways, RestartUnlessStopped:
+	case RestartOnFailure:
+		if len(parts) == 2 {
+			count, err := strconv.Atoi(parts[1])
+			if err != nil || count < 0 {
+				return nil, fmt.Errorf("invalid maximum retry count %q in restart policy %s", parts[1], policy)
+			}
+			p.MaximumRetryCount = count
+		}
+		return p, nil
+	default:
+		return nil, fmt.Errorf("invalid restart policy %s, expect no, on-failure[:N], always or unless-stopped", policy)
+	}
+	if len(parts) == 2 {
+		return nil, fmt.Errorf("maximum retry count can only be used with on-failure")
+	}
+	return p, nil
+}
+
+// IsNone 没有设置重启策略
+func (p *RestartPolicy) IsNone() bool {
+	return p == nil || p.Name == RestartNo
+}
+
+// ShouldRestart 容器自己退出之后是否需要重启，restartCount是已经重启过的次数
+func (p *RestartPolicy) ShouldRestart(exitCode, restartCount int) bool {
+	if p == nil {
+		return false
+	}
+	switch p.Name {
+	case RestartAlways, RestartUnlessStopped:
+		return true
+	case RestartOnFailure:
+		return exitCode != 0 && (p.MaximumRetryCount == 0 || restartCount < p.MaximumRetryCount)
+	}
+	return false
+}
+
+// NextRestartDelay 根据上一次的等待时间和容器这次运行了多久计算下一次重启前等待的时间
+func NextRestartDelay(last, uptime time.Duration) time.Duration {
+	if last == 0 || uptime >= restartResetAfter {
+		return restartDelayMin
+	}
+	if next := last * 2; next < restartDelayMax {
+		return next
+	}
+	return restartDelayMax
+}
@@ -0,0 +1,83 @@
+package container
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseRestartPolicy(t *testing.T) {
+	cases := []struct {
+		in   string
+		want RestartPolicy
+	}{
+		{"", RestartPolicy{Name: RestartNo}},
+		{"no", RestartPolicy{Name: RestartNo}},
+		{"always", RestartPolicy{Name: RestartAlways}},
+		{"unless-stopped", RestartPolicy{Name: RestartUnlessStopped}},
+		{"on-failure", RestartPolicy{Name: RestartOnFailure}},
+		{"on-failure:3", RestartPolicy{Name: RestartOnFailure, MaximumRetryCount: 3}},
+	}
+	for _, c := range cases {
+		p, err := ParseRestartPolicy(c.in)
+		if err != nil {
+			t.Errorf("parse %q: %v", c.in, err)
+			continue
+		}
+		if *p != c.want {
+			t.Errorf("parse %q = %+v, want %+v", c.in, *p, c.want)
+		}
+	}
+	for _, in := range []string{"sometimes", "always:3", "on-failure:x", "on-failure:-1"} {
+		if _, err := ParseRestartPolicy(in); err == nil {
+			t.Errorf("parse %q should fail", in)
+		}
+	}
+}
+
+func TestShouldRestart(t *testing.T) {
+	cases := []struct {
+		policy   string
+		exitCode int
+		count    int
+		want     bool
+	}{
+		{"no", 1, 0, false},
+		{"always", 0, 100, true},
+		{"unless-stopped", 0, 0, true},
+		{"on-failure", 0, 0, false},
+		{"on-failure", 137, 100, true},
+		{"on-failure:2", 1, 1, true},
+		{"on-failure:2", 1, 2, false},
+	}
+	for _, c := range cases {
+		p, _ := ParseRestartPolicy(c.policy)
+		if got := p.ShouldRestart(c.exitCode, c.count); got != c.want {
+			t.Errorf("%s exit %d count %d: got %v, want %v", c.policy, c.exitCode, c.count, got, c.want)
+		}
+	}
+	var none *RestartPolicy
+	if none.ShouldRestart(1, 0) || !none.IsNone() {
+		t.Errorf("nil policy should never restart")
+	}
+}
+
+func TestNextRestartDelay(t *testing.T) {
+	delay := time.Duration(0)
+	var got []time.Duration
+	for i := 0; i < 4; i++ {
+		delay = NextRestartDelay(delay, time.Second)
+		got = append(got, delay)
+	}
+	want := []time.Duration{100 * time.Millisecond, 200 * time.Millisecond, 400 * time.Millisecond, 800 * time.Millisecond}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("delays %v, want %v", got, want)
+		}
+	}
+	if d := NextRestartDelay(40*time.Second, time.Second); d != time.Minute {
+		t.Errorf("delay should be capped at 1m, got %v", d)
+	}
+	if d := NextRestartDelay(time.Minute, time.Minute); d != 100*time.Millisecond {
+		t.Errorf("delay should reset after a long run, got %v", d)
+	}
+}
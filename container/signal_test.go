@@ -0,0 +1,28 @@
+package container
+
+import (
+	"syscall"
+	"testing"
+)
+
+func TestParseSignal(t *testing.T) {
+	cases := map[string]syscall.Signal{
+		"SIGTERM": syscall.SIGTERM,
+		"TERM":    syscall.SIGTERM,
+		"sigkill": syscall.SIGKILL,
+		"usr1":    syscall.SIGUSR1,
+		"9":       syscall.SIGKILL,
+		"34":      syscall.Signal(34),
+	}
+	for in, want := range cases {
+		sig, err := ParseSignal(in)
+		if err != nil || sig != want {
+			t.Errorf("parse %q = %v, %v, want %v", in, sig, err, want)
+		}
+	}
+	for _, in := range []string{"", "SIGFOO", "0", "65", "-1"} {
+		if _, err := ParseSignal(in); err == nil {
+			t.Errorf("parse %q should fail", in)
+		}
+	}
+}
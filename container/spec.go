@@ -0,0 +1,153 @@
+package container
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/xianlubird/mydocker/cgroups/subsystems"
+	"github.com/xianlubird/mydocker/seccomp"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// InitSpecVersion 父进程与init进程之间约定的spec版本，字段发生不兼容变化时递增
+const InitSpecVersion = 1
+
+// InitSpec 是父进程通过管道(fd 3)发送给容器init进程的启动描述。
+// 相比以前用空格拼接命令的方式，参数中包含空格也不会被拆开，新增的init选项也只需要在这里加字段。
+type InitSpec struct {
+	Version  int      `json:"version"`
+	Args     []string `json:"args"`               //用户命令及参数，原样传递
+	Env      []string `json:"env,omitempty"`      //容器进程的环境变量
+	Cwd      string   `json:"cwd,omitempty"`      //pivot_root之后的工作目录
+	Hostname string   `json:"hostname,omitempty"` //UTS namespace中的主机名
+	User     string   `json:"user,omitempty"`     //运行用户, user[:group]，可以是名字或数字
+	Mounts   []Mount  `json:"mounts,omitempty"`   //pivot_root之后额外挂载的文件系统
+	Rlimits  []Rlimit `json:"rlimits,omitempty"`  //exec之前设置的资源限制
+
+	AdditionalGids []int    `json:"additionalGids,omitempty"` //除了/etc/group之外额外的附加组
+	Capabilities   []string `json:"capabilities"`             //保留的capability，nil表示不限制，空数组表示全部去掉，不能omitempty
+	ReadonlyRootfs bool     `json:"readonlyRootfs,omitempty"` //以只读方式remount容器根目录
+	ExecFifo       string   `json:"execFifo,omitempty"`       //create创建的容器在exec用户命令之前等待start
+	MaskedPaths    []string `json:"maskedPaths,omitempty"`    //容器内屏蔽的路径
+	ReadonlyPaths  []string `json:"readonlyPaths,omitempty"`  //容器内只读的路径
+	Terminal       bool     `json:"terminal,omitempty"`       //标准输入是pty的slave，需要设置成控制终端
+	Devices        []Device `json:"devices,omitempty"`        ///dev下创建的设备节点
+	ShmSize        int64    `json:"shmSize,omitempty"`        ///dev/shm的大小，0表示使用DefaultShmSize
+
+	Seccomp *seccomp.Seccomp `json:"seccomp,omitempty"` //已经按capability处理过的seccomp profile，nil表示不过滤
+}
+
+type Mount struct {
+	Source      string   `json:"source"`
+	Destination string   `json:"destination"`
+	Type        string   `json:"type"`
+	Options     []string `json:"options,omitempty"`
+}
+
+type Rlimit struct {
+	Type string `json:"type"`
+	Soft uint64 `json:"soft"`
+	Hard uint64 `json:"hard"`
+}
+
+var rlimitTypes = map[string]int{
+	"cpu":        0,
+	"fsize":      1,
+	"data":       2,
+	"stack":      3,
+	"core":       4,
+	"rss":        5,
+	"nproc":      6,
+	"nofile":     7,
+	"memlock":    8,
+	"as":         9,
+	"locks":      10,
+	"sigpending": 11,
+	"msgqueue":   12,
+	"nice":       13,
+	"rtprio":     14,
+	"rttime":     15,
+}
+
+// rlimitResource 同时接受 nofile 和 RLIMIT_NOFILE 两种写法
+func rlimitResource(name string) (int, error) {
+	key := strings.TrimPrefix(strings.ToLower(name), "rlimit_")
+	resource, ok := rlimitTypes[key]
+	if !ok {
+		return 0, fmt.Errorf("unknown rlimit type %s", name)
+	}
+	return resource, nil
+}
+
+// ParseRlimit 解析 --ulimit 参数, 格式为 type=soft[:hard]
+func ParseRlimit(val string) (Rlimit, error) {
+	parts := strings.SplitN(val, "=", 2)
+	if len(parts) != 2 {
+		return Rlimit{}, fmt.Errorf("invalid ulimit %s, expect type=soft[:hard]", val)
+	}
+	if _, err := rlimitResource(parts[0]); err != nil {
+		return Rlimit{}, err
+	}
+	limits := strings.SplitN(parts[1], ":", 2)
+	soft, err := strconv.ParseUint(limits[0], 10, 64)
+	if err != nil {
+		return Rlimit{}, fmt.Errorf("invalid ulimit soft value %s", limits[0])
+	}
+	hard := soft
+	if len(limits) == 2 {
+		if hard, err = strconv.ParseUint(limits[1], 10, 64); err != nil {
+			return Rlimit{}, fmt.Errorf("invalid ulimit hard value %s", limits[1])
+		}
+	}
+	if soft > hard {
+		return Rlimit{}, fmt.Errorf("ulimit soft value %d is larger than hard value %d", soft, hard)
+	}
+	return Rlimit{Type: parts[0], Soft: soft, Hard: hard}, nil
+}
+
+// ParseSize 解析 64m、1g 这样的大小，单位是1024的倍数，没有单位时是字节
+func ParseSize(val string) (int64, error) {
+	return subsystems.ParseSize(val)
+}
+
+// ParseTmpfs 解析 --tmpfs 参数，格式为 path[:options]，默认带上 nosuid,nodev,noexec
+func ParseTmpfs(val string) (Mount, error) {
+	parts := strings.SplitN(val, ":", 2)
+	if !strings.HasPrefix(parts[0], "/") {
+		return Mount{}, fmt.Errorf("invalid tmpfs %s, path must be absolute", val)
+	}
+	options := []string{"nosuid", "nodev", "noexec"}
+	if len(parts) == 2 && parts[1] != "" {
+		options = append(options, strings.Split(parts[1], ",")...)
+	}
+	return Mount{Source: "tmpfs", Destination: parts[0], Type: "tmpfs", Options: options}, nil
+}
+
+// WriteInitSpec 把spec以JSON格式写入管道
+func WriteInitSpec(w io.Writer, spec *InitSpec) error {
+	spec.Version = InitSpecVersion
+	return json.NewEncoder(w).Encode(spec)
+}
+
+// ReadInitSpec 从管道中解析spec并校验版本
+func ReadInitSpec(r io.Reader) (*InitSpec, error) {
+	spec := &InitSpec{}
+	if err := json.NewDecoder(r).Decode(spec); err != nil {
+		return nil, fmt.Errorf("decode init spec error %v", err)
+	}
+	if spec.Version != InitSpecVersion {
+		return nil, fmt.Errorf("unsupported init spec version %d, want %d", spec.Version, InitSpecVersion)
+	}
+	if len(spec.Args) == 0 {
+		return nil, fmt.Errorf("init spec has no command")
+	}
+	return spec, nil
+}
+
+func readInitSpec() (*InitSpec, error) {
+	pipe := os.NewFile(uintptr(3), "pipe")
+	defer pipe.Close()
+	return ReadInitSpec(pipe)
+}
@@ -0,0 +1,103 @@
+package container
+
+import (
+	"bytes"
+	"reflect"
+	"syscall"
+	"testing"
+)
+
+func TestInitSpecRoundTrip(t *testing.T) {
+	spec := &InitSpec{
+		Args:     []string{"sh", "-c", "echo hello world"},
+		Env:      []string{"PATH=/bin:/usr/bin", "GREETING=hi there"},
+		Cwd:      "/tmp",
+		Hostname: "box",
+		Rlimits:  []Rlimit{{Type: "nofile", Soft: 1024, Hard: 2048}},
+	}
+	var buf bytes.Buffer
+	if err := WriteInitSpec(&buf, spec); err != nil {
+		t.Fatalf("write init spec %v", err)
+	}
+	got, err := ReadInitSpec(&buf)
+	if err != nil {
+		t.Fatalf("read init spec %v", err)
+	}
+	if !reflect.DeepEqual(got, spec) {
+		t.Fatalf("init spec mismatch, got %+v want %+v", got, spec)
+	}
+}
+
+func TestReadInitSpecVersion(t *testing.T) {
+	if _, err := ReadInitSpec(bytes.NewBufferString(`{"version":99,"args":["sh"]}`)); err == nil {
+		t.Fatalf("expect unsupported version error")
+	}
+}
+
+func TestParseRlimit(t *testing.T) {
+	rl, err := ParseRlimit("nofile=1024:2048")
+	if err != nil || rl.Soft != 1024 || rl.Hard != 2048 {
+		t.Fatalf("parse rlimit got %+v %v", rl, err)
+	}
+	rl, err = ParseRlimit("RLIMIT_CORE=0")
+	if err != nil || rl.Soft != 0 || rl.Hard != 0 {
+		t.Fatalf("parse rlimit got %+v %v", rl, err)
+	}
+	for _, bad := range []string{"nofile", "foo=1", "nofile=2:1", "nofile=x"} {
+		if _, err := ParseRlimit(bad); err == nil {
+			t.Fatalf("expect error for %s", bad)
+		}
+	}
+}
+
+func TestParseTmpfs(t *testing.T) {
+	m, err := ParseTmpfs("/run:size=64m,exec")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.Destination != "/run" || m.Type != "tmpfs" {
+		t.Fatalf("unexpected mount %+v", m)
+	}
+	flags, data := parseMountOptions(m.Options)
+	if flags&syscall.MS_NOEXEC != 0 || flags&syscall.MS_NOSUID == 0 || data != "size=64m" {
+		t.Fatalf("unexpected options %x %q", flags, data)
+	}
+	if _, err := ParseTmpfs("run"); err == nil {
+		t.Fatal("expect error for relative path")
+	}
+}
+
+func TestParseSize(t *testing.T) {
+	cases := map[string]int64{"1024": 1024, "64m": 64 << 20, "1G": 1 << 30, "512kb": 512 << 10}
+	for val, want := range cases {
+		if got, err := ParseSize(val); err != nil || got != want {
+			t.Errorf("parse size %s got %d %v, want %d", val, got, err, want)
+		}
+	}
+	for _, bad := range []string{"", "m", "-1", "1x"} {
+		if _, err := ParseSize(bad); err == nil {
+			t.Errorf("expect error for %q", bad)
+		}
+	}
+}
+
+func TestParseDevice(t *testing.T) {
+	d, err := ParseDevice("/dev/null:/dev/mynull:rw")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d.Path != "/dev/mynull" || d.HostPath != "/dev/null" || d.Type != "c" || d.Major != 1 || d.Minor != 3 {
+		t.Fatalf("unexpected device %+v", d)
+	}
+	if rule := d.CgroupRule(); rule != "c 1:3 rw" {
+		t.Fatalf("unexpected cgroup rule %s", rule)
+	}
+	if d, err := ParseDevice("/dev/zero:r"); err != nil || d.Path != "/dev/zero" || d.Permissions != "r" {
+		t.Fatalf("unexpected device %+v %v", d, err)
+	}
+	for _, bad := range []string{"/etc/passwd", "/dev/null:dev/null", "/dev/null:/dev/null:rx"} {
+		if _, err := ParseDevice(bad); err == nil {
+			t.Errorf("expect error for %s", bad)
+		}
+	}
+}
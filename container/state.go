@@ -0,0 +1,153 @@
+package container
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/xianlubird/mydocker/cgroups"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// TimeFormat 是ContainerInfo中各个时间的格式
+const TimeFormat = "2006-01-02 15:04:05"
+
+// 容器的生命周期：
+//
+//	created --start--> running --pause--> paused --unpause--> running
+//	created/running/paused --stop--> stopped，进程自己退出时是exited
+//	stopped/exited --start--> running
+//	running --按重启策略退出--> restarting --等待之后--> running
+//
+// 进程已经不在、却没有人记录退出的容器是dead，shim晚一步记录退出时仍然可以变成stopped或exited
+var stateTransitions = map[string][]string{
+	CREATED:    {RUNNING, STOP, Exit, DEAD},
+	RUNNING:    {PAUSED, STOP, Exit, DEAD, RESTARTING},
+	PAUSED:     {RUNNING, STOP, Exit, DEAD},
+	STOP:       {RUNNING},
+	Exit:       {RUNNING},
+	DEAD:       {STOP, Exit},
+	RESTARTING: {RUNNING, STOP, Exit, DEAD},
+}
+
+// IsActive 表示容器还没有结束：created、running或者paused时init进程应该还在，restarting时shim在等待重启
+func (c *ContainerInfo) IsActive() bool {
+	return c.Status == CREATED || c.Status == RUNNING || c.Status == PAUSED || c.Status == RESTARTING
+}
+
+// Transition 切换容器的状态并更新时间，不允许的转换返回错误
+func (c *ContainerInfo) Transition(to string) error {
+	allowed := false
+	for _, s := range stateTransitions[c.Status] {
+		if s == to {
+			allowed = true
+			break
+		}
+	}
+	if !allowed {
+		return fmt.Errorf("container %s is %s, can not become %s", c.Name, c.Status, to)
+	}
+	now := time.Now().Format(TimeFormat)
+	switch to {
+	case RUNNING:
+		if c.Status != PAUSED {
+			c.StartedAt = now
+			c.FinishedAt = ""
+		}
+	case STOP, Exit, DEAD, RESTARTING:
+		c.Pid = " "
+		c.FinishedAt = now
+	}
+	c.Status = to
+	return nil
+}
+
+// Reconcile 用 /proc/<pid> 和进程所在的cgroup检查记录的状态，返回状态是否有变化。
+// init已经不在时：shim还活着说明它马上会记录退出，不用处理；OCI bundle创建的容器没有人记录退出，
+// 按OCI的定义是stopped；其他容器的shim已经不在了，标记为dead
+func (c *ContainerInfo) Reconcile() bool {
+	if !c.IsActive() {
+		return false
+	}
+	pid, _ := strconv.Atoi(strings.TrimSpace(c.Pid))
+	if pid > 0 && processInCgroup(pid, cgroups.NewCgroupManager(c.Id, c.CgroupDriver).ProcPath()) {
+		return false
+	}
+	if c.ShimRunning() {
+		return false
+	}
+	to := DEAD
+	if c.Bundle != "" {
+		to = STOP
+	}
+	return c.Transition(to) == nil
+}
+
+// ShimRunning 等待init的进程还在，说明容器还没有退出或者还在清理，stop之后也可能是这样
+func (c *ContainerInfo) ShimRunning() bool {
+	return c.ShimPid > 0 && ProcessAlive(c.ShimPid)
+}
+
+// PidNamespace 返回进程所在的pid namespace，比如 pid:[4026532263]，进程已经不在时返回空字符串
+func PidNamespace(pid int) string {
+	ns, err := os.Readlink(fmt.Sprintf("/proc/%d/ns/pid", pid))
+	if err != nil {
+		return ""
+	}
+	return ns
+}
+
+// PidNamespaceEmpty 检查pid namespace中是否还有进程，僵尸进程的ns读不到，不算在内。
+// init退出时内核会杀掉namespace中的其他进程，但是它们可能还没有真正退出
+func PidNamespaceEmpty(ns string) bool {
+	if ns == "" {
+		return true
+	}
+	entries, err := ioutil.ReadDir("/proc")
+	if err != nil {
+		return false
+	}
+	for _, entry := range entries {
+		pid, err := strconv.Atoi(entry.Name())
+		if err != nil {
+			continue
+		}
+		if PidNamespace(pid) == ns {
+			return false
+		}
+	}
+	return true
+}
+
+// ProcessAlive 进程存在并且不是僵尸进程
+func ProcessAlive(pid int) bool {
+	stat, err := ioutil.ReadFile(fmt.Sprintf("/proc/%d/stat", pid))
+	if err != nil {
+		return false
+	}
+	// comm中可能有空格和括号，进程状态在最后一个)之后
+	i := bytes.LastIndexByte(stat, ')')
+	return i >= 0 && i+2 < len(stat) && stat[i+2] != 'Z'
+}
+
+// processInCgroup pid可能已经被别的进程复用，还要检查它是否在容器的cgroup中。
+// cgroupPath是容器使用的驱动下cgroup的完整路径，要和 /proc/<pid>/cgroup 中某个hierarchy的路径完全相同
+func processInCgroup(pid int, cgroupPath string) bool {
+	if !ProcessAlive(pid) {
+		return false
+	}
+	content, err := ioutil.ReadFile(fmt.Sprintf("/proc/%d/cgroup", pid))
+	if err != nil {
+		return false
+	}
+	// 每行是 hierarchy-ID:controller-list:cgroup-path
+	for _, line := range strings.Split(strings.TrimSpace(string(content)), "\n") {
+		fields := strings.SplitN(line, ":", 3)
+		if len(fields) == 3 && fields[2] == cgroupPath {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,90 @@
+package container
+
+import (
+	"io/ioutil"
+	"os"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestTransition(t *testing.T) {
+	info := &ContainerInfo{Name: "c1", Pid: "42", Status: CREATED}
+	for _, to := range []string{RUNNING, PAUSED, RUNNING, RESTARTING, RUNNING, Exit, RUNNING, STOP} {
+		if err := info.Transition(to); err != nil {
+			t.Fatalf("transition to %s: %v", to, err)
+		}
+	}
+	if info.Pid != " " || info.StartedAt == "" || info.FinishedAt == "" {
+		t.Errorf("unexpected info after stop: %+v", info)
+	}
+	for _, c := range []struct{ from, to string }{
+		{STOP, PAUSED},
+		{Exit, STOP},
+		{DEAD, RUNNING},
+		{PAUSED, RESTARTING},
+		{CREATED, CREATED},
+	} {
+		info := &ContainerInfo{Name: "c1", Status: c.from}
+		if err := info.Transition(c.to); err == nil {
+			t.Errorf("%s -> %s should be rejected", c.from, c.to)
+		}
+		if info.Status != c.from {
+			t.Errorf("%s -> %s changed status to %s", c.from, c.to, info.Status)
+		}
+	}
+}
+
+func TestReconcile(t *testing.T) {
+	self := strconv.Itoa(os.Getpid())
+	cases := []struct {
+		info    ContainerInfo
+		changed bool
+		want    string
+	}{
+		// 测试进程不在以这个ID命名的cgroup中，相当于pid被复用了
+		{ContainerInfo{Id: "no-such-container", Pid: self, Status: RUNNING}, true, DEAD},
+		{ContainerInfo{Id: "no-such-container", Pid: " ", Status: RUNNING, ShimPid: os.Getpid()}, false, RUNNING},
+		{ContainerInfo{Id: "no-such-container", Pid: " ", Status: CREATED, Bundle: "/bundle"}, true, STOP},
+		{ContainerInfo{Id: "no-such-container", Pid: " ", Status: Exit}, false, Exit},
+		{ContainerInfo{Id: "no-such-container", Pid: " ", Status: RESTARTING, ShimPid: os.Getpid()}, false, RESTARTING},
+		{ContainerInfo{Id: "no-such-container", Pid: " ", Status: RESTARTING}, true, DEAD},
+	}
+	for i, c := range cases {
+		info := c.info
+		if changed := info.Reconcile(); changed != c.changed || info.Status != c.want {
+			t.Errorf("case %d: changed %v status %s, want %v %s", i, changed, info.Status, c.changed, c.want)
+		}
+	}
+}
+
+func TestPidNamespaceEmpty(t *testing.T) {
+	ns := PidNamespace(os.Getpid())
+	if ns == "" {
+		t.Skip("pid namespace is not available")
+	}
+	if PidNamespaceEmpty(ns) {
+		t.Errorf("namespace %s of the test process should not be empty", ns)
+	}
+	if !PidNamespaceEmpty("pid:[0]") || !PidNamespaceEmpty("") {
+		t.Errorf("unknown namespace should be empty")
+	}
+}
+
+func TestProcessInCgroup(t *testing.T) {
+	content, err := ioutil.ReadFile("/proc/self/cgroup")
+	if err != nil {
+		t.Skip(err)
+	}
+	fields := strings.SplitN(strings.Split(strings.TrimSpace(string(content)), "\n")[0], ":", 3)
+	if len(fields) != 3 || len(fields[2]) < 2 {
+		t.Skipf("unexpected /proc/self/cgroup %s", content)
+	}
+	if !processInCgroup(os.Getpid(), fields[2]) {
+		t.Errorf("test process should be in cgroup %s", fields[2])
+	}
+	// 只包含容器ID的其他cgroup不算
+	if processInCgroup(os.Getpid(), fields[2][:len(fields[2])-1]) {
+		t.Errorf("prefix of cgroup %s should not match", fields[2])
+	}
+}
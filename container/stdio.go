@@ -0,0 +1,73 @@
+package container
+
+import (
+	"github.com/xianlubird/mydocker/term"
+	"os"
+	"os/exec"
+)
+
+// ProcessIO 是容器进程标准输入输出留在宿主机上的一端
+type ProcessIO struct {
+	Pty    *term.Pty //-ti 时容器的终端，Stdin和Output都为空
+	Stdin  *os.File  //-i 时写入容器的标准输入
+	Output *os.File  //没有终端时容器的stdout和stderr
+
+	child []*os.File
+}
+
+// newProcessIO 设置cmd的标准输入输出。没有-i时容器的标准输入是/dev/null
+func newProcessIO(cmd *exec.Cmd, tty, interactive bool) (*ProcessIO, error) {
+	processIO := &ProcessIO{}
+	if tty {
+		// init进程会把slave设置成自己的控制终端
+		pty, err := term.OpenPty()
+		if err != nil {
+			return nil, err
+		}
+		processIO.Pty = pty
+		processIO.child = []*os.File{pty.Slave}
+		cmd.Stdin = pty.Slave
+		cmd.Stdout = pty.Slave
+		cmd.Stderr = pty.Slave
+		return processIO, nil
+	}
+	readOutput, writeOutput, err := os.Pipe()
+	if err != nil {
+		return nil, err
+	}
+	processIO.Output = readOutput
+	processIO.child = []*os.File{writeOutput}
+	cmd.Stdout = writeOutput
+	cmd.Stderr = writeOutput
+	if interactive {
+		readStdin, writeStdin, err := os.Pipe()
+		if err != nil {
+			processIO.Close()
+			return nil, err
+		}
+		processIO.Stdin = writeStdin
+		processIO.child = append(processIO.child, readStdin)
+		cmd.Stdin = readStdin
+	}
+	return processIO, nil
+}
+
+// CloseChild 在容器进程启动之后关闭交给它的一端，容器退出时读Output才会返回EOF
+func (p *ProcessIO) CloseChild() {
+	for _, f := range p.child {
+		f.Close()
+	}
+	p.child = nil
+}
+
+func (p *ProcessIO) Close() {
+	p.CloseChild()
+	for _, f := range []*os.File{p.Stdin, p.Output} {
+		if f != nil {
+			f.Close()
+		}
+	}
+	if p.Pty != nil {
+		p.Pty.Master.Close()
+	}
+}
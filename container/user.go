@@ -0,0 +1,177 @@
+package container
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// ExecUser 是 user[:group] 解析之后容器进程使用的身份
+type ExecUser struct {
+	Uid   int
+	Gid   int
+	Sgids []int //附加组
+	Home  string
+}
+
+type passwdEntry struct {
+	name string
+	uid  int
+	gid  int
+	home string
+}
+
+type groupEntry struct {
+	name    string
+	gid     int
+	members []string
+}
+
+// LookupUser 在root下的 /etc/passwd 和 /etc/group 中解析 user[:group]，用户和组可以是名字或数字。
+// 数字的用户可以不在passwd中，名字必须存在。没有指定组时使用passwd中的主组，
+// 并把/etc/group中包含该用户的组作为附加组；指定了组时不再添加附加组
+func LookupUser(userSpec, root string) (*ExecUser, error) {
+	userPart, groupPart := userSpec, ""
+	if i := strings.Index(userSpec, ":"); i >= 0 {
+		userPart, groupPart = userSpec[:i], userSpec[i+1:]
+	}
+	if userPart == "" {
+		userPart = "0"
+	}
+	passwd, err := readPasswd(filepath.Join(root, "etc/passwd"))
+	if err != nil {
+		return nil, err
+	}
+	groups, err := readGroup(filepath.Join(root, "etc/group"))
+	if err != nil {
+		return nil, err
+	}
+
+	user := &ExecUser{Home: "/"}
+	var entry *passwdEntry
+	if uid, err := strconv.Atoi(userPart); err == nil {
+		if uid < 0 {
+			return nil, fmt.Errorf("invalid uid %s", userPart)
+		}
+		user.Uid = uid
+		for i := range passwd {
+			if passwd[i].uid == uid {
+				entry = &passwd[i]
+				break
+			}
+		}
+	} else {
+		for i := range passwd {
+			if passwd[i].name == userPart {
+				entry = &passwd[i]
+				break
+			}
+		}
+		if entry == nil {
+			return nil, fmt.Errorf("unable to find user %s: no matching entries in passwd file", userPart)
+		}
+		user.Uid = entry.uid
+	}
+	if entry != nil {
+		user.Gid = entry.gid
+		if entry.home != "" {
+			user.Home = entry.home
+		}
+	}
+
+	if groupPart != "" {
+		if gid, err := strconv.Atoi(groupPart); err == nil {
+			if gid < 0 {
+				return nil, fmt.Errorf("invalid gid %s", groupPart)
+			}
+			user.Gid = gid
+		} else {
+			found := false
+			for _, g := range groups {
+				if g.name == groupPart {
+					user.Gid, found = g.gid, true
+					break
+				}
+			}
+			if !found {
+				return nil, fmt.Errorf("unable to find group %s: no matching entries in group file", groupPart)
+			}
+		}
+		return user, nil
+	}
+	if entry != nil {
+		for _, g := range groups {
+			if g.gid == user.Gid {
+				continue
+			}
+			for _, member := range g.members {
+				if member == entry.name {
+					user.Sgids = append(user.Sgids, g.gid)
+					break
+				}
+			}
+		}
+	}
+	return user, nil
+}
+
+// readColonFile 按行读取passwd格式的文件，文件不存在时当作空文件
+func readColonFile(path string, minFields int, fn func(fields []string)) error {
+	f, err := os.Open(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if fields := strings.Split(line, ":"); len(fields) >= minFields {
+			fn(fields)
+		}
+	}
+	return scanner.Err()
+}
+
+// passwd的格式为 name:password:uid:gid:gecos:home:shell
+func readPasswd(path string) ([]passwdEntry, error) {
+	var entries []passwdEntry
+	err := readColonFile(path, 4, func(fields []string) {
+		uid, err1 := strconv.Atoi(fields[2])
+		gid, err2 := strconv.Atoi(fields[3])
+		if err1 != nil || err2 != nil {
+			return
+		}
+		entry := passwdEntry{name: fields[0], uid: uid, gid: gid}
+		if len(fields) > 5 {
+			entry.home = fields[5]
+		}
+		entries = append(entries, entry)
+	})
+	return entries, err
+}
+
+// group的格式为 name:password:gid:member1,member2
+func readGroup(path string) ([]groupEntry, error) {
+	var entries []groupEntry
+	err := readColonFile(path, 3, func(fields []string) {
+		gid, err := strconv.Atoi(fields[2])
+		if err != nil {
+			return
+		}
+		entry := groupEntry{name: fields[0], gid: gid}
+		if len(fields) > 3 && fields[3] != "" {
+			entry.members = strings.Split(fields[3], ",")
+		}
+		entries = append(entries, entry)
+	})
+	return entries, err
+}
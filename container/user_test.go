@@ -0,0 +1,45 @@
+package container
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestLookupUser(t *testing.T) {
+	root, _ := ioutil.TempDir("", "rootfs")
+	defer os.RemoveAll(root)
+	os.MkdirAll(filepath.Join(root, "etc"), 0755)
+	ioutil.WriteFile(filepath.Join(root, "etc/passwd"), []byte(
+		"root:x:0:0:root:/root:/bin/sh\napp:x:1000:1000:app:/home/app:/bin/sh\n"), 0644)
+	ioutil.WriteFile(filepath.Join(root, "etc/group"), []byte(
+		"root:x:0:\napp:x:1000:\nwheel:x:10:root,app\naudio:x:29:app\nstaff:x:50:\n"), 0644)
+
+	cases := []struct {
+		spec string
+		want ExecUser
+	}{
+		{"", ExecUser{Uid: 0, Gid: 0, Sgids: []int{10}, Home: "/root"}},
+		{"app", ExecUser{Uid: 1000, Gid: 1000, Sgids: []int{10, 29}, Home: "/home/app"}},
+		{"app:staff", ExecUser{Uid: 1000, Gid: 50, Home: "/home/app"}},
+		{"1000:29", ExecUser{Uid: 1000, Gid: 29, Home: "/home/app"}},
+		{"4242", ExecUser{Uid: 4242, Gid: 0, Home: "/"}},
+	}
+	for _, c := range cases {
+		got, err := LookupUser(c.spec, root)
+		if err != nil {
+			t.Errorf("%q: %v", c.spec, err)
+			continue
+		}
+		if !reflect.DeepEqual(*got, c.want) {
+			t.Errorf("%q: got %+v want %+v", c.spec, *got, c.want)
+		}
+	}
+	for _, spec := range []string{"nobody", "app:nogroup", "-1"} {
+		if _, err := LookupUser(spec, root); err == nil {
+			t.Errorf("%q resolved without error", spec)
+		}
+	}
+}
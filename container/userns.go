@@ -0,0 +1,95 @@
+package container
+
+import (
+	"fmt"
+	"github.com/xianlubird/mydocker/idtools"
+	"os"
+)
+
+// SubIDUser 是在 /etc/subuid 和 /etc/subgid 中给容器分配从属id的用户，
+// 没有指定 --uidmap/--gidmap 时使用分配给它的第一段id
+const SubIDUser = "mydocker"
+
+var (
+	SubUIDFile = "/etc/subuid"
+	SubGIDFile = "/etc/subgid"
+)
+
+// NewIdentityMapping 按 --uidmap/--gidmap 生成user namespace的id映射，
+// 只指定了其中一个时uid和gid使用同样的映射，都没有指定时从subuid和subgid中分配
+func NewIdentityMapping(uidMaps, gidMaps []string) (*idtools.IdentityMapping, error) {
+	mapping := &idtools.IdentityMapping{}
+	for _, val := range uidMaps {
+		m, err := idtools.ParseIDMap(val)
+		if err != nil {
+			return nil, err
+		}
+		mapping.UIDMaps = append(mapping.UIDMaps, m)
+	}
+	for _, val := range gidMaps {
+		m, err := idtools.ParseIDMap(val)
+		if err != nil {
+			return nil, err
+		}
+		mapping.GIDMaps = append(mapping.GIDMaps, m)
+	}
+	switch {
+	case len(mapping.UIDMaps) == 0 && len(mapping.GIDMaps) == 0:
+		uidMap, err := idtools.SubIDRange(SubUIDFile, SubIDUser)
+		if err != nil {
+			return nil, err
+		}
+		gidMap, err := idtools.SubIDRange(SubGIDFile, SubIDUser)
+		if err != nil {
+			return nil, err
+		}
+		mapping.UIDMaps, mapping.GIDMaps = []idtools.IDMap{uidMap}, []idtools.IDMap{gidMap}
+	case len(mapping.GIDMaps) == 0:
+		mapping.GIDMaps = mapping.UIDMaps
+	case len(mapping.UIDMaps) == 0:
+		mapping.UIDMaps = mapping.GIDMaps
+	}
+	// init进程以容器内的root运行，root必须有映射
+	if _, _, err := mapping.RootPair(); err != nil {
+		return nil, fmt.Errorf("user namespace must map container root: %v", err)
+	}
+	return mapping, nil
+}
+
+// layerStoreName 是layer缓存中的目录名，不同的id映射使用各自按宿主机id解压的layer
+func layerStoreName(driver StorageDriver, idMapping *idtools.IdentityMapping) string {
+	if idMapping == nil {
+		return driver.Name()
+	}
+	return driver.Name() + "-" + idMapping.Key()
+}
+
+// chownToRoot 把目录的属主改成容器内的root，没有user namespace时不做任何事
+func chownToRoot(path string, idMapping *idtools.IdentityMapping) error {
+	if idMapping == nil {
+		return nil
+	}
+	uid, gid, err := idMapping.RootPair()
+	if err != nil {
+		return err
+	}
+	if err := os.Lchown(path, uid, gid); err != nil {
+		return fmt.Errorf("chown %s error %v", path, err)
+	}
+	return nil
+}
+
+// prepareRuntimeDirs 创建mydocker自己的目录并设置成0711。user namespace中的init进程在宿主机上是普通用户，
+// 需要能穿过这些目录进入自己的挂载点，只有x没有r，其他用户不能列出里面的容器。不修改宿主机上已有目录的权限
+func prepareRuntimeDirs(dirs ...string) error {
+	for _, dir := range dirs {
+		if err := os.MkdirAll(dir, 0711); err != nil {
+			return fmt.Errorf("mkdir %s error %v", dir, err)
+		}
+		// MkdirAll受umask影响，已经存在的目录也要改过来
+		if err := os.Chmod(dir, 0711); err != nil {
+			return fmt.Errorf("chmod %s error %v", dir, err)
+		}
+	}
+	return nil
+}
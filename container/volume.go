@@ -2,22 +2,48 @@ package container
 
 import (
 	log "github.com/Sirupsen/logrus"
+	"github.com/xianlubird/mydocker/archive"
+	"github.com/xianlubird/mydocker/idtools"
+	"github.com/xianlubird/mydocker/image"
 	"os"
-	"os/exec"
+	"path/filepath"
 	"strings"
 	"fmt"
+	"syscall"
 )
 
-//Create a AUFS filesystem as container root workspace
-func NewWorkSpace(volume, imageName, containerName string) {
-	CreateReadOnlyLayer(imageName)
-	CreateWriteLayer(containerName)
-	CreateMountPoint(containerName, imageName)
+//Create the container root workspace with the selected storage driver
+//idMapping不为nil时容器运行在user namespace中，镜像层和可写层都按映射后的宿主机id准备
+func NewWorkSpace(volume, imageName, containerName string, idMapping *idtools.IdentityMapping) {
+	driver := storageDriver
+	lowerDirs, err := CreateReadOnlyLayer(driver, imageName, idMapping)
+	if err != nil {
+		log.Errorf("Create read only layer of image %s error %v", imageName, err)
+		return
+	}
+	if err := driver.CreateLayer(containerName); err != nil {
+		log.Errorf("Create write layer of %s error %v", containerName, err)
+		return
+	}
+	if idMapping != nil {
+		if err := chownToRoot(layerDiffDir(containerName), idMapping); err != nil {
+			log.Errorf("Create write layer of %s error %v", containerName, err)
+			return
+		}
+	}
+	if err := prepareRuntimeDirs(RuntimeRoot, filepath.Dir(fmt.Sprintf(MntUrl, containerName))); err != nil {
+		log.Errorf("Prepare rootfs of %s error %v", containerName, err)
+		return
+	}
+	if err := driver.Mount(containerName, lowerDirs); err != nil {
+		log.Errorf("Mount rootfs of %s with %s driver error %v", containerName, driver.Name(), err)
+		return
+	}
 	if volume != "" {
 		volumeURLs := strings.Split(volume, ":")
 		length := len(volumeURLs)
 		if length == 2 && volumeURLs[0] != "" && volumeURLs[1] != "" {
-			MountVolume(volumeURLs, containerName)
+			MountVolume(volumeURLs, containerName, idMapping)
 			log.Infof("NewWorkSpace volume urls %q", volumeURLs)
 		} else {
 			log.Infof("Volume parameter input is not correct.")
@@ -25,76 +51,117 @@ func NewWorkSpace(volume, imageName, containerName string) {
 	}
 }
 
-//Decompression tar image
-func CreateReadOnlyLayer(imageName string) error {
-	unTarFolderUrl := RootUrl + "/" + imageName + "/"
-	imageUrl := RootUrl + "/" + imageName + ".tar"
+//准备镜像的只读层，返回从上到下排列的只读层目录
+//1. 已经导入过的镜像，按存储驱动把layer解压到layer缓存中
+//2. /root/<image>.tar 或 /root/<image>/ 是OCI image layout时，先导入再使用
+//3. /root/<image>.tar 是普通的rootfs tar包时，作为只有一层的镜像导入
+//4. 否则按以前的方式直接使用 /root/<image>/ 作为唯一的只读层，这样的容器不能commit，也不能使用user namespace
+func CreateReadOnlyLayer(driver StorageDriver, imageName string, idMapping *idtools.IdentityMapping) ([]string, error) {
+	img, err := image.Get(imageName)
+	if err != nil {
+		return nil, err
+	}
+	// 本地文件按不带tag的镜像名查找
+	fileName := imageName
+	if name, tag, err := image.ParseReference(imageName); err == nil && tag == image.DefaultTag {
+		fileName = name
+	}
+	if img == nil {
+		for _, layoutPath := range []string{RootUrl + "/" + fileName, RootUrl + "/" + fileName + ".tar"} {
+			if image.IsLayout(layoutPath) {
+				if img, err = image.Import(layoutPath, "", imageName); err != nil {
+					log.Errorf("Import image layout %s error %v", layoutPath, err)
+					return nil, err
+				}
+				break
+			}
+		}
+	}
+	imageUrl := RootUrl + "/" + fileName + ".tar"
+	if img == nil {
+		if exist, _ := PathExists(imageUrl); exist {
+			if img, err = image.ImportTar(imageUrl, imageName, image.CommitOptions{}); err != nil {
+				log.Errorf("Import image tar %s error %v", imageUrl, err)
+				return nil, err
+			}
+		}
+	}
+	if img != nil {
+		return imageLowerDirs(driver, img, idMapping)
+	}
+
+	unTarFolderUrl := RootUrl + "/" + fileName + "/"
 	exist, err := PathExists(unTarFolderUrl)
 	if err != nil {
 		log.Infof("Fail to judge whether dir %s exists. %v", unTarFolderUrl, err)
-		return err
+		return nil, err
 	}
 	if !exist {
-		if err := os.MkdirAll(unTarFolderUrl, 0622); err != nil {
-			log.Errorf("Mkdir %s error %v", unTarFolderUrl, err)
-			return err
-		}
-
-		if _, err := exec.Command("tar", "-xvf", imageUrl, "-C", unTarFolderUrl).CombinedOutput(); err != nil {
-			log.Errorf("Untar dir %s error %v", unTarFolderUrl, err)
-			return err
-		}
+		return nil, fmt.Errorf("image %s not found", imageName)
 	}
-	return nil
+	if idMapping != nil {
+		return nil, fmt.Errorf("image %s is not a layered image, user namespace is not supported", imageName)
+	}
+	return []string{unTarFolderUrl}, nil
 }
 
-func CreateWriteLayer(containerName string) {
-	writeURL := fmt.Sprintf(WriteLayerUrl, containerName)
-	if err := os.MkdirAll(writeURL, 0777); err != nil {
-		log.Infof("Mkdir write layer dir %s error. %v", writeURL, err)
+// 每个layer只解压一次，最上层在前
+func imageLowerDirs(driver StorageDriver, img *image.Image, idMapping *idtools.IdentityMapping) ([]string, error) {
+	opts := &archive.TarOptions{}
+	if idMapping != nil {
+		opts.UIDMaps, opts.GIDMaps = idMapping.UIDMaps, idMapping.GIDMaps
+	}
+	dirs := make([]string, 0, len(img.Layers))
+	for i := len(img.Layers) - 1; i >= 0; i-- {
+		layerPath := image.LayerPath(layerStoreName(driver, idMapping), img.Layers[i].Digest)
+		if err := image.ExtractLayer(img.Layers[i], layerPath, opts, driver.ConvertLayer); err != nil {
+			return nil, err
+		}
+		dirs = append(dirs, layerPath)
 	}
+	return dirs, nil
 }
 
-func MountVolume(volumeURLs []string, containerName string) error {
+//数据卷直接bind mount到容器的挂载点下，和存储驱动无关。
+//使用user namespace时，新建的宿主机目录交给容器内的root，已经存在的目录不修改属主
+func MountVolume(volumeURLs []string, containerName string, idMapping *idtools.IdentityMapping) error {
 	parentUrl := volumeURLs[0]
 	if err := os.Mkdir(parentUrl, 0777); err != nil {
 		log.Infof("Mkdir parent dir %s error. %v", parentUrl, err)
+	} else if err := chownToRoot(parentUrl, idMapping); err != nil {
+		log.Errorf("Mount volume failed. %v", err)
+		return err
 	}
 	containerUrl := volumeURLs[1]
 	mntURL := fmt.Sprintf(MntUrl, containerName)
 	containerVolumeURL := mntURL + "/" +  containerUrl
-	if err := os.Mkdir(containerVolumeURL, 0777); err != nil {
+	if err := os.MkdirAll(containerVolumeURL, 0777); err != nil {
 		log.Infof("Mkdir container dir %s error. %v", containerVolumeURL, err)
 	}
-	dirs := "dirs=" + parentUrl
-	_, err := exec.Command("mount", "-t", "aufs", "-o", dirs, "none", containerVolumeURL).CombinedOutput()
-	if err != nil {
+	if err := syscall.Mount(parentUrl, containerVolumeURL, "bind", syscall.MS_BIND|syscall.MS_REC, ""); err != nil {
 		log.Errorf("Mount volume failed. %v", err)
 		return err
 	}
 	return nil
 }
 
-func CreateMountPoint(containerName , imageName string) error {
-	mntUrl := fmt.Sprintf(MntUrl, containerName)
-	if err := os.MkdirAll(mntUrl, 0777); err != nil {
-		log.Errorf("Mkdir mountpoint dir %s error. %v", mntUrl, err)
-		return err
-	}
-	tmpWriteLayer := fmt.Sprintf(WriteLayerUrl, containerName)
-	tmpImageLocation := RootUrl + "/" + imageName
-	mntURL := fmt.Sprintf(MntUrl, containerName)
-	dirs := "dirs=" + tmpWriteLayer + ":" + tmpImageLocation
-	_, err := exec.Command("mount", "-t", "aufs", "-o", dirs, "none", mntURL).CombinedOutput()
+//Delete the container workspace while container exit
+func DeleteWorkSpace(volume, containerName, driverName string) {
+	driver, err := GetStorageDriver(driverName)
 	if err != nil {
-		log.Errorf("Run command for creating mount point failed %v", err)
-		return err
+		log.Errorf("Delete workspace of %s error %v", containerName, err)
+		return
 	}
-	return nil
+	UnmountWorkSpace(volume, containerName, driverName)
+	driver.Remove(containerName)
 }
 
-//Delete the AUFS filesystem while container exit
-func DeleteWorkSpace(volume, containerName string) {
+//只卸载数据卷和rootfs，保留可写层，start时由NewWorkSpace重新挂载
+func UnmountWorkSpace(volume, containerName, driverName string) error {
+	driver, err := GetStorageDriver(driverName)
+	if err != nil {
+		return err
+	}
 	if volume != "" {
 		volumeURLs := strings.Split(volume, ":")
 		length := len(volumeURLs)
@@ -102,19 +169,8 @@ func DeleteWorkSpace(volume, containerName string) {
 			DeleteVolume(volumeURLs, containerName)
 		}
 	}
-	DeleteMountPoint(containerName)
-	DeleteWriteLayer(containerName)
-}
-
-func DeleteMountPoint(containerName string) error {
-	mntURL := fmt.Sprintf(MntUrl, containerName)
-	_, err := exec.Command("umount", mntURL).CombinedOutput()
-	if err != nil {
-		log.Errorf("Unmount %s error %v", mntURL, err)
-		return err
-	}
-	if err := os.RemoveAll(mntURL); err != nil {
-		log.Errorf("Remove mountpoint dir %s error %v", mntURL, err)
+	if err := driver.Unmount(containerName); err != nil {
+		log.Errorf("Unmount rootfs of %s error %v", containerName, err)
 		return err
 	}
 	return nil
@@ -123,20 +179,13 @@ func DeleteMountPoint(containerName string) error {
 func DeleteVolume(volumeURLs []string, containerName string) error {
 	mntURL := fmt.Sprintf(MntUrl, containerName)
 	containerUrl := mntURL + "/" +  volumeURLs[1]
-	if _, err := exec.Command("umount", containerUrl).CombinedOutput(); err != nil {
+	if err := syscall.Unmount(containerUrl, syscall.MNT_DETACH); err != nil {
 		log.Errorf("Umount volume %s failed. %v", containerUrl, err)
 		return err
 	}
 	return nil
 }
 
-func DeleteWriteLayer(containerName string) {
-	writeURL := fmt.Sprintf(WriteLayerUrl, containerName)
-	if err := os.RemoveAll(writeURL); err != nil {
-		log.Infof("Remove writeLayer dir %s error %v", writeURL, err)
-	}
-}
-
 func PathExists(path string) (bool, error) {
 	_, err := os.Stat(path)
 	if err == nil {
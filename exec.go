@@ -3,24 +3,42 @@ package main
 import (
 	"fmt"
 	log "github.com/Sirupsen/logrus"
+	"github.com/xianlubird/mydocker/cgroups"
 	"github.com/xianlubird/mydocker/container"
+	"github.com/xianlubird/mydocker/seccomp"
+	"github.com/xianlubird/mydocker/term"
 	"io/ioutil"
 	"encoding/json"
+	"strconv"
 	"strings"
 	"os/exec"
 	"os"
+	"syscall"
 	_ "github.com/xianlubird/mydocker/nsenter"
 )
 
 const ENV_EXEC_PID = "mydocker_pid"
 const ENV_EXEC_CMD = "mydocker_cmd"
+const ENV_EXEC_USER = "mydocker_user"
+const ENV_EXEC_CAPS = "mydocker_caps"
+const ENV_EXEC_SECCOMP = "mydocker_seccomp"
+const ENV_EXEC_SYNC = "mydocker_sync"
 
-func ExecContainer(containerName string, comArray []string) {
-	pid, err := GetContainerPidByName(containerName)
+func ExecContainer(containerName string, comArray []string, user string, tty bool) {
+	containerInfo, err := inspectContainer(containerName)
 	if err != nil {
-		log.Errorf("Exec container getContainerPidByName %s error %v", containerName, err)
+		log.Errorf("Exec container getContainerInfoByName %s error %v", containerName, err)
 		return
 	}
+	if containerInfo.Status == container.PAUSED {
+		log.Errorf("Container %s is paused, unpause it first", containerName)
+		return
+	}
+	if containerInfo.Status != container.RUNNING {
+		log.Errorf("Container %s is %s, only running container can exec", containerName, containerInfo.Status)
+		return
+	}
+	pid := containerInfo.Pid
 
 	cmdStr := strings.Join(comArray, " ")
 	log.Infof("container pid %s", pid)
@@ -30,14 +48,80 @@ func ExecContainer(containerName string, comArray []string) {
 	cmd.Stdin = os.Stdin
 	cmd.Stdout = os.Stdout
 	cmd.Stderr = os.Stderr
+	var pty *term.Pty
+	if tty {
+		if pty, err = term.OpenPty(); err != nil {
+			log.Errorf("Exec container %s open pty error %v", containerName, err)
+			return
+		}
+		cmd.Stdin = pty.Slave
+		cmd.Stdout = pty.Slave
+		cmd.Stderr = pty.Slave
+		//nsenter之前就在新的session中设置好控制终端，加入容器的namespace之后仍然有效
+		cmd.SysProcAttr = &syscall.SysProcAttr{Setsid: true, Setctty: true, Ctty: 0}
+	}
 
-	os.Setenv(ENV_EXEC_PID, pid)
-	os.Setenv(ENV_EXEC_CMD, cmdStr)
-	containerEnvs := getEnvsByPid(pid)
-	cmd.Env = append(os.Environ(), containerEnvs...)
+	//容器的环境变量来自镜像或者bundle，不能让它带上nsenter的控制变量，
+	//否则镜像中的mydocker_pid就能让nsenter加入宿主机进程的namespace。
+	//glibc的getenv返回第一个匹配的变量，控制变量只能出现一次，放在最后
+	cmd.Env = append(filterControlEnvs(os.Environ()), filterControlEnvs(getEnvsByPid(pid))...)
+	cmd.Env = append(cmd.Env, ENV_EXEC_PID+"="+pid, ENV_EXEC_CMD+"="+cmdStr)
+	//和容器的init进程保留同样的capability，不能通过exec拿到更多的权限
+	if containerInfo.Capabilities != nil {
+		mask, err := container.CapabilityMask(containerInfo.Capabilities)
+		if err != nil {
+			log.Errorf("Exec container %s error %v", containerName, err)
+			return
+		}
+		cmd.Env = append(cmd.Env, fmt.Sprintf("%s=%x", ENV_EXEC_CAPS, mask))
+	}
+	if containerInfo.Seccomp != nil {
+		filter, err := seccomp.Compile(containerInfo.Seccomp)
+		if err != nil {
+			log.Errorf("Exec container %s compile seccomp profile error %v", containerName, err)
+			return
+		}
+		cmd.Env = append(cmd.Env, ENV_EXEC_SECCOMP+"="+seccomp.Encode(filter))
+	}
+	if user != "" {
+		//按容器自己的passwd和group解析，nsenter切换namespace之后再切换用户
+		execUser, err := container.LookupUser(user, fmt.Sprintf("/proc/%s/root", pid))
+		if err != nil {
+			log.Errorf("Exec container %s lookup user %s error %v", containerName, user, err)
+			return
+		}
+		cmd.Env = append(cmd.Env, ENV_EXEC_USER+"="+formatExecUser(execUser), "HOME="+execUser.Home)
+	}
 
-	if err := cmd.Run(); err != nil {
+	//nsenter等CLI把它加入容器的cgroup之后再运行命令，stop时可以通过cgroup杀掉exec进去的进程
+	syncRead, syncWrite, err := os.Pipe()
+	if err != nil {
 		log.Errorf("Exec container %s error %v", containerName, err)
+		return
+	}
+	cmd.ExtraFiles = []*os.File{syncRead}
+	cmd.Env = append(cmd.Env, ENV_EXEC_SYNC+"=3")
+
+	if err := cmd.Start(); err != nil {
+		syncRead.Close()
+		syncWrite.Close()
+		log.Errorf("Exec container %s error %v", containerName, err)
+		return
+	}
+	syncRead.Close()
+	if err := cgroups.NewCgroupManager(containerInfo.Id, containerInfo.CgroupDriver).Apply(cmd.Process.Pid); err != nil {
+		log.Warnf("Exec container %s join cgroup error %v", containerName, err)
+	}
+	syncWrite.Close()
+	var detachTty func()
+	if pty != nil {
+		detachTty = pty.Attach()
+	}
+	if err := cmd.Wait(); err != nil {
+		log.Errorf("Exec container %s error %v", containerName, err)
+	}
+	if detachTty != nil {
+		detachTty()
 	}
 }
 
@@ -66,3 +150,24 @@ func getEnvsByPid(pid string) []string {
 	envs := strings.Split(string(contentBytes), "\u0000")
 	return envs
 }
+
+// filterControlEnvs 去掉mydocker_开头的变量和空字符串，这些变量只能由exec自己设置
+func filterControlEnvs(envs []string) []string {
+	var filtered []string
+	for _, env := range envs {
+		if env == "" || strings.HasPrefix(env, "mydocker_") {
+			continue
+		}
+		filtered = append(filtered, env)
+	}
+	return filtered
+}
+
+//传给nsenter的格式为 uid:gid:附加组1,附加组2
+func formatExecUser(user *container.ExecUser) string {
+	sgids := make([]string, 0, len(user.Sgids))
+	for _, gid := range user.Sgids {
+		sgids = append(sgids, strconv.Itoa(gid))
+	}
+	return fmt.Sprintf("%d:%d:%s", user.Uid, user.Gid, strings.Join(sgids, ","))
+}
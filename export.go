@@ -0,0 +1,48 @@
+package main
+
+import (
+	"fmt"
+	log "github.com/Sirupsen/logrus"
+	"github.com/xianlubird/mydocker/archive"
+	"github.com/xianlubird/mydocker/container"
+	"github.com/xianlubird/mydocker/image"
+	"io"
+)
+
+//把容器的整个rootfs导出成tar包，压缩格式按文件后缀选择
+func exportContainer(containerName, output string) error {
+	containerInfo, err := getContainerInfoByName(containerName)
+	if err != nil {
+		return err
+	}
+	if containerInfo.Bundle != "" {
+		return fmt.Errorf("container %s is created from bundle %s, export the bundle rootfs directly", containerName, containerInfo.Bundle)
+	}
+	rootfs, err := container.ExportRootfs(containerName, containerInfo.Volume)
+	if err != nil {
+		log.Errorf("Export rootfs of %s error %v", containerName, err)
+		return err
+	}
+	defer rootfs.Close()
+	var src io.Reader = rootfs
+	if m := containerInfo.IDMapping; m != nil {
+		remapped := archive.ToContainerOwnership(rootfs, m.UIDMaps, m.GIDMaps)
+		defer remapped.Close()
+		src = remapped
+	}
+
+	w, err := image.CreateArchive(output)
+	if err != nil {
+		log.Errorf("Create %s error %v", output, err)
+		return err
+	}
+	_, err = io.Copy(w, src)
+	if closeErr := w.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		log.Errorf("Write %s error %v", output, err)
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,141 @@
+package idtools
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// IDMap 把容器内从ContainerID开始的Size个id映射到宿主机上从HostID开始的id，
+// 和 /proc/<pid>/uid_map 中的一行对应
+type IDMap struct {
+	ContainerID int `json:"containerId"`
+	HostID      int `json:"hostId"`
+	Size        int `json:"size"`
+}
+
+// ParseIDMap 解析 --uidmap/--gidmap 参数，格式为 containerID:hostID:size
+func ParseIDMap(val string) (IDMap, error) {
+	parts := strings.Split(val, ":")
+	if len(parts) != 3 {
+		return IDMap{}, fmt.Errorf("invalid id map %s, expect containerID:hostID:size", val)
+	}
+	var ids [3]int
+	for i, part := range parts {
+		id, err := strconv.Atoi(part)
+		if err != nil || id < 0 {
+			return IDMap{}, fmt.Errorf("invalid id map %s, expect containerID:hostID:size", val)
+		}
+		ids[i] = id
+	}
+	if ids[2] == 0 {
+		return IDMap{}, fmt.Errorf("invalid id map %s, size must be positive", val)
+	}
+	return IDMap{ContainerID: ids[0], HostID: ids[1], Size: ids[2]}, nil
+}
+
+func (m IDMap) String() string {
+	return fmt.Sprintf("%d:%d:%d", m.ContainerID, m.HostID, m.Size)
+}
+
+// ToHost 把容器内的id转换成宿主机上的id，maps为空时不转换
+func ToHost(id int, maps []IDMap) (int, error) {
+	if len(maps) == 0 {
+		return id, nil
+	}
+	for _, m := range maps {
+		if id >= m.ContainerID && id < m.ContainerID+m.Size {
+			return m.HostID + id - m.ContainerID, nil
+		}
+	}
+	return -1, fmt.Errorf("container id %d is not mapped", id)
+}
+
+// ToContainer 把宿主机上的id转换成容器内的id，maps为空时不转换
+func ToContainer(id int, maps []IDMap) (int, error) {
+	if len(maps) == 0 {
+		return id, nil
+	}
+	for _, m := range maps {
+		if id >= m.HostID && id < m.HostID+m.Size {
+			return m.ContainerID + id - m.HostID, nil
+		}
+	}
+	return -1, fmt.Errorf("host id %d is not mapped", id)
+}
+
+// SubIDRange 从 /etc/subuid 或 /etc/subgid 中找到分配给user的第一段从属id，
+// 返回把容器内从0开始的id映射到这一段的IDMap
+func SubIDRange(path, user string) (IDMap, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return IDMap{}, err
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		parts := strings.Split(line, ":")
+		if len(parts) != 3 || parts[0] != user {
+			continue
+		}
+		start, err1 := strconv.Atoi(parts[1])
+		size, err2 := strconv.Atoi(parts[2])
+		if err1 != nil || err2 != nil || start < 0 || size <= 0 {
+			return IDMap{}, fmt.Errorf("invalid entry %q in %s", line, path)
+		}
+		return IDMap{ContainerID: 0, HostID: start, Size: size}, nil
+	}
+	if err := scanner.Err(); err != nil {
+		return IDMap{}, err
+	}
+	return IDMap{}, fmt.Errorf("no subordinate id range for %s in %s", user, path)
+}
+
+// IdentityMapping 是容器user namespace的uid和gid映射
+type IdentityMapping struct {
+	UIDMaps []IDMap `json:"uidMappings,omitempty"`
+	GIDMaps []IDMap `json:"gidMappings,omitempty"`
+}
+
+// RootPair 返回容器内root在宿主机上对应的uid和gid
+func (m *IdentityMapping) RootPair() (int, int, error) {
+	uid, err := ToHost(0, m.UIDMaps)
+	if err != nil {
+		return -1, -1, err
+	}
+	gid, err := ToHost(0, m.GIDMaps)
+	if err != nil {
+		return -1, -1, err
+	}
+	return uid, gid, nil
+}
+
+// Key 用来区分不同映射下按宿主机id解压好的layer，包含所有映射段，比如 0-100000-65536.0-100000-65536。
+// 只用root对应的id区分的话，root相同但其他段不同的映射会用到按别的映射解压的layer
+func (m *IdentityMapping) Key() string {
+	return mapsKey(m.UIDMaps) + "." + mapsKey(m.GIDMaps)
+}
+
+func mapsKey(maps []IDMap) string {
+	parts := make([]string, 0, len(maps))
+	for _, m := range maps {
+		parts = append(parts, fmt.Sprintf("%d-%d-%d", m.ContainerID, m.HostID, m.Size))
+	}
+	return strings.Join(parts, "_")
+}
+
+// SysProcIDMaps 转换成 SysProcAttr 中使用的格式
+func SysProcIDMaps(maps []IDMap) []syscall.SysProcIDMap {
+	sysMaps := make([]syscall.SysProcIDMap, 0, len(maps))
+	for _, m := range maps {
+		sysMaps = append(sysMaps, syscall.SysProcIDMap{ContainerID: m.ContainerID, HostID: m.HostID, Size: m.Size})
+	}
+	return sysMaps
+}
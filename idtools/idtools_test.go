@@ -0,0 +1,67 @@
+package idtools
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+func TestParseIDMap(t *testing.T) {
+	m, err := ParseIDMap("0:100000:65536")
+	if err != nil || m != (IDMap{ContainerID: 0, HostID: 100000, Size: 65536}) {
+		t.Fatalf("got %v %v", m, err)
+	}
+	for _, val := range []string{"", "0:100000", "0:-1:10", "a:1:1", "0:1:0"} {
+		if _, err := ParseIDMap(val); err == nil {
+			t.Errorf("%q parsed without error", val)
+		}
+	}
+}
+
+func TestMapping(t *testing.T) {
+	maps := []IDMap{{0, 100000, 1000}, {1000, 5000, 10}}
+	cases := []struct{ container, host int }{{0, 100000}, {999, 100999}, {1000, 5000}, {1009, 5009}}
+	for _, c := range cases {
+		if got, err := ToHost(c.container, maps); err != nil || got != c.host {
+			t.Errorf("ToHost(%d) = %d %v", c.container, got, err)
+		}
+		if got, err := ToContainer(c.host, maps); err != nil || got != c.container {
+			t.Errorf("ToContainer(%d) = %d %v", c.host, got, err)
+		}
+	}
+	if _, err := ToHost(1010, maps); err == nil {
+		t.Error("unmapped container id")
+	}
+	if _, err := ToContainer(0, maps); err == nil {
+		t.Error("unmapped host id")
+	}
+	if got, _ := ToHost(42, nil); got != 42 {
+		t.Errorf("empty mapping changed id to %d", got)
+	}
+}
+
+func TestMappingKey(t *testing.T) {
+	m := &IdentityMapping{UIDMaps: []IDMap{{0, 100000, 1000}, {1000, 5000, 10}}, GIDMaps: []IDMap{{0, 100000, 65536}}}
+	if key := m.Key(); key != "0-100000-1000_1000-5000-10.0-100000-65536" {
+		t.Errorf("unexpected key %s", key)
+	}
+	// root的映射相同，其他段不同的映射不能共用layer
+	other := &IdentityMapping{UIDMaps: []IDMap{{0, 100000, 1000}}, GIDMaps: m.GIDMaps}
+	if other.Key() == m.Key() {
+		t.Errorf("different mappings have the same key %s", m.Key())
+	}
+}
+
+func TestSubIDRange(t *testing.T) {
+	f, _ := ioutil.TempFile("", "subuid")
+	defer os.Remove(f.Name())
+	f.WriteString("# comment\nalice:100000:65536\nmydocker:165536:65536\nmydocker:300000:10\n")
+	f.Close()
+	m, err := SubIDRange(f.Name(), "mydocker")
+	if err != nil || m != (IDMap{ContainerID: 0, HostID: 165536, Size: 65536}) {
+		t.Fatalf("got %v %v", m, err)
+	}
+	if _, err := SubIDRange(f.Name(), "bob"); err == nil {
+		t.Error("found range for missing user")
+	}
+}
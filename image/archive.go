@@ -0,0 +1,192 @@
+package image
+
+import (
+	"archive/tar"
+	"encoding/json"
+	"fmt"
+	log "github.com/Sirupsen/logrus"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// save 时把镜像记录中OCI config放不下的元数据写到index的annotation里，load 时再恢复
+const (
+	AnnotationCreated = "org.opencontainers.image.created"
+	AnnotationAuthors = "org.opencontainers.image.authors"
+	annotationParent  = "io.mydocker.image.parent"
+	annotationComment = "io.mydocker.image.comment"
+	annotationChanges = "io.mydocker.image.changes"
+)
+
+// SaveArchive 把镜像连同所有layer保存成OCI image layout格式的tar包，
+// 压缩格式按path的后缀选择，见 ArchiveFormat
+func SaveArchive(refs []string, path string) error {
+	index := &Index{SchemaVersion: 2, MediaType: MediaTypeImageIndex}
+	var blobs []string
+	saved := map[string]bool{}
+	addBlob := func(digest string) {
+		if digest != "" && !saved[digest] {
+			saved[digest] = true
+			blobs = append(blobs, digest)
+		}
+	}
+	for _, ref := range refs {
+		img, err := Get(ref)
+		if err != nil {
+			return err
+		}
+		if img == nil {
+			return fmt.Errorf("no such image %s", ref)
+		}
+		desc, err := img.manifestDescriptor()
+		if err != nil {
+			return err
+		}
+		desc.Annotations = img.annotations()
+		index.Manifests = append(index.Manifests, desc)
+		addBlob(img.Digest)
+		addBlob(img.Config)
+		for _, layer := range img.Layers {
+			addBlob(layer.Digest)
+		}
+	}
+
+	w, err := CreateArchive(path)
+	if err != nil {
+		return err
+	}
+	tw := tar.NewWriter(w)
+	err = writeLayoutTar(tw, index, blobs)
+	if err == nil {
+		err = tw.Close()
+	}
+	if closeErr := w.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(path)
+		return fmt.Errorf("save images to %s error %v", path, err)
+	}
+	return nil
+}
+
+// manifestDescriptor 从保存的manifest得到它的media type和大小，docker格式的manifest原样保留
+func (img *Image) manifestDescriptor() (Descriptor, error) {
+	content, err := ioutil.ReadFile(BlobPath(img.Digest))
+	if err != nil {
+		return Descriptor{}, fmt.Errorf("manifest of image %s not found: %v", img.Reference(), err)
+	}
+	manifest := &Manifest{}
+	if err := json.Unmarshal(content, manifest); err != nil {
+		return Descriptor{}, err
+	}
+	mediaType := manifest.MediaType
+	if mediaType == "" {
+		mediaType = MediaTypeImageManifest
+	}
+	return Descriptor{MediaType: mediaType, Digest: img.Digest, Size: int64(len(content))}, nil
+}
+
+func (img *Image) annotations() map[string]string {
+	annotations := map[string]string{AnnotationRefName: img.Reference()}
+	set := func(key, value string) {
+		if value != "" {
+			annotations[key] = value
+		}
+	}
+	set(AnnotationCreated, img.Created)
+	set(AnnotationAuthors, img.Author)
+	set(annotationParent, img.Parent)
+	set(annotationComment, img.Comment)
+	if len(img.Changes) > 0 {
+		changes, _ := json.Marshal(img.Changes)
+		annotations[annotationChanges] = string(changes)
+	}
+	return annotations
+}
+
+func writeLayoutTar(tw *tar.Writer, index *Index, blobs []string) error {
+	writeFile := func(name string, content []byte) error {
+		if err := tw.WriteHeader(&tar.Header{Name: name, Mode: 0644, Size: int64(len(content)), Typeflag: tar.TypeReg}); err != nil {
+			return err
+		}
+		_, err := tw.Write(content)
+		return err
+	}
+	if err := writeFile(layoutFile, []byte(`{"imageLayoutVersion":"1.0.0"}`)); err != nil {
+		return err
+	}
+	content, err := json.Marshal(index)
+	if err != nil {
+		return err
+	}
+	if err := writeFile(indexFile, content); err != nil {
+		return err
+	}
+	for _, digest := range blobs {
+		if err := writeBlobFile(tw, digest); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func writeBlobFile(tw *tar.Writer, digest string) error {
+	f, err := os.Open(BlobPath(digest))
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	name := filepath.Join(blobsDir, strings.Replace(digest, ":", "/", 1))
+	if err := tw.WriteHeader(&tar.Header{Name: name, Mode: 0644, Size: fi.Size(), Typeflag: tar.TypeReg}); err != nil {
+		return err
+	}
+	_, err = io.Copy(tw, f)
+	return err
+}
+
+// LoadArchive 导入 SaveArchive 保存的tar包中所有带引用名的镜像，并恢复镜像的元数据
+func LoadArchive(path string) ([]*Image, error) {
+	layout, err := OpenLayout(path)
+	if err != nil {
+		return nil, err
+	}
+	defer layout.Close()
+	index, err := layout.Index()
+	if err != nil {
+		return nil, err
+	}
+	var images []*Image
+	for _, desc := range index.Manifests {
+		ref := desc.Annotations[AnnotationRefName]
+		if ref == "" {
+			log.Warnf("Skip manifest %s without %s annotation", desc.Digest, AnnotationRefName)
+			continue
+		}
+		img, err := importManifest(layout, ref, ref)
+		if err != nil {
+			return images, fmt.Errorf("load image %s error %v", ref, err)
+		}
+		if created := desc.Annotations[AnnotationCreated]; created != "" {
+			img.Created = created
+		}
+		img.Author = desc.Annotations[AnnotationAuthors]
+		img.Parent = desc.Annotations[annotationParent]
+		img.Comment = desc.Annotations[annotationComment]
+		if changes := desc.Annotations[annotationChanges]; changes != "" {
+			json.Unmarshal([]byte(changes), &img.Changes)
+		}
+		if err := img.Save(); err != nil {
+			return images, err
+		}
+		images = append(images, img)
+	}
+	return images, nil
+}
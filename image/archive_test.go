@@ -0,0 +1,96 @@
+package image
+
+import (
+	"archive/tar"
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestSaveLoadArchive(t *testing.T) {
+	for _, name := range []string{"images.tar", "images.tar.gz", "images.tar.bz2", "images.tar.xz"} {
+		t.Run(name, func(t *testing.T) {
+			defer setTestRoot(t)()
+			layout := newTestLayout(t, "v1", map[string]string{"etc/os-release": "base"})
+			defer os.RemoveAll(layout)
+			parent, err := Import(layout, "", "base:v1")
+			if err != nil {
+				t.Fatal(err)
+			}
+			var diff bytes.Buffer
+			tw := tar.NewWriter(&diff)
+			tw.WriteHeader(&tar.Header{Name: "app", Mode: 0644, Size: 1, Typeflag: tar.TypeReg})
+			tw.Write([]byte("1"))
+			tw.Close()
+			child, err := Commit("app:v2", parent, &diff, CommitOptions{Author: "me", Comment: "app", Changes: []string{"ENV A=1"}})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			dir, _ := ioutil.TempDir("", "save")
+			defer os.RemoveAll(dir)
+			archive := filepath.Join(dir, name)
+			if err := SaveArchive([]string{"base:v1", "app:v2"}, archive); err != nil {
+				t.Fatalf("save %v", err)
+			}
+			if got := ArchiveFormat(archive); got != map[string]string{
+				"images.tar": "Tar", "images.tar.gz": "TarGz", "images.tar.bz2": "TarBz2", "images.tar.xz": "TarXZ"}[name] {
+				t.Fatalf("wrong format %s", got)
+			}
+
+			// 清空镜像仓库，模拟在另一台机器上load
+			os.RemoveAll(BlobRoot)
+			os.Remove(CatalogPath)
+			images, err := LoadArchive(archive)
+			if err != nil || len(images) != 2 {
+				t.Fatalf("load got %v %v", images, err)
+			}
+			loaded, err := Get("app:v2")
+			if err != nil || loaded == nil {
+				t.Fatalf("get loaded image %v %v", loaded, err)
+			}
+			if !reflect.DeepEqual(loaded, child) {
+				t.Fatalf("loaded image %+v differs from saved %+v", loaded, child)
+			}
+			if err := ExtractLayer(loaded.Layers[1], LayerPath("test", loaded.Layers[1].Digest), nil, nil); err != nil {
+				t.Fatalf("extract loaded layer %v", err)
+			}
+		})
+	}
+}
+
+func TestImportTarCompressed(t *testing.T) {
+	defer setTestRoot(t)()
+	dir, _ := ioutil.TempDir("", "import")
+	defer os.RemoveAll(dir)
+	archive := filepath.Join(dir, "rootfs.tar.xz")
+	w, err := CreateArchive(archive)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tw := tar.NewWriter(w)
+	tw.WriteHeader(&tar.Header{Name: "bin/", Mode: 0755, Typeflag: tar.TypeDir})
+	tw.WriteHeader(&tar.Header{Name: "bin/sh", Mode: 0755, Size: 2, Typeflag: tar.TypeReg})
+	tw.Write([]byte("sh"))
+	tw.Close()
+	w.Close()
+
+	img, err := ImportTar(archive, "rootfs", CommitOptions{Comment: "imported", Changes: []string{"CMD sh"}})
+	if err != nil {
+		t.Fatalf("import %v", err)
+	}
+	dest := LayerPath("test", img.Layers[0].Digest)
+	if err := ExtractLayer(img.Layers[0], dest, nil, nil); err != nil {
+		t.Fatal(err)
+	}
+	if content, err := ioutil.ReadFile(filepath.Join(dest, "bin/sh")); err != nil || string(content) != "sh" {
+		t.Fatalf("imported rootfs got %q %v", content, err)
+	}
+	config, _ := img.LoadConfig()
+	if config == nil || !reflect.DeepEqual(config.Config.Cmd, []string{"/bin/sh", "-c", "sh"}) {
+		t.Fatalf("config not applied %+v", config)
+	}
+}
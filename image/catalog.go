@@ -0,0 +1,240 @@
+package image
+
+import (
+	"encoding/json"
+	"fmt"
+	log "github.com/Sirupsen/logrus"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"syscall"
+)
+
+// CatalogPath 记录所有镜像，key是 name:tag，和网络的配置一样放在mydocker的状态目录下
+var CatalogPath = "/var/run/mydocker/image/repositories.json"
+
+const DefaultTag = "latest"
+
+// ParseReference 把 name[:tag] 拆成name和tag，tag缺省为latest。
+// name中可以带registry的端口，比如 localhost:5000/busybox
+func ParseReference(ref string) (string, string, error) {
+	name, tag := ref, DefaultTag
+	if i := strings.LastIndex(ref, ":"); i > strings.LastIndex(ref, "/") {
+		name, tag = ref[:i], ref[i+1:]
+	}
+	if name == "" || tag == "" || strings.ContainsAny(ref, " \t@") {
+		return "", "", fmt.Errorf("invalid image reference %q", ref)
+	}
+	return name, tag, nil
+}
+
+// NormalizeReference 返回 name:tag 形式的引用，不合法的引用原样返回
+func NormalizeReference(ref string) string {
+	name, tag, err := ParseReference(ref)
+	if err != nil {
+		return ref
+	}
+	return name + ":" + tag
+}
+
+func newImage(ref string) (*Image, error) {
+	name, tag, err := ParseReference(ref)
+	if err != nil {
+		return nil, err
+	}
+	return &Image{Name: name, Tag: tag}, nil
+}
+
+func (img *Image) Reference() string {
+	return img.Name + ":" + img.Tag
+}
+
+// ID 是去掉算法前缀的manifest digest的前12位
+func (img *Image) ID() string {
+	return shortID(img.Digest)
+}
+
+func shortID(digest string) string {
+	id := strings.TrimPrefix(digest, "sha256:")
+	if len(id) > 12 {
+		id = id[:12]
+	}
+	return id
+}
+
+type catalog map[string]*Image
+
+func loadCatalog() (catalog, error) {
+	c := catalog{}
+	content, err := ioutil.ReadFile(CatalogPath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return c, nil
+		}
+		return nil, err
+	}
+	if err := json.Unmarshal(content, &c); err != nil {
+		return nil, fmt.Errorf("parse image catalog %s error %v", CatalogPath, err)
+	}
+	return c, nil
+}
+
+func (c catalog) dump() error {
+	content, err := json.Marshal(c)
+	if err != nil {
+		return err
+	}
+	tmpPath := CatalogPath + ".tmp"
+	if err := ioutil.WriteFile(tmpPath, content, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmpPath, CatalogPath)
+}
+
+// updateCatalog 在文件锁的保护下读出catalog，修改后写回
+func updateCatalog(update func(c catalog) error) error {
+	if err := os.MkdirAll(filepath.Dir(CatalogPath), 0755); err != nil {
+		return err
+	}
+	lock, err := os.OpenFile(CatalogPath+".lock", os.O_CREATE|os.O_RDWR, 0644)
+	if err != nil {
+		return err
+	}
+	defer lock.Close()
+	if err := syscall.Flock(int(lock.Fd()), syscall.LOCK_EX); err != nil {
+		return err
+	}
+	defer syscall.Flock(int(lock.Fd()), syscall.LOCK_UN)
+
+	c, err := loadCatalog()
+	if err != nil {
+		return err
+	}
+	if err := update(c); err != nil {
+		return err
+	}
+	return c.dump()
+}
+
+// Get 按 name[:tag]、镜像digest或者digest的前缀查找镜像，镜像不存在时返回nil
+func Get(ref string) (*Image, error) {
+	c, err := loadCatalog()
+	if err != nil {
+		return nil, err
+	}
+	return c.lookup(ref)
+}
+
+func (c catalog) lookup(ref string) (*Image, error) {
+	if img, ok := c[NormalizeReference(ref)]; ok {
+		return img, nil
+	}
+	id := strings.TrimPrefix(ref, "sha256:")
+	if len(id) < 4 || strings.Trim(id, "0123456789abcdef") != "" {
+		return nil, nil
+	}
+	var found *Image
+	for _, img := range c {
+		if !strings.HasPrefix(strings.TrimPrefix(img.Digest, "sha256:"), id) {
+			continue
+		}
+		if found != nil && found.Digest != img.Digest {
+			return nil, fmt.Errorf("image id %s is ambiguous", ref)
+		}
+		found = img
+	}
+	return found, nil
+}
+
+// List 返回所有镜像，按引用排序
+func List() ([]*Image, error) {
+	c, err := loadCatalog()
+	if err != nil {
+		return nil, err
+	}
+	images := make([]*Image, 0, len(c))
+	for _, img := range c {
+		images = append(images, img)
+	}
+	sort.Slice(images, func(i, j int) bool {
+		return images[i].Reference() < images[j].Reference()
+	})
+	return images, nil
+}
+
+// References 返回指向同一个digest的所有引用
+func References(digest string) ([]string, error) {
+	images, err := List()
+	if err != nil {
+		return nil, err
+	}
+	var refs []string
+	for _, img := range images {
+		if img.Digest == digest {
+			refs = append(refs, img.Reference())
+		}
+	}
+	return refs, nil
+}
+
+// Save 把镜像记录到catalog中，同名的镜像被替换
+func (img *Image) Save() error {
+	img.Size = 0
+	for _, layer := range img.Layers {
+		img.Size += layer.Size
+	}
+	return updateCatalog(func(c catalog) error {
+		c[img.Reference()] = img
+		return nil
+	})
+}
+
+// Remove 从catalog中删除镜像引用，并清理不再被任何镜像使用的blob和解压好的layer
+func Remove(ref string) (*Image, error) {
+	var removed *Image
+	err := updateCatalog(func(c catalog) error {
+		img, err := c.lookup(ref)
+		if err != nil {
+			return err
+		}
+		if img == nil {
+			return fmt.Errorf("no such image %s", ref)
+		}
+		delete(c, img.Reference())
+		removed = img
+
+		used := map[string]bool{}
+		for _, other := range c {
+			used[other.Digest], used[other.Config] = true, true
+			for _, layer := range other.Layers {
+				used[layer.Digest] = true
+			}
+		}
+		unused := []string{img.Digest, img.Config}
+		for _, layer := range img.Layers {
+			unused = append(unused, layer.Digest)
+		}
+		for _, digest := range unused {
+			if digest == "" || used[digest] {
+				continue
+			}
+			removeBlob(digest)
+		}
+		return nil
+	})
+	return removed, err
+}
+
+func removeBlob(digest string) {
+	if err := os.Remove(BlobPath(digest)); err != nil && !os.IsNotExist(err) {
+		log.Warnf("Remove blob %s error %v", digest, err)
+	}
+	layerDirs, _ := filepath.Glob(LayerPath("*", digest))
+	for _, dir := range layerDirs {
+		if err := os.RemoveAll(dir); err != nil {
+			log.Warnf("Remove layer %s error %v", dir, err)
+		}
+	}
+}
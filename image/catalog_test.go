@@ -0,0 +1,87 @@
+package image
+
+import (
+	"os"
+	"testing"
+)
+
+func TestParseReference(t *testing.T) {
+	cases := []struct {
+		ref, name, tag string
+	}{
+		{"busybox", "busybox", "latest"},
+		{"busybox:1.36", "busybox", "1.36"},
+		{"localhost:5000/busybox", "localhost:5000/busybox", "latest"},
+		{"localhost:5000/busybox:v1", "localhost:5000/busybox", "v1"},
+	}
+	for _, c := range cases {
+		name, tag, err := ParseReference(c.ref)
+		if err != nil || name != c.name || tag != c.tag {
+			t.Fatalf("parse %s got %s %s %v", c.ref, name, tag, err)
+		}
+	}
+	for _, bad := range []string{"", ":v1", "busybox:", "a b"} {
+		if _, _, err := ParseReference(bad); err == nil {
+			t.Fatalf("expect error for %q", bad)
+		}
+	}
+}
+
+func TestCatalogRemove(t *testing.T) {
+	defer setTestRoot(t)()
+	base := map[string]string{"etc/os-release": "base"}
+	layout1 := newTestLayout(t, "v1", base, map[string]string{"app/one": "1"})
+	defer os.RemoveAll(layout1)
+	layout2 := newTestLayout(t, "v2", base, map[string]string{"app/two": "2"})
+	defer os.RemoveAll(layout2)
+	one, err := Import(layout1, "", "app:one")
+	if err != nil {
+		t.Fatal(err)
+	}
+	two, err := Import(layout2, "", "app:two")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Import(layout2, "", "alias"); err != nil {
+		t.Fatal(err)
+	}
+	for _, layer := range append(one.Layers, two.Layers...) {
+		if err := ExtractLayer(layer, LayerPath("vfs", layer.Digest), nil, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	images, err := List()
+	if err != nil || len(images) != 3 || images[0].Reference() != "alias:latest" {
+		t.Fatalf("list got %v %v", images, err)
+	}
+	if img, err := Get(two.ID()); err != nil || img == nil || img.Digest != two.Digest {
+		t.Fatalf("get by id got %v %v", img, err)
+	}
+	if refs, _ := References(two.Digest); len(refs) != 2 {
+		t.Fatalf("two should have 2 references, got %v", refs)
+	}
+	if one.Size == 0 {
+		t.Fatalf("size should be recorded")
+	}
+
+	if _, err := Remove("app:two"); err != nil {
+		t.Fatal(err)
+	}
+	// alias还在使用，layer不能删除
+	if _, err := os.Stat(BlobPath(two.Layers[1].Digest)); err != nil {
+		t.Fatalf("layer still used by alias was removed")
+	}
+	if _, err := Remove("alias"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(LayerPath("vfs", two.Layers[1].Digest)); !os.IsNotExist(err) {
+		t.Fatalf("unused layer should be removed")
+	}
+	if _, err := os.Stat(BlobPath(one.Layers[0].Digest)); err != nil {
+		t.Fatalf("shared base layer should be kept")
+	}
+	if _, err := Remove("alias"); err == nil {
+		t.Fatalf("expect error removing unknown image")
+	}
+}
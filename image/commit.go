@@ -0,0 +1,313 @@
+package image
+
+import (
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	log "github.com/Sirupsen/logrus"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"time"
+)
+
+// CommitOptions 是 commit 时 -a -m -c 指定的元数据
+type CommitOptions struct {
+	Author  string
+	Comment string
+	// Changes 是Dockerfile格式的指令，支持 ENV LABEL CMD ENTRYPOINT WORKDIR USER
+	Changes []string
+}
+
+// Commit 把容器可写层的diff(未压缩的OCI layer tar)保存成新的layer，
+// 加在parent的所有layer之上注册为镜像name
+func Commit(name string, parent *Image, diff io.Reader, opts CommitOptions) (*Image, error) {
+	img, err := newImage(name)
+	if err != nil {
+		return nil, err
+	}
+	config, err := parent.LoadConfig()
+	if err != nil {
+		return nil, err
+	}
+	if config == nil {
+		config = &ImageConfig{}
+	}
+	// 先应用change，指令有错误时不留下layer
+	if err := ApplyChanges(&config.Config, opts.Changes); err != nil {
+		return nil, err
+	}
+	layer, diffID, err := putLayer(diff)
+	if err != nil {
+		return nil, err
+	}
+
+	created := time.Now().UTC().Format(time.RFC3339)
+	config.Created = created
+	config.Author = opts.Author
+	config.Architecture = runtime.GOARCH
+	config.OS = "linux"
+	config.RootFS.Type = "layers"
+	config.RootFS.DiffIDs = append(config.RootFS.DiffIDs, diffID)
+	config.History = append(config.History, History{
+		Created:   created,
+		CreatedBy: strings.Join(append([]string{"mydocker commit"}, opts.Changes...), " "),
+		Author:    opts.Author,
+		Comment:   opts.Comment,
+	})
+	configDesc, err := putJSON(MediaTypeImageConfig, config)
+	if err != nil {
+		return nil, err
+	}
+
+	layers := append(append([]Layer{}, parent.Layers...), layer)
+	manifest := &Manifest{
+		SchemaVersion: 2,
+		MediaType:     MediaTypeImageManifest,
+		Config:        configDesc,
+	}
+	for _, l := range layers {
+		manifest.Layers = append(manifest.Layers, Descriptor{MediaType: l.MediaType, Digest: l.Digest, Size: l.Size})
+	}
+	manifestDesc, err := putJSON(MediaTypeImageManifest, manifest)
+	if err != nil {
+		return nil, err
+	}
+
+	img.Digest = manifestDesc.Digest
+	img.Config = configDesc.Digest
+	img.Layers = layers
+	img.Parent = parent.Digest
+	img.Created = created
+	img.Author = opts.Author
+	img.Comment = opts.Comment
+	img.Changes = opts.Changes
+	if err := img.Save(); err != nil {
+		return nil, err
+	}
+	log.Infof("Committed image %s with new layer %s on top of %s", img.Reference(), layer.Digest, parent.Reference())
+	return img, nil
+}
+
+// ImportTar 把一个完整rootfs的tar包作为只有一层的镜像导入，压缩格式见 ArchiveFormat。
+// mydocker import 和以前直接放在 /root/<image>.tar 的镜像都走这里
+func ImportTar(tarPath, name string, opts CommitOptions) (*Image, error) {
+	img, err := newImage(name)
+	if err != nil {
+		return nil, err
+	}
+	config := &ImageConfig{}
+	if err := ApplyChanges(&config.Config, opts.Changes); err != nil {
+		return nil, err
+	}
+	r, err := OpenArchive(tarPath)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	layer, diffID, err := putLayer(r)
+	if err != nil {
+		return nil, err
+	}
+	config.Created = time.Now().UTC().Format(time.RFC3339)
+	config.Author = opts.Author
+	config.Architecture = runtime.GOARCH
+	config.OS = "linux"
+	config.RootFS = RootFS{Type: "layers", DiffIDs: []string{diffID}}
+	config.History = []History{{
+		Created:   config.Created,
+		CreatedBy: strings.Join(append([]string{"mydocker import " + tarPath}, opts.Changes...), " "),
+		Author:    opts.Author,
+		Comment:   opts.Comment,
+	}}
+	configDesc, err := putJSON(MediaTypeImageConfig, config)
+	if err != nil {
+		return nil, err
+	}
+	manifestDesc, err := putJSON(MediaTypeImageManifest, &Manifest{
+		SchemaVersion: 2,
+		MediaType:     MediaTypeImageManifest,
+		Config:        configDesc,
+		Layers:        []Descriptor{{MediaType: layer.MediaType, Digest: layer.Digest, Size: layer.Size}},
+	})
+	if err != nil {
+		return nil, err
+	}
+	img.Digest = manifestDesc.Digest
+	img.Config = configDesc.Digest
+	img.Layers = []Layer{layer}
+	img.Created = config.Created
+	img.Author = opts.Author
+	img.Comment = opts.Comment
+	img.Changes = opts.Changes
+	if err := img.Save(); err != nil {
+		return nil, err
+	}
+	log.Infof("Imported %s as image %s", tarPath, img.Reference())
+	return img, nil
+}
+
+// putLayer 把未压缩的layer tar用gzip压缩后保存到BlobRoot，
+// 返回layer和未压缩内容的digest(即config中的diff id)
+func putLayer(r io.Reader) (Layer, string, error) {
+	tmp, err := blobTempFile()
+	if err != nil {
+		return Layer{}, "", err
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+
+	blobHash, diffHash := sha256.New(), sha256.New()
+	counter := &countWriter{}
+	gz := gzip.NewWriter(io.MultiWriter(tmp, blobHash, counter))
+	if _, err := io.Copy(io.MultiWriter(gz, diffHash), r); err != nil {
+		return Layer{}, "", fmt.Errorf("write layer error %v", err)
+	}
+	if err := gz.Close(); err != nil {
+		return Layer{}, "", err
+	}
+	layer := Layer{
+		Digest:    fmt.Sprintf("sha256:%x", blobHash.Sum(nil)),
+		MediaType: MediaTypeLayerGzip,
+		Size:      counter.n,
+	}
+	if err := commitBlob(tmp, layer.Digest); err != nil {
+		return Layer{}, "", err
+	}
+	return layer, fmt.Sprintf("sha256:%x", diffHash.Sum(nil)), nil
+}
+
+// putJSON 把v序列化后保存到BlobRoot
+func putJSON(mediaType string, v interface{}) (Descriptor, error) {
+	content, err := json.Marshal(v)
+	if err != nil {
+		return Descriptor{}, err
+	}
+	desc := Descriptor{MediaType: mediaType, Digest: digestOf(content), Size: int64(len(content))}
+	tmp, err := blobTempFile()
+	if err != nil {
+		return Descriptor{}, err
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+	if _, err := tmp.Write(content); err != nil {
+		return Descriptor{}, err
+	}
+	return desc, commitBlob(tmp, desc.Digest)
+}
+
+func blobTempFile() (*os.File, error) {
+	dir := filepath.Join(BlobRoot, "sha256")
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	return ioutil.TempFile(dir, "tmp-")
+}
+
+// commitBlob 把写好的临时文件rename成digest对应的blob，blob已经存在时保留原来的
+func commitBlob(tmp *os.File, digest string) error {
+	if err := tmp.Sync(); err != nil {
+		return err
+	}
+	blobPath := BlobPath(digest)
+	if _, err := os.Stat(blobPath); err == nil {
+		return nil
+	}
+	return os.Rename(tmp.Name(), blobPath)
+}
+
+type countWriter struct {
+	n int64
+}
+
+func (w *countWriter) Write(p []byte) (int, error) {
+	w.n += int64(len(p))
+	return len(p), nil
+}
+
+// ApplyChanges 把 commit -c 指定的指令应用到config上
+func ApplyChanges(config *RunConfig, changes []string) error {
+	for _, change := range changes {
+		fields := strings.SplitN(strings.TrimSpace(change), " ", 2)
+		if len(fields) != 2 || strings.TrimSpace(fields[1]) == "" {
+			return fmt.Errorf("invalid change %q", change)
+		}
+		value := strings.TrimSpace(fields[1])
+		switch strings.ToUpper(fields[0]) {
+		case "ENV":
+			key, val, err := parseKeyValue(value)
+			if err != nil {
+				return fmt.Errorf("invalid change %q: %v", change, err)
+			}
+			config.Env = setEnv(config.Env, key, val)
+		case "LABEL":
+			key, val, err := parseKeyValue(value)
+			if err != nil {
+				return fmt.Errorf("invalid change %q: %v", change, err)
+			}
+			if config.Labels == nil {
+				config.Labels = map[string]string{}
+			}
+			config.Labels[key] = val
+		case "CMD":
+			cmd, err := parseCommand(value)
+			if err != nil {
+				return fmt.Errorf("invalid change %q: %v", change, err)
+			}
+			config.Cmd = cmd
+		case "ENTRYPOINT":
+			entrypoint, err := parseCommand(value)
+			if err != nil {
+				return fmt.Errorf("invalid change %q: %v", change, err)
+			}
+			config.Entrypoint = entrypoint
+		case "WORKDIR":
+			if !filepath.IsAbs(value) {
+				return fmt.Errorf("invalid change %q: WORKDIR must be an absolute path", change)
+			}
+			config.WorkingDir = filepath.Clean(value)
+		case "USER":
+			config.User = value
+		case "STOPSIGNAL":
+			config.StopSignal = value
+		default:
+			return fmt.Errorf("unsupported change %q", change)
+		}
+	}
+	return nil
+}
+
+// 支持 KEY=VALUE 和 KEY VALUE 两种写法
+func parseKeyValue(s string) (string, string, error) {
+	sep := strings.IndexAny(s, "= ")
+	if sep <= 0 {
+		return "", "", fmt.Errorf("missing value")
+	}
+	return s[:sep], strings.TrimSpace(s[sep+1:]), nil
+}
+
+// JSON数组是exec格式，否则和Dockerfile一样用 /bin/sh -c 执行
+func parseCommand(s string) ([]string, error) {
+	if strings.HasPrefix(s, "[") {
+		var args []string
+		if err := json.Unmarshal([]byte(s), &args); err != nil {
+			return nil, err
+		}
+		return args, nil
+	}
+	return []string{"/bin/sh", "-c", s}, nil
+}
+
+func setEnv(env []string, key, value string) []string {
+	for i, kv := range env {
+		if strings.HasPrefix(kv, key+"=") {
+			env[i] = key + "=" + value
+			return env
+		}
+	}
+	return append(env, key+"="+value)
+}
@@ -0,0 +1,86 @@
+package image
+
+import (
+	"archive/tar"
+	"bytes"
+	"os"
+	"reflect"
+	"testing"
+)
+
+func TestCommit(t *testing.T) {
+	defer setTestRoot(t)()
+	layout := newTestLayout(t, "v1", map[string]string{"etc/os-release": "base"})
+	defer os.RemoveAll(layout)
+	parent, err := Import(layout, "v1", "base")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var diff bytes.Buffer
+	tw := tar.NewWriter(&diff)
+	tw.WriteHeader(&tar.Header{Name: "app/hello", Mode: 0644, Size: 2, Typeflag: tar.TypeReg})
+	tw.Write([]byte("hi"))
+	tw.WriteHeader(&tar.Header{Name: "etc/.wh.os-release", Typeflag: tar.TypeReg})
+	tw.Close()
+
+	opts := CommitOptions{Author: "tester", Comment: "add app", Changes: []string{"ENV FOO=bar", `CMD ["/app/hello"]`, "WORKDIR /app"}}
+	img, err := Commit("app", parent, &diff, opts)
+	if err != nil {
+		t.Fatalf("commit %v", err)
+	}
+	if len(img.Layers) != 2 || img.Layers[0] != parent.Layers[0] || img.Parent != parent.Digest {
+		t.Fatalf("commit should add one layer on top of parent, got %+v", img)
+	}
+	dest := LayerPath("test", img.Layers[1].Digest)
+	if err := ExtractLayer(img.Layers[1], dest, nil, nil); err != nil {
+		t.Fatalf("extract committed layer %v", err)
+	}
+	if _, err := os.Stat(dest + "/app/hello"); err != nil {
+		t.Fatalf("committed layer missing file %v", err)
+	}
+
+	saved, err := Get("app")
+	if err != nil || saved == nil || saved.Author != "tester" || saved.Comment != "add app" {
+		t.Fatalf("get committed image got %+v %v", saved, err)
+	}
+	config, err := saved.LoadConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(config.Config.Env, []string{"FOO=bar"}) || !reflect.DeepEqual(config.Config.Cmd, []string{"/app/hello"}) ||
+		config.Config.WorkingDir != "/app" {
+		t.Fatalf("changes not applied %+v", config.Config)
+	}
+	if len(config.RootFS.DiffIDs) != 1 || len(config.History) != 1 {
+		t.Fatalf("config should record the new layer %+v", config)
+	}
+
+	if _, err := Commit("bad", parent, &diff, CommitOptions{Changes: []string{"RUN rm -rf /"}}); err == nil {
+		t.Fatalf("expect error for unsupported change")
+	}
+}
+
+func TestApplyChanges(t *testing.T) {
+	config := &RunConfig{Env: []string{"PATH=/bin", "FOO=old"}}
+	changes := []string{"ENV FOO new", "LABEL version=1", "CMD echo hi", "ENTRYPOINT [\"/init\"]", "USER 1000", "STOPSIGNAL SIGQUIT"}
+	if err := ApplyChanges(config, changes); err != nil {
+		t.Fatal(err)
+	}
+	want := &RunConfig{
+		User:       "1000",
+		Env:        []string{"PATH=/bin", "FOO=new"},
+		Entrypoint: []string{"/init"},
+		Cmd:        []string{"/bin/sh", "-c", "echo hi"},
+		Labels:     map[string]string{"version": "1"},
+		StopSignal: "SIGQUIT",
+	}
+	if !reflect.DeepEqual(config, want) {
+		t.Fatalf("got %+v want %+v", config, want)
+	}
+	for _, bad := range []string{"ENV", "WORKDIR relative", "CMD [broken"} {
+		if err := ApplyChanges(&RunConfig{}, []string{bad}); err == nil {
+			t.Fatalf("expect error for %q", bad)
+		}
+	}
+}
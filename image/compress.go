@@ -0,0 +1,76 @@
+package image
+
+import (
+	"fmt"
+	"github.com/mholt/archiver"
+	"github.com/xianlubird/mydocker/archive"
+	"io"
+	"os"
+)
+
+// import/export/save/load 支持的tar包格式，名字和 archiver.SupportedFormats 中的一致。
+// 压缩格式放在前面，因为 archiver.Tar 会把任何 .tar 结尾的文件都当作普通tar
+var archiveFormats = []string{"TarGz", "TarBz2", "TarXZ", "Tar"}
+
+// ArchiveFormat 按文件名后缀或者文件内容判断tar包的压缩格式，
+// 文件不存在时只看后缀，不认识的后缀当作不压缩的tar
+func ArchiveFormat(path string) string {
+	for _, name := range archiveFormats {
+		if format, ok := archiver.SupportedFormats[name]; ok && format.Match(path) {
+			return name
+		}
+	}
+	return "Tar"
+}
+
+type multiCloser struct {
+	io.Reader
+	io.Writer
+	closers []io.Closer
+}
+
+func (m *multiCloser) Close() error {
+	var err error
+	for _, c := range m.closers {
+		if e := c.Close(); e != nil && err == nil {
+			err = e
+		}
+	}
+	return err
+}
+
+// OpenArchive 打开tar包，按文件内容判断压缩格式，返回解压后的tar流
+func OpenArchive(path string) (io.ReadCloser, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	r, err := archive.DecompressStream(f)
+	if err != nil {
+		f.Close()
+		return nil, fmt.Errorf("open archive %s error %v", path, err)
+	}
+	return &multiCloser{Reader: r, closers: []io.Closer{r, f}}, nil
+}
+
+var archiveCompressions = map[string]archive.Compression{
+	"TarGz":  archive.Gzip,
+	"TarBz2": archive.Bzip2,
+	"TarXZ":  archive.Xz,
+	"Tar":    archive.Uncompressed,
+}
+
+// CreateArchive 创建tar包，按path的后缀选择压缩格式，返回写入tar流的writer
+func CreateArchive(path string) (io.WriteCloser, error) {
+	f, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+	// 文件已经清空，这里只会按后缀判断
+	w, err := archive.CompressStream(f, archiveCompressions[ArchiveFormat(path)])
+	if err != nil {
+		f.Close()
+		return nil, fmt.Errorf("create archive %s error %v", path, err)
+	}
+	return &multiCloser{Writer: w, closers: []io.Closer{w, f}}, nil
+}
@@ -0,0 +1,254 @@
+package image
+
+import (
+	"archive/tar"
+	"encoding/json"
+	"fmt"
+	"github.com/xianlubird/mydocker/archive"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+)
+
+// OCI image layout 中用到的结构，参考 https://github.com/opencontainers/image-spec
+const (
+	MediaTypeImageIndex    = "application/vnd.oci.image.index.v1+json"
+	MediaTypeImageManifest = "application/vnd.oci.image.manifest.v1+json"
+	MediaTypeLayer         = "application/vnd.oci.image.layer.v1.tar"
+	MediaTypeLayerGzip     = "application/vnd.oci.image.layer.v1.tar+gzip"
+	MediaTypeLayerZstd     = "application/vnd.oci.image.layer.v1.tar+zstd"
+	MediaTypeImageConfig   = "application/vnd.oci.image.config.v1+json"
+
+	// docker save 出来的镜像使用的media type
+	MediaTypeDockerManifestList = "application/vnd.docker.distribution.manifest.list.v2+json"
+	MediaTypeDockerManifest     = "application/vnd.docker.distribution.manifest.v2+json"
+	MediaTypeDockerLayerGzip    = "application/vnd.docker.image.rootfs.diff.tar.gzip"
+
+	AnnotationRefName = "org.opencontainers.image.ref.name"
+
+	layoutFile = "oci-layout"
+	indexFile  = "index.json"
+	blobsDir   = "blobs"
+)
+
+type Descriptor struct {
+	MediaType   string            `json:"mediaType"`
+	Digest      string            `json:"digest"`
+	Size        int64             `json:"size"`
+	Annotations map[string]string `json:"annotations,omitempty"`
+	Platform    *Platform         `json:"platform,omitempty"`
+}
+
+type Platform struct {
+	Architecture string `json:"architecture"`
+	OS           string `json:"os"`
+}
+
+type Index struct {
+	SchemaVersion int          `json:"schemaVersion"`
+	MediaType     string       `json:"mediaType,omitempty"`
+	Manifests     []Descriptor `json:"manifests"`
+}
+
+type Manifest struct {
+	SchemaVersion int          `json:"schemaVersion"`
+	MediaType     string       `json:"mediaType,omitempty"`
+	Config        Descriptor   `json:"config"`
+	Layers        []Descriptor `json:"layers"`
+}
+
+// ImageConfig 是镜像的config blob，只包含mydocker用到的字段
+type ImageConfig struct {
+	Created      string    `json:"created,omitempty"`
+	Author       string    `json:"author,omitempty"`
+	Architecture string    `json:"architecture"`
+	OS           string    `json:"os"`
+	Config       RunConfig `json:"config"`
+	RootFS       RootFS    `json:"rootfs"`
+	History      []History `json:"history,omitempty"`
+}
+
+// RunConfig 是运行容器时的默认参数
+type RunConfig struct {
+	User       string            `json:"User,omitempty"`
+	Env        []string          `json:"Env,omitempty"`
+	Entrypoint []string          `json:"Entrypoint,omitempty"`
+	Cmd        []string          `json:"Cmd,omitempty"`
+	WorkingDir string            `json:"WorkingDir,omitempty"`
+	Labels     map[string]string `json:"Labels,omitempty"`
+	StopSignal string            `json:"StopSignal,omitempty"`
+}
+
+type RootFS struct {
+	Type    string   `json:"type"`
+	DiffIDs []string `json:"diff_ids"`
+}
+
+type History struct {
+	Created    string `json:"created,omitempty"`
+	CreatedBy  string `json:"created_by,omitempty"`
+	Author     string `json:"author,omitempty"`
+	Comment    string `json:"comment,omitempty"`
+	EmptyLayer bool   `json:"empty_layer,omitempty"`
+}
+
+// Layout 是磁盘上的一个OCI image layout目录
+type Layout struct {
+	Path string
+	// 从tar包解出来的临时目录，Close时删除
+	tmpDir string
+}
+
+// IsLayout 判断path是否是OCI image layout目录或者包含layout的tar包，tar包可以是压缩的
+func IsLayout(path string) bool {
+	fi, err := os.Stat(path)
+	if err != nil {
+		return false
+	}
+	if fi.IsDir() {
+		_, err := os.Stat(filepath.Join(path, layoutFile))
+		return err == nil
+	}
+	f, err := OpenArchive(path)
+	if err != nil {
+		return false
+	}
+	defer f.Close()
+	tr := tar.NewReader(f)
+	for {
+		hdr, err := tr.Next()
+		if err != nil {
+			return false
+		}
+		if filepath.Clean(hdr.Name) == layoutFile {
+			return true
+		}
+	}
+}
+
+// OpenLayout 打开layout目录，如果是tar包则先解到临时目录
+func OpenLayout(path string) (*Layout, error) {
+	fi, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	layout := &Layout{Path: path}
+	if !fi.IsDir() {
+		tmpDir, err := ioutil.TempDir("", "mydocker-layout")
+		if err != nil {
+			return nil, err
+		}
+		if err := unpackLayoutTar(path, tmpDir); err != nil {
+			os.RemoveAll(tmpDir)
+			return nil, err
+		}
+		layout.Path = tmpDir
+		layout.tmpDir = tmpDir
+	}
+	if _, err := os.Stat(filepath.Join(layout.Path, layoutFile)); err != nil {
+		layout.Close()
+		return nil, fmt.Errorf("%s is not an OCI image layout", path)
+	}
+	return layout, nil
+}
+
+// unpackLayoutTar 把layout tar包解到临时目录，属主没有意义，不做修改
+func unpackLayoutTar(tarPath, dest string) error {
+	f, err := os.Open(tarPath)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if err := archive.Untar(f, dest, &archive.TarOptions{NoLchown: true}); err != nil {
+		return fmt.Errorf("unpack layout tar %s error %v", tarPath, err)
+	}
+	return nil
+}
+
+func (l *Layout) Close() error {
+	if l.tmpDir != "" {
+		return os.RemoveAll(l.tmpDir)
+	}
+	return nil
+}
+
+// BlobPath 返回digest对应的blob文件路径，digest格式为 algorithm:hex
+func (l *Layout) BlobPath(digest string) (string, error) {
+	parts := strings.SplitN(digest, ":", 2)
+	if len(parts) != 2 || parts[0] == "" || parts[1] == "" || strings.ContainsAny(parts[1], "/.") {
+		return "", fmt.Errorf("invalid digest %s", digest)
+	}
+	return filepath.Join(l.Path, blobsDir, parts[0], parts[1]), nil
+}
+
+func (l *Layout) readJSON(digest string, v interface{}) error {
+	blobPath, err := l.BlobPath(digest)
+	if err != nil {
+		return err
+	}
+	content, err := ioutil.ReadFile(blobPath)
+	if err != nil {
+		return err
+	}
+	if got := digestOf(content); got != digest {
+		return fmt.Errorf("blob %s has digest %s", digest, got)
+	}
+	return json.Unmarshal(content, v)
+}
+
+func (l *Layout) Index() (*Index, error) {
+	content, err := ioutil.ReadFile(filepath.Join(l.Path, indexFile))
+	if err != nil {
+		return nil, err
+	}
+	index := &Index{}
+	if err := json.Unmarshal(content, index); err != nil {
+		return nil, fmt.Errorf("parse %s error %v", indexFile, err)
+	}
+	return index, nil
+}
+
+// ResolveManifest 按照引用名找到镜像manifest，ref为空时取第一个匹配当前平台的manifest。
+// index中的项也可能是嵌套的image index，这里会递归查找。
+func (l *Layout) ResolveManifest(ref string) (*Descriptor, *Manifest, error) {
+	index, err := l.Index()
+	if err != nil {
+		return nil, nil, err
+	}
+	var candidates []Descriptor
+	for _, desc := range index.Manifests {
+		if ref == "" || desc.Annotations[AnnotationRefName] == ref {
+			candidates = append(candidates, desc)
+		}
+	}
+	if len(candidates) == 0 {
+		return nil, nil, fmt.Errorf("no manifest named %s in layout", ref)
+	}
+	return l.resolve(candidates)
+}
+
+func (l *Layout) resolve(descs []Descriptor) (*Descriptor, *Manifest, error) {
+	for _, desc := range descs {
+		if desc.Platform != nil && (desc.Platform.OS != "linux" || desc.Platform.Architecture != runtime.GOARCH) {
+			continue
+		}
+		switch desc.MediaType {
+		case MediaTypeImageIndex, MediaTypeDockerManifestList:
+			nested := &Index{}
+			if err := l.readJSON(desc.Digest, nested); err != nil {
+				return nil, nil, err
+			}
+			return l.resolve(nested.Manifests)
+		case MediaTypeImageManifest, MediaTypeDockerManifest:
+			manifest := &Manifest{}
+			if err := l.readJSON(desc.Digest, manifest); err != nil {
+				return nil, nil, err
+			}
+			d := desc
+			return &d, manifest, nil
+		}
+	}
+	return nil, nil, fmt.Errorf("no manifest for linux/%s in layout", runtime.GOARCH)
+}
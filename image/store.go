@@ -0,0 +1,214 @@
+package image
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	log "github.com/Sirupsen/logrus"
+	"github.com/xianlubird/mydocker/archive"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+var (
+	// BlobRoot 下按digest保存layer的原始压缩包
+	BlobRoot = "/root/blobs"
+	// LayerRoot/<driver>/ 下是按digest解压好的layer，同一个存储驱动的多个镜像共用同一份。
+	// 和容器的挂载点一样放在权限为0711的 /var/lib/mydocker 下面
+	LayerRoot = "/var/lib/mydocker/layers"
+)
+
+type Image struct {
+	Name    string   `json:"name"`
+	Tag     string   `json:"tag"`
+	Digest  string   `json:"digest"`           //manifest的digest，也用作镜像ID
+	Config  string   `json:"config,omitempty"` //config blob的digest
+	Size    int64    `json:"size"`             //所有layer压缩包的大小
+	Layers  []Layer  `json:"layers"`           //第一个是最底层
+	Parent  string   `json:"parent,omitempty"` //commit出来的镜像记录父镜像的digest
+	Created string   `json:"created,omitempty"`
+	Author  string   `json:"author,omitempty"`
+	Comment string   `json:"comment,omitempty"`
+	Changes []string `json:"changes,omitempty"`
+}
+
+type Layer struct {
+	Digest    string `json:"digest"`
+	MediaType string `json:"mediaType"`
+	Size      int64  `json:"size"`
+}
+
+func digestOf(content []byte) string {
+	return fmt.Sprintf("sha256:%x", sha256.Sum256(content))
+}
+
+// BlobPath 返回layer压缩包在BlobRoot下的路径
+func BlobPath(digest string) string {
+	return filepath.Join(BlobRoot, strings.Replace(digest, ":", "/", 1))
+}
+
+// LayerPath 返回layer按存储驱动解压后的目录
+func LayerPath(driver, digest string) string {
+	return filepath.Join(LayerRoot, driver, strings.Replace(digest, ":", "-", 1))
+}
+
+// Import 把OCI image layout(目录或tar包)中ref指定的镜像导入为name，
+// 已经保存过的layer直接复用
+func Import(layoutPath, ref, name string) (*Image, error) {
+	layout, err := OpenLayout(layoutPath)
+	if err != nil {
+		return nil, err
+	}
+	defer layout.Close()
+	img, err := importManifest(layout, ref, name)
+	if err != nil {
+		return nil, err
+	}
+	log.Infof("Imported image %s with %d layers from %s", img.Reference(), len(img.Layers), layoutPath)
+	return img, nil
+}
+
+func importManifest(layout *Layout, ref, name string) (*Image, error) {
+	img, err := newImage(name)
+	if err != nil {
+		return nil, err
+	}
+	desc, manifest, err := layout.ResolveManifest(ref)
+	if err != nil {
+		return nil, err
+	}
+	img.Digest = desc.Digest
+	img.Config = manifest.Config.Digest
+	for _, blob := range []Descriptor{*desc, manifest.Config} {
+		if err := storeBlob(layout, blob); err != nil {
+			return nil, err
+		}
+	}
+	if config, err := img.LoadConfig(); err == nil && config.Created != "" {
+		img.Created = config.Created
+	} else {
+		img.Created = time.Now().UTC().Format(time.RFC3339)
+	}
+	for _, desc := range manifest.Layers {
+		if err := storeBlob(layout, desc); err != nil {
+			return nil, err
+		}
+		img.Layers = append(img.Layers, Layer{Digest: desc.Digest, MediaType: desc.MediaType, Size: desc.Size})
+	}
+	if err := img.Save(); err != nil {
+		return nil, err
+	}
+	return img, nil
+}
+
+// LoadConfig 读取镜像的config，老的镜像记录没有config时返回nil
+func (img *Image) LoadConfig() (*ImageConfig, error) {
+	if img.Config == "" {
+		return nil, nil
+	}
+	content, err := ioutil.ReadFile(BlobPath(img.Config))
+	if err != nil {
+		return nil, err
+	}
+	config := &ImageConfig{}
+	if err := json.Unmarshal(content, config); err != nil {
+		return nil, fmt.Errorf("parse config of image %s error %v", img.Name, err)
+	}
+	return config, nil
+}
+
+// 把layout中的blob复制到BlobRoot，复制的同时校验digest
+func storeBlob(layout *Layout, desc Descriptor) error {
+	blobPath := BlobPath(desc.Digest)
+	if _, err := os.Stat(blobPath); err == nil {
+		log.Infof("Layer %s already exists", desc.Digest)
+		return nil
+	}
+	srcPath, err := layout.BlobPath(desc.Digest)
+	if err != nil {
+		return err
+	}
+	src, err := os.Open(srcPath)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+	if err := os.MkdirAll(filepath.Dir(blobPath), 0755); err != nil {
+		return err
+	}
+	tmp, err := ioutil.TempFile(filepath.Dir(blobPath), "tmp-")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	hash := sha256.New()
+	_, err = io.Copy(io.MultiWriter(tmp, hash), src)
+	tmp.Close()
+	if err != nil {
+		return err
+	}
+	if got := fmt.Sprintf("sha256:%x", hash.Sum(nil)); got != desc.Digest {
+		return fmt.Errorf("layer digest mismatch, want %s got %s", desc.Digest, got)
+	}
+	return os.Rename(tmp.Name(), blobPath)
+}
+
+// ExtractLayer 把layer解压到dest，dest已经存在时直接返回。
+// 先解压到临时目录并交给convert处理whiteout等存储驱动相关的格式，完成后再rename，
+// 避免中途失败留下不完整的layer。opts中的id映射用于给user namespace中的容器准备layer
+func ExtractLayer(layer Layer, dest string, opts *archive.TarOptions, convert func(dir string) error) error {
+	if _, err := os.Stat(dest); err == nil {
+		return nil
+	}
+	blob, err := os.Open(BlobPath(layer.Digest))
+	if err != nil {
+		return err
+	}
+	defer blob.Close()
+
+	if err := os.MkdirAll(filepath.Dir(dest), 0755); err != nil {
+		return err
+	}
+	tmpPath, err := ioutil.TempDir(filepath.Dir(dest), "tmp-")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(tmpPath)
+
+	switch layer.MediaType {
+	case MediaTypeLayer, MediaTypeLayerGzip, MediaTypeDockerLayerGzip, MediaTypeLayerZstd:
+	default:
+		return fmt.Errorf("unsupported layer media type %s", layer.MediaType)
+	}
+	// 目录权限以layer中的根目录为准，layer中没有根目录时使用0755，TempDir默认是0700
+	os.Chmod(tmpPath, 0755)
+	if err := archive.Untar(layerProgress(blob, layer), tmpPath, opts); err != nil {
+		return fmt.Errorf("untar layer %s error %v", layer.Digest, err)
+	}
+	if convert != nil {
+		if err := convert(tmpPath); err != nil {
+			return fmt.Errorf("convert layer %s error %v", layer.Digest, err)
+		}
+	}
+	return os.Rename(tmpPath, dest)
+}
+
+// layerProgress 在解压layer时每完成10%打印一次进度
+func layerProgress(r io.Reader, layer Layer) io.Reader {
+	last := int64(-10)
+	return archive.NewProgressReader(r, layer.Size, func(current, total int64) {
+		if total <= 0 {
+			return
+		}
+		percent := current * 100 / total
+		if percent/10 == last/10 {
+			return
+		}
+		last = percent
+		log.Infof("Extracting layer %s: %d%%", shortID(layer.Digest), percent)
+	})
+}
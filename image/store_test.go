@@ -0,0 +1,132 @@
+package image
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// 写一个gzip压缩的layer blob，files是文件名到内容的映射
+func writeLayer(t *testing.T, layoutDir string, files map[string]string) Descriptor {
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(gz)
+	for name, content := range files {
+		tw.WriteHeader(&tar.Header{Name: name, Mode: 0644, Size: int64(len(content)), Typeflag: tar.TypeReg})
+		tw.Write([]byte(content))
+	}
+	tw.Close()
+	gz.Close()
+	return writeBlob(t, layoutDir, MediaTypeLayerGzip, buf.Bytes())
+}
+
+func writeBlob(t *testing.T, layoutDir, mediaType string, content []byte) Descriptor {
+	desc := Descriptor{MediaType: mediaType, Digest: digestOf(content), Size: int64(len(content))}
+	blobDir := filepath.Join(layoutDir, blobsDir, "sha256")
+	os.MkdirAll(blobDir, 0755)
+	if err := ioutil.WriteFile(filepath.Join(blobDir, desc.Digest[len("sha256:"):]), content, 0644); err != nil {
+		t.Fatal(err)
+	}
+	return desc
+}
+
+func writeJSONBlob(t *testing.T, layoutDir, mediaType string, v interface{}) Descriptor {
+	content, _ := json.Marshal(v)
+	return writeBlob(t, layoutDir, mediaType, content)
+}
+
+func newTestLayout(t *testing.T, ref string, layers ...map[string]string) string {
+	layoutDir, err := ioutil.TempDir("", "layout")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ioutil.WriteFile(filepath.Join(layoutDir, layoutFile), []byte(`{"imageLayoutVersion":"1.0.0"}`), 0644)
+	manifest := &Manifest{SchemaVersion: 2, Config: writeJSONBlob(t, layoutDir, MediaTypeImageConfig, map[string]string{})}
+	for _, files := range layers {
+		manifest.Layers = append(manifest.Layers, writeLayer(t, layoutDir, files))
+	}
+	manifestDesc := writeJSONBlob(t, layoutDir, MediaTypeImageManifest, manifest)
+	manifestDesc.Annotations = map[string]string{AnnotationRefName: ref}
+	index, _ := json.Marshal(&Index{SchemaVersion: 2, Manifests: []Descriptor{manifestDesc}})
+	ioutil.WriteFile(filepath.Join(layoutDir, indexFile), index, 0644)
+	return layoutDir
+}
+
+func setTestRoot(t *testing.T) func() {
+	root, err := ioutil.TempDir("", "store")
+	if err != nil {
+		t.Fatal(err)
+	}
+	oldCatalogPath, oldLayerRoot := CatalogPath, LayerRoot
+	oldBlobRoot := BlobRoot
+	CatalogPath, LayerRoot = filepath.Join(root, "image", "repositories.json"), filepath.Join(root, "layers")
+	BlobRoot = filepath.Join(root, "blobs")
+	return func() {
+		CatalogPath, LayerRoot, BlobRoot = oldCatalogPath, oldLayerRoot, oldBlobRoot
+		os.RemoveAll(root)
+	}
+}
+
+func TestImportSharesLayers(t *testing.T) {
+	defer setTestRoot(t)()
+	base := map[string]string{"etc/os-release": "base"}
+	layout1 := newTestLayout(t, "v1", base, map[string]string{"app/one": "1"})
+	defer os.RemoveAll(layout1)
+	layout2 := newTestLayout(t, "v2", base, map[string]string{"app/two": "2"})
+	defer os.RemoveAll(layout2)
+
+	if !IsLayout(layout1) {
+		t.Fatalf("%s should be a layout", layout1)
+	}
+	img1, err := Import(layout1, "v1", "one")
+	if err != nil {
+		t.Fatalf("import one %v", err)
+	}
+	img2, err := Import(layout2, "", "two")
+	if err != nil {
+		t.Fatalf("import two %v", err)
+	}
+	if img1.Layers[0] != img2.Layers[0] {
+		t.Fatalf("base layer should be shared, got %v and %v", img1.Layers[0], img2.Layers[0])
+	}
+	dest := LayerPath("test", img2.Layers[1].Digest)
+	converted := false
+	if err := ExtractLayer(img2.Layers[1], dest, nil, func(string) error { converted = true; return nil }); err != nil {
+		t.Fatalf("extract layer %v", err)
+	}
+	if content, err := ioutil.ReadFile(filepath.Join(dest, "app/two")); err != nil || string(content) != "2" || !converted {
+		t.Fatalf("layer not extracted: %q %v", content, err)
+	}
+	saved, err := Get("two")
+	if err != nil || saved == nil || saved.Digest != img2.Digest {
+		t.Fatalf("get image got %+v %v", saved, err)
+	}
+	if _, err := Import(layout1, "missing", "three"); err == nil {
+		t.Fatalf("expect error for unknown ref")
+	}
+}
+
+func TestImportDigestMismatch(t *testing.T) {
+	defer setTestRoot(t)()
+	layout := newTestLayout(t, "v1", map[string]string{"a": "a"})
+	defer os.RemoveAll(layout)
+	l, _ := OpenLayout(layout)
+	_, manifest, err := l.ResolveManifest("v1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	blobPath, _ := l.BlobPath(manifest.Layers[0].Digest)
+	content, _ := ioutil.ReadFile(blobPath)
+	ioutil.WriteFile(blobPath, append(content, 0), 0644)
+	if _, err := Import(layout, "v1", "broken"); err == nil {
+		t.Fatalf("expect digest mismatch")
+	}
+	if _, err := os.Stat(BlobPath(manifest.Layers[0].Digest)); !os.IsNotExist(err) {
+		t.Fatalf("broken layer should not be kept")
+	}
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	log "github.com/Sirupsen/logrus"
+	"github.com/xianlubird/mydocker/image"
+	"os"
+	"text/tabwriter"
+	"time"
+)
+
+func listImages() error {
+	images, err := image.List()
+	if err != nil {
+		log.Errorf("List images error %v", err)
+		return err
+	}
+	w := tabwriter.NewWriter(os.Stdout, 12, 1, 3, ' ', 0)
+	fmt.Fprint(w, "REPOSITORY\tTAG\tIMAGE ID\tCREATED\tSIZE\n")
+	for _, img := range images {
+		created := img.Created
+		if t, err := time.Parse(time.RFC3339, img.Created); err == nil {
+			created = t.Local().Format("2006-01-02 15:04:05")
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n",
+			img.Name,
+			img.Tag,
+			img.ID(),
+			created,
+			formatSize(img.Size))
+	}
+	if err := w.Flush(); err != nil {
+		log.Errorf("Flush error %v", err)
+		return err
+	}
+	return nil
+}
+
+//删除镜像引用，有容器在使用且这是镜像的最后一个引用时拒绝删除
+func removeImage(ref string) error {
+	img, err := image.Get(ref)
+	if err != nil {
+		return err
+	}
+	if img == nil {
+		return fmt.Errorf("no such image %s", ref)
+	}
+	refs, err := image.References(img.Digest)
+	if err != nil {
+		return err
+	}
+	containers, err := listContainerInfos()
+	if err != nil {
+		return err
+	}
+	for _, info := range containers {
+		used := info.ImageID == img.Digest && len(refs) == 1
+		if info.ImageID == "" && image.NormalizeReference(info.Image) == img.Reference() {
+			used = true
+		}
+		if used {
+			return fmt.Errorf("image %s is used by container %s", ref, info.Name)
+		}
+	}
+	if _, err := image.Remove(img.Reference()); err != nil {
+		log.Errorf("Remove image %s error %v", ref, err)
+		return err
+	}
+	fmt.Printf("Untagged: %s\n", img.Reference())
+	return nil
+}
+
+type imageInspect struct {
+	*image.Image
+	ID          string             `json:"id"`
+	ImageConfig *image.ImageConfig `json:"imageConfig,omitempty"`
+}
+
+func inspectImage(ref string) error {
+	img, err := image.Get(ref)
+	if err != nil {
+		return err
+	}
+	if img == nil {
+		return fmt.Errorf("no such image %s", ref)
+	}
+	config, err := img.LoadConfig()
+	if err != nil {
+		log.Errorf("Load config of image %s error %v", ref, err)
+		return err
+	}
+	content, err := json.MarshalIndent(&imageInspect{Image: img, ID: img.Digest, ImageConfig: config}, "", "    ")
+	if err != nil {
+		return err
+	}
+	fmt.Println(string(content))
+	return nil
+}
+
+func formatSize(size int64) string {
+	units := []string{"B", "KB", "MB", "GB", "TB"}
+	value := float64(size)
+	i := 0
+	for value >= 1000 && i < len(units)-1 {
+		value /= 1000
+		i++
+	}
+	return fmt.Sprintf("%.4g%s", value, units[i])
+}
+
+func importImage(tarPath, imageName string, opts image.CommitOptions) error {
+	img, err := image.ImportTar(tarPath, imageName, opts)
+	if err != nil {
+		log.Errorf("Import %s error %v", tarPath, err)
+		return err
+	}
+	fmt.Println(img.Digest)
+	return nil
+}
+
+func saveImages(refs []string, output string) error {
+	if err := image.SaveArchive(refs, output); err != nil {
+		log.Errorf("Save images error %v", err)
+		return err
+	}
+	return nil
+}
+
+func loadImages(input string) error {
+	images, err := image.LoadArchive(input)
+	for _, img := range images {
+		fmt.Printf("Loaded image: %s\n", img.Reference())
+	}
+	if err != nil {
+		log.Errorf("Load images from %s error %v", input, err)
+		return err
+	}
+	return nil
+}
@@ -11,17 +11,52 @@ import (
 )
 
 func ListContainers() {
+	containers, err := listContainerInfos()
+	if err != nil {
+		return
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 12, 1, 3, ' ', 0)
+	fmt.Fprint(w, "ID\tNAME\tPID\tSTATUS\tRESTARTS\tCOMMAND\tCREATED\n")
+	for _, item := range containers {
+		if err := reconcileContainerInfo(item); err != nil {
+			log.Warnf("Reconcile container %s error %v", item.Name, err)
+		}
+		status := item.Status
+		if status == container.Exit {
+			status = fmt.Sprintf("%s (%d)", status, item.ExitCode)
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%d\t%s\t%s\n",
+			item.Id,
+			item.Name,
+			item.Pid,
+			status,
+			item.RestartCount,
+			item.Command,
+			item.CreatedTime)
+	}
+	if err := w.Flush(); err != nil {
+		log.Errorf("Flush error %v", err)
+		return
+	}
+}
+
+//读取所有容器的信息，network和image目录下是网络和镜像的记录
+func listContainerInfos() ([]*container.ContainerInfo, error) {
 	dirURL := fmt.Sprintf(container.DefaultInfoLocation, "")
 	dirURL = dirURL[:len(dirURL)-1]
 	files, err := ioutil.ReadDir(dirURL)
 	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
 		log.Errorf("Read dir %s error %v", dirURL, err)
-		return
+		return nil, err
 	}
 
 	var containers []*container.ContainerInfo
 	for _, file := range files {
-		if file.Name() == "network" {
+		if file.Name() == "network" || file.Name() == "image" {
 			continue
 		}
 		tmpContainer, err := getContainerInfo(file)
@@ -31,22 +66,7 @@ func ListContainers() {
 		}
 		containers = append(containers, tmpContainer)
 	}
-
-	w := tabwriter.NewWriter(os.Stdout, 12, 1, 3, ' ', 0)
-	fmt.Fprint(w, "ID\tNAME\tPID\tSTATUS\tCOMMAND\tCREATED\n")
-	for _, item := range containers {
-		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\n",
-			item.Id,
-			item.Name,
-			item.Pid,
-			item.Status,
-			item.Command,
-			item.CreatedTime)
-	}
-	if err := w.Flush(); err != nil {
-		log.Errorf("Flush error %v", err)
-		return
-	}
+	return containers, nil
 }
 
 func getContainerInfo(file os.FileInfo) (*container.ContainerInfo, error) {
@@ -66,3 +86,17 @@ func getContainerInfo(file os.FileInfo) (*container.ContainerInfo, error) {
 
 	return &containerInfo, nil
 }
+
+// inspectContainerInfo 输出核对之后的容器信息
+func inspectContainerInfo(containerName string) error {
+	containerInfo, err := inspectContainer(containerName)
+	if err != nil {
+		return err
+	}
+	content, err := json.MarshalIndent(containerInfo, "", "  ")
+	if err != nil {
+		return err
+	}
+	fmt.Fprintln(os.Stdout, string(content))
+	return nil
+}
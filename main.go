@@ -3,6 +3,8 @@ package main
 import (
 	log "github.com/Sirupsen/logrus"
 	"github.com/urfave/cli"
+	"github.com/xianlubird/mydocker/cgroups"
+	"github.com/xianlubird/mydocker/container"
 	"os"
 )
 
@@ -17,14 +19,45 @@ func main() {
 
 	app.Commands = []cli.Command{
 		initCommand,
+		shimCommand,
 		runCommand,
+		attachCommand,
 		listCommand,
+		inspectCommand,
 		logCommand,
 		execCommand,
 		stopCommand,
+		restartCommand,
+		killCommand,
+		pauseCommand,
+		unpauseCommand,
 		removeCommand,
 		commitCommand,
 		networkCommand,
+		createCommand,
+		startCommand,
+		stateCommand,
+		pullCommand,
+		imagesCommand,
+		rmiCommand,
+		imageCommand,
+		exportCommand,
+		importCommand,
+		saveCommand,
+		loadCommand,
+	}
+
+	app.Flags = []cli.Flag{
+		cli.StringFlag{
+			Name:  "storage-driver, s",
+			Value: container.DefaultStorageDriver,
+			Usage: "storage driver for container rootfs: overlay, vfs or aufs",
+		},
+		cli.StringFlag{
+			Name:  "cgroup-driver",
+			Value: cgroups.DefaultCgroupDriver,
+			Usage: "cgroup driver: cgroupfs or systemd",
+		},
 	}
 
 	app.Before = func(context *cli.Context) error {
@@ -32,7 +65,10 @@ func main() {
 		log.SetFormatter(&log.JSONFormatter{})
 
 		log.SetOutput(os.Stdout)
-		return nil
+		if err := container.SetStorageDriver(context.GlobalString("storage-driver")); err != nil {
+			return err
+		}
+		return cgroups.SetCgroupDriver(context.GlobalString("cgroup-driver"))
 	}
 
 	if err := app.Run(os.Args); err != nil {
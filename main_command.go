@@ -6,8 +6,15 @@ import (
 	"github.com/urfave/cli"
 	"github.com/xianlubird/mydocker/cgroups/subsystems"
 	"github.com/xianlubird/mydocker/container"
+	"github.com/xianlubird/mydocker/idtools"
+	"github.com/xianlubird/mydocker/image"
 	"github.com/xianlubird/mydocker/network"
+	"github.com/xianlubird/mydocker/seccomp"
+	"github.com/xianlubird/mydocker/term"
 	"os"
+	"path/filepath"
+	"strings"
+	"time"
 )
 //定义了runCommand的FLAGS,其作用类似于运用命令行时使用--来指定参数。
 var runCommand = cli.Command{
@@ -22,6 +29,22 @@ var runCommand = cli.Command{
 			Name:  "d",
 			Usage: "detach container",
 		},
+		cli.BoolFlag{
+			Name:  "i",
+			Usage: "keep stdin open for attach, use with -d",
+		},
+		cli.BoolFlag{
+			Name:  "rm",
+			Usage: "remove the container when it exits, containers run with -ti in the foreground are always removed",
+		},
+		cli.StringFlag{
+			Name:  "stop-signal",
+			Usage: "signal to stop the container, default is the image's StopSignal or SIGTERM",
+		},
+		cli.StringFlag{
+			Name:  "restart",
+			Usage: "restart policy when the container exits: no, on-failure[:max-retries], always or unless-stopped",
+		},
 		cli.StringFlag{
 			Name:  "m",
 			Usage: "memory limit",
@@ -34,6 +57,46 @@ var runCommand = cli.Command{
 			Name:  "cpuset",
 			Usage: "cpuset limit",
 		},
+		cli.StringFlag{
+			Name:  "cpu-period",
+			Usage: "CPU CFS period in microseconds",
+		},
+		cli.StringFlag{
+			Name:  "cpu-quota",
+			Usage: "CPU CFS quota in microseconds, -1 for unlimited",
+		},
+		cli.StringFlag{
+			Name:  "cpus",
+			Usage: "number of CPUs, converted to CPU CFS quota",
+		},
+		cli.StringFlag{
+			Name:  "memory-swap",
+			Usage: "memory plus swap limit, -1 for unlimited swap",
+		},
+		cli.StringFlag{
+			Name:  "memory-reservation",
+			Usage: "memory soft limit",
+		},
+		cli.StringFlag{
+			Name:  "pids-limit",
+			Usage: "maximum number of processes, -1 for unlimited",
+		},
+		cli.StringFlag{
+			Name:  "blkio-weight",
+			Usage: "block IO weight, between 10 and 1000",
+		},
+		cli.StringSliceFlag{
+			Name:  "device-read-bps",
+			Usage: "limit read rate from a block device ie: --device-read-bps /dev/sda:10m",
+		},
+		cli.StringSliceFlag{
+			Name:  "device-write-bps",
+			Usage: "limit write rate to a block device ie: --device-write-bps /dev/sda:10m",
+		},
+		cli.StringSliceFlag{
+			Name:  "hugetlb-limit",
+			Usage: "limit hugepage usage ie: --hugetlb-limit 2MB:100m",
+		},
 		cli.StringFlag{
 			Name:  "name",
 			Usage: "container name",
@@ -54,6 +117,66 @@ var runCommand = cli.Command{
 			Name: "p",
 			Usage: "port mapping",
 		},
+		cli.StringFlag{
+			Name:  "hostname",
+			Usage: "container host name",
+		},
+		cli.StringFlag{
+			Name:  "w",
+			Usage: "working directory inside the container",
+		},
+		cli.StringFlag{
+			Name:  "u",
+			Usage: "user[:group] to run the command as, names are resolved in the container, ie: -u nobody:nogroup",
+		},
+		cli.StringSliceFlag{
+			Name:  "ulimit",
+			Usage: "ulimit options, ie: nofile=1024:2048",
+		},
+		cli.BoolFlag{
+			Name:  "userns",
+			Usage: "run in a new user namespace, ids are allocated from the " + container.SubIDUser + " entries of /etc/subuid and /etc/subgid unless --uidmap/--gidmap is given",
+		},
+		cli.StringSliceFlag{
+			Name:  "uidmap",
+			Usage: "uid mapping of the user namespace, ie: --uidmap 0:100000:65536",
+		},
+		cli.StringSliceFlag{
+			Name:  "gidmap",
+			Usage: "gid mapping of the user namespace, ie: --gidmap 0:100000:65536",
+		},
+		cli.StringSliceFlag{
+			Name:  "cap-add",
+			Usage: "add linux capabilities, ie: --cap-add NET_ADMIN, ALL for all capabilities",
+		},
+		cli.StringSliceFlag{
+			Name:  "cap-drop",
+			Usage: "drop linux capabilities, ie: --cap-drop MKNOD, ALL for all capabilities",
+		},
+		cli.BoolFlag{
+			Name:  "privileged",
+			Usage: "give all capabilities to the container",
+		},
+		cli.BoolFlag{
+			Name:  "read-only",
+			Usage: "mount the container's root filesystem as read only",
+		},
+		cli.StringSliceFlag{
+			Name:  "tmpfs",
+			Usage: "mount a tmpfs directory, ie: --tmpfs /run:size=64m",
+		},
+		cli.StringSliceFlag{
+			Name:  "device",
+			Usage: "add a host device to the container, ie: --device /dev/fuse:/dev/fuse:rwm",
+		},
+		cli.StringFlag{
+			Name:  "shm-size",
+			Usage: "size of /dev/shm, ie: --shm-size 128m",
+		},
+		cli.StringSliceFlag{
+			Name:  "security-opt",
+			Usage: "security options, ie: --security-opt seccomp=profile.json or seccomp=unconfined",
+		},
 	},
 	//这里是run命令执行的真正函数
 	//1.判断参数书否包含command
@@ -81,14 +204,51 @@ var runCommand = cli.Command{
 
 		createTty := context.Bool("ti")
 		detach := context.Bool("d")
-
-		if createTty && detach {
-			return fmt.Errorf("ti and d paramter can not both provided")
-		}
 		resConf := &subsystems.ResourceConfig{
 			MemoryLimit: context.String("m"),
 			CpuSet:      context.String("cpuset"),
 			CpuShare:    context.String("cpushare"),
+			CpuPeriod:   context.String("cpu-period"),
+			CpuQuota:    context.String("cpu-quota"),
+
+			MemorySwap:        context.String("memory-swap"),
+			MemoryReservation: context.String("memory-reservation"),
+			PidsLimit:         context.String("pids-limit"),
+			BlkioWeight:       context.String("blkio-weight"),
+		}
+		if cpus := context.String("cpus"); cpus != "" {
+			if resConf.CpuQuota != "" {
+				return fmt.Errorf("--cpus and --cpu-quota can not be used together")
+			}
+			quota, period, err := container.ParseCpus(cpus, resConf.CpuPeriod)
+			if err != nil {
+				return err
+			}
+			resConf.CpuQuota, resConf.CpuPeriod = quota, period
+		}
+		for _, val := range context.StringSlice("device-read-bps") {
+			rule, err := container.ParseThrottleDevice(val)
+			if err != nil {
+				return err
+			}
+			resConf.DeviceReadBps = append(resConf.DeviceReadBps, rule)
+		}
+		for _, val := range context.StringSlice("device-write-bps") {
+			rule, err := container.ParseThrottleDevice(val)
+			if err != nil {
+				return err
+			}
+			resConf.DeviceWriteBps = append(resConf.DeviceWriteBps, rule)
+		}
+		for _, val := range context.StringSlice("hugetlb-limit") {
+			limit, err := container.ParseHugetlbLimit(val)
+			if err != nil {
+				return err
+			}
+			resConf.HugetlbLimits = append(resConf.HugetlbLimits, limit)
+		}
+		if err := container.ValidateResources(resConf); err != nil {
+			return err
 		}
 		log.Infof("createTty %v", createTty)
 		containerName := context.String("name")
@@ -98,8 +258,134 @@ var runCommand = cli.Command{
 		envSlice := context.StringSlice("e")
 		portmapping := context.StringSlice("p")
 
-		Run(createTty, cmdArray, resConf, containerName, volume, imageName, envSlice, network, portmapping)
-		return nil
+		spec := &container.InitSpec{
+			Args:     cmdArray,
+			Env:      hostEnv(),
+			Cwd:      context.String("w"),
+			Hostname: context.String("hostname"),
+			User:     context.String("u"),
+			Terminal: createTty,
+
+			ReadonlyRootfs: context.Bool("read-only"),
+		}
+		imageStopSignal, err := applyImageConfig(spec, imageName)
+		if err != nil {
+			return err
+		}
+		//--stop-signal 优先，其次是镜像的StopSignal
+		stopSignal := context.String("stop-signal")
+		if stopSignal == "" {
+			stopSignal = imageStopSignal
+		}
+		if stopSignal != "" {
+			if _, err := container.ParseSignal(stopSignal); err != nil {
+				return err
+			}
+		}
+		spec.Env = append(spec.Env, envSlice...)
+		for _, ulimit := range context.StringSlice("ulimit") {
+			rlimit, err := container.ParseRlimit(ulimit)
+			if err != nil {
+				return err
+			}
+			spec.Rlimits = append(spec.Rlimits, rlimit)
+		}
+		for _, tmpfs := range context.StringSlice("tmpfs") {
+			mount, err := container.ParseTmpfs(tmpfs)
+			if err != nil {
+				return err
+			}
+			spec.Mounts = append(spec.Mounts, mount)
+		}
+		spec.Devices = container.DefaultDevices()
+		for _, val := range context.StringSlice("device") {
+			device, err := container.ParseDevice(val)
+			if err != nil {
+				return err
+			}
+			spec.Devices = append(spec.Devices, device)
+		}
+		if shmSize := context.String("shm-size"); shmSize != "" {
+			size, err := container.ParseSize(shmSize)
+			if err != nil {
+				return err
+			}
+			spec.ShmSize = size
+		}
+		if len(spec.Args) < 1 {
+			return fmt.Errorf("Missing container command")
+		}
+		privileged := context.Bool("privileged")
+		caps, err := container.ComputeCapabilities(context.StringSlice("cap-add"), context.StringSlice("cap-drop"), privileged)
+		if err != nil {
+			return err
+		}
+		spec.Capabilities = caps
+		if !privileged {
+			spec.MaskedPaths = container.DefaultMaskedPaths
+			spec.ReadonlyPaths = container.DefaultReadonlyPaths
+			resConf.Devices = container.DeviceCgroupRules(spec.Devices)
+		}
+		profile, err := seccompProfile(context.StringSlice("security-opt"), privileged)
+		if err != nil {
+			return err
+		}
+		if profile != nil {
+			if spec.Seccomp, err = seccomp.Setup(profile, caps); err != nil {
+				return err
+			}
+			//提前编译一次，profile有问题时不用等到容器启动才报错
+			if _, err := seccomp.Compile(spec.Seccomp); err != nil {
+				return err
+			}
+		}
+		//指定了id映射时隐含 --userns
+		var idMapping *idtools.IdentityMapping
+		uidMaps, gidMaps := context.StringSlice("uidmap"), context.StringSlice("gidmap")
+		if context.Bool("userns") || len(uidMaps) > 0 || len(gidMaps) > 0 {
+			mapping, err := container.NewIdentityMapping(uidMaps, gidMaps)
+			if err != nil {
+				return err
+			}
+			idMapping = mapping
+		}
+
+		restartPolicy, err := container.ParseRestartPolicy(context.String("restart"))
+		if err != nil {
+			return err
+		}
+		//重启策略由shim执行，前台运行的容器总是在退出之后删除
+		if !restartPolicy.IsNone() && (context.Bool("rm") || createTty && !detach) {
+			return fmt.Errorf("restart policy can not be used with --rm or a foreground container")
+		}
+
+		containerID := randStringBytes(10)
+		//如果容器名字为空，就用随机产生的10位字符串作为容器名
+		if containerName == "" {
+			containerName = containerID
+		}
+		cfg := &runConfig{
+			Tty:           createTty,
+			Interactive:   context.Bool("i"),
+			Spec:          spec,
+			Resources:     resConf,
+			ID:            containerID,
+			Name:          containerName,
+			Volume:        volume,
+			Image:         imageName,
+			Network:       network,
+			PortMapping:   portmapping,
+			IDMapping:     idMapping,
+			Privileged:    privileged,
+			AutoRemove:    context.Bool("rm") || createTty && !detach,
+			RestartPolicy: restartPolicy,
+			StopSignal:    stopSignal,
+		}
+		//-ti 在前台运行，否则交给shim在后台运行，之后可以 attach
+		if createTty && !detach {
+			return Run(cfg)
+		}
+		return runDetached(cfg)
 	},
 }
 
@@ -116,6 +402,32 @@ var initCommand = cli.Command{
 	},
 }
 
+var shimCommand = cli.Command{
+	Name:  "shim",
+	Usage: "Hold the stdio of a detached container until it exits. Do not call it outside",
+	Action: func(context *cli.Context) error {
+		return runShim()
+	},
+}
+
+var attachCommand = cli.Command{
+	Name:  "attach",
+	Usage: "attach to the stdio of a detached container ie: mydocker attach [container]",
+	Flags: []cli.Flag{
+		cli.StringFlag{
+			Name:  "detach-keys",
+			Value: term.DefaultDetachKeys,
+			Usage: "key sequence for detaching from the container",
+		},
+	},
+	Action: func(context *cli.Context) error {
+		if len(context.Args()) < 1 {
+			return fmt.Errorf("Missing container name")
+		}
+		return attachContainer(context.Args().Get(0), context.String("detach-keys"))
+	},
+}
+
 var listCommand = cli.Command{
 	Name:  "ps",
 	Usage: "list all the containers",
@@ -125,6 +437,17 @@ var listCommand = cli.Command{
 	},
 }
 
+var inspectCommand = cli.Command{
+	Name:  "inspect",
+	Usage: "show details of a container ie: mydocker inspect [container]",
+	Action: func(context *cli.Context) error {
+		if len(context.Args()) < 1 {
+			return fmt.Errorf("Missing container name")
+		}
+		return inspectContainerInfo(context.Args().Get(0))
+	},
+}
+
 var logCommand = cli.Command{
 	Name:  "logs",
 	Usage: "print logs of a container",
@@ -141,10 +464,20 @@ var logCommand = cli.Command{
 var execCommand = cli.Command{
 	Name:  "exec",
 	Usage: "exec a command into container",
+	Flags: []cli.Flag{
+		cli.BoolFlag{
+			Name:  "ti",
+			Usage: "allocate a pseudo-terminal for the command",
+		},
+		cli.StringFlag{
+			Name:  "u",
+			Usage: "user[:group] to run the command as, names are resolved in the container",
+		},
+	},
 	Action: func(context *cli.Context) error {
 		//This is for callback
 		if os.Getenv(ENV_EXEC_PID) != "" {
-			log.Infof("pid callback pid %s", os.Getgid())
+			log.Infof("pid callback pid %d", os.Getgid())
 			return nil
 		}
 
@@ -156,7 +489,7 @@ var execCommand = cli.Command{
 		for _, arg := range context.Args().Tail() {
 			commandArray = append(commandArray, arg)
 		}
-		ExecContainer(containerName, commandArray)
+		ExecContainer(containerName, commandArray, context.String("u"), context.Bool("ti"))
 		return nil
 	},
 }
@@ -164,13 +497,79 @@ var execCommand = cli.Command{
 var stopCommand = cli.Command{
 	Name:  "stop",
 	Usage: "stop a container",
+	Flags: []cli.Flag{
+		cli.IntFlag{
+			Name:  "t",
+			Value: 10,
+			Usage: "seconds to wait for the container to exit before killing it",
+		},
+	},
 	Action: func(context *cli.Context) error {
 		if len(context.Args()) < 1 {
 			return fmt.Errorf("Missing container name")
 		}
 		containerName := context.Args().Get(0)
-		stopContainer(containerName)
-		return nil
+		timeout := time.Duration(context.Int("t")) * time.Second
+		return stopContainer(containerName, timeout)
+	},
+}
+
+var pauseCommand = cli.Command{
+	Name:  "pause",
+	Usage: "freeze all processes of a container",
+	Action: func(context *cli.Context) error {
+		if len(context.Args()) < 1 {
+			return fmt.Errorf("Missing container name")
+		}
+		return pauseContainer(context.Args().Get(0))
+	},
+}
+
+var unpauseCommand = cli.Command{
+	Name:  "unpause",
+	Usage: "thaw all processes of a paused container",
+	Action: func(context *cli.Context) error {
+		if len(context.Args()) < 1 {
+			return fmt.Errorf("Missing container name")
+		}
+		return unpauseContainer(context.Args().Get(0))
+	},
+}
+
+var killCommand = cli.Command{
+	Name:  "kill",
+	Usage: "send a signal to the init process of a container",
+	Flags: []cli.Flag{
+		cli.StringFlag{
+			Name:  "s",
+			Value: "SIGKILL",
+			Usage: "signal to send, like SIGTERM, HUP or 9",
+		},
+	},
+	Action: func(context *cli.Context) error {
+		if len(context.Args()) < 1 {
+			return fmt.Errorf("Missing container name")
+		}
+		return killContainer(context.Args().Get(0), context.String("s"))
+	},
+}
+
+var restartCommand = cli.Command{
+	Name:  "restart",
+	Usage: "stop a container and start it again",
+	Flags: []cli.Flag{
+		cli.IntFlag{
+			Name:  "t",
+			Value: 10,
+			Usage: "seconds to wait for stop before killing the container",
+		},
+	},
+	Action: func(context *cli.Context) error {
+		if len(context.Args()) < 1 {
+			return fmt.Errorf("Missing container name")
+		}
+		timeout := time.Duration(context.Int("t")) * time.Second
+		return restartContainer(context.Args().Get(0), timeout)
 	},
 }
 
@@ -189,18 +588,231 @@ var removeCommand = cli.Command{
 
 var commitCommand = cli.Command{
 	Name:  "commit",
-	Usage: "commit a container into image",
+	Usage: "commit the write layer of a container into a new image ie: mydocker commit [container] [image]",
+	Flags: []cli.Flag{
+		cli.StringFlag{
+			Name:  "a",
+			Usage: "author of the image",
+		},
+		cli.StringFlag{
+			Name:  "m",
+			Usage: "commit message",
+		},
+		cli.StringSliceFlag{
+			Name:  "c",
+			Usage: "apply Dockerfile instruction to the image, ie: -c 'ENV FOO=bar' -c 'CMD [\"sh\"]'",
+		},
+	},
 	Action: func(context *cli.Context) error {
 		if len(context.Args()) < 2 {
 			return fmt.Errorf("Missing container name and image name")
 		}
 		containerName := context.Args().Get(0)
 		imageName := context.Args().Get(1)
-		commitContainer(containerName, imageName)
+		return commitContainer(containerName, imageName, image.CommitOptions{
+			Author:  context.String("a"),
+			Comment: context.String("m"),
+			Changes: context.StringSlice("c"),
+		})
+	},
+}
+
+var createCommand = cli.Command{
+	Name:  "create",
+	Usage: "create a container from an OCI bundle ie: mydocker create --bundle [dir] [container-id]",
+	Flags: []cli.Flag{
+		cli.StringFlag{
+			Name:  "bundle, b",
+			Value: ".",
+			Usage: "path to the OCI bundle directory",
+		},
+	},
+	Action: func(context *cli.Context) error {
+		if len(context.Args()) < 1 {
+			return fmt.Errorf("Missing container id")
+		}
+		return createContainer(context.Args().Get(0), context.String("bundle"))
+	},
+}
+
+var startCommand = cli.Command{
+	Name:  "start",
+	Usage: "start a created container, or run a stopped container again",
+	Action: func(context *cli.Context) error {
+		if len(context.Args()) < 1 {
+			return fmt.Errorf("Missing container id")
+		}
+		return startContainer(context.Args().Get(0))
+	},
+}
+
+var stateCommand = cli.Command{
+	Name:  "state",
+	Usage: "output the OCI state of a container",
+	Action: func(context *cli.Context) error {
+		if len(context.Args()) < 1 {
+			return fmt.Errorf("Missing container id")
+		}
+		return stateContainer(context.Args().Get(0))
+	},
+}
+
+var pullCommand = cli.Command{
+	Name:  "pull",
+	Usage: "import an OCI image layout directory or tar ie: mydocker pull [layout] [image]",
+	Flags: []cli.Flag{
+		cli.StringFlag{
+			Name:  "ref",
+			Usage: "org.opencontainers.image.ref.name of the manifest to import",
+		},
+	},
+	Action: func(context *cli.Context) error {
+		if len(context.Args()) < 1 {
+			return fmt.Errorf("Missing image layout path")
+		}
+		layoutPath := context.Args().Get(0)
+		imageName := context.Args().Get(1)
+		if imageName == "" {
+			imageName = context.String("ref")
+		}
+		if imageName == "" {
+			imageName = strings.TrimSuffix(filepath.Base(layoutPath), ".tar")
+		}
+		if _, err := image.Import(layoutPath, context.String("ref"), imageName); err != nil {
+			return fmt.Errorf("pull image error: %v", err)
+		}
 		return nil
 	},
 }
 
+var imagesCommand = cli.Command{
+	Name:  "images",
+	Usage: "list images",
+	Action: func(context *cli.Context) error {
+		return listImages()
+	},
+}
+
+var rmiCommand = cli.Command{
+	Name:  "rmi",
+	Usage: "remove images ie: mydocker rmi [image]...",
+	Action: func(context *cli.Context) error {
+		if len(context.Args()) < 1 {
+			return fmt.Errorf("Missing image name")
+		}
+		for _, ref := range context.Args() {
+			if err := removeImage(ref); err != nil {
+				return fmt.Errorf("remove image error: %v", err)
+			}
+		}
+		return nil
+	},
+}
+
+var imageCommand = cli.Command{
+	Name:  "image",
+	Usage: "image commands",
+	Subcommands: []cli.Command{
+		{
+			Name:  "inspect",
+			Usage: "show details of an image",
+			Action: func(context *cli.Context) error {
+				if len(context.Args()) < 1 {
+					return fmt.Errorf("Missing image name")
+				}
+				return inspectImage(context.Args().Get(0))
+			},
+		},
+	},
+}
+
+var exportCommand = cli.Command{
+	Name:  "export",
+	Usage: "export the rootfs of a container as a tar archive ie: mydocker export -o [file] [container]",
+	Flags: []cli.Flag{
+		cli.StringFlag{
+			Name:  "o",
+			Usage: "output file, .tar.gz .tar.bz2 .tar.xz are compressed, default [container].tar",
+		},
+	},
+	Action: func(context *cli.Context) error {
+		if len(context.Args()) < 1 {
+			return fmt.Errorf("Missing container name")
+		}
+		containerName := context.Args().Get(0)
+		output := context.String("o")
+		if output == "" {
+			output = containerName + ".tar"
+		}
+		return exportContainer(containerName, output)
+	},
+}
+
+var importCommand = cli.Command{
+	Name:  "import",
+	Usage: "import a rootfs tar archive as an image ie: mydocker import [file] [image]",
+	Flags: []cli.Flag{
+		cli.StringFlag{
+			Name:  "m",
+			Usage: "commit message",
+		},
+		cli.StringSliceFlag{
+			Name:  "c",
+			Usage: "apply Dockerfile instruction to the image",
+		},
+	},
+	Action: func(context *cli.Context) error {
+		if len(context.Args()) < 2 {
+			return fmt.Errorf("Missing tar file and image name")
+		}
+		return importImage(context.Args().Get(0), context.Args().Get(1), image.CommitOptions{
+			Comment: context.String("m"),
+			Changes: context.StringSlice("c"),
+		})
+	},
+}
+
+var saveCommand = cli.Command{
+	Name:  "save",
+	Usage: "save images with all their layers to an archive ie: mydocker save -o [file] [image]...",
+	Flags: []cli.Flag{
+		cli.StringFlag{
+			Name:  "o",
+			Usage: "output file, .tar.gz .tar.bz2 .tar.xz are compressed",
+		},
+	},
+	Action: func(context *cli.Context) error {
+		if len(context.Args()) < 1 {
+			return fmt.Errorf("Missing image name")
+		}
+		if context.String("o") == "" {
+			return fmt.Errorf("Missing output file")
+		}
+		return saveImages(context.Args(), context.String("o"))
+	},
+}
+
+var loadCommand = cli.Command{
+	Name:  "load",
+	Usage: "load images from an archive created by save ie: mydocker load -i [file]",
+	Flags: []cli.Flag{
+		cli.StringFlag{
+			Name:  "i",
+			Usage: "input file",
+		},
+	},
+	Action: func(context *cli.Context) error {
+		input := context.String("i")
+		if input == "" {
+			input = context.Args().Get(0)
+		}
+		if input == "" {
+			return fmt.Errorf("Missing input file")
+		}
+		return loadImages(input)
+	},
+}
+
 var networkCommand = cli.Command{
 	Name:  "network",
 	Usage: "container network commands",
@@ -69,7 +69,12 @@ func (d *BridgeNetworkDriver) Connect(network *Network, endpoint *Endpoint) erro
 }
 
 func (d *BridgeNetworkDriver) Disconnect(network Network, endpoint *Endpoint) error {
-	return nil
+	// 容器的network namespace销毁时veth会一起删除，这里只处理还留在宿主机上的
+	link, err := netlink.LinkByName(endpoint.ID[:5])
+	if err != nil {
+		return nil
+	}
+	return netlink.LinkDel(link)
 }
 
 
@@ -266,14 +266,19 @@ func configEndpointIpAddressAndRoute(ep *Endpoint, cinfo *container.ContainerInf
 }
 
 func configPortMapping(ep *Endpoint, cinfo *container.ContainerInfo) error {
+	return setPortMapping(ep, "-A")
+}
+
+// setPortMapping action为-A时添加端口映射的DNAT规则，为-D时删除
+func setPortMapping(ep *Endpoint, action string) error {
 	for _, pm := range ep.PortMapping {
 		portMapping :=strings.Split(pm, ":")
 		if len(portMapping) != 2 {
 			logrus.Errorf("port mapping format error, %v", pm)
 			continue
 		}
-		iptablesCmd := fmt.Sprintf("-t nat -A PREROUTING -p tcp -m tcp --dport %s -j DNAT --to-destination %s:%s",
-			portMapping[0], ep.IPAddress.String(), portMapping[1])
+		iptablesCmd := fmt.Sprintf("-t nat %s PREROUTING -p tcp -m tcp --dport %s -j DNAT --to-destination %s:%s",
+			action, portMapping[0], ep.IPAddress.String(), portMapping[1])
 		cmd := exec.Command("iptables", strings.Split(iptablesCmd, " ")...)
 		//err := cmd.Run()
 		output, err := cmd.Output()
@@ -291,11 +296,12 @@ func Connect(networkName string, cinfo *container.ContainerInfo) error {
 		return fmt.Errorf("No Such Network: %s", networkName)
 	}
 
-	// 分配容器IP地址
+	// 分配容器IP地址，记录在容器信息中，容器退出时Disconnect释放
 	ip, err := ipAllocator.Allocate(network.IpRange)
 	if err != nil {
 		return err
 	}
+	cinfo.IPAddress = ip.String()
 
 	// 创建网络端点
 	ep := &Endpoint{
@@ -316,6 +322,25 @@ func Connect(networkName string, cinfo *container.ContainerInfo) error {
 	return configPortMapping(ep, cinfo)
 }
 
+// Disconnect 在容器退出之后调用：删除端口映射，删除还留着的veth，释放容器的IP
 func Disconnect(networkName string, cinfo *container.ContainerInfo) error {
-	return nil
+	network, ok := networks[networkName]
+	if !ok {
+		return fmt.Errorf("No Such Network: %s", networkName)
+	}
+	ip := net.ParseIP(cinfo.IPAddress)
+	if ip == nil {
+		return fmt.Errorf("container %s has no ip in network %s", cinfo.Name, networkName)
+	}
+	ep := &Endpoint{
+		ID: fmt.Sprintf("%s-%s", cinfo.Id, networkName),
+		IPAddress: ip,
+		Network: network,
+		PortMapping: cinfo.PortMapping,
+	}
+	setPortMapping(ep, "-D")
+	if err := drivers[network.Driver].Disconnect(*network, ep); err != nil {
+		return err
+	}
+	return ipAllocator.Release(network.IpRange, &ip)
 }
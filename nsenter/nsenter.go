@@ -9,6 +9,104 @@ package nsenter
 #include <stdlib.h>
 #include <string.h>
 #include <fcntl.h>
+#include <grp.h>
+#include <sys/prctl.h>
+#include <sys/syscall.h>
+#include <linux/capability.h>
+#include <linux/filter.h>
+#include <linux/seccomp.h>
+
+// switch_user 切换到 uid:gid:附加组1,附加组2 指定的用户，失败时直接退出，不能以root继续执行
+static void switch_user(char *spec) {
+	gid_t groups[256];
+	int ngroups = 0;
+	unsigned int uid, gid;
+	char *p;
+	if (sscanf(spec, "%u:%u", &uid, &gid) != 2) {
+		fprintf(stderr, "invalid exec user %s\n", spec);
+		exit(1);
+	}
+	p = strchr(strchr(spec, ':') + 1, ':');
+	if (p != NULL) {
+		p++;
+		while (*p != '\0' && ngroups < 256) {
+			groups[ngroups++] = (gid_t)strtoul(p, &p, 10);
+			if (*p != ',') {
+				break;
+			}
+			p++;
+		}
+	}
+	if (setgroups(ngroups, groups) == -1 || setresgid(gid, gid, gid) == -1 || setresuid(uid, uid, uid) == -1) {
+		fprintf(stderr, "switch to user %s failed: %s\n", spec, strerror(errno));
+		exit(1);
+	}
+}
+
+// drop_bounding_set 从bounding set中去掉不在mask中的capability，需要在切换用户之前调用
+static void drop_bounding_set(unsigned long long mask) {
+	int c;
+	for (c = 0; c < 64; c++) {
+		if (mask & (1ULL << c)) {
+			continue;
+		}
+		// 超出内核支持范围的capability返回EINVAL
+		if (prctl(PR_CAPBSET_DROP, c, 0, 0, 0) == -1 && errno != EINVAL) {
+			fprintf(stderr, "drop capability %d failed: %s\n", c, strerror(errno));
+			exit(1);
+		}
+	}
+	prctl(PR_CAP_AMBIENT, PR_CAP_AMBIENT_CLEAR_ALL, 0, 0, 0);
+}
+
+// limit_capabilities 把当前持有的capability限制在mask之内
+static void limit_capabilities(unsigned long long mask) {
+	struct __user_cap_header_struct hdr = { _LINUX_CAPABILITY_VERSION_3, 0 };
+	struct __user_cap_data_struct data[2];
+	int i;
+	if (syscall(SYS_capget, &hdr, data) == -1) {
+		fprintf(stderr, "capget failed: %s\n", strerror(errno));
+		exit(1);
+	}
+	for (i = 0; i < 2; i++) {
+		data[i].permitted &= (unsigned int)(mask >> (32 * i));
+		data[i].effective = data[i].permitted;
+		data[i].inheritable = data[i].permitted;
+	}
+	if (syscall(SYS_capset, &hdr, data) == -1) {
+		fprintf(stderr, "capset failed: %s\n", strerror(errno));
+		exit(1);
+	}
+}
+
+// install_seccomp 安装由十六进制编码的struct sock_filter数组，需要在去掉CAP_SYS_ADMIN之前调用
+static void install_seccomp(char *hex) {
+	size_t len = strlen(hex) / 2;
+	unsigned char *buf;
+	size_t i;
+	struct sock_fprog prog;
+	if (len == 0 || len % sizeof(struct sock_filter) != 0) {
+		fprintf(stderr, "invalid seccomp filter\n");
+		exit(1);
+	}
+	buf = malloc(len);
+	if (buf == NULL) {
+		exit(1);
+	}
+	for (i = 0; i < len; i++) {
+		if (sscanf(hex + 2 * i, "%2hhx", &buf[i]) != 1) {
+			fprintf(stderr, "invalid seccomp filter\n");
+			exit(1);
+		}
+	}
+	prog.len = len / sizeof(struct sock_filter);
+	prog.filter = (struct sock_filter *)buf;
+	if (prctl(PR_SET_SECCOMP, SECCOMP_MODE_FILTER, &prog, 0, 0) == -1) {
+		fprintf(stderr, "install seccomp filter failed: %s\n", strerror(errno));
+		exit(1);
+	}
+	free(buf);
+}
 
 __attribute__((constructor)) void enter_namespace(void) {
 	char *mydocker_pid;
@@ -28,10 +126,12 @@ __attribute__((constructor)) void enter_namespace(void) {
 		return;
 	}
 	int i;
+	int joined_user = 0;
 	char nspath[1024];
-	char *namespaces[] = { "ipc", "uts", "net", "pid", "mnt" };
+	// user要最先加入，之后才有权限加入它拥有的其他namespace
+	char *namespaces[] = { "user", "ipc", "uts", "net", "pid", "mnt" };
 
-	for (i=0; i<5; i++) {
+	for (i=0; i<6; i++) {
 		sprintf(nspath, "/proc/%s/ns/%s", mydocker_pid, namespaces[i]);
 		int fd = open(nspath, O_RDONLY);
 
@@ -39,9 +139,52 @@ __attribute__((constructor)) void enter_namespace(void) {
 			//fprintf(stderr, "setns on %s namespace failed: %s\n", namespaces[i], strerror(errno));
 		} else {
 			//fprintf(stdout, "setns on %s namespace succeeded\n", namespaces[i]);
+			if (i == 0) {
+				joined_user = 1;
+			}
 		}
 		close(fd);
 	}
+	// 容器和宿主机共用user namespace时setns会失败。加入了容器的user namespace时，
+	// 宿主机的root在里面没有映射，要切换成容器内的root
+	if (joined_user) {
+		setgroups(0, NULL);
+		setresgid(0, 0, 0);
+		setresuid(0, 0, 0);
+	}
+	char *mydocker_caps = getenv("mydocker_caps");
+	unsigned long long cap_mask = 0;
+	if (mydocker_caps) {
+		cap_mask = strtoull(mydocker_caps, NULL, 16);
+		drop_bounding_set(cap_mask);
+	}
+	char *mydocker_seccomp = getenv("mydocker_seccomp");
+	if (mydocker_seccomp) {
+		install_seccomp(mydocker_seccomp);
+	}
+	char *mydocker_user = getenv("mydocker_user");
+	if (mydocker_user) {
+		switch_user(mydocker_user);
+	}
+	if (mydocker_caps && geteuid() == 0) {
+		limit_capabilities(cap_mask);
+	}
+	// exec的CLI把这个进程加入容器的cgroup之后才运行命令，命令fork出来的进程都在cgroup中
+	char *mydocker_sync = getenv("mydocker_sync");
+	if (mydocker_sync) {
+		int sync_fd = atoi(mydocker_sync);
+		char c;
+		read(sync_fd, &c, 1);
+		close(sync_fd);
+	}
+	// 控制变量不能出现在exec的命令的环境变量中，system之前去掉
+	mydocker_cmd = strdup(mydocker_cmd);
+	unsetenv("mydocker_pid");
+	unsetenv("mydocker_cmd");
+	unsetenv("mydocker_caps");
+	unsetenv("mydocker_seccomp");
+	unsetenv("mydocker_user");
+	unsetenv("mydocker_sync");
 	int res = system(mydocker_cmd);
 	exit(0);
 	return;
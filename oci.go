@@ -0,0 +1,187 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	log "github.com/Sirupsen/logrus"
+	"github.com/xianlubird/mydocker/cgroups"
+	"github.com/xianlubird/mydocker/container"
+	"golang.org/x/sys/unix"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+//按照OCI bundle创建容器，init进程完成所有初始化之后阻塞在exec fifo上，等待 start
+func createContainer(containerID, bundle string) error {
+	bundle, err := filepath.Abs(bundle)
+	if err != nil {
+		return err
+	}
+	spec, err := container.LoadBundle(bundle)
+	if err != nil {
+		return err
+	}
+	attr, err := spec.SysProcAttr()
+	if err != nil {
+		return err
+	}
+	resConf, err := spec.Resources()
+	if err != nil {
+		return err
+	}
+	// create没有console socket，init的标准输入输出不是终端，不能假装支持
+	if spec.Process.Terminal {
+		return fmt.Errorf("process.terminal is not supported by create, set it to false")
+	}
+
+	dirURL := fmt.Sprintf(container.DefaultInfoLocation, containerID)
+	if exist, _ := container.PathExists(dirURL); exist {
+		return fmt.Errorf("container %s already exists", containerID)
+	}
+	if err := os.MkdirAll(dirURL, 0622); err != nil {
+		return fmt.Errorf("mkdir %s error %v", dirURL, err)
+	}
+	// 任何一步失败都删除容器目录和其中的exec fifo，不留下创建了一半的容器
+	created := false
+	defer func() {
+		if !created {
+			if err := os.RemoveAll(dirURL); err != nil {
+				log.Warnf("Remove %s error %v", dirURL, err)
+			}
+		}
+	}()
+	fifoPath := filepath.Join(dirURL, container.ExecFifoName)
+	if err := unix.Mkfifo(fifoPath, 0622); err != nil {
+		return fmt.Errorf("create exec fifo %s error %v", fifoPath, err)
+	}
+	// Mkfifo受umask影响。user namespace中的init在宿主机上是普通用户，要能写fifo
+	if err := os.Chmod(fifoPath, 0622); err != nil {
+		return fmt.Errorf("chmod exec fifo %s error %v", fifoPath, err)
+	}
+	// init也穿不过容器目录，fifo由我们打开之后作为fd传给它
+	fifo, err := os.OpenFile(fifoPath, unix.O_PATH|unix.O_CLOEXEC, 0)
+	if err != nil {
+		return fmt.Errorf("open exec fifo %s error %v", fifoPath, err)
+	}
+	defer fifo.Close()
+
+	parent, writePipe := container.NewBundleProcess(containerID, spec.RootfsPath(bundle), attr)
+	if parent == nil {
+		return fmt.Errorf("new bundle process error")
+	}
+	parent.ExtraFiles = append(parent.ExtraFiles, fifo)
+	if err := parent.Start(); err != nil {
+		writePipe.Close()
+		return fmt.Errorf("start init process error %v", err)
+	}
+
+	// 先把init放进cgroup，记录成created之后容器里的进程一定是受限制的
+	cgroupManager := cgroups.NewCgroupManager(containerID, "")
+	// 后面的步骤失败时杀掉已经启动的init，删除它的cgroup
+	abort := func() {
+		writePipe.Close()
+		parent.Process.Kill()
+		parent.Wait()
+		cgroupManager.Destroy()
+	}
+	if err := cgroupManager.Set(resConf); err != nil {
+		abort()
+		return err
+	}
+	if err := cgroupManager.Apply(parent.Process.Pid); err != nil {
+		abort()
+		return err
+	}
+
+	initSpec := spec.InitSpec()
+	initSpec.ExecFifo = fmt.Sprintf("/proc/self/fd/%d", 3+len(parent.ExtraFiles)-1)
+	containerInfo := &container.ContainerInfo{
+		Id:           containerID,
+		Pid:          strconv.Itoa(parent.Process.Pid),
+		Name:         containerID,
+		Command:      strings.Join(spec.Process.Args, " "),
+		CreatedTime:  time.Now().Format(container.TimeFormat),
+		Status:       container.CREATED,
+		Bundle:       bundle,
+		CgroupDriver: cgroups.CgroupDriver(),
+		Capabilities: initSpec.Capabilities,
+		Seccomp:      initSpec.Seccomp,
+	}
+	if err := writeContainerInfo(containerInfo); err != nil {
+		abort()
+		return err
+	}
+
+	created = true
+	sendInitCommand(initSpec, writePipe)
+	return nil
+}
+
+//打开exec fifo让阻塞的init进程继续执行用户命令，run创建的容器停止之后重新运行
+func startContainer(containerID string) error {
+	containerInfo, err := inspectContainer(containerID)
+	if err != nil {
+		return err
+	}
+	if containerInfo.Bundle == "" {
+		return startStoppedContainer(containerInfo)
+	}
+	if containerInfo.Status != container.CREATED {
+		return fmt.Errorf("container %s is %s, only created container can be started", containerID, containerInfo.Status)
+	}
+	fifoPath := filepath.Join(fmt.Sprintf(container.DefaultInfoLocation, containerID), container.ExecFifoName)
+	fifo, err := os.OpenFile(fifoPath, os.O_RDONLY, 0)
+	if err != nil {
+		return fmt.Errorf("open exec fifo %s error %v", fifoPath, err)
+	}
+	content, err := ioutil.ReadAll(fifo)
+	fifo.Close()
+	if err != nil {
+		return fmt.Errorf("read exec fifo error %v", err)
+	}
+	if len(content) == 0 {
+		return fmt.Errorf("container %s init process exited before start", containerID)
+	}
+	if err := os.Remove(fifoPath); err != nil {
+		log.Warnf("Remove exec fifo %s error %v", fifoPath, err)
+	}
+	return updateContainerInfo(containerID, func(info *container.ContainerInfo) {
+		if err := info.Transition(container.RUNNING); err != nil {
+			log.Warnf("Start container %s: %v", containerID, err)
+		}
+	})
+}
+
+func stateContainer(containerID string) error {
+	containerInfo, err := inspectContainer(containerID)
+	if err != nil {
+		return err
+	}
+	state := &container.OCIState{
+		Version: container.OCIVersion,
+		ID:      containerInfo.Id,
+		Status:  containerInfo.Status,
+		Bundle:  containerInfo.Bundle,
+	}
+	if containerInfo.IsActive() {
+		state.Pid, _ = strconv.Atoi(strings.TrimSpace(containerInfo.Pid))
+	} else {
+		// OCI中进程退出之后都是stopped
+		state.Status = container.STOP
+	}
+	if containerInfo.Bundle != "" {
+		if spec, err := container.LoadBundle(containerInfo.Bundle); err == nil {
+			state.Annotations = spec.Annotations
+		}
+	}
+	content, err := json.MarshalIndent(state, "", "  ")
+	if err != nil {
+		return err
+	}
+	fmt.Fprintln(os.Stdout, string(content))
+	return nil
+}
@@ -0,0 +1,41 @@
+package main
+
+import (
+	"fmt"
+	"github.com/xianlubird/mydocker/cgroups"
+	"github.com/xianlubird/mydocker/container"
+)
+
+// pauseContainer 通过freezer冻结容器cgroup中的所有进程，包括exec进去的进程
+func pauseContainer(containerName string) error {
+	containerInfo, err := inspectContainer(containerName)
+	if err != nil {
+		return err
+	}
+	if containerInfo.Status != container.RUNNING {
+		return fmt.Errorf("container %s is %s, only running container can be paused", containerName, containerInfo.Status)
+	}
+	if err := cgroups.NewCgroupManager(containerInfo.Id, containerInfo.CgroupDriver).Freeze(true); err != nil {
+		return fmt.Errorf("pause container %s error %v", containerName, err)
+	}
+	return updateContainerInfo(containerName, func(info *container.ContainerInfo) {
+		info.Transition(container.PAUSED)
+	})
+}
+
+// unpauseContainer 解冻容器中的所有进程
+func unpauseContainer(containerName string) error {
+	containerInfo, err := inspectContainer(containerName)
+	if err != nil {
+		return err
+	}
+	if containerInfo.Status != container.PAUSED {
+		return fmt.Errorf("container %s is %s, only paused container can be unpaused", containerName, containerInfo.Status)
+	}
+	if err := cgroups.NewCgroupManager(containerInfo.Id, containerInfo.CgroupDriver).Freeze(false); err != nil {
+		return fmt.Errorf("unpause container %s error %v", containerName, err)
+	}
+	return updateContainerInfo(containerName, func(info *container.ContainerInfo) {
+		info.Transition(container.RUNNING)
+	})
+}
@@ -7,117 +7,348 @@ import (
 	"github.com/xianlubird/mydocker/cgroups"
 	"github.com/xianlubird/mydocker/cgroups/subsystems"
 	"github.com/xianlubird/mydocker/container"
+	"github.com/xianlubird/mydocker/idtools"
+	"github.com/xianlubird/mydocker/image"
 	"github.com/xianlubird/mydocker/network"
+	"github.com/xianlubird/mydocker/seccomp"
 	"math/rand"
 	"os"
+	"os/exec"
 	"strconv"
 	"strings"
+	"syscall"
 	"time"
 )
+// runConfig 是run命令解析出来的参数，detach时序列化之后交给shim
+type runConfig struct {
+	Tty           bool                       `json:"tty"`         //分配pty
+	Interactive   bool                       `json:"interactive"` //-i，保持容器的标准输入
+	Spec          *container.InitSpec        `json:"spec"`
+	Resources     *subsystems.ResourceConfig `json:"resources"`
+	ID            string                     `json:"id"`
+	Name          string                     `json:"name"`
+	Volume        string                     `json:"volume"`
+	Image         string                     `json:"image"`
+	Network       string                     `json:"network"`
+	PortMapping   []string                   `json:"portmapping"`
+	IDMapping     *idtools.IdentityMapping   `json:"idMapping,omitempty"`
+	Privileged    bool                       `json:"privileged"`
+	AutoRemove    bool                       `json:"autoRemove"` //--rm，前台运行的容器也总是删除
+	Existing      bool                       `json:"existing"`   //start已经停止的容器，沿用原来的可写层和容器信息
+	RestartPolicy *container.RestartPolicy   `json:"restartPolicy,omitempty"`
+	StopSignal    string                     `json:"stopSignal,omitempty"`
+
+	restarting bool //shim按重启策略重新运行容器，容器在等待期间被stop的话不再运行
+}
+
 //main函数中的Run做了什么？
-func Run(tty bool, comArray []string, res *subsystems.ResourceConfig, containerName, volume, imageName string,
-	envSlice []string, nw string, portmapping []string) {
-	//获取10位字符串给containerdID
-	containerID := randStringBytes(10)
-	//如果容器名字为空，就用上述随机产生的10位字符创容器ID
-	if containerName == "" {
-		containerName = containerID
+//run -ti 时在前台运行容器，当前终端接到容器的pty上，等容器退出之后清理
+func Run(cfg *runConfig) error {
+	parent, processIO, cgroupManager, err := launchContainer(cfg)
+	if err != nil {
+		return err
 	}
+	detachTty := processIO.Pty.Attach()
+	parent.Wait()
+	detachTty()
+	containerExited(cfg.Name, cgroupManager, parent.ProcessState)
+	return nil
+}
 
-	parent, writePipe := container.NewParentProcess(tty, containerName, volume, imageName, envSlice)
+// launchContainer 启动容器的init进程，记录容器信息，设置cgroup和网络之后把InitSpec发给init
+func launchContainer(cfg *runConfig) (*exec.Cmd, *container.ProcessIO, *cgroups.CgroupManager, error) {
+	parent, writePipe, processIO := container.NewParentProcess(cfg.Tty, cfg.Interactive, cfg.Name, cfg.Volume, cfg.Image, cfg.IDMapping)
 	if parent == nil {
-		log.Errorf("New parent process error")
-		return
+		return nil, nil, nil, fmt.Errorf("new parent process error")
 	}
 	//这里的Start方法才是真正开始前面创建好的command的调用，首先会clone一个Namespace隔离的进程。
 	//然后在子进程中，调用/proc/self/exe,也就是调用自己，发送init参数，调用我们写的init方法，去初始化容器的一些资源。
 	if err := parent.Start(); err != nil {
-		log.Error(err)
+		processIO.Close()
+		return nil, nil, nil, err
 	}
+	processIO.CloseChild()
 
-	//record container info
-	containerName, err := recordContainerInfo(parent.Process.Pid, comArray, containerName, containerID, volume)
-	if err != nil {
-		log.Errorf("Record container info error %v", err)
-		return
+	// use containerID as cgroup name
+	cgroupManager := cgroups.NewCgroupManager(cfg.ID, "")
+
+	// 后面的步骤失败时杀掉还在等InitSpec的init，把已经准备好的网络、rootfs和cgroup清理掉，
+	// start已有的容器时保留rootfs和容器信息
+	netInfo := &container.ContainerInfo{
+		Id:          cfg.ID,
+		Pid:         strconv.Itoa(parent.Process.Pid),
+		Name:        cfg.Name,
+		PortMapping: cfg.PortMapping,
+	}
+	fail := func(err error) (*exec.Cmd, *container.ProcessIO, *cgroups.CgroupManager, error) {
+		if netInfo.IPAddress != "" {
+			network.Disconnect(cfg.Network, netInfo)
+		}
+		writePipe.Close()
+		parent.Process.Kill()
+		parent.Wait()
+		processIO.Close()
+		cgroupManager.Destroy()
+		if !cfg.Existing {
+			container.DeleteWorkSpace(cfg.Volume, cfg.Name, "")
+			deleteContainerInfo(cfg.Name)
+		}
+		return nil, nil, nil, err
 	}
 
-	// use containerID as cgroup name
-	cgroupManager := cgroups.NewCgroupManager(containerID)
-	defer cgroupManager.Destroy()
-	cgroupManager.Set(res)
-	cgroupManager.Apply(parent.Process.Pid)
+	// 限制设不上时不能让容器在没有限制的情况下运行
+	if err := cgroupManager.Set(cfg.Resources); err != nil {
+		return fail(err)
+	}
+	if err := cgroupManager.Apply(parent.Process.Pid); err != nil {
+		return fail(err)
+	}
 
-	if nw != "" {
+	if cfg.Network != "" {
 		// config container network
 		network.Init()
-		containerInfo := &container.ContainerInfo{
-			Id:          containerID,
-			Pid:         strconv.Itoa(parent.Process.Pid),
-			Name:        containerName,
-			PortMapping: portmapping,
-		}
-		if err := network.Connect(nw, containerInfo); err != nil {
-			log.Errorf("Error Connect Network %v", err)
-			return
+		if err := network.Connect(cfg.Network, netInfo); err != nil {
+			return fail(fmt.Errorf("connect network error %v", err))
 		}
 	}
 
-	sendInitCommand(comArray, writePipe)
-
-	if tty {
-		parent.Wait()
-		deleteContainerInfo(containerName)
-		container.DeleteWorkSpace(volume, containerName)
+	//record container info
+	record := recordContainerInfo
+	if cfg.Existing {
+		record = recordContainerStart
+	}
+	if err := record(parent.Process.Pid, cfg, netInfo.IPAddress); err != nil {
+		return fail(fmt.Errorf("record container info error %v", err))
 	}
 
+	sendInitCommand(cfg.Spec, writePipe)
+	return parent, processIO, cgroupManager, nil
 }
 
-func sendInitCommand(comArray []string, writePipe *os.File) {
-	command := strings.Join(comArray, " ")
-	log.Infof("command all is %s", command)
-	writePipe.WriteString(command)
-	writePipe.Close()
+func sendInitCommand(spec *container.InitSpec, writePipe *os.File) {
+	defer writePipe.Close()
+	log.Infof("command all is %q", spec.Args)
+	if err := container.WriteInitSpec(writePipe, spec); err != nil {
+		log.Errorf("Send init spec error %v", err)
+	}
 }
 
-func recordContainerInfo(containerPID int, commandArray []string, containerName, id, volume string) (string, error) {
-	createTime := time.Now().Format("2006-01-02 15:04:05")
-	command := strings.Join(commandArray, "")
+func recordContainerInfo(containerPID int, cfg *runConfig, ipAddress string) error {
+	createTime := time.Now().Format(container.TimeFormat)
+	command := strings.Join(cfg.Spec.Args, " ")
+	driver, _ := container.GetStorageDriver("")
+	imageName, imageID := cfg.Image, ""
+	if img, err := image.Get(imageName); err == nil && img != nil {
+		imageName, imageID = img.Reference(), img.Digest
+	}
 	containerInfo := &container.ContainerInfo{
-		Id:          id,
-		Pid:         strconv.Itoa(containerPID),
-		Command:     command,
-		CreatedTime: createTime,
-		Status:      container.RUNNING,
-		Name:        containerName,
-		Volume:      volume,
+		Id:            cfg.ID,
+		Pid:           strconv.Itoa(containerPID),
+		Command:       command,
+		CreatedTime:   createTime,
+		Status:        container.RUNNING,
+		StartedAt:     createTime,
+		ShimPid:       os.Getpid(),
+		Name:          cfg.Name,
+		Volume:        cfg.Volume,
+		Image:         imageName,
+		ImageID:       imageID,
+		StorageDriver: driver.Name(),
+		CgroupDriver:  cgroups.CgroupDriver(),
+		IDMapping:     cfg.IDMapping,
+		Capabilities:  cfg.Spec.Capabilities,
+		Privileged:    cfg.Privileged,
+		Seccomp:       cfg.Spec.Seccomp,
+		Tty:           cfg.Tty,
+		OpenStdin:     cfg.Interactive,
+		PortMapping:   cfg.PortMapping,
+		Network:       cfg.Network,
+		IPAddress:     ipAddress,
+		AutoRemove:    cfg.AutoRemove,
+		Spec:          cfg.Spec,
+		Resources:     cfg.Resources,
+		RestartPolicy: cfg.RestartPolicy,
+		StopSignal:    cfg.StopSignal,
 	}
 
+	return writeContainerInfo(containerInfo)
+}
+
+// recordContainerStart 重新运行已有的容器时只更新进程、网络和状态，创建时间等保持不变。
+// 按重启策略重启时累加重启次数，手动start时清零
+func recordContainerStart(containerPID int, cfg *runConfig, ipAddress string) error {
+	var transitionErr error
+	err := updateContainerInfo(cfg.Name, func(containerInfo *container.ContainerInfo) {
+		if cfg.restarting != (containerInfo.Status == container.RESTARTING) {
+			transitionErr = fmt.Errorf("container %s is %s, can not be started", cfg.Name, containerInfo.Status)
+			return
+		}
+		if transitionErr = containerInfo.Transition(container.RUNNING); transitionErr != nil {
+			return
+		}
+		if cfg.restarting {
+			containerInfo.RestartCount++
+		} else {
+			containerInfo.RestartCount = 0
+			containerInfo.ManuallyStopped = false
+		}
+		containerInfo.Pid = strconv.Itoa(containerPID)
+		containerInfo.ShimPid = os.Getpid()
+		containerInfo.IPAddress = ipAddress
+		containerInfo.ExitCode = 0
+		containerInfo.OOMKilled = false
+	})
+	if err != nil {
+		return err
+	}
+	return transitionErr
+}
+
+func writeContainerInfo(containerInfo *container.ContainerInfo) error {
 	jsonBytes, err := json.Marshal(containerInfo)
 	if err != nil {
 		log.Errorf("Record container info error %v", err)
-		return "", err
+		return err
 	}
 	jsonStr := string(jsonBytes)
 
-	dirUrl := fmt.Sprintf(container.DefaultInfoLocation, containerName)
+	dirUrl := fmt.Sprintf(container.DefaultInfoLocation, containerInfo.Name)
 	if err := os.MkdirAll(dirUrl, 0622); err != nil {
 		log.Errorf("Mkdir error %s error %v", dirUrl, err)
-		return "", err
+		return err
 	}
 	fileName := dirUrl + "/" + container.ConfigName
 	file, err := os.Create(fileName)
-	defer file.Close()
 	if err != nil {
 		log.Errorf("Create file %s error %v", fileName, err)
-		return "", err
+		return err
 	}
+	defer file.Close()
 	if _, err := file.WriteString(jsonStr); err != nil {
 		log.Errorf("File write string error %v", err)
-		return "", err
+		return err
+	}
+	return nil
+}
+
+// updateContainerInfo 加锁读出容器信息，修改之后写回，shim和stop等命令可能同时修改
+func updateContainerInfo(containerName string, update func(*container.ContainerInfo)) error {
+	configFilePath := fmt.Sprintf(container.DefaultInfoLocation, containerName) + container.ConfigName
+	file, err := os.OpenFile(configFilePath, os.O_RDWR, 0)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	if err := syscall.Flock(int(file.Fd()), syscall.LOCK_EX); err != nil {
+		return fmt.Errorf("lock %s error %v", configFilePath, err)
+	}
+	var containerInfo container.ContainerInfo
+	if err := json.NewDecoder(file).Decode(&containerInfo); err != nil {
+		return fmt.Errorf("decode %s error %v", configFilePath, err)
+	}
+	update(&containerInfo)
+	content, err := json.Marshal(&containerInfo)
+	if err != nil {
+		return err
+	}
+	if err := file.Truncate(0); err != nil {
+		return err
+	}
+	_, err = file.WriteAt(content, 0)
+	return err
+}
+
+// containerExited 由init进程的父进程(前台运行时是CLI，否则是shim)在init退出之后调用：
+// 断开网络、删除cgroup，--rm 的容器连同rootfs和容器信息一起删除，
+// 其他容器记录退出码和退出时间，rootfs保留给 logs、commit 和 export 使用
+func containerExited(containerName string, cgroupManager *cgroups.CgroupManager, state *os.ProcessState) {
+	containerInfo, err := getContainerInfoByName(containerName)
+	if err != nil {
+		log.Errorf("Get container %s info error %v", containerName, err)
+		return
+	}
+	oomKilled := cgroupManager.OOMKilled()
+	releaseContainerResources(containerInfo, cgroupManager)
+	if containerInfo.AutoRemove {
+		deleteContainerInfo(containerName)
+		container.DeleteWorkSpace(containerInfo.Volume, containerName, containerInfo.StorageDriver)
+		return
+	}
+	exitCode := exitStatus(state)
+	log.Infof("Container %s exited with code %d, oom killed %v", containerName, exitCode, oomKilled)
+	err = updateContainerInfo(containerName, func(info *container.ContainerInfo) {
+		info.ExitCode = exitCode
+		info.OOMKilled = oomKilled
+		to := container.Exit
+		if info.ManuallyStopped {
+			to = container.STOP
+		}
+		if info.Status == container.STOP {
+			// 等待重启时被stop的容器已经是stopped，只补上真正退出的时间
+			info.FinishedAt = time.Now().Format(container.TimeFormat)
+		} else if err := info.Transition(to); err != nil {
+			log.Warnf("Record exit of container %s: %v", containerName, err)
+		}
+	})
+	if err != nil {
+		log.Errorf("Record exit of container %s error %v", containerName, err)
+	}
+}
+
+// prepareRestart 由shim在init退出之后调用，按重启策略判断是否重启：需要重启时释放网络和cgroup，
+// 记录退出码，状态变成restarting。stop过的容器不重启，返回false之后按正常退出处理
+func prepareRestart(containerName string, cgroupManager *cgroups.CgroupManager, state *os.ProcessState) bool {
+	containerInfo, err := getContainerInfoByName(containerName)
+	if err != nil {
+		return false
+	}
+	exitCode := exitStatus(state)
+	if containerInfo.Status != container.RUNNING || containerInfo.ManuallyStopped ||
+		!containerInfo.RestartPolicy.ShouldRestart(exitCode, containerInfo.RestartCount) {
+		return false
+	}
+	oomKilled := cgroupManager.OOMKilled()
+	releaseContainerResources(containerInfo, cgroupManager)
+	restart := false
+	err = updateContainerInfo(containerName, func(info *container.ContainerInfo) {
+		info.ExitCode = exitCode
+		info.OOMKilled = oomKilled
+		info.IPAddress = ""
+		// 释放资源的时候容器被stop了
+		if info.Status != container.RUNNING || info.ManuallyStopped {
+			return
+		}
+		restart = info.Transition(container.RESTARTING) == nil
+	})
+	if err != nil {
+		log.Errorf("Record exit of container %s error %v", containerName, err)
+		return false
+	}
+	log.Infof("Container %s exited with code %d, oom killed %v", containerName, exitCode, oomKilled)
+	return restart
+}
+
+// releaseContainerResources 断开容器的网络并删除它的cgroup，IP已经释放过的不再断开
+func releaseContainerResources(containerInfo *container.ContainerInfo, cgroupManager *cgroups.CgroupManager) {
+	if containerInfo.Network != "" && containerInfo.IPAddress != "" {
+		network.Init()
+		if err := network.Disconnect(containerInfo.Network, containerInfo); err != nil {
+			log.Errorf("Disconnect container %s from network %s error %v", containerInfo.Name, containerInfo.Network, err)
+		}
 	}
+	cgroupManager.Destroy()
+}
 
-	return containerName, nil
+// exitStatus 和shell一样，被信号杀掉的进程退出码是128+信号
+func exitStatus(state *os.ProcessState) int {
+	status, ok := state.Sys().(syscall.WaitStatus)
+	if !ok {
+		return -1
+	}
+	if status.Signaled() {
+		return 128 + int(status.Signal())
+	}
+	return status.ExitStatus()
 }
 
 func deleteContainerInfo(containerId string) {
@@ -127,6 +358,48 @@ func deleteContainerInfo(containerId string) {
 	}
 }
 
+//容器进程继承宿主机的环境变量，HOME除外，由init按容器内的用户设置
+func hostEnv() []string {
+	var env []string
+	for _, kv := range os.Environ() {
+		if !strings.HasPrefix(kv, "HOME=") {
+			env = append(env, kv)
+		}
+	}
+	return env
+}
+
+// seccompProfile 解析 --security-opt seccomp=<file|unconfined>，返回nil表示不过滤。
+// 没有指定时使用默认profile，--privileged 的容器默认不过滤
+func seccompProfile(securityOpts []string, privileged bool) (*seccomp.Seccomp, error) {
+	var profile *seccomp.Seccomp
+	explicit := false
+	for _, opt := range securityOpts {
+		kv := strings.SplitN(opt, "=", 2)
+		if len(kv) != 2 || kv[0] != "seccomp" {
+			return nil, fmt.Errorf("invalid security option %s, expect seccomp=<file|unconfined>", opt)
+		}
+		explicit = true
+		if kv[1] == seccomp.Unconfined {
+			profile = nil
+			continue
+		}
+		loaded, err := seccomp.LoadProfile(kv[1])
+		if err != nil {
+			return nil, err
+		}
+		profile = loaded
+	}
+	if explicit || privileged {
+		return profile, nil
+	}
+	if !seccomp.Supported() {
+		log.Warnf("Seccomp is not supported on this architecture, run container unconfined")
+		return nil, nil
+	}
+	return seccomp.DefaultProfile(), nil
+}
+
 func randStringBytes(n int) string {
 	letterBytes := "1234567890"
 	rand.Seed(time.Now().UnixNano())
@@ -0,0 +1,310 @@
+package seccomp
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"syscall"
+	"unsafe"
+)
+
+// seccomp过滤器的返回值，参考 linux/seccomp.h
+const (
+	retKillProcess = 0x80000000
+	retKillThread  = 0x00000000
+	retTrap        = 0x00030000
+	retErrno       = 0x00050000
+	retTrace       = 0x7ff00000
+	retLog         = 0x7ffc0000
+	retAllow       = 0x7fff0000
+
+	prSetSeccomp      = 22
+	seccompModeFilter = 2
+
+	// struct seccomp_data 中各字段的偏移，参数按小端保存，低32位在前
+	offsetNr   = 0
+	offsetArch = 4
+	offsetArgs = 16
+
+	// 经典BPF的条件跳转只能向前跳255条指令
+	maxJump = 255
+)
+
+// actionValue 把profile中的动作翻译成过滤器的返回值，errnoRet为空时ERRNO返回EPERM
+func actionValue(action Action, errnoRet *uint) (uint32, error) {
+	data := uint32(syscall.EPERM)
+	if errnoRet != nil {
+		data = uint32(*errnoRet)
+	}
+	switch action {
+	case ActKill, ActKillThread:
+		return retKillThread, nil
+	case ActKillProcess:
+		return retKillProcess, nil
+	case ActTrap:
+		return retTrap, nil
+	case ActErrno:
+		return retErrno | data&0xffff, nil
+	case ActTrace:
+		if errnoRet == nil {
+			data = 0
+		}
+		return retTrace | data&0xffff, nil
+	case ActLog:
+		return retLog, nil
+	case ActAllow:
+		return retAllow, nil
+	}
+	return 0, fmt.Errorf("unknown seccomp action %s", action)
+}
+
+func stmt(code uint16, k uint32) syscall.SockFilter {
+	return syscall.SockFilter{Code: code, K: k}
+}
+
+func jump(code uint16, k uint32, jt, jf int) syscall.SockFilter {
+	return syscall.SockFilter{Code: code, K: k, Jt: uint8(jt), Jf: uint8(jf)}
+}
+
+func loadWord(offset uint32) syscall.SockFilter {
+	return stmt(syscall.BPF_LD|syscall.BPF_W|syscall.BPF_ABS, offset)
+}
+
+func ret(value uint32) syscall.SockFilter {
+	return stmt(syscall.BPF_RET|syscall.BPF_K, value)
+}
+
+// Compile 把Setup处理过的profile编译成BPF过滤器。
+// 规则按profile中的顺序逐条匹配，第一条命中的规则决定返回值，都没有命中时返回defaultAction。
+// 只支持当前架构，其他架构(包括x32)的系统调用都会杀掉进程
+func Compile(profile *Seccomp) ([]syscall.SockFilter, error) {
+	if auditArch == 0 {
+		return nil, fmt.Errorf("seccomp is not supported on this architecture")
+	}
+	defaultValue, err := actionValue(profile.DefaultAction, profile.DefaultErrnoRet)
+	if err != nil {
+		return nil, err
+	}
+	filter := []syscall.SockFilter{
+		loadWord(offsetArch),
+		jump(syscall.BPF_JMP|syscall.BPF_JEQ|syscall.BPF_K, auditArch, 1, 0),
+		ret(retKillProcess),
+		loadWord(offsetNr),
+	}
+	if x32SyscallBit != 0 {
+		filter = append(filter,
+			jump(syscall.BPF_JMP|syscall.BPF_JGE|syscall.BPF_K, x32SyscallBit, 0, 1),
+			ret(retKillProcess))
+	}
+	type rule struct {
+		name  string
+		nr    uint32
+		args  []*Arg
+		value uint32
+	}
+	var rules []rule
+	for _, call := range profile.Syscalls {
+		value, err := actionValue(call.Action, call.ErrnoRet)
+		if err != nil {
+			return nil, err
+		}
+		names := call.Names
+		if call.Name != "" {
+			names = append([]string{call.Name}, names...)
+		}
+		for _, name := range names {
+			nr, ok := syscallNumbers[name]
+			if !ok {
+				// profile中通常包含其他架构才有的系统调用
+				continue
+			}
+			rules = append(rules, rule{name, nr, call.Args, value})
+		}
+	}
+	// 和默认动作相同的规则只有在后面没有同一个系统调用的规则时才能省略，
+	// 否则它会挡住后面动作不同的规则，省略之后第一条命中的就变成了后面的规则
+	later := map[uint32]bool{}
+	keep := make([]bool, len(rules))
+	for i := len(rules) - 1; i >= 0; i-- {
+		keep[i] = rules[i].value != defaultValue || later[rules[i].nr]
+		later[rules[i].nr] = true
+	}
+	for i, r := range rules {
+		if !keep[i] {
+			continue
+		}
+		for _, args := range argGroups(r.args) {
+			block, err := compileRule(r.nr, args, r.value)
+			if err != nil {
+				return nil, fmt.Errorf("syscall %s: %v", r.name, err)
+			}
+			filter = append(filter, block...)
+		}
+	}
+	filter = append(filter, ret(defaultValue))
+	if len(filter) > 4096 {
+		return nil, fmt.Errorf("seccomp filter is too long: %d instructions", len(filter))
+	}
+	return filter, nil
+}
+
+// argGroups 和runc一样处理参数条件：对不同参数的条件同时满足才匹配，
+// 同一个参数出现多次时每个条件单独成为一条规则，满足任意一个即可
+func argGroups(args []*Arg) [][]*Arg {
+	seen := map[uint]bool{}
+	for _, arg := range args {
+		if seen[arg.Index] {
+			groups := make([][]*Arg, 0, len(args))
+			for _, arg := range args {
+				groups = append(groups, []*Arg{arg})
+			}
+			return groups
+		}
+		seen[arg.Index] = true
+	}
+	return [][]*Arg{args}
+}
+
+// compileRule 生成一条规则，进入时A寄存器中是系统调用号，离开时仍然是：
+//
+//	jeq nr, 0, fail
+//	参数条件，不满足时跳到fail
+//	ret value
+//	fail: ld nr  (有参数条件时才需要重新加载)
+func compileRule(nr uint32, args []*Arg, value uint32) ([]syscall.SockFilter, error) {
+	if len(args) == 0 {
+		return []syscall.SockFilter{
+			jump(syscall.BPF_JMP|syscall.BPF_JEQ|syscall.BPF_K, nr, 0, 1),
+			ret(value),
+		}, nil
+	}
+	length := 3
+	for _, arg := range args {
+		n, err := conditionLength(arg)
+		if err != nil {
+			return nil, err
+		}
+		length += n
+	}
+	fail := length - 1
+	if fail-1 > maxJump {
+		return nil, fmt.Errorf("too many argument conditions")
+	}
+	block := make([]syscall.SockFilter, 0, length)
+	block = append(block, jump(syscall.BPF_JMP|syscall.BPF_JEQ|syscall.BPF_K, nr, 0, fail-1))
+	for _, arg := range args {
+		block = append(block, compileCondition(arg, len(block), fail)...)
+	}
+	return append(block, ret(value), loadWord(offsetNr)), nil
+}
+
+func conditionLength(arg *Arg) (int, error) {
+	if arg.Index > 5 {
+		return 0, fmt.Errorf("invalid argument index %d", arg.Index)
+	}
+	switch arg.Op {
+	case OpEqualTo, OpNotEqual:
+		return 4, nil
+	case OpGreaterThan, OpGreaterEqual, OpLessThan, OpLessEqual:
+		return 5, nil
+	case OpMaskedEqual:
+		return 6, nil
+	}
+	return 0, fmt.Errorf("unknown operator %s", arg.Op)
+}
+
+// compileCondition 生成一个64位参数的比较，start是第一条指令在规则中的位置，
+// 满足时落到条件之后的下一条指令，不满足时跳到fail。BPF只能比较32位，先比高32位再比低32位
+func compileCondition(arg *Arg, start, fail int) []syscall.SockFilter {
+	length, _ := conditionLength(arg)
+	end := start + length
+	// to 计算第i条指令跳到target的偏移
+	to := func(i, target int) int {
+		return target - (start + i) - 1
+	}
+	hiOffset := uint32(offsetArgs + 8*arg.Index + 4)
+	loOffset := uint32(offsetArgs + 8*arg.Index)
+	hi, lo := uint32(arg.Value>>32), uint32(arg.Value)
+	jeq := uint16(syscall.BPF_JMP | syscall.BPF_JEQ | syscall.BPF_K)
+	jgt := uint16(syscall.BPF_JMP | syscall.BPF_JGT | syscall.BPF_K)
+	jge := uint16(syscall.BPF_JMP | syscall.BPF_JGE | syscall.BPF_K)
+
+	switch arg.Op {
+	case OpEqualTo:
+		return []syscall.SockFilter{
+			loadWord(hiOffset),
+			jump(jeq, hi, 0, to(1, fail)),
+			loadWord(loOffset),
+			jump(jeq, lo, 0, to(3, fail)),
+		}
+	case OpNotEqual:
+		return []syscall.SockFilter{
+			loadWord(hiOffset),
+			jump(jeq, hi, 0, to(1, end)),
+			loadWord(loOffset),
+			jump(jeq, lo, to(3, fail), 0),
+		}
+	case OpGreaterThan, OpGreaterEqual:
+		lowCmp := jgt
+		if arg.Op == OpGreaterEqual {
+			lowCmp = jge
+		}
+		return []syscall.SockFilter{
+			loadWord(hiOffset),
+			jump(jgt, hi, to(1, end), 0),
+			jump(jeq, hi, 0, to(2, fail)),
+			loadWord(loOffset),
+			jump(lowCmp, lo, 0, to(4, fail)),
+		}
+	case OpLessThan, OpLessEqual:
+		// a < b 即 !(a >= b)，a <= b 即 !(a > b)
+		lowCmp := jge
+		if arg.Op == OpLessEqual {
+			lowCmp = jgt
+		}
+		return []syscall.SockFilter{
+			loadWord(hiOffset),
+			jump(jge, hi, 0, to(1, end)),
+			jump(jeq, hi, 0, to(2, fail)),
+			loadWord(loOffset),
+			jump(lowCmp, lo, to(4, fail), 0),
+		}
+	default: // OpMaskedEqual
+		and := uint16(syscall.BPF_ALU | syscall.BPF_AND | syscall.BPF_K)
+		return []syscall.SockFilter{
+			loadWord(hiOffset),
+			stmt(and, hi),
+			jump(jeq, uint32(arg.ValueTwo>>32), 0, to(2, fail)),
+			loadWord(loOffset),
+			stmt(and, lo),
+			jump(jeq, uint32(arg.ValueTwo), 0, to(5, fail)),
+		}
+	}
+}
+
+// InstallFilter 在当前线程上安装过滤器，之后exec出来的进程会继承它。
+// 没有设置no_new_privs时需要CAP_SYS_ADMIN，所以要在去掉capability之前调用
+func InstallFilter(filter []syscall.SockFilter) error {
+	prog := syscall.SockFprog{Len: uint16(len(filter)), Filter: &filter[0]}
+	if _, _, errno := syscall.RawSyscall(syscall.SYS_PRCTL, prSetSeccomp, seccompModeFilter, uintptr(unsafe.Pointer(&prog))); errno != 0 {
+		return fmt.Errorf("install seccomp filter error %v", errno)
+	}
+	return nil
+}
+
+// Encode 把过滤器按struct sock_filter的内存布局转换成十六进制，exec时通过环境变量交给nsenter
+func Encode(filter []syscall.SockFilter) string {
+	buf := make([]byte, 8*len(filter))
+	for i, f := range filter {
+		b := buf[8*i:]
+		binary.LittleEndian.PutUint16(b, f.Code)
+		b[2], b[3] = f.Jt, f.Jf
+		binary.LittleEndian.PutUint32(b[4:], f.K)
+	}
+	return hex.EncodeToString(buf)
+}
+
+// Supported 表示当前架构是否有系统调用号表
+func Supported() bool {
+	return auditArch != 0
+}
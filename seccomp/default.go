@@ -0,0 +1,133 @@
+package seccomp
+
+import "syscall"
+
+// 没有CAP_SYS_ADMIN时clone不能创建新的namespace:
+// CLONE_NEWNS|CLONE_NEWUTS|CLONE_NEWIPC|CLONE_NEWUSER|CLONE_NEWPID|CLONE_NEWNET|CLONE_NEWCGROUP
+const cloneNamespaceFlags = 0x7E020000
+
+// DefaultProfile 是没有指定 --security-opt seccomp 时使用的profile，和docker的默认profile基本一致：
+// 默认返回EPERM，放行常用的系统调用，需要特定capability的系统调用只在容器保留了该capability时放行
+func DefaultProfile() *Seccomp {
+	enosys := uint(syscall.ENOSYS)
+	return &Seccomp{
+		DefaultAction: ActErrno,
+		// 只编译了本机架构的系统调用表，32位x86和x32的系统调用都会被杀掉，不列出来
+		Architectures: []string{"SCMP_ARCH_X86_64"},
+		Syscalls: []*Syscall{
+			{
+				Names: []string{
+					"accept", "accept4", "access", "adjtimex", "alarm", "bind", "brk", "capget", "capset",
+					"chdir", "chmod", "chown", "chown32", "clock_adjtime", "clock_getres", "clock_gettime",
+					"clock_nanosleep", "close", "close_range", "connect", "copy_file_range", "creat", "dup",
+					"dup2", "dup3", "epoll_create", "epoll_create1", "epoll_ctl", "epoll_ctl_old",
+					"epoll_pwait", "epoll_pwait2", "epoll_wait", "epoll_wait_old", "eventfd", "eventfd2",
+					"execve", "execveat", "exit", "exit_group", "faccessat", "faccessat2", "fadvise64",
+					"fallocate", "fanotify_mark", "fchdir", "fchmod", "fchmodat", "fchown", "fchownat",
+					"fcntl", "fdatasync", "fgetxattr", "flistxattr", "flock", "fork", "fremovexattr",
+					"fsetxattr", "fstat", "fstatfs", "fsync", "ftruncate", "futex", "futex_waitv", "futimesat",
+					"getcpu", "getcwd", "getdents", "getdents64", "getegid", "geteuid", "getgid", "getgroups",
+					"getitimer", "getpeername", "getpgid", "getpgrp", "getpid", "getppid", "getpriority",
+					"getrandom", "getresgid", "getresuid", "getrlimit", "get_robust_list", "getrusage",
+					"getsid", "getsockname", "getsockopt", "get_thread_area", "gettid", "gettimeofday",
+					"getuid", "getxattr", "inotify_add_watch", "inotify_init", "inotify_init1",
+					"inotify_rm_watch", "io_cancel", "ioctl", "io_destroy", "io_getevents", "io_pgetevents",
+					"ioprio_get", "ioprio_set", "io_setup", "io_submit", "io_uring_enter", "io_uring_register",
+					"io_uring_setup", "kill", "landlock_add_rule", "landlock_create_ruleset",
+					"landlock_restrict_self", "lchown", "lgetxattr", "link", "linkat", "listen", "listxattr",
+					"llistxattr", "lremovexattr", "lseek", "lsetxattr", "lstat", "madvise", "membarrier",
+					"memfd_create", "memfd_secret", "mincore", "mkdir", "mkdirat", "mknod", "mknodat", "mlock",
+					"mlock2", "mlockall", "mmap", "mprotect", "mq_getsetattr", "mq_notify", "mq_open",
+					"mq_timedreceive", "mq_timedsend", "mq_unlink", "mremap", "msgctl", "msgget", "msgrcv",
+					"msgsnd", "msync", "munlock", "munlockall", "munmap", "name_to_handle_at", "nanosleep",
+					"newfstatat", "open", "openat", "openat2", "pause", "pidfd_open", "pidfd_send_signal",
+					"pipe", "pipe2", "pkey_alloc", "pkey_free", "pkey_mprotect", "poll", "ppoll", "prctl",
+					"pread64", "preadv", "preadv2", "prlimit64", "process_mrelease", "pselect6", "pwrite64",
+					"pwritev", "pwritev2", "read", "readahead", "readlink", "readlinkat", "readv", "recvfrom",
+					"recvmmsg", "recvmsg", "remap_file_pages", "removexattr", "rename", "renameat",
+					"renameat2", "restart_syscall", "rmdir", "rseq", "rt_sigaction", "rt_sigpending",
+					"rt_sigprocmask", "rt_sigqueueinfo", "rt_sigreturn", "rt_sigsuspend", "rt_sigtimedwait",
+					"rt_tgsigqueueinfo", "sched_getaffinity", "sched_getattr", "sched_getparam",
+					"sched_get_priority_max", "sched_get_priority_min", "sched_getscheduler",
+					"sched_rr_get_interval", "sched_setaffinity", "sched_setattr", "sched_setparam",
+					"sched_setscheduler", "sched_yield", "seccomp", "select", "semctl", "semget", "semop",
+					"semtimedop", "sendfile", "sendmmsg", "sendmsg", "sendto", "setfsgid", "setfsuid",
+					"setgid", "setgroups", "setitimer", "setpgid", "setpriority", "setregid", "setresgid",
+					"setresuid", "setreuid", "setrlimit", "set_robust_list", "setsid", "setsockopt",
+					"set_thread_area", "set_tid_address", "setuid", "setxattr", "shmat", "shmctl", "shmdt",
+					"shmget", "shutdown", "sigaltstack", "signalfd", "signalfd4", "socket", "socketpair",
+					"splice", "stat", "statfs", "statx", "symlink", "symlinkat", "sync", "sync_file_range",
+					"syncfs", "sysinfo", "tee", "tgkill", "time", "timer_create", "timer_delete",
+					"timer_getoverrun", "timer_gettime", "timer_settime", "timerfd_create",
+					"timerfd_gettime", "timerfd_settime", "times", "tkill", "truncate", "umask", "uname",
+					"unlink", "unlinkat", "utime", "utimensat", "utimes", "vfork", "vmsplice", "wait4",
+					"waitid", "write", "writev", "arch_prctl", "modify_ldt",
+				},
+				Action: ActAllow,
+			},
+			{
+				Names:    []string{"ptrace"},
+				Action:   ActAllow,
+				Includes: &Filter{MinKernel: "4.8"},
+				Comment:  "4.8之前的内核中ptrace可以绕过seccomp",
+			},
+			{Name: "personality", Action: ActAllow, Args: []*Arg{{Index: 0, Value: 0x0, Op: OpEqualTo}}},
+			{Name: "personality", Action: ActAllow, Args: []*Arg{{Index: 0, Value: 0x0008, Op: OpEqualTo}}},
+			{Name: "personality", Action: ActAllow, Args: []*Arg{{Index: 0, Value: 0x20000, Op: OpEqualTo}}},
+			{Name: "personality", Action: ActAllow, Args: []*Arg{{Index: 0, Value: 0x20008, Op: OpEqualTo}}},
+			{Name: "personality", Action: ActAllow, Args: []*Arg{{Index: 0, Value: 0xffffffff, Op: OpEqualTo}}},
+			{
+				Names: []string{
+					"bpf", "clone", "clone3", "fanotify_init", "fsconfig", "fsmount", "fsopen", "fspick",
+					"lookup_dcookie", "mount", "mount_setattr", "move_mount", "open_tree", "perf_event_open",
+					"quotactl", "quotactl_fd", "setdomainname", "sethostname", "setns", "syslog", "umount",
+					"umount2", "unshare",
+				},
+				Action:   ActAllow,
+				Includes: &Filter{Caps: []string{"CAP_SYS_ADMIN"}},
+			},
+			{
+				Name:     "clone",
+				Action:   ActAllow,
+				Args:     []*Arg{{Index: 0, Value: cloneNamespaceFlags, ValueTwo: 0, Op: OpMaskedEqual}},
+				Excludes: &Filter{Caps: []string{"CAP_SYS_ADMIN"}},
+			},
+			{
+				Name:     "clone3",
+				Action:   ActErrno,
+				ErrnoRet: &enosys,
+				Excludes: &Filter{Caps: []string{"CAP_SYS_ADMIN"}},
+				Comment:  "clone3的参数在内存中无法检查，返回ENOSYS让libc回退到clone",
+			},
+			{Name: "open_by_handle_at", Action: ActAllow, Includes: &Filter{Caps: []string{"CAP_DAC_READ_SEARCH"}}},
+			{Name: "reboot", Action: ActAllow, Includes: &Filter{Caps: []string{"CAP_SYS_BOOT"}}},
+			{Name: "chroot", Action: ActAllow, Includes: &Filter{Caps: []string{"CAP_SYS_CHROOT"}}},
+			{
+				Names:    []string{"delete_module", "init_module", "finit_module"},
+				Action:   ActAllow,
+				Includes: &Filter{Caps: []string{"CAP_SYS_MODULE"}},
+			},
+			{Name: "acct", Action: ActAllow, Includes: &Filter{Caps: []string{"CAP_SYS_PACCT"}}},
+			{
+				Names:    []string{"kcmp", "pidfd_getfd", "process_madvise", "process_vm_readv", "process_vm_writev", "ptrace"},
+				Action:   ActAllow,
+				Includes: &Filter{Caps: []string{"CAP_SYS_PTRACE"}},
+			},
+			{Names: []string{"iopl", "ioperm"}, Action: ActAllow, Includes: &Filter{Caps: []string{"CAP_SYS_RAWIO"}}},
+			{
+				Names:    []string{"settimeofday", "stime", "clock_settime"},
+				Action:   ActAllow,
+				Includes: &Filter{Caps: []string{"CAP_SYS_TIME"}},
+			},
+			{Name: "vhangup", Action: ActAllow, Includes: &Filter{Caps: []string{"CAP_SYS_TTY_CONFIG"}}},
+			{
+				Names:    []string{"get_mempolicy", "mbind", "set_mempolicy", "set_mempolicy_home_node"},
+				Action:   ActAllow,
+				Includes: &Filter{Caps: []string{"CAP_SYS_NICE"}},
+			},
+			{Name: "syslog", Action: ActAllow, Includes: &Filter{Caps: []string{"CAP_SYSLOG"}}},
+			{Name: "bpf", Action: ActAllow, Includes: &Filter{Caps: []string{"CAP_BPF"}}},
+			{Name: "perf_event_open", Action: ActAllow, Includes: &Filter{Caps: []string{"CAP_PERFMON"}}},
+		},
+	}
+}
@@ -0,0 +1,212 @@
+package seccomp
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// Unconfined 是 --security-opt seccomp=unconfined，不安装任何过滤器
+const Unconfined = "unconfined"
+
+type Action string
+
+const (
+	ActKill        Action = "SCMP_ACT_KILL"
+	ActKillThread  Action = "SCMP_ACT_KILL_THREAD"
+	ActKillProcess Action = "SCMP_ACT_KILL_PROCESS"
+	ActTrap        Action = "SCMP_ACT_TRAP"
+	ActErrno       Action = "SCMP_ACT_ERRNO"
+	ActTrace       Action = "SCMP_ACT_TRACE"
+	ActAllow       Action = "SCMP_ACT_ALLOW"
+	ActLog         Action = "SCMP_ACT_LOG"
+)
+
+type Operator string
+
+const (
+	OpNotEqual     Operator = "SCMP_CMP_NE"
+	OpLessThan     Operator = "SCMP_CMP_LT"
+	OpLessEqual    Operator = "SCMP_CMP_LE"
+	OpEqualTo      Operator = "SCMP_CMP_EQ"
+	OpGreaterEqual Operator = "SCMP_CMP_GE"
+	OpGreaterThan  Operator = "SCMP_CMP_GT"
+	OpMaskedEqual  Operator = "SCMP_CMP_MASKED_EQ"
+)
+
+// Seccomp 是docker的seccomp profile格式，也兼容OCI config.json中的linux.seccomp
+// 参考 https://github.com/moby/moby/blob/master/profiles/seccomp/default.json
+type Seccomp struct {
+	DefaultAction   Action     `json:"defaultAction"`
+	DefaultErrnoRet *uint      `json:"defaultErrnoRet,omitempty"`
+	Architectures   []string   `json:"architectures,omitempty"`
+	ArchMap         []ArchMap  `json:"archMap,omitempty"`
+	Syscalls        []*Syscall `json:"syscalls,omitempty"`
+}
+
+// ArchMap 是主架构和它的子架构，比如x86_64下还可以运行x86和x32的程序
+type ArchMap struct {
+	Arch      string   `json:"architecture"`
+	SubArches []string `json:"subArchitectures"`
+}
+
+// Syscall 是一条规则，Name和Names二选一，Args中的条件同时满足时才生效
+type Syscall struct {
+	Name     string   `json:"name,omitempty"`
+	Names    []string `json:"names,omitempty"`
+	Action   Action   `json:"action"`
+	ErrnoRet *uint    `json:"errnoRet,omitempty"`
+	Args     []*Arg   `json:"args,omitempty"`
+	Comment  string   `json:"comment,omitempty"`
+	Includes *Filter  `json:"includes,omitempty"`
+	Excludes *Filter  `json:"excludes,omitempty"`
+}
+
+// Arg 比较第Index个参数，MASKED_EQ时表示 arg & Value == ValueTwo
+type Arg struct {
+	Index    uint     `json:"index"`
+	Value    uint64   `json:"value"`
+	ValueTwo uint64   `json:"valueTwo,omitempty"`
+	Op       Operator `json:"op"`
+}
+
+// Filter 按容器的capability、架构和内核版本决定规则是否生效
+type Filter struct {
+	Caps      []string `json:"caps,omitempty"`
+	Arches    []string `json:"arches,omitempty"`
+	MinKernel string   `json:"minKernel,omitempty"`
+}
+
+// LoadProfile 读取 --security-opt seccomp=<file> 指定的profile
+func LoadProfile(path string) (*Seccomp, error) {
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("read seccomp profile %s error %v", path, err)
+	}
+	profile := &Seccomp{}
+	if err := json.Unmarshal(content, profile); err != nil {
+		return nil, fmt.Errorf("decode seccomp profile %s error %v", path, err)
+	}
+	return profile, nil
+}
+
+// Setup 根据容器保留的capability处理includes/excludes，返回只包含生效规则的profile。
+// 结果记录在InitSpec和ContainerInfo中，init和exec不需要再知道容器的capability
+func Setup(profile *Seccomp, caps []string) (*Seccomp, error) {
+	if profile.DefaultAction == "" {
+		return nil, fmt.Errorf("seccomp profile has no defaultAction")
+	}
+	kernel, err := kernelVersion()
+	if err != nil {
+		return nil, err
+	}
+	capSet := map[string]bool{}
+	for _, c := range caps {
+		capSet[strings.ToUpper(c)] = true
+	}
+	resolved := &Seccomp{
+		DefaultAction:   profile.DefaultAction,
+		DefaultErrnoRet: profile.DefaultErrnoRet,
+		Architectures:   profile.Architectures,
+		ArchMap:         profile.ArchMap,
+	}
+	for _, call := range profile.Syscalls {
+		ok, err := call.applies(capSet, kernel)
+		if err != nil {
+			return nil, err
+		}
+		if !ok {
+			continue
+		}
+		rule := *call
+		rule.Includes, rule.Excludes = nil, nil
+		resolved.Syscalls = append(resolved.Syscalls, &rule)
+	}
+	return resolved, nil
+}
+
+func (call *Syscall) applies(caps map[string]bool, kernel [2]int) (bool, error) {
+	if inc := call.Includes; inc != nil {
+		for _, c := range inc.Caps {
+			if !caps[c] {
+				return false, nil
+			}
+		}
+		if len(inc.Arches) > 0 && !containsArch(inc.Arches, nativeArch) {
+			return false, nil
+		}
+		if inc.MinKernel != "" {
+			min, err := parseKernelVersion(inc.MinKernel)
+			if err != nil {
+				return false, err
+			}
+			if kernel[0] < min[0] || kernel[0] == min[0] && kernel[1] < min[1] {
+				return false, nil
+			}
+		}
+	}
+	if exc := call.Excludes; exc != nil {
+		for _, c := range exc.Caps {
+			if caps[c] {
+				return false, nil
+			}
+		}
+		if containsArch(exc.Arches, nativeArch) {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+// includes/excludes中的架构有 amd64 和 SCMP_ARCH_X86_64 两种写法
+func containsArch(arches []string, arch string) bool {
+	for _, a := range arches {
+		if a == arch || "SCMP_ARCH_"+strings.ToUpper(goArchNames[a]) == arch {
+			return true
+		}
+	}
+	return false
+}
+
+var goArchNames = map[string]string{
+	"amd64": "x86_64",
+	"386":   "x86",
+	"arm64": "aarch64",
+	"arm":   "arm",
+}
+
+// parseKernelVersion 解析 4.8 或 5.15.0-91-generic 这样的版本号中的主次版本
+func parseKernelVersion(release string) ([2]int, error) {
+	var version [2]int
+	parts := strings.SplitN(release, ".", 3)
+	if len(parts) < 2 {
+		return version, fmt.Errorf("invalid kernel version %s", release)
+	}
+	for i := 0; i < 2; i++ {
+		digits := strings.TrimRightFunc(parts[i], func(r rune) bool { return r < '0' || r > '9' })
+		n, err := strconv.Atoi(digits)
+		if err != nil {
+			return version, fmt.Errorf("invalid kernel version %s", release)
+		}
+		version[i] = n
+	}
+	return version, nil
+}
+
+func kernelVersion() ([2]int, error) {
+	var uts syscall.Utsname
+	if err := syscall.Uname(&uts); err != nil {
+		return [2]int{}, fmt.Errorf("uname error %v", err)
+	}
+	release := make([]byte, 0, len(uts.Release))
+	for _, c := range uts.Release {
+		if c == 0 {
+			break
+		}
+		release = append(release, byte(c))
+	}
+	return parseKernelVersion(string(release))
+}
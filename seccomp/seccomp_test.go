@@ -0,0 +1,191 @@
+package seccomp
+
+import (
+	"encoding/hex"
+	"syscall"
+	"testing"
+)
+
+// run 用一个简单的解释器执行过滤器，只实现Compile会生成的几种指令
+func run(t *testing.T, filter []syscall.SockFilter, arch, nr uint32, args ...uint64) uint32 {
+	data := make([]uint32, 16)
+	data[0], data[1] = nr, arch
+	for i, arg := range args {
+		data[4+2*i] = uint32(arg)
+		data[5+2*i] = uint32(arg >> 32)
+	}
+	var a uint32
+	for pc := 0; pc < len(filter); pc++ {
+		f := filter[pc]
+		switch f.Code {
+		case syscall.BPF_LD | syscall.BPF_W | syscall.BPF_ABS:
+			a = data[f.K/4]
+		case syscall.BPF_ALU | syscall.BPF_AND | syscall.BPF_K:
+			a &= f.K
+		case syscall.BPF_RET | syscall.BPF_K:
+			return f.K
+		default:
+			var cond bool
+			switch f.Code {
+			case syscall.BPF_JMP | syscall.BPF_JEQ | syscall.BPF_K:
+				cond = a == f.K
+			case syscall.BPF_JMP | syscall.BPF_JGT | syscall.BPF_K:
+				cond = a > f.K
+			case syscall.BPF_JMP | syscall.BPF_JGE | syscall.BPF_K:
+				cond = a >= f.K
+			default:
+				t.Fatalf("unexpected instruction %+v", f)
+			}
+			if cond {
+				pc += int(f.Jt)
+			} else {
+				pc += int(f.Jf)
+			}
+		}
+	}
+	t.Fatal("filter fell off the end")
+	return 0
+}
+
+func TestCompileArgs(t *testing.T) {
+	if !Supported() {
+		t.Skip("seccomp is not supported on this architecture")
+	}
+	nr := syscallNumbers["mmap"]
+	errno := uint(22)
+	cases := []struct {
+		arg   Arg
+		match []uint64
+		miss  []uint64
+	}{
+		{Arg{Op: OpEqualTo, Value: 1 << 33}, []uint64{1 << 33}, []uint64{0, 1<<33 + 1}},
+		{Arg{Op: OpNotEqual, Value: 5}, []uint64{4, 5 + 1<<32}, []uint64{5}},
+		{Arg{Op: OpGreaterThan, Value: 1<<32 + 10}, []uint64{1<<32 + 11, 2 << 32}, []uint64{1<<32 + 10, 100}},
+		{Arg{Op: OpGreaterEqual, Value: 10}, []uint64{10, 1 << 32}, []uint64{9}},
+		{Arg{Op: OpLessThan, Value: 1<<32 + 10}, []uint64{1<<32 + 9, 100}, []uint64{1<<32 + 10, 2 << 32}},
+		{Arg{Op: OpLessEqual, Value: 10}, []uint64{10, 0}, []uint64{11, 1<<32 + 1}},
+		{Arg{Op: OpMaskedEqual, Value: 0xff | 1<<40, ValueTwo: 0x12}, []uint64{0x1012, 1<<41 | 0x12}, []uint64{0x13, 1<<40 | 0x12}},
+	}
+	for _, c := range cases {
+		arg := c.arg
+		arg.Index = 2
+		filter, err := Compile(&Seccomp{
+			DefaultAction: ActAllow,
+			Syscalls:      []*Syscall{{Name: "mmap", Action: ActErrno, ErrnoRet: &errno, Args: []*Arg{&arg}}},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, v := range c.match {
+			if got := run(t, filter, auditArch, nr, 0, 0, v); got != retErrno|22 {
+				t.Errorf("%s %#x: arg %#x not matched, got %#x", arg.Op, arg.Value, v, got)
+			}
+		}
+		for _, v := range c.miss {
+			if got := run(t, filter, auditArch, nr, 0, 0, v); got != retAllow {
+				t.Errorf("%s %#x: arg %#x matched, got %#x", arg.Op, arg.Value, v, got)
+			}
+		}
+	}
+}
+
+func TestCompileOrder(t *testing.T) {
+	if !Supported() {
+		t.Skip("seccomp is not supported on this architecture")
+	}
+	// 第一条规则的动作和默认动作相同，但是它先命中，后面的规则不能生效
+	filter, err := Compile(&Seccomp{
+		DefaultAction: ActErrno,
+		Syscalls: []*Syscall{
+			{Name: "mmap", Action: ActErrno, Args: []*Arg{{Index: 0, Op: OpEqualTo, Value: 1}}},
+			{Name: "mmap", Action: ActAllow},
+			{Name: "read", Action: ActErrno},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	eperm := uint32(retErrno | syscall.EPERM)
+	if got := run(t, filter, auditArch, syscallNumbers["mmap"], 1); got != eperm {
+		t.Errorf("mmap(1): got %#x, want %#x", got, eperm)
+	}
+	if got := run(t, filter, auditArch, syscallNumbers["mmap"], 2); got != retAllow {
+		t.Errorf("mmap(2): got %#x, want %#x", got, retAllow)
+	}
+	// 后面没有同一个系统调用的规则时仍然省略，read的规则不在过滤器中
+	want := 4 + 7 + 2 + 1
+	if x32SyscallBit != 0 {
+		want += 2
+	}
+	if len(filter) != want {
+		t.Errorf("filter has %d instructions, want %d", len(filter), want)
+	}
+}
+
+func TestDefaultProfile(t *testing.T) {
+	if !Supported() {
+		t.Skip("seccomp is not supported on this architecture")
+	}
+	compile := func(caps []string) []syscall.SockFilter {
+		profile, err := Setup(DefaultProfile(), caps)
+		if err != nil {
+			t.Fatal(err)
+		}
+		filter, err := Compile(profile)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return filter
+	}
+	eperm := uint32(retErrno | syscall.EPERM)
+	filter := compile([]string{"CAP_CHOWN", "CAP_SYS_CHROOT"})
+	checks := []struct {
+		name string
+		args []uint64
+		want uint32
+	}{
+		{"read", nil, retAllow},
+		{"chroot", nil, retAllow},
+		{"mount", nil, eperm},
+		{"reboot", nil, eperm},
+		{"clone", []uint64{uint64(syscall.SIGCHLD)}, retAllow},
+		{"clone", []uint64{syscall.CLONE_NEWNS | uint64(syscall.SIGCHLD)}, eperm},
+		{"clone3", nil, retErrno | uint32(syscall.ENOSYS)},
+		{"personality", []uint64{0x0008}, retAllow},
+		{"personality", []uint64{0x0400}, eperm},
+	}
+	for _, c := range checks {
+		if got := run(t, filter, auditArch, syscallNumbers[c.name], c.args...); got != c.want {
+			t.Errorf("%s%v: got %#x, want %#x", c.name, c.args, got, c.want)
+		}
+	}
+	if got := run(t, filter, 0x40000003, syscallNumbers["read"]); got != retKillProcess {
+		t.Errorf("foreign arch: got %#x", got)
+	}
+	if x32SyscallBit != 0 {
+		if got := run(t, filter, auditArch, x32SyscallBit|syscallNumbers["read"]); got != retKillProcess {
+			t.Errorf("x32 syscall: got %#x", got)
+		}
+	}
+
+	filter = compile([]string{"CAP_SYS_ADMIN"})
+	for _, name := range []string{"mount", "clone3", "chroot"} {
+		want := uint32(retAllow)
+		if name == "chroot" {
+			want = eperm
+		}
+		if got := run(t, filter, auditArch, syscallNumbers[name], syscall.CLONE_NEWNS); got != want {
+			t.Errorf("CAP_SYS_ADMIN %s: got %#x, want %#x", name, got, want)
+		}
+	}
+}
+
+func TestEncode(t *testing.T) {
+	buf, err := hex.DecodeString(Encode([]syscall.SockFilter{{Code: 0x15, Jt: 1, Jf: 2, K: 0xc000003e}}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []byte{0x15, 0, 1, 2, 0x3e, 0, 0, 0xc0}; string(buf) != string(want) {
+		t.Errorf("encoded % x, want % x", buf, want)
+	}
+}
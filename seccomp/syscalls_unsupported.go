@@ -0,0 +1,13 @@
+//go:build !amd64
+// +build !amd64
+
+package seccomp
+
+// 其他架构还没有系统调用号表，Compile会返回错误
+const (
+	nativeArch    = ""
+	auditArch     = 0
+	x32SyscallBit = 0
+)
+
+var syscallNumbers = map[string]uint32{}
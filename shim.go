@@ -0,0 +1,423 @@
+package main
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	log "github.com/Sirupsen/logrus"
+	"github.com/xianlubird/mydocker/cgroups"
+	"github.com/xianlubird/mydocker/container"
+	"github.com/xianlubird/mydocker/term"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// attach客户端发给shim的消息由1字节类型、4字节长度和内容组成，shim发给客户端的是容器的原始输出
+const (
+	attachStdin  byte = 0 //写入容器标准输入的数据
+	attachResize byte = 1 //窗口大小，高和宽各2字节
+
+	maxAttachFrame = 1 << 20
+	shimReady      = "ok"
+
+	// 每个客户端最多缓存这么多块还没发出去的输出，缓存满了就断开这个客户端
+	attachOutputBuffer = 64
+	attachWriteTimeout = 10 * time.Second
+)
+
+func writeAttachFrame(w io.Writer, kind byte, payload []byte) error {
+	buf := make([]byte, 5+len(payload))
+	buf[0] = kind
+	binary.BigEndian.PutUint32(buf[1:], uint32(len(payload)))
+	copy(buf[5:], payload)
+	_, err := w.Write(buf)
+	return err
+}
+
+func readAttachFrame(r io.Reader) (byte, []byte, error) {
+	header := make([]byte, 5)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return 0, nil, err
+	}
+	length := binary.BigEndian.Uint32(header[1:])
+	if length > maxAttachFrame {
+		return 0, nil, fmt.Errorf("attach frame too large: %d", length)
+	}
+	payload := make([]byte, length)
+	if _, err := io.ReadFull(r, payload); err != nil {
+		return 0, nil, err
+	}
+	return header[0], payload, nil
+}
+
+func attachSocketPath(containerName string) string {
+	return filepath.Join(fmt.Sprintf(container.DefaultInfoLocation, containerName), container.AttachSocketName)
+}
+
+// runDetached 启动shim进程来运行容器，容器启动之后CLI就退出，
+// shim在后台持有容器的标准输入输出，等待容器退出之后记录退出码并清理
+func runDetached(cfg *runConfig) error {
+	dirURL := fmt.Sprintf(container.DefaultInfoLocation, cfg.Name)
+	if err := os.MkdirAll(dirURL, 0622); err != nil {
+		return fmt.Errorf("mkdir %s error %v", dirURL, err)
+	}
+	// shim的日志写到容器目录下，不能继承CLI的标准输出，否则 $(mydocker run -d ...) 要等到容器退出才返回
+	shimLog, err := os.OpenFile(dirURL+container.ShimLogFile, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return fmt.Errorf("open shim log error %v", err)
+	}
+	defer shimLog.Close()
+	configRead, configWrite, err := os.Pipe()
+	if err != nil {
+		return err
+	}
+	readyRead, readyWrite, err := os.Pipe()
+	if err != nil {
+		return err
+	}
+	self, err := os.Readlink("/proc/self/exe")
+	if err != nil {
+		return err
+	}
+	// shim是新的进程，要把全局参数选择的存储驱动和cgroup驱动传下去
+	driver, _ := container.GetStorageDriver("")
+	cmd := exec.Command(self, "--storage-driver", driver.Name(), "--cgroup-driver", cgroups.CgroupDriver(), "shim")
+	cmd.Stdout = shimLog
+	cmd.Stderr = shimLog
+	cmd.ExtraFiles = []*os.File{configRead, readyWrite}
+	// 新的session，CLI所在的终端关闭时shim不会收到SIGHUP
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setsid: true}
+	if err := cmd.Start(); err != nil {
+		return fmt.Errorf("start shim error %v", err)
+	}
+	configRead.Close()
+	readyWrite.Close()
+
+	err = json.NewEncoder(configWrite).Encode(cfg)
+	configWrite.Close()
+	if err != nil {
+		return fmt.Errorf("send config to shim error %v", err)
+	}
+	result, err := ioutil.ReadAll(readyRead)
+	readyRead.Close()
+	if err != nil {
+		return err
+	}
+	if string(result) != shimReady {
+		if len(result) == 0 {
+			return fmt.Errorf("shim exited unexpectedly, see %s", dirURL+container.ShimLogFile)
+		}
+		return fmt.Errorf("%s", result)
+	}
+	// shim和容器的生命周期一样长，不需要等它
+	cmd.Process.Release()
+	log.Infof("container %s is running", cfg.Name)
+	return nil
+}
+
+// runShim 是shim进程的入口：从fd 3读取runConfig，启动容器之后通过fd 4告诉CLI结果，然后一直运行到容器退出。
+// shim在新的session中，CLI退出或者终端关闭都不影响它
+func runShim() error {
+	// 继承来的fd没有close-on-exec，不关掉的话容器进程会一直拿着ready管道，CLI等到容器退出才返回
+	syscall.CloseOnExec(3)
+	syscall.CloseOnExec(4)
+	configPipe := os.NewFile(3, "config")
+	readyPipe := os.NewFile(4, "ready")
+	fail := func(err error) error {
+		fmt.Fprint(readyPipe, err)
+		readyPipe.Close()
+		return err
+	}
+	cfg := &runConfig{}
+	err := json.NewDecoder(configPipe).Decode(cfg)
+	configPipe.Close()
+	if err != nil {
+		return fail(fmt.Errorf("decode run config error %v", err))
+	}
+
+	parent, processIO, cgroupManager, err := launchContainer(cfg)
+	if err != nil {
+		return fail(err)
+	}
+	s, err := newShim(cfg.Name)
+	if err != nil {
+		parent.Process.Kill()
+		parent.Wait()
+		processIO.Close()
+		// 和launchContainer失败时一样：新建的容器整个删掉，start已有的容器按退出处理，都要释放网络和cgroup
+		if cfg.Existing {
+			containerExited(cfg.Name, cgroupManager, parent.ProcessState)
+			return fail(err)
+		}
+		if containerInfo, infoErr := getContainerInfoByName(cfg.Name); infoErr == nil {
+			releaseContainerResources(containerInfo, cgroupManager)
+		} else {
+			cgroupManager.Destroy()
+		}
+		container.DeleteWorkSpace(cfg.Volume, cfg.Name, "")
+		deleteContainerInfo(cfg.Name)
+		return fail(err)
+	}
+	outputDone := s.attachProcess(processIO)
+	go s.serve()
+	fmt.Fprint(readyPipe, shimReady)
+	readyPipe.Close()
+
+	// shim是init的父进程，负责回收它，按重启策略重新运行，不再重启时清理容器
+	delay := time.Duration(0)
+	for {
+		startedAt := time.Now()
+		parent.Wait()
+		s.detachProcess(outputDone)
+		if !prepareRestart(cfg.Name, cgroupManager, parent.ProcessState) {
+			break
+		}
+		delay = container.NextRestartDelay(delay, time.Since(startedAt))
+		log.Infof("Restart container %s in %v", cfg.Name, delay)
+		if !waitRestartDelay(cfg.Name, delay) {
+			break
+		}
+		// 上一次的rootfs还挂载着，和start一样在可写层上重新挂载
+		cfg.Existing, cfg.restarting = true, true
+		container.UnmountWorkSpace(cfg.Volume, cfg.Name, "")
+		newParent, processIO, newCgroupManager, err := launchContainer(cfg)
+		if err != nil {
+			log.Errorf("Restart container %s error %v", cfg.Name, err)
+			break
+		}
+		parent, cgroupManager = newParent, newCgroupManager
+		outputDone = s.attachProcess(processIO)
+	}
+	s.close()
+	containerExited(cfg.Name, cgroupManager, parent.ProcessState)
+	return nil
+}
+
+// waitRestartDelay 等待delay之后重启，期间容器被stop或者删除就不再重启
+func waitRestartDelay(containerName string, delay time.Duration) bool {
+	deadline := time.Now().Add(delay)
+	for {
+		containerInfo, err := getContainerInfoByName(containerName)
+		if err != nil || containerInfo.Status != container.RESTARTING {
+			return false
+		}
+		remaining := deadline.Sub(time.Now())
+		if remaining <= 0 {
+			return true
+		}
+		if remaining > 100*time.Millisecond {
+			remaining = 100 * time.Millisecond
+		}
+		time.Sleep(remaining)
+	}
+}
+
+// shim 把容器的输出写入container.log并转发给所有attach的客户端，客户端的输入写入容器的标准输入。
+// 容器按重启策略重新运行时，socket和日志保持不变，换成新的init进程的标准输入输出
+type shim struct {
+	logFile  *os.File
+	listener net.Listener
+
+	mu        sync.Mutex
+	processIO *container.ProcessIO
+	clients   map[net.Conn]*attachClient
+}
+
+// attachClient 每个客户端有自己的发送队列和goroutine，慢的客户端不会卡住读容器输出
+type attachClient struct {
+	conn   net.Conn
+	output chan []byte
+}
+
+// writeOutput 把队列中的输出发给客户端，队列关闭并且发完之后断开连接
+func (c *attachClient) writeOutput() {
+	defer c.conn.Close()
+	for data := range c.output {
+		c.conn.SetWriteDeadline(time.Now().Add(attachWriteTimeout))
+		if _, err := c.conn.Write(data); err != nil {
+			return
+		}
+	}
+}
+
+func newShim(containerName string) (*shim, error) {
+	logFile, err := container.OpenLogFile(containerName)
+	if err != nil {
+		return nil, err
+	}
+	socketPath := attachSocketPath(containerName)
+	os.Remove(socketPath)
+	listener, err := net.Listen("unix", socketPath)
+	if err != nil {
+		logFile.Close()
+		return nil, fmt.Errorf("listen %s error %v", socketPath, err)
+	}
+	return &shim{
+		logFile:  logFile,
+		listener: listener,
+		clients:  map[net.Conn]*attachClient{},
+	}, nil
+}
+
+// attachProcess 开始转发新的init进程的输出，返回的channel在输出结束时关闭
+func (s *shim) attachProcess(processIO *container.ProcessIO) chan struct{} {
+	s.mu.Lock()
+	s.processIO = processIO
+	s.mu.Unlock()
+	outputDone := make(chan struct{})
+	go func() {
+		s.copyOutput(output(processIO))
+		close(outputDone)
+	}()
+	return outputDone
+}
+
+// detachProcess 在init退出之后调用，断开所有客户端，客户端读到EOF就知道容器退出了
+func (s *shim) detachProcess(outputDone chan struct{}) {
+	// 和 Pty.Attach 一样，不一直等还拿着输出的后台进程
+	select {
+	case <-outputDone:
+	case <-time.After(time.Second):
+	}
+	s.disconnectClients()
+	s.mu.Lock()
+	s.processIO.Close()
+	s.processIO = nil
+	s.mu.Unlock()
+}
+
+// disconnectClients 关闭所有客户端的队列，已经在队列中的输出发完之后再断开
+func (s *shim) disconnectClients() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for conn, client := range s.clients {
+		close(client.output)
+		delete(s.clients, conn)
+	}
+}
+
+// dropClient 马上断开客户端，调用时要持有s.mu
+func (s *shim) dropClient(conn net.Conn) {
+	if client, ok := s.clients[conn]; ok {
+		close(client.output)
+		delete(s.clients, conn)
+		conn.Close()
+	}
+}
+
+func output(processIO *container.ProcessIO) *os.File {
+	if processIO.Pty != nil {
+		return processIO.Pty.Master
+	}
+	return processIO.Output
+}
+
+// stdin 没有-i时返回nil，客户端的输入直接丢掉
+func stdin(processIO *container.ProcessIO) *os.File {
+	if processIO.Pty != nil {
+		return processIO.Pty.Master
+	}
+	return processIO.Stdin
+}
+
+// copyOutput 一直读到容器的输出关闭，pty在所有slave关闭之后返回EIO
+func (s *shim) copyOutput(output *os.File) {
+	buf := make([]byte, 32*1024)
+	for {
+		n, err := output.Read(buf)
+		if n > 0 {
+			if _, err := s.logFile.Write(buf[:n]); err != nil {
+				log.Warnf("Write container log error %v", err)
+			}
+			s.broadcast(buf[:n])
+		}
+		if err != nil {
+			return
+		}
+	}
+}
+
+// broadcast 不等待客户端，队列满了说明客户端太慢，直接断开它
+func (s *shim) broadcast(data []byte) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(s.clients) == 0 {
+		return
+	}
+	// data是读输出的缓冲区，下次读的时候会被覆盖
+	data = append([]byte(nil), data...)
+	for conn, client := range s.clients {
+		select {
+		case client.output <- data:
+		default:
+			log.Warnf("Attach client is too slow, disconnect it")
+			s.dropClient(conn)
+		}
+	}
+}
+
+func (s *shim) serve() {
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			return
+		}
+		client := &attachClient{conn: conn, output: make(chan []byte, attachOutputBuffer)}
+		s.mu.Lock()
+		s.clients[conn] = client
+		s.mu.Unlock()
+		go client.writeOutput()
+		go s.handleClient(conn)
+	}
+}
+
+func (s *shim) handleClient(conn net.Conn) {
+	defer func() {
+		s.mu.Lock()
+		s.dropClient(conn)
+		s.mu.Unlock()
+	}()
+	for {
+		kind, payload, err := readAttachFrame(conn)
+		if err != nil {
+			return
+		}
+		s.mu.Lock()
+		processIO := s.processIO
+		s.mu.Unlock()
+		// 容器正在等待重启
+		if processIO == nil {
+			continue
+		}
+		switch kind {
+		case attachStdin:
+			if stdin := stdin(processIO); stdin != nil {
+				if _, err := stdin.Write(payload); err != nil {
+					log.Warnf("Write container stdin error %v", err)
+				}
+			}
+		case attachResize:
+			if processIO.Pty != nil && len(payload) == 4 {
+				ws := &term.Winsize{
+					Height: binary.BigEndian.Uint16(payload),
+					Width:  binary.BigEndian.Uint16(payload[2:]),
+				}
+				term.SetWinsize(processIO.Pty.Master.Fd(), ws)
+			}
+		}
+	}
+}
+
+// close 关闭socket(同时删除socket文件)和日志，断开等待重启期间连上来的客户端，容器不再重启时调用
+func (s *shim) close() {
+	s.listener.Close()
+	s.disconnectClients()
+	s.logFile.Close()
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"fmt"
+	"github.com/xianlubird/mydocker/cgroups"
+	"github.com/xianlubird/mydocker/container"
+	"time"
+)
+
+// startStoppedContainer 用run时记录的配置重新运行停止或者退出的容器：
+// 卸载上次留下的rootfs，由新的shim重新创建namespace，在原来的可写层上挂载rootfs，
+// 恢复cgroup限制、网络和端口映射，运行原来的命令
+func startStoppedContainer(containerInfo *container.ContainerInfo) error {
+	containerName := containerInfo.Name
+	if containerInfo.Status != container.STOP && containerInfo.Status != container.Exit {
+		return fmt.Errorf("container %s is %s, only created, stopped or exited container can be started", containerName, containerInfo.Status)
+	}
+	if containerInfo.ShimRunning() {
+		return fmt.Errorf("container %s is still stopping, try again later", containerName)
+	}
+	if containerInfo.Spec == nil {
+		return fmt.Errorf("container %s has no recorded command, can not be started", containerName)
+	}
+	// 按容器创建时的存储驱动挂载，cgroup驱动也和创建时一样，shim也用这两个驱动
+	if err := container.SetStorageDriver(containerInfo.StorageDriver); err != nil {
+		return err
+	}
+	if containerInfo.CgroupDriver != "" {
+		if err := cgroups.SetCgroupDriver(containerInfo.CgroupDriver); err != nil {
+			return err
+		}
+	}
+	if err := container.UnmountWorkSpace(containerInfo.Volume, containerName, containerInfo.StorageDriver); err != nil {
+		return fmt.Errorf("unmount rootfs of %s error %v", containerName, err)
+	}
+	// 镜像的tag可能已经指向了别的镜像，按创建时的镜像ID找只读层
+	imageName := containerInfo.Image
+	if containerInfo.ImageID != "" {
+		imageName = containerInfo.ImageID
+	}
+	cfg := &runConfig{
+		Tty:           containerInfo.Tty,
+		Interactive:   containerInfo.OpenStdin,
+		Spec:          containerInfo.Spec,
+		Resources:     containerInfo.Resources,
+		ID:            containerInfo.Id,
+		Name:          containerName,
+		Volume:        containerInfo.Volume,
+		Image:         imageName,
+		Network:       containerInfo.Network,
+		PortMapping:   containerInfo.PortMapping,
+		IDMapping:     containerInfo.IDMapping,
+		Privileged:    containerInfo.Privileged,
+		AutoRemove:    containerInfo.AutoRemove,
+		Existing:      true,
+		RestartPolicy: containerInfo.RestartPolicy,
+		StopSignal:    containerInfo.StopSignal,
+	}
+	return runDetached(cfg)
+}
+
+// restartContainer 先停止正在运行的容器，timeout之内没有退出就用SIGKILL杀掉，然后重新启动
+func restartContainer(containerName string, timeout time.Duration) error {
+	containerInfo, err := inspectContainer(containerName)
+	if err != nil {
+		return err
+	}
+	if containerInfo.Bundle != "" {
+		return fmt.Errorf("container %s is created from bundle, can not be restarted", containerName)
+	}
+	if containerInfo.IsActive() {
+		if err := stopContainer(containerName, timeout); err != nil {
+			return err
+		}
+		if containerInfo, err = inspectContainer(containerName); err != nil {
+			return err
+		}
+	}
+	return startStoppedContainer(containerInfo)
+}
@@ -4,45 +4,162 @@ import (
 	log "github.com/Sirupsen/logrus"
 	"syscall"
 	"strconv"
+	"github.com/xianlubird/mydocker/cgroups"
 	"github.com/xianlubird/mydocker/container"
 	"fmt"
 	"io/ioutil"
 	"encoding/json"
 	"os"
+	"time"
 )
 
-func stopContainer(containerName string) {
-	pid, err := GetContainerPidByName(containerName)
+// killTimeout 是SIGKILL之后等待容器退出的时间
+const killTimeout = 10 * time.Second
+
+// stopContainer 给容器的init发送stop信号，等待容器的pid namespace中的进程全部退出，
+// timeout之内没有退出就用SIGKILL杀掉cgroup中的所有进程。容器的状态由shim在容器退出之后记录
+func stopContainer(containerName string, timeout time.Duration) error {
+	containerInfo, err := inspectContainer(containerName)
 	if err != nil {
-		log.Errorf("Get contaienr pid by name %s error %v", containerName, err)
-		return
+		return err
 	}
-	pidInt, err := strconv.Atoi(pid)
+	if !containerInfo.IsActive() {
+		return fmt.Errorf("container %s is %s, can not be stopped", containerName, containerInfo.Status)
+	}
+	// 先记下是手动停止的，shim看到之后不会按重启策略重启，退出时记录为stopped；
+	// 等待重启的容器没有进程，直接标记为stopped，shim就不再重启它
+	err = updateContainerInfo(containerName, func(info *container.ContainerInfo) {
+		info.ManuallyStopped = true
+		if info.Status == container.RESTARTING {
+			info.Transition(container.STOP)
+		}
+	})
 	if err != nil {
-		log.Errorf("Conver pid from string to int error %v", err)
-		return
+		return err
 	}
-	if err := syscall.Kill(pidInt, syscall.SIGTERM); err != nil {
-		log.Errorf("Stop container %s error %v", containerName, err)
-		return
+	if containerInfo.Status != container.RESTARTING {
+		if err := signalAndWait(containerInfo, timeout); err != nil {
+			return err
+		}
 	}
-	containerInfo, err := getContainerInfoByName(containerName)
+	// 等shim记录完退出并释放网络和cgroup
+	if !waitContainerExit(containerInfo, killTimeout) {
+		return fmt.Errorf("container %s exited but shim is still running", containerName)
+	}
+	// OCI bundle创建的容器没有shim，由stop自己记录；--rm 的容器可能已经被shim删除了
+	err = updateContainerInfo(containerName, func(info *container.ContainerInfo) {
+		if info.IsActive() {
+			info.Transition(container.STOP)
+		}
+	})
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// signalAndWait 发送stop信号并等待pid namespace中没有进程，超时之后通过cgroup杀掉所有进程
+func signalAndWait(containerInfo *container.ContainerInfo, timeout time.Duration) error {
+	pid, err := strconv.Atoi(containerInfo.Pid)
 	if err != nil {
-		log.Errorf("Get container %s info error %v", containerName, err)
-		return
+		return fmt.Errorf("invalid pid %q of container %s", containerInfo.Pid, containerInfo.Name)
+	}
+	signal := containerInfo.StopSignal
+	if signal == "" {
+		signal = container.DefaultStopSignal
 	}
-	containerInfo.Status = container.STOP
-	containerInfo.Pid = " "
-	newContentBytes, err := json.Marshal(containerInfo)
+	sig, err := container.ParseSignal(signal)
 	if err != nil {
-		log.Errorf("Json marshal %s error %v", containerName, err)
-		return
+		return err
 	}
-	dirURL := fmt.Sprintf(container.DefaultInfoLocation, containerName)
-	configFilePath := dirURL + container.ConfigName
-	if err := ioutil.WriteFile(configFilePath, newContentBytes, 0622); err != nil {
-		log.Errorf("Write file %s error", configFilePath, err)
+	// 要在发信号之前取，init退出之后就读不到了
+	ns := container.PidNamespace(pid)
+	if ns == container.PidNamespace(os.Getpid()) {
+		// 和宿主机共用pid namespace的bundle容器，只能等init
+		ns = ""
+	}
+	exited := func() bool {
+		if ns != "" {
+			return container.PidNamespaceEmpty(ns)
+		}
+		return !container.ProcessAlive(pid)
+	}
+	if err := syscall.Kill(pid, sig); err != nil && err != syscall.ESRCH {
+		return fmt.Errorf("send %s to container %s error %v", signal, containerInfo.Name, err)
+	}
+	// 冻结的进程收不到信号，发完信号之后解冻，容器退出时状态从paused变成stopped
+	if containerInfo.Status == container.PAUSED {
+		if err := cgroups.NewCgroupManager(containerInfo.Id, containerInfo.CgroupDriver).Freeze(false); err != nil {
+			log.Warnf("Unpause container %s error %v", containerInfo.Name, err)
+		}
+	}
+	if waitFor(exited, timeout) {
+		return nil
+	}
+	log.Warnf("Container %s did not exit in %v after %s, kill it", containerInfo.Name, timeout, signal)
+	syscall.Kill(pid, syscall.SIGKILL)
+	if err := cgroups.NewCgroupManager(containerInfo.Id, containerInfo.CgroupDriver).Kill(); err != nil {
+		log.Warnf("Kill processes of container %s error %v", containerInfo.Name, err)
+	}
+	if !waitFor(exited, killTimeout) {
+		return fmt.Errorf("container %s did not exit after SIGKILL", containerInfo.Name)
+	}
+	return nil
+}
+
+// killContainer 给容器的init发送信号，默认SIGKILL。SIGKILL和stop信号会让容器退出，
+// 和stop一样记录为手动停止，不再按重启策略重启；其他信号(比如让进程重新加载配置的SIGHUP)不影响重启策略
+func killContainer(containerName, signal string) error {
+	sig, err := container.ParseSignal(signal)
+	if err != nil {
+		return err
 	}
+	containerInfo, err := inspectContainer(containerName)
+	if err != nil {
+		return err
+	}
+	if containerInfo.Status == container.PAUSED {
+		return fmt.Errorf("container %s is paused, unpause it first or use stop", containerName)
+	}
+	if containerInfo.Status != container.RUNNING {
+		return fmt.Errorf("container %s is %s, only running container can be killed", containerName, containerInfo.Status)
+	}
+	pid, err := strconv.Atoi(containerInfo.Pid)
+	if err != nil {
+		return fmt.Errorf("invalid pid %q of container %s", containerInfo.Pid, containerName)
+	}
+	stopSignal, _ := container.ParseSignal(containerInfo.StopSignal)
+	if containerInfo.StopSignal == "" {
+		stopSignal = syscall.SIGTERM
+	}
+	if sig == syscall.SIGKILL || sig == stopSignal {
+		err := updateContainerInfo(containerName, func(info *container.ContainerInfo) {
+			info.ManuallyStopped = true
+		})
+		if err != nil {
+			return err
+		}
+	}
+	if err := syscall.Kill(pid, sig); err != nil {
+		return fmt.Errorf("kill container %s error %v", containerName, err)
+	}
+	return nil
+}
+
+// waitContainerExit 等待shim记录完容器的退出并退出，shim退出之后网络和cgroup已经释放
+func waitContainerExit(containerInfo *container.ContainerInfo, timeout time.Duration) bool {
+	return waitFor(func() bool { return !containerInfo.ShimRunning() }, timeout)
+}
+
+func waitFor(done func() bool, timeout time.Duration) bool {
+	deadline := time.Now().Add(timeout)
+	for !done() {
+		if time.Now().After(deadline) {
+			return false
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	return true
 }
 
 func getContainerInfoByName(containerName string) (*container.ContainerInfo, error) {
@@ -61,20 +178,54 @@ func getContainerInfoByName(containerName string) (*container.ContainerInfo, err
 	return &containerInfo, nil
 }
 
-func removeContainer(containerName string) {
+// inspectContainer 读取容器信息并和实际的进程状态核对，命令根据核对之后的状态判断能不能执行
+func inspectContainer(containerName string) (*container.ContainerInfo, error) {
 	containerInfo, err := getContainerInfoByName(containerName)
+	if err != nil {
+		return nil, err
+	}
+	return containerInfo, reconcileContainerInfo(containerInfo)
+}
+
+// reconcileContainerInfo 状态有变化时加锁重新核对一次再写回，shim可能刚好在记录退出
+func reconcileContainerInfo(containerInfo *container.ContainerInfo) error {
+	if !containerInfo.Reconcile() {
+		return nil
+	}
+	return updateContainerInfo(containerInfo.Name, func(info *container.ContainerInfo) {
+		info.Reconcile()
+		*containerInfo = *info
+	})
+}
+
+func removeContainer(containerName string) {
+	containerInfo, err := inspectContainer(containerName)
 	if err != nil {
 		log.Errorf("Get container %s info error %v", containerName, err)
 		return
 	}
-	if containerInfo.Status != container.STOP {
-		log.Errorf("Couldn't remove running container")
+	switch containerInfo.Status {
+	case container.CREATED:
+		// create 之后还没有 start 的容器，init进程阻塞在exec fifo上，直接杀掉
+		if pid, err := strconv.Atoi(containerInfo.Pid); err == nil {
+			syscall.Kill(pid, syscall.SIGKILL)
+		}
+	case container.RUNNING, container.PAUSED, container.RESTARTING:
+		log.Errorf("Couldn't remove %s container %s, stop it first", containerInfo.Status, containerName)
 		return
+	case container.DEAD:
+		// 没有人在容器退出时清理，网络和cgroup还留着
+		releaseContainerResources(containerInfo, cgroups.NewCgroupManager(containerInfo.Id, containerInfo.CgroupDriver))
 	}
 	dirURL := fmt.Sprintf(container.DefaultInfoLocation, containerName)
 	if err := os.RemoveAll(dirURL); err != nil {
 		log.Errorf("Remove file %s error %v", dirURL, err)
 		return
 	}
-	container.DeleteWorkSpace(containerInfo.Volume, containerName)
+	// OCI bundle的rootfs不归mydocker管理
+	if containerInfo.Bundle != "" {
+		cgroups.NewCgroupManager(containerInfo.Id, containerInfo.CgroupDriver).Destroy()
+		return
+	}
+	container.DeleteWorkSpace(containerInfo.Volume, containerName, containerInfo.StorageDriver)
 }
@@ -0,0 +1,99 @@
+package term
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// DefaultDetachKeys 和docker一样，先按ctrl-p再按ctrl-q离开attach
+const DefaultDetachKeys = "ctrl-p,ctrl-q"
+
+// ErrDetached 表示用户输入了detach的按键序列
+var ErrDetached = errors.New("detached from container")
+
+// ParseDetachKeys 解析逗号分隔的按键序列，每一项是单个字符或者 ctrl-<a-z|@|[|\|]|^|_>
+func ParseDetachKeys(keys string) ([]byte, error) {
+	var seq []byte
+	for _, key := range strings.Split(keys, ",") {
+		if len(key) == 1 {
+			seq = append(seq, key[0])
+			continue
+		}
+		lower := strings.ToLower(key)
+		if !strings.HasPrefix(lower, "ctrl-") || len(lower) != len("ctrl-")+1 {
+			return nil, fmt.Errorf("invalid detach key %q", key)
+		}
+		c := lower[len("ctrl-")]
+		switch {
+		case c >= 'a' && c <= 'z':
+			seq = append(seq, c-'a'+1)
+		case c == '@', c == '[', c == '\\', c == ']', c == '^', c == '_':
+			seq = append(seq, c-'@')
+		default:
+			return nil, fmt.Errorf("invalid detach key %q", key)
+		}
+	}
+	return seq, nil
+}
+
+// escapeProxy 在输入中查找detach按键序列，匹配了一部分的字节先扣住，
+// 确定不是detach之后再和后面的输入一起交给调用者
+type escapeProxy struct {
+	r       io.Reader
+	keys    []byte
+	matched int
+	pending []byte
+	err     error
+}
+
+// NewEscapeProxy 返回的Reader读到完整的按键序列时返回ErrDetached，序列本身不会被读出
+func NewEscapeProxy(r io.Reader, keys []byte) io.Reader {
+	return &escapeProxy{r: r, keys: keys}
+}
+
+func (e *escapeProxy) Read(p []byte) (int, error) {
+	if len(e.keys) == 0 {
+		return e.r.Read(p)
+	}
+	for len(e.pending) == 0 {
+		if e.err != nil {
+			return 0, e.err
+		}
+		buf := make([]byte, len(p))
+		n, err := e.r.Read(buf)
+		e.err = err
+		e.scan(buf[:n])
+		// 输入结束时扣住的字节也要交出去
+		if e.err != nil && e.err != ErrDetached {
+			e.pending = append(e.pending, e.keys[:e.matched]...)
+			e.matched = 0
+		}
+	}
+	n := copy(p, e.pending)
+	e.pending = e.pending[n:]
+	return n, nil
+}
+
+// scan 把确定不属于detach序列的字节放进pending，序列完整时丢掉后面的输入
+func (e *escapeProxy) scan(buf []byte) {
+	for _, c := range buf {
+		if c == e.keys[e.matched] {
+			e.matched++
+			if e.matched == len(e.keys) {
+				e.err = ErrDetached
+				return
+			}
+			continue
+		}
+		// 扣住的字节不是detach序列，原样放回
+		e.pending = append(e.pending, e.keys[:e.matched]...)
+		e.matched = 0
+		if c == e.keys[0] {
+			e.matched = 1
+			continue
+		}
+		e.pending = append(e.pending, c)
+	}
+}
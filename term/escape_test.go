@@ -0,0 +1,65 @@
+package term
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"testing"
+)
+
+func TestParseDetachKeys(t *testing.T) {
+	cases := []struct {
+		keys string
+		want []byte
+	}{
+		{DefaultDetachKeys, []byte{16, 17}},
+		{"ctrl-@,a,CTRL-Z", []byte{0, 'a', 26}},
+		{"ctrl-[", []byte{27}},
+	}
+	for _, c := range cases {
+		got, err := ParseDetachKeys(c.keys)
+		if err != nil {
+			t.Fatalf("%s: %v", c.keys, err)
+		}
+		if !bytes.Equal(got, c.want) {
+			t.Errorf("%s: got %v, want %v", c.keys, got, c.want)
+		}
+	}
+	for _, keys := range []string{"", "ctrl-", "ctrl-1", "alt-a", "ab"} {
+		if _, err := ParseDetachKeys(keys); err == nil {
+			t.Errorf("%q: expected error", keys)
+		}
+	}
+}
+
+// oneByteReader 每次只读一个字节，按键序列会跨多次Read
+type oneByteReader struct{ r io.Reader }
+
+func (o oneByteReader) Read(p []byte) (int, error) {
+	return o.r.Read(p[:1])
+}
+
+func TestEscapeProxy(t *testing.T) {
+	keys := []byte{16, 17}
+	cases := []struct {
+		input    string
+		want     string
+		detached bool
+	}{
+		{"hello", "hello", false},
+		{"ab\x10\x11cd", "ab", true},
+		{"a\x10b\x10\x10\x11", "a\x10b\x10", true},
+		{"a\x10", "a\x10", false},
+	}
+	for _, c := range cases {
+		for _, r := range []io.Reader{bytes.NewBufferString(c.input), oneByteReader{bytes.NewBufferString(c.input)}} {
+			got, err := ioutil.ReadAll(NewEscapeProxy(r, keys))
+			if c.detached && err != ErrDetached || !c.detached && err != nil {
+				t.Errorf("%q: unexpected error %v", c.input, err)
+			}
+			if string(got) != c.want {
+				t.Errorf("%q: got %q, want %q", c.input, got, c.want)
+			}
+		}
+	}
+}
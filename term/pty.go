@@ -0,0 +1,108 @@
+package term
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+	"unsafe"
+)
+
+const (
+	tiocgptn   = 0x80045430
+	tiocsptlck = 0x40045431
+)
+
+// Pty 是一对伪终端，Master留在父进程，Slave交给容器进程作为控制终端
+type Pty struct {
+	Master *os.File
+	Slave  *os.File
+}
+
+// OpenPty 在宿主机的devpts上分配一对pty
+func OpenPty() (*Pty, error) {
+	master, err := os.OpenFile("/dev/ptmx", os.O_RDWR|syscall.O_NOCTTY|syscall.O_CLOEXEC, 0)
+	if err != nil {
+		return nil, err
+	}
+	var unlock int32
+	if err := ioctl(master.Fd(), tiocsptlck, uintptr(unsafe.Pointer(&unlock))); err != nil {
+		master.Close()
+		return nil, fmt.Errorf("unlockpt error %v", err)
+	}
+	var n uint32
+	if err := ioctl(master.Fd(), tiocgptn, uintptr(unsafe.Pointer(&n))); err != nil {
+		master.Close()
+		return nil, fmt.Errorf("ptsname error %v", err)
+	}
+	slave, err := os.OpenFile(fmt.Sprintf("/dev/pts/%d", n), os.O_RDWR|syscall.O_NOCTTY, 0)
+	if err != nil {
+		master.Close()
+		return nil, err
+	}
+	return &Pty{Master: master, Slave: slave}, nil
+}
+
+// SetControllingTerminal 在新的session中把fd设为控制终端，调用进程不能是进程组的leader
+func SetControllingTerminal(fd uintptr) error {
+	if _, err := syscall.Setsid(); err != nil {
+		return fmt.Errorf("setsid error %v", err)
+	}
+	if err := ioctl(fd, syscall.TIOCSCTTY, 0); err != nil {
+		return fmt.Errorf("set controlling terminal error %v", err)
+	}
+	return nil
+}
+
+// Attach 在容器进程启动之后调用，把当前进程的标准输入输出接到pty的master上：
+// 宿主机终端进入raw模式，转发输入输出，并把窗口大小的变化同步给pty。
+// 返回的函数在容器进程退出之后调用，它等待剩余的输出写完并恢复宿主机终端
+func (p *Pty) Attach() func() {
+	// 父进程不再需要slave，所有slave都关闭之后读master才会返回EIO
+	p.Slave.Close()
+	master := p.Master
+	stdin := os.Stdin.Fd()
+	var state *State
+	if IsTerminal(stdin) {
+		resizePty(stdin, master)
+		if s, err := MakeRaw(stdin); err == nil {
+			state = s
+		}
+	}
+
+	winch := make(chan os.Signal, 1)
+	signal.Notify(winch, syscall.SIGWINCH)
+	go func() {
+		for range winch {
+			resizePty(stdin, master)
+		}
+	}()
+	go io.Copy(master, os.Stdin)
+	done := make(chan struct{})
+	go func() {
+		io.Copy(os.Stdout, master)
+		close(done)
+	}()
+
+	return func() {
+		// 容器里留在后台的进程可能还拿着slave，不能一直等下去
+		select {
+		case <-done:
+		case <-time.After(time.Second):
+		}
+		signal.Stop(winch)
+		close(winch)
+		master.Close()
+		if state != nil {
+			Restore(stdin, state)
+		}
+	}
+}
+
+func resizePty(stdin uintptr, master *os.File) {
+	if ws, err := GetWinsize(stdin); err == nil {
+		SetWinsize(master.Fd(), ws)
+	}
+}
@@ -0,0 +1,69 @@
+package term
+
+import (
+	"syscall"
+	"unsafe"
+)
+
+// State 是进入raw模式之前的终端设置
+type State struct {
+	termios syscall.Termios
+}
+
+// Winsize 和 struct winsize 对应
+type Winsize struct {
+	Height uint16
+	Width  uint16
+	x      uint16
+	y      uint16
+}
+
+func ioctl(fd, req, arg uintptr) error {
+	if _, _, errno := syscall.Syscall(syscall.SYS_IOCTL, fd, req, arg); errno != 0 {
+		return errno
+	}
+	return nil
+}
+
+// IsTerminal 判断fd是不是终端
+func IsTerminal(fd uintptr) bool {
+	var termios syscall.Termios
+	return ioctl(fd, syscall.TCGETS, uintptr(unsafe.Pointer(&termios))) == nil
+}
+
+// MakeRaw 把终端设置成raw模式，输入不回显、不按行缓冲，Ctrl-C等也原样交给容器内的进程
+func MakeRaw(fd uintptr) (*State, error) {
+	var old syscall.Termios
+	if err := ioctl(fd, syscall.TCGETS, uintptr(unsafe.Pointer(&old))); err != nil {
+		return nil, err
+	}
+	raw := old
+	raw.Iflag &^= syscall.IGNBRK | syscall.BRKINT | syscall.PARMRK | syscall.ISTRIP | syscall.INLCR | syscall.IGNCR | syscall.ICRNL | syscall.IXON
+	raw.Oflag &^= syscall.OPOST
+	raw.Lflag &^= syscall.ECHO | syscall.ECHONL | syscall.ICANON | syscall.ISIG | syscall.IEXTEN
+	raw.Cflag &^= syscall.CSIZE | syscall.PARENB
+	raw.Cflag |= syscall.CS8
+	raw.Cc[syscall.VMIN] = 1
+	raw.Cc[syscall.VTIME] = 0
+	if err := ioctl(fd, syscall.TCSETS, uintptr(unsafe.Pointer(&raw))); err != nil {
+		return nil, err
+	}
+	return &State{termios: old}, nil
+}
+
+// Restore 恢复MakeRaw之前的设置
+func Restore(fd uintptr, state *State) error {
+	return ioctl(fd, syscall.TCSETS, uintptr(unsafe.Pointer(&state.termios)))
+}
+
+func GetWinsize(fd uintptr) (*Winsize, error) {
+	ws := &Winsize{}
+	if err := ioctl(fd, syscall.TIOCGWINSZ, uintptr(unsafe.Pointer(ws))); err != nil {
+		return nil, err
+	}
+	return ws, nil
+}
+
+func SetWinsize(fd uintptr, ws *Winsize) error {
+	return ioctl(fd, syscall.TIOCSWINSZ, uintptr(unsafe.Pointer(ws)))
+}